@@ -0,0 +1,374 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// minCompactionSize is the size in bytes the append log must reach before it is
+// compacted, so small memories are not compacted on every change.
+const minCompactionSize = 4096
+
+// logRecord is a single line of the append log. Each record contains the
+// complete state of a key after a change, so replaying a record more than once
+// has no effect.
+type logRecord struct {
+	Key     string     `json:"key"`
+	Value   []byte     `json:"value"`
+	Deleted bool       `json:"deleted,omitempty"`
+	Version *uint64    `json:"version,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Type    string     `json:"content_type,omitempty"` // see SetTyped
+	CRC32   *uint32    `json:"crc32,omitempty"`        // see WithValueChecksums
+}
+
+// logPath returns the path of the append log.
+func (m *Storage) logPath() string {
+	return m.path + ".log"
+}
+
+// checkAppendLogOptions returns an error if the append log was combined with an
+// option that requires the memory file to be rewritten on every change.
+func (m *Storage) checkAppendLogOptions() error {
+	if m.logRatio == 0 {
+		switch {
+		case m.compactEvery > 0:
+			return errors.New("a snapshot interval requires an append log")
+		case m.compactGate != nil:
+			return errors.New("a compaction schedule requires an append log")
+		}
+		return nil
+	}
+
+	switch {
+	case m.versionFile:
+		return errors.New("an append log cannot be combined with a version file")
+	case m.onConflict != nil:
+		return errors.New("an append log cannot be combined with conflict detection")
+	case m.fileWatchInterval > 0:
+		return errors.New("an append log cannot be combined with a file watch")
+	case m.shards > 0:
+		return errors.New("an append log cannot be combined with sharding")
+	case m.loaded != nil:
+		return errors.New("an append log cannot be combined with a background load")
+	case m.maxSerializedSize > 0:
+		return errors.New("an append log cannot be combined with a maximum serialized size")
+	case m.aead != nil:
+		return errors.New("an append log cannot be combined with encryption")
+	case m.keyIndexPath != "":
+		return errors.New("an append log cannot be combined with a key index")
+	case m.mirrorPath != "":
+		return errors.New("an append log cannot be combined with a mirror")
+	}
+
+	return nil
+}
+
+// replayLog applies all records of the append log to the memory. A truncated
+// last record is expected if the process crashed while appending it, so it is
+// skipped. Any other invalid record is an error. The log may contain JSON and
+// binary records (see WithBinaryAppendLog).
+func (m *Storage) replayLog() error {
+	content, err := m.readAll(m.logPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return m.statSnapshot()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read append log: %w", err)
+	}
+
+	m.logSize = int64(len(content))
+
+	var num int
+	for len(content) > 0 {
+		r, n, err := decodeLogRecord(content)
+		if errors.Is(err, errTruncatedRecord) {
+			m.logger.Warn("Ignoring truncated record at the end of the append log", zap.String("path", m.logPath()))
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid record %d in append log: %w", num+1, err)
+		}
+
+		content = content[n:]
+
+		// the keys of the log are hashed already (see WithKeyHashing)
+		r.Key = m.normalizePrefix(r.Key)
+		m.applyRecord(r)
+		num++
+	}
+
+	m.logger.Debug("Replayed append log", zap.String("path", m.logPath()), zap.Int("num_records", num))
+	return m.statSnapshot()
+}
+
+// decodeLogRecord decodes the record at the start of the content and returns
+// it together with its length in bytes. If the content ends before the record
+// is complete, errTruncatedRecord is returned.
+func decodeLogRecord(content []byte) (logRecord, int, error) {
+	if content[0] == binaryRecordMarker {
+		return decodeBinaryRecord(content)
+	}
+
+	i := bytes.IndexByte(content, '\n')
+	if i < 0 {
+		return logRecord{}, 0, errTruncatedRecord
+	}
+
+	var r logRecord
+	err := json.Unmarshal(content[:i], &r)
+	return r, i + 1, err
+}
+
+// statSnapshot remembers the size of the memory file, which is used to decide
+// when the append log is compacted.
+func (m *Storage) statSnapshot() error {
+	info, err := m.fsys.Stat(m.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		m.snapshotSize = 0
+	case err != nil:
+		return fmt.Errorf("failed to get size of memory file: %w", err)
+	default:
+		m.snapshotSize = info.Size()
+	}
+
+	return nil
+}
+
+// applyRecord sets the key to the state that is described by the record. The
+// record is not appended to the log again.
+func (m *Storage) applyRecord(r logRecord) {
+	delete(m.data, r.Key)
+	delete(m.versions, r.Key)
+	delete(m.expires, r.Key)
+	delete(m.types, r.Key)
+	delete(m.loadedChecksums, r.Key)
+	if r.Deleted {
+		return
+	}
+
+	m.data[r.Key] = r.Value
+	if r.CRC32 != nil {
+		if m.loadedChecksums == nil {
+			m.loadedChecksums = map[string]uint32{}
+		}
+		m.loadedChecksums[r.Key] = *r.CRC32
+	}
+	if r.Version != nil {
+		if m.versions == nil {
+			m.versions = map[string]uint64{}
+		}
+		m.versions[r.Key] = *r.Version
+	}
+	if r.Expires != nil {
+		if m.expires == nil {
+			m.expires = map[string]time.Time{}
+		}
+		m.expires[r.Key] = *r.Expires
+	}
+	if r.Type != "" {
+		if m.types == nil {
+			m.types = map[string]string{}
+		}
+		m.types[r.Key] = r.Type
+	}
+}
+
+// persistLog appends the current state of all changed keys to the append log
+// and returns the number of appended bytes. Afterwards, the log is compacted if
+// it has grown too large. A failed compaction is only logged, since the
+// changes are already safe in the log. The caller must hold the write lock.
+func (m *Storage) persistLog(span trace.Span) (int, error) {
+	keys := make([]string, 0, len(m.logKeys))
+	for key := range m.logKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, key := range keys {
+		value, ok := m.data[key]
+		r := logRecord{Key: key, Value: value, Deleted: !ok}
+		if version, ok := m.versions[key]; ok {
+			r.Version = &version
+		}
+		if t, ok := m.expires[key]; ok {
+			r.Expires = &t
+		}
+		if ok {
+			r.Type = m.types[key]
+		}
+		if ok && m.valueChecksums {
+			checksum := m.storedChecksum(key, value)
+			r.CRC32 = &checksum
+		}
+
+		if m.binaryLog {
+			buf.Write(appendBinaryRecord(nil, r))
+			continue
+		}
+
+		err := enc.Encode(r)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode append log record: %w", err)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("bytes", buf.Len()), attribute.Int("num_records", len(keys)))
+
+	err := m.appendToLog(buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	m.logKeys = nil
+	m.lastPersist = m.now()
+
+	if m.compactEvery > 0 {
+		// the log is compacted in the background (see compactPeriodically)
+		return buf.Len(), nil
+	}
+
+	if m.logSize >= minCompactionSize && float64(m.logSize) > m.logRatio*float64(m.snapshotSize) && m.compactionAllowed() {
+		err := m.compactLog()
+		if err != nil {
+			m.logger.Error("Failed to compact append log", zap.String("path", m.logPath()), zap.Error(err))
+		}
+	}
+
+	return buf.Len(), nil
+}
+
+// appendToLog appends the encoded records to the append log.
+func (m *Storage) appendToLog(records []byte) error {
+	err := m.withTimeout(func() error {
+		return m.writeLog(records)
+	})
+	if err != nil {
+		return err
+	}
+
+	m.logSize += int64(len(records))
+	return nil
+}
+
+// writeLog implements appendToLog without a timeout.
+func (m *Storage) writeLog(records []byte) error {
+	f, err := m.fsys.OpenFile(m.logPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, m.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open append log: %w", err)
+	}
+
+	_, err = io.Copy(f, bytes.NewReader(records))
+	if err == nil && m.syncWrites {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write to append log: %w", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close append log; data might not have been fully persisted to disk: %w", err)
+	}
+
+	return nil
+}
+
+// compactLog writes all data to the memory file and then removes the append
+// log. If the process crashes in between, the old log is replayed on top of the
+// new memory file, which does not change the data since each record contains
+// the complete state of its key.
+func (m *Storage) compactLog() error {
+	content, err := m.serialize(m.data)
+	if err != nil {
+		return err
+	}
+
+	if m.backups > 0 {
+		m.rotateBackups()
+	}
+
+	err = m.writeFile(m.path, content)
+	if err != nil {
+		return err
+	}
+
+	m.snapshotSize = int64(len(content))
+	m.diskChecksum = sha256.Sum256(content)
+
+	err = m.fsys.Remove(m.logPath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove compacted append log: %w", err)
+	}
+
+	m.logSize = 0
+	m.logger.Debug("Compacted append log", zap.String("path", m.path), zap.Int64("bytes", m.snapshotSize))
+	return nil
+}
+
+// compactPeriodically compacts the append log once per snapshot interval if
+// any change was appended since the last compaction (see
+// WithSnapshotInterval).
+func (m *Storage) compactPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.compactEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.compactScheduled()
+		}
+	}
+}
+
+// compactionAllowed returns true if the compaction schedule allows to compact
+// the append log right now (see WithCompactionSchedule). A panic in the
+// schedule is recovered and postpones the compaction.
+func (m *Storage) compactionAllowed() (allowed bool) {
+	if m.compactGate == nil {
+		return true
+	}
+
+	defer func() {
+		if perr := m.recoverPanic("compaction schedule", recover()); perr != nil {
+			allowed = false
+		}
+	}()
+
+	return m.compactGate()
+}
+
+// compactScheduled compacts the append log if any change was appended since
+// the last compaction. A draining memory must never write the memory file, so
+// its log is kept until a later memory of the same file compacts it.
+func (m *Storage) compactScheduled() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data == nil || m.logSize == 0 || m.paused || m.readOnly || m.draining || !m.compactionAllowed() {
+		return
+	}
+
+	err := m.compactLog()
+	if err != nil {
+		m.logger.Error("Failed to compact append log", zap.String("path", m.logPath()), zap.Error(err))
+	}
+}
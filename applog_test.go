@@ -0,0 +1,327 @@
+package file
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithAppendLog(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	mem, err := NewMemory(tempFile, WithAppendLog(1))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("empty", nil))
+	_, err = mem.SetWithVersion("versioned", []byte("v1"), 0)
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("deleted", []byte("baz")))
+	_, err = mem.Delete("deleted")
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	// only the log is written
+	assert.NoFileExists(t, tempFile)
+	content, err := os.ReadFile(tempFile + ".log")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`{"key":"foo","value":"YmFy"}`,
+		`{"key":"empty","value":null}`,
+		`{"key":"versioned","value":"djE=","version":1}`,
+		`{"key":"deleted","value":"YmF6"}`,
+		`{"key":"deleted","value":null,"deleted":true}`,
+	}, "\n")+"\n", string(content))
+
+	mem, err = NewMemory(tempFile, WithAppendLog(1))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"empty", "foo", "versioned"}, keys)
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+
+	_, version, _, err := mem.GetWithVersion("versioned")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), version)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithAppendLog_TruncatedRecord(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	content := `{"key":"foo","value":"YmFy"}` + "\n" + `{"key":"bar","val`
+	require.NoError(t, os.WriteFile(tempFile+".log", []byte(content), 0660))
+
+	mem, err := NewMemory(tempFile, WithAppendLog(1))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+}
+
+func TestWithAppendLog_InvalidRecord(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile + ".log")
+
+	content := `{"key":"foo","value":42}` + "\n"
+	require.NoError(t, os.WriteFile(tempFile+".log", []byte(content), 0660))
+
+	_, err := NewMemory(tempFile, WithAppendLog(1))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid record 1 in append log")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithAppendLog_Compaction(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	mem, err := NewMemory(tempFile, WithAppendLog(0.5))
+	require.NoError(t, err)
+
+	// the first record exceeds the minimum size of the log
+	require.NoError(t, mem.Set("big", make([]byte, minCompactionSize)))
+	assert.NoFileExists(t, tempFile+".log")
+	assert.FileExists(t, tempFile)
+
+	// the log is now small compared to the memory file
+	require.NoError(t, mem.Set("small", []byte("foo")))
+	assert.FileExists(t, tempFile+".log")
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithAppendLog(0.5))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"big", "small"}, keys)
+
+	value, _, err := mem.Get("big")
+	require.NoError(t, err)
+	assert.Len(t, value, minCompactionSize)
+}
+
+func TestWithAppendLog_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithAppendLog(0))
+	require.EqualError(t, err, "compaction ratio must be positive but got 0")
+
+	_, err = NewMemory(tempFilePath(), WithAppendLog(1), WithShards(2))
+	require.EqualError(t, err, "an append log cannot be combined with sharding")
+
+	_, err = NewMemory(tempFilePath(), WithSnapshotInterval(time.Minute))
+	require.EqualError(t, err, "a snapshot interval requires an append log")
+
+	_, err = NewMemory(tempFilePath(), WithAppendLog(1), WithSnapshotInterval(0))
+	require.EqualError(t, err, "snapshot interval must be positive but got 0s")
+
+	_, err = NewMemory(tempFilePath(), WithCompactionSchedule(func() bool { return true }))
+	require.EqualError(t, err, "a compaction schedule requires an append log")
+
+	_, err = NewMemory(tempFilePath(), WithAppendLog(1), WithCompactionSchedule(nil))
+	require.EqualError(t, err, "compaction schedule must not be nil")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCompactionSchedule(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	allowed := false
+	mem, err := NewMemory(tempFile, WithAppendLog(0.01), WithCompactionSchedule(func() bool { return allowed }))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	// the log exceeds its compaction ratio but the schedule does not allow it
+	require.NoError(t, mem.Set("big", make([]byte, minCompactionSize)))
+	require.NoError(t, mem.Set("small", []byte("foo")))
+	assert.FileExists(t, tempFile+".log")
+	assert.NoFileExists(t, tempFile)
+
+	allowed = true
+	require.NoError(t, mem.Set("small", []byte("bar")))
+	assert.NoFileExists(t, tempFile+".log")
+	assertRecovered(t, mem, map[string]string{"big": string(make([]byte, minCompactionSize)), "small": "bar"})
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCompactionSchedule_SnapshotInterval(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	core, logs := observer.New(zap.ErrorLevel)
+	schedule := func() bool { return false }
+	mem, err := NewMemory(tempFile,
+		WithLogger(zap.New(core)),
+		WithAppendLog(1),
+		WithSnapshotInterval(time.Hour),
+		WithCompactionSchedule(func() bool { return schedule() }),
+	)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("foo")))
+	mem.compactScheduled()
+	assert.FileExists(t, tempFile+".log")
+
+	// a broken schedule postpones the compaction
+	schedule = func() bool { panic("schedule is broken") }
+	mem.compactScheduled()
+	assert.FileExists(t, tempFile+".log")
+	assert.Equal(t, 1, logs.FilterMessage("Recovered from panic").Len())
+
+	schedule = func() bool { return true }
+	mem.compactScheduled()
+	assert.NoFileExists(t, tempFile+".log")
+	assert.FileExists(t, tempFile)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithSnapshotInterval(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	mem, err := NewMemory(tempFile, WithAppendLog(1), WithSnapshotInterval(10*time.Millisecond), WithSync())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("foo")))
+	require.NoError(t, mem.Set("b", []byte("bar")))
+	assertRecovered(t, mem, map[string]string{"a": "foo", "b": "bar"})
+
+	// the log is folded into the memory file in the background
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(tempFile + ".log")
+		return os.IsNotExist(err)
+	}, time.Second, time.Millisecond)
+	assert.FileExists(t, tempFile)
+
+	require.NoError(t, mem.Set("a", []byte("baz")))
+	_, err = mem.Delete("b")
+	require.NoError(t, err)
+	assertRecovered(t, mem, map[string]string{"a": "baz"})
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithSnapshotInterval_NoInlineCompaction(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	mem, err := NewMemory(tempFile, WithAppendLog(0.01), WithSnapshotInterval(time.Hour))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	// the log exceeds its compaction ratio but is only compacted periodically
+	require.NoError(t, mem.Set("big", make([]byte, minCompactionSize)))
+	require.NoError(t, mem.Set("small", []byte("foo")))
+	assert.FileExists(t, tempFile+".log")
+	assert.NoFileExists(t, tempFile)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithSnapshotInterval_Draining(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	mem, err := NewMemory(tempFile, WithAppendLog(1), WithSnapshotInterval(time.Hour))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("foo")))
+	require.NoError(t, mem.Drain())
+
+	mem.compactScheduled()
+	assert.FileExists(t, tempFile+".log")
+	assert.NoFileExists(t, tempFile)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithSnapshotInterval_CrashDuringCompaction(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	mem, err := NewMemory(tempFile, WithAppendLog(1), WithSnapshotInterval(time.Hour))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("foo")))
+	require.NoError(t, mem.Set("b", []byte("bar")))
+	require.NoError(t, mem.Set("a", []byte("baz")))
+
+	log, err := os.ReadFile(tempFile + ".log")
+	require.NoError(t, err)
+
+	mem.mu.Lock()
+	require.NoError(t, mem.compactLog())
+	mem.mu.Unlock()
+
+	// the process crashed after the snapshot was written but before the log
+	// was removed, so the old log is replayed on top of the snapshot
+	require.NoError(t, os.WriteFile(tempFile+".log", log, 0600))
+	assertRecovered(t, mem, map[string]string{"a": "baz", "b": "bar"})
+}
+
+// assertRecovered simulates a crash of the memory by copying its files as they
+// are on disk right now and asserts that a memory that is created from the copy
+// contains exactly the given data.
+//
+// noinspection GoUnhandledErrorResult
+func assertRecovered(t *testing.T, mem *Storage, expected map[string]string) {
+	t.Helper()
+
+	crashed := tempFilePath()
+	defer os.Remove(crashed)
+	defer os.Remove(crashed + ".log")
+
+	// the lock prevents a compaction while the files are copied
+	mem.mu.Lock()
+	for _, suffix := range []string{"", ".log"} {
+		content, err := os.ReadFile(mem.path + suffix)
+		if os.IsNotExist(err) {
+			continue
+		}
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(crashed+suffix, content, 0600))
+	}
+	mem.mu.Unlock()
+
+	recovered, err := NewMemory(crashed, WithAppendLog(1))
+	require.NoError(t, err)
+	defer recovered.Close()
+
+	data := map[string]string{}
+	keys, err := recovered.Keys()
+	require.NoError(t, err)
+	for _, key := range keys {
+		value, _, err := recovered.Get(key)
+		require.NoError(t, err)
+		data[key] = string(value)
+	}
+
+	assert.Equal(t, expected, data)
+}
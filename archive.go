@@ -0,0 +1,126 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// archiveTimeFormat is the layout of the timestamp that is appended to the path
+// of an archived memory file. It sorts chronologically and avoids colons, which
+// are not allowed in file names on Windows.
+const archiveTimeFormat = "2006-01-02T15-04-05"
+
+// checkArchiveOptions returns an error if WithMaxFileAge(…) is combined with
+// options that store the state of the memory outside of the memory file.
+func (m *Storage) checkArchiveOptions() error {
+	if m.maxFileAge == 0 {
+		if m.maxArchives > 0 {
+			return errors.New("max archives require a max file age")
+		}
+		return nil
+	}
+
+	switch {
+	case m.readOnly:
+		return errors.New("a read-only memory cannot be combined with a max file age")
+	case m.shards > 0:
+		return errors.New("sharding cannot be combined with a max file age")
+	case m.logRatio > 0:
+		return errors.New("an append log cannot be combined with a max file age")
+	case m.externalThreshold > 0:
+		return errors.New("external values cannot be combined with a max file age")
+	}
+
+	return nil
+}
+
+// archivePath returns the path the memory file is moved to when it is archived
+// at the given time (see WithMaxFileAge).
+func (m *Storage) archivePath(now time.Time) string {
+	return m.path + "." + now.UTC().Format(archiveTimeFormat)
+}
+
+// archiveOldFile moves the memory file to a timestamped archive if it was last
+// modified longer ago than the configured max file age, so the memory starts
+// empty. Old archives are removed afterwards (see WithMaxArchives).
+func (m *Storage) archiveOldFile() error {
+	info, err := m.fsys.Stat(m.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check age of memory file: %w", err)
+	}
+
+	now := m.now()
+	age := now.Sub(info.ModTime())
+	if age < m.maxFileAge {
+		return nil
+	}
+
+	dest := m.archivePath(now)
+	if err := m.fsys.Rename(m.path, dest); err != nil {
+		return fmt.Errorf("failed to archive memory file: %w", err)
+	}
+
+	m.logger.Info("Archived memory file because it exceeded the max file age",
+		zap.String("path", m.path),
+		zap.String("archive_path", dest),
+		zap.Duration("age", age),
+	)
+
+	if m.maxArchives > 0 {
+		m.pruneArchives()
+	}
+
+	return nil
+}
+
+// pruneArchives removes the oldest archives of the memory file until at most
+// the configured number of archives is left. Archives are only removed to save
+// space, so errors are logged but do not prevent the memory from starting.
+func (m *Storage) pruneArchives() {
+	dir, prefix := filepath.Split(m.path + ".")
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := m.readDir(dir)
+	if err != nil {
+		m.logger.Warn("Failed to list archives of memory file", zap.String("dir", dir), zap.Error(err))
+		return
+	}
+
+	var archives []string
+	for _, e := range entries {
+		suffix := strings.TrimPrefix(e.Name(), prefix)
+		if e.IsDir() || len(suffix) == len(e.Name()) {
+			continue
+		}
+
+		if _, err := time.Parse(archiveTimeFormat, suffix); err != nil {
+			continue
+		}
+
+		archives = append(archives, filepath.Join(dir, e.Name()))
+	}
+
+	// the timestamps sort chronologically, so the oldest archives come first
+	sort.Strings(archives)
+	for len(archives) > m.maxArchives {
+		if err := m.fsys.Remove(archives[0]); err != nil {
+			m.logger.Warn("Failed to remove old archive of memory file",
+				zap.String("path", archives[0]),
+				zap.Error(err),
+			)
+		}
+		archives = archives[1:]
+	}
+}
@@ -0,0 +1,109 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWithMaxFileAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.json")
+	writeMemoryFile(t, path, map[string][]byte{"test": []byte("foo")})
+
+	// a recent file is loaded as usual
+	mem, err := NewMemory(path, WithMaxFileAge(time.Hour))
+	require.NoError(t, err)
+	value, ok, err := mem.Get("test")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "foo", string(value))
+	require.NoError(t, mem.Close())
+
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	modTime := now.Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	core, logs := observer.New(zap.InfoLevel)
+	mem, err = NewMemory(path,
+		WithMaxFileAge(24*time.Hour),
+		WithClock(&fakeClock{now: now}),
+		WithLogger(zap.New(core)),
+	)
+	require.NoError(t, err)
+
+	_, ok, err = mem.Get("test")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, mem.Close())
+
+	archived, err := NewMemory(path + ".2024-01-02T15-04-05")
+	require.NoError(t, err)
+	value, ok, err = archived.Get("test")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "foo", string(value))
+	require.NoError(t, archived.Close())
+
+	require.Equal(t, 1, logs.FilterMessage("Archived memory file because it exceeded the max file age").Len())
+}
+
+func TestWithMaxArchives(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "memory.json")
+
+	old := []string{
+		path + ".2023-12-30T00-00-00",
+		path + ".2023-12-31T00-00-00",
+		path + ".not-an-archive",
+	}
+	for _, p := range old {
+		require.NoError(t, os.WriteFile(p, []byte("{}"), 0660))
+	}
+
+	writeMemoryFile(t, path, map[string][]byte{"test": []byte("foo")})
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	modTime := now.Add(-48 * time.Hour)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+
+	mem, err := NewMemory(path,
+		WithMaxFileAge(24*time.Hour),
+		WithMaxArchives(2),
+		WithClock(&fakeClock{now: now}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	assert.NoFileExists(t, old[0])
+	assert.FileExists(t, old[1])
+	assert.FileExists(t, old[2])
+	assert.FileExists(t, path+".2024-01-02T15-04-05")
+}
+
+func TestWithMaxFileAge_Invalid(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	_, err := NewMemory(tempFile, WithMaxFileAge(0))
+	assert.EqualError(t, err, "max file age must be positive but got 0s")
+
+	_, err = NewMemory(tempFile, WithMaxArchives(0))
+	assert.EqualError(t, err, "number of archives must be positive but got 0")
+
+	_, err = NewMemory(tempFile, WithMaxArchives(3))
+	assert.EqualError(t, err, "max archives require a max file age")
+
+	_, err = NewMemory(tempFile, WithMaxFileAge(time.Hour), WithReadOnly())
+	assert.EqualError(t, err, "a read-only memory cannot be combined with a max file age")
+
+	_, err = NewMemory(tempFile, WithMaxFileAge(time.Hour), WithShards(2))
+	assert.EqualError(t, err, "sharding cannot be combined with a max file age")
+
+	_, err = NewMemory("", WithMaxFileAge(time.Hour))
+	assert.EqualError(t, err, "a memory without a path cannot be combined with a max file age")
+}
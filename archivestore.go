@@ -0,0 +1,310 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchiveStore is a Store that keeps the memory file as a single entry of a tar
+// or zip archive, e.g. so the memory can be distributed together with other
+// assets of a bot. The other entries of the archive are never changed.
+type ArchiveStore struct {
+	path  string
+	entry string
+	zip   bool
+}
+
+// NewArchiveStore returns a Store that reads and writes the entry with the
+// given name in the tar or zip archive at path. The format of the archive is
+// determined by the extension of its path, which must be either ".tar" or
+// ".zip". Each save rewrites the entire archive and then atomically replaces
+// the archive at path, so readers either see the previous or the new archive.
+// If the archive does not exist yet, it is created by the first save. Use the
+// store via NewMemoryWithStore(…).
+func NewArchiveStore(path, entry string) (*ArchiveStore, error) {
+	if entry == "" {
+		return nil, errors.New("archive entry must not be empty")
+	}
+
+	s := &ArchiveStore{path: path, entry: entry}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tar":
+	case ".zip":
+		s.zip = true
+	default:
+		return nil, fmt.Errorf("unsupported archive %q: only .tar and .zip archives are supported", path)
+	}
+
+	return s, nil
+}
+
+// Load implements Store. It returns an error that wraps fs.ErrNotExist if the
+// archive or its entry does not exist.
+func (s *ArchiveStore) Load() (io.ReadCloser, error) {
+	var content []byte
+	found := false
+	err := s.walk(func(name string, r io.Reader) error {
+		if name != s.entry {
+			return nil
+		}
+
+		var err error
+		content, err = io.ReadAll(r)
+		found = true
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, fmt.Errorf("entry %q of archive %q: %w", s.entry, s.path, fs.ErrNotExist)
+	}
+
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// Save implements Store. The content is buffered and the archive is rewritten
+// when the writer is closed.
+func (s *ArchiveStore) Save() (io.WriteCloser, error) {
+	return &archiveWriter{store: s}, nil
+}
+
+// walk calls fn for each regular file of the archive in the order of the
+// archive.
+func (s *ArchiveStore) walk(fn func(name string, r io.Reader) error) error {
+	if s.zip {
+		zr, err := zip.OpenReader(s.path)
+		if err != nil {
+			return fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+
+			r, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("failed to read entry %q of archive: %w", f.Name, err)
+			}
+
+			err = fn(f.Name, r)
+			_ = r.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// replace writes a copy of the archive in which the entry has the given
+// content to a temporary file next to the archive and renames it to the path
+// of the archive.
+func (s *ArchiveStore) replace(content []byte) error {
+	mode := fs.FileMode(0600)
+	info, err := os.Stat(s.path)
+	exists := err == nil
+	switch {
+	case exists:
+		mode = info.Mode().Perm()
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary archive: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails after a successful rename
+
+	if s.zip {
+		err = s.copyZip(tmp, content, exists)
+	} else {
+		err = s.copyTar(tmp, content, exists)
+	}
+
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// copyTar writes the entries of the current archive to w, if it exists, while
+// the entry of the store is replaced by the content. If the archive did not
+// contain the entry yet, it is appended.
+func (s *ArchiveStore) copyTar(w io.Writer, content []byte, exists bool) error {
+	tw := tar.NewWriter(w)
+	newHeader := func(prev *tar.Header) *tar.Header {
+		hdr := &tar.Header{Name: s.entry, Mode: 0600}
+		if prev != nil {
+			hdr = prev
+		}
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(len(content))
+		hdr.ModTime = time.Now()
+		return hdr
+	}
+
+	written := false
+	if exists {
+		f, err := os.Open(s.path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		tr := tar.NewReader(f)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			if hdr.Name == s.entry && hdr.Typeflag == tar.TypeReg && !written {
+				if err := tw.WriteHeader(newHeader(hdr)); err != nil {
+					return err
+				}
+				if _, err := tw.Write(content); err != nil {
+					return err
+				}
+				written = true
+				continue
+			}
+
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !written {
+		if err := tw.WriteHeader(newHeader(nil)); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// copyZip is like copyTar for zip archives. The other entries are copied
+// without decompressing them.
+func (s *ArchiveStore) copyZip(w io.Writer, content []byte, exists bool) error {
+	zw := zip.NewWriter(w)
+	writeEntry := func(prev *zip.FileHeader) error {
+		hdr := &zip.FileHeader{Name: s.entry, Method: zip.Deflate}
+		hdr.SetMode(0600)
+		if prev != nil {
+			hdr.Comment = prev.Comment
+			hdr.Method = prev.Method
+			hdr.SetMode(prev.Mode())
+		}
+		hdr.Modified = time.Now()
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(content)
+		return err
+	}
+
+	written := false
+	if exists {
+		zr, err := zip.OpenReader(s.path)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		zw.SetComment(zr.Comment)
+		for _, f := range zr.File {
+			if f.Name == s.entry && !f.FileInfo().IsDir() && !written {
+				if err := writeEntry(&f.FileHeader); err != nil {
+					return err
+				}
+				written = true
+				continue
+			}
+
+			if err := zw.Copy(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !written {
+		if err := writeEntry(nil); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// archiveWriter buffers the content of the memory file until it is closed.
+type archiveWriter struct {
+	bytes.Buffer
+	store *ArchiveStore
+}
+
+// Close rewrites the archive with the buffered content.
+func (w *archiveWriter) Close() error {
+	return w.store.replace(w.Bytes())
+}
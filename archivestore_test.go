@@ -0,0 +1,156 @@
+package file
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readArchive returns the content of all regular files of the archive by their
+// names.
+func readArchive(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	if filepath.Ext(path) == ".zip" {
+		zr, err := zip.OpenReader(path)
+		require.NoError(t, err)
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			r, err := f.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(r)
+			require.NoError(t, err)
+			files[f.Name] = string(content)
+		}
+		return files
+	}
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = string(content)
+	}
+}
+
+// writeArchive creates an archive at path that contains the given files.
+func writeArchive(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if filepath.Ext(path) == ".zip" {
+		zw := zip.NewWriter(&buf)
+		for name, content := range files {
+			w, err := zw.Create(name)
+			require.NoError(t, err)
+			_, err = w.Write([]byte(content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, zw.Close())
+	} else {
+		tw := tar.NewWriter(&buf)
+		for name, content := range files {
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+			_, err := tw.Write([]byte(content))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tw.Close())
+	}
+
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestArchiveStore(t *testing.T) {
+	for _, ext := range []string{".tar", ".zip"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "assets"+ext)
+			writeArchive(t, path, map[string]string{"README.md": "hello", "logo.svg": "<svg/>"})
+
+			store, err := NewArchiveStore(path, "state/memory.json")
+			require.NoError(t, err)
+
+			_, err = store.Load()
+			require.True(t, errors.Is(err, fs.ErrNotExist), err)
+
+			mem, err := NewMemoryWithStore(store)
+			require.NoError(t, err)
+			require.NoError(t, mem.Set("foo", []byte("bar")))
+			require.NoError(t, mem.Set("foo", []byte("baz")))
+			require.NoError(t, mem.Close())
+
+			// the other entries are kept and the entry is only contained once
+			files := readArchive(t, path)
+			assert.Len(t, files, 3)
+			assert.Equal(t, "hello", files["README.md"])
+			assert.Equal(t, "<svg/>", files["logo.svg"])
+			assert.Contains(t, files["state/memory.json"], `"foo":"YmF6"`)
+
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, fs.FileMode(0644), info.Mode().Perm())
+
+			mem, err = NewMemoryWithStore(store)
+			require.NoError(t, err)
+			defer mem.Close()
+
+			value, ok, err := mem.Get("foo")
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, []byte("baz"), value)
+
+			// no temporary files are left behind
+			entries, err := os.ReadDir(filepath.Dir(path))
+			require.NoError(t, err)
+			assert.Len(t, entries, 1)
+		})
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestArchiveStore_NewArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.tar")
+	store, err := NewArchiveStore(path, "memory.json")
+	require.NoError(t, err)
+
+	_, err = store.Load()
+	require.True(t, errors.Is(err, fs.ErrNotExist), err)
+
+	mem, err := NewMemoryWithStore(store)
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	files := readArchive(t, path)
+	assert.Len(t, files, 1)
+	assert.Contains(t, files["memory.json"], `"foo":"YmFy"`)
+}
+
+func TestNewArchiveStore_Invalid(t *testing.T) {
+	_, err := NewArchiveStore("assets.tar", "")
+	assert.EqualError(t, err, "archive entry must not be empty")
+
+	_, err = NewArchiveStore("assets.tar.gz", "memory.json")
+	assert.EqualError(t, err, `unsupported archive "assets.tar.gz": only .tar and .zip archives are supported`)
+}
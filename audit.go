@@ -0,0 +1,75 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// auditRecord is a single line of the audit log (see WithAuditLog).
+type auditRecord struct {
+	Time      time.Time `json:"time"`
+	Memory    string    `json:"memory,omitempty"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value,omitempty"`
+	ValueHash string    `json:"value_sha256,omitempty"`
+}
+
+// audit appends the given change to the audit log. It is registered as change
+// hook so only changes that were written to the memory file are recorded.
+// Errors are logged since they must not affect the change itself.
+func (m *Storage) audit(event ChangeEvent) {
+	r := auditRecord{
+		Time:   m.now().UTC(),
+		Memory: m.name,
+		Op:     event.Op.String(),
+		Key:    event.Key,
+	}
+
+	if event.Op == ChangeSet {
+		if m.auditValues {
+			r.Value = event.Value
+		} else {
+			hash := sha256.Sum256(event.Value)
+			r.ValueHash = hex.EncodeToString(hash[:])
+		}
+	}
+
+	if err := m.writeAuditRecord(r); err != nil {
+		m.logger.Error("Failed to write audit log",
+			zap.String("path", m.auditPath),
+			zap.String("key", event.Key),
+			zap.Error(err),
+		)
+	}
+}
+
+// writeAuditRecord appends the record as a single line to the audit log.
+func (m *Storage) writeAuditRecord(r auditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	f, err := m.fsys.OpenFile(m.auditPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, m.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if err == nil && m.syncWrites {
+		err = f.Sync()
+	}
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write to audit log: %w", err)
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,99 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAuditLog(t *testing.T, path string) []auditRecord {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r auditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithAuditLog(t *testing.T) {
+	tempFile := tempFilePath()
+	auditFile := tempFile + ".audit"
+	defer os.Remove(tempFile)
+	defer os.Remove(auditFile)
+
+	clock := &fakeClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	mem, err := NewMemory(tempFile, WithAuditLog(auditFile, false), WithClock(clock), WithName("test"))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("secret")))
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+
+	// deleting a missing key does not change the memory
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	records := readAuditLog(t, auditFile)
+	require.Len(t, records, 2)
+	assert.Equal(t, auditRecord{
+		Time:      clock.now,
+		Memory:    "test",
+		Op:        "set",
+		Key:       "foo",
+		ValueHash: "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b",
+	}, records[0])
+	assert.Equal(t, auditRecord{Time: clock.now, Memory: "test", Op: "delete", Key: "foo"}, records[1])
+
+	content, err := os.ReadFile(auditFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "secret")
+
+	// the audit log is appended to when the memory is opened again
+	mem, err = NewMemory(tempFile, WithAuditLog(auditFile, true))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("bar", []byte("baz")))
+	require.NoError(t, mem.Close())
+
+	records = readAuditLog(t, auditFile)
+	require.Len(t, records, 3)
+	assert.Equal(t, "bar", records[2].Key)
+	assert.Equal(t, "baz", string(records[2].Value))
+	assert.Empty(t, records[2].ValueHash)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithAuditLog_FlushInterval(t *testing.T) {
+	tempFile := tempFilePath()
+	auditFile := tempFile + ".audit"
+	defer os.Remove(tempFile)
+	defer os.Remove(auditFile)
+
+	mem, err := NewMemory(tempFile, WithAuditLog(auditFile, false), WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoFileExists(t, auditFile)
+
+	require.NoError(t, mem.Close())
+	require.Len(t, readAuditLog(t, auditFile), 1)
+}
+
+func TestWithAuditLog_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithAuditLog("", false))
+	require.EqualError(t, err, "audit log path must not be empty")
+}
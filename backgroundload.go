@@ -0,0 +1,103 @@
+package file
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// ErrNotReady is returned by operations that need the entire memory file while
+// it is still loaded in the background (see WithBackgroundLoad).
+var ErrNotReady = errors.New("memory file is still loading")
+
+// NotReadyPolicy decides how operations behave that need the entire memory file
+// while it is still being loaded via WithBackgroundLoad(…).
+type NotReadyPolicy int
+
+// The available not-ready policies.
+const (
+	// WaitForLoad blocks the operation until the file was loaded.
+	WaitForLoad NotReadyPolicy = iota
+
+	// FailNotReady fails the operation with ErrNotReady.
+	FailNotReady
+)
+
+// startBackgroundLoad loads the memory file in a background goroutine and
+// merges its content into the seed data once it is ready.
+func (m *Storage) startBackgroundLoad() {
+	m.background(func(stop <-chan struct{}) {
+		f, err := m.readMemoryFile()
+		if err == nil && f != nil {
+			err = m.checkLoadedKeys(m.path, f.data)
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if m.data == nil {
+			// memory was closed while we were loading the file
+			return
+		}
+
+		if err != nil {
+			m.logger.Error("Failed to load memory file in background",
+				zap.String("path", m.path),
+				zap.Error(err),
+			)
+			m.loadErr = err
+			close(m.loaded)
+			return
+		}
+
+		m.useFile(f)
+		if f != nil {
+			for key, value := range f.data {
+				// the value from the file wins over the seed
+				m.data[key] = value
+			}
+		}
+
+		m.logger.Info("Memory file loaded in background",
+			zap.String("path", m.path),
+			zap.Int("num_memories", len(m.data)),
+		)
+
+		close(m.loaded)
+	})
+}
+
+// isLoaded returns true if the memory file has been loaded completely.
+func (m *Storage) isLoaded() bool {
+	if m.loaded == nil {
+		return true
+	}
+
+	select {
+	case <-m.loaded:
+		return true
+	default:
+		return false
+	}
+}
+
+// awaitLoad makes sure the memory file was loaded completely before an
+// operation continues. Depending on the configured NotReadyPolicy it either
+// blocks until the file is loaded or returns ErrNotReady. If loading the file
+// failed, its error is returned.
+func (m *Storage) awaitLoad() error {
+	if m.loaded == nil {
+		return nil
+	}
+
+	if !m.isLoaded() && m.notReadyPolicy == FailNotReady {
+		return ErrNotReady
+	}
+
+	select {
+	case <-m.loaded:
+		return m.loadErr
+	case <-m.stop:
+		return ErrClosed
+	}
+}
@@ -0,0 +1,116 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestWithBackgroundLoad(t *testing.T) {
+	path := tempFilePath()
+	defer os.Remove(path)
+
+	writeMemoryFile(t, path, map[string][]byte{
+		"foo": []byte("from file"),
+		"bar": []byte("only in file"),
+	})
+
+	seed := map[string][]byte{
+		"foo":  []byte("from seed"),
+		"seed": []byte("only in seed"),
+	}
+
+	m, err := NewMemory(path,
+		WithLogger(zaptest.NewLogger(t)),
+		WithBackgroundLoad(seed, WaitForLoad),
+	)
+	require.NoError(t, err)
+
+	// a key that is not part of the seed blocks until the file was loaded
+	value, ok, err := m.Get("bar")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "only in file", string(value))
+	assert.True(t, m.isLoaded())
+
+	value, _, err = m.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "from file", string(value))
+
+	keys, err := m.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar", "foo", "seed"}, keys)
+
+	require.NoError(t, m.Set("baz", []byte("new")))
+	require.NoError(t, m.Close())
+
+	// the seed is persisted together with the content of the file
+	m, err = NewMemory(path)
+	require.NoError(t, err)
+
+	keys, err = m.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar", "baz", "foo", "seed"}, keys)
+	require.NoError(t, m.Close())
+}
+
+func TestWithBackgroundLoad_NotReady(t *testing.T) {
+	path := tempFilePath()
+	defer os.Remove(path)
+
+	seed := map[string][]byte{"foo": []byte("from seed")}
+	m, err := newMemory(path, []Option{WithBackgroundLoad(seed, FailNotReady)})
+	require.NoError(t, err)
+	m.data["foo"] = seed["foo"]
+
+	// the memory is set up like NewMemory would do but the background load is
+	// not started so we can observe the behavior while the file is loading
+	value, ok, err := m.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "from seed", string(value))
+
+	_, _, err = m.Get("bar")
+	assert.True(t, errors.Is(err, ErrNotReady))
+
+	_, err = m.Keys()
+	assert.True(t, errors.Is(err, ErrNotReady))
+
+	err = m.Set("bar", []byte("baz"))
+	assert.True(t, errors.Is(err, ErrNotReady))
+
+	m.startBackgroundLoad()
+	eventually(t, m.isLoaded)
+
+	require.NoError(t, m.Set("bar", []byte("baz")))
+	require.NoError(t, m.Close())
+}
+
+func TestWithBackgroundLoad_Error(t *testing.T) {
+	path := tempFilePath()
+	defer os.Remove(path)
+
+	require.NoError(t, os.WriteFile(path, []byte("{invalid"), 0600))
+
+	seed := map[string][]byte{"foo": []byte("from seed")}
+	m, err := NewMemory(path, WithBackgroundLoad(seed, WaitForLoad))
+	require.NoError(t, err)
+
+	err = m.Set("foo", []byte("bar"))
+	assert.Error(t, err)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "{invalid", string(content), "file must not be overwritten")
+
+	require.NoError(t, m.Close())
+}
+
+func TestWithBackgroundLoad_InvalidPolicy(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithBackgroundLoad(nil, NotReadyPolicy(42)))
+	assert.EqualError(t, err, "invalid not-ready policy 42")
+}
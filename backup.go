@@ -0,0 +1,50 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// backupPath returns the path of the n-th backup of the memory file.
+func (m *Storage) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", m.path, n)
+}
+
+// rotateBackups shifts all existing backups by one generation and copies the
+// current memory file to the first backup (see WithBackups). The memory file
+// itself is copied instead of renamed so it is never missing, even if the
+// process crashes before the new file is written. Backups are only a safety
+// net, so errors are logged but never block the actual write. The caller must
+// hold the write lock or persistMu (see writeSnapshot).
+func (m *Storage) rotateBackups() {
+	content, err := m.readAll(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// there is nothing to back up yet
+		return
+	}
+	if err != nil {
+		m.logger.Warn("Failed to read memory file for backup", zap.String("path", m.path), zap.Error(err))
+		return
+	}
+
+	for n := m.backups - 1; n >= 1; n-- {
+		err := m.fsys.Rename(m.backupPath(n), m.backupPath(n+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.logger.Warn("Failed to rotate backup of memory file",
+				zap.String("path", m.backupPath(n)),
+				zap.Error(err),
+			)
+		}
+	}
+
+	err = m.writeAll(m.backupPath(1), content, m.fileMode)
+	if err != nil {
+		m.logger.Warn("Failed to write backup of memory file",
+			zap.String("path", m.backupPath(1)),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,69 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithBackups(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".1")
+	defer os.Remove(tempFile + ".2")
+
+	mem, err := NewMemory(tempFile, WithBackups(2))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("1")))
+	assert.NoFileExists(t, tempFile+".1", "the first write has nothing to back up")
+
+	require.NoError(t, mem.Set("b", []byte("2")))
+	require.NoError(t, mem.Set("c", []byte("3")))
+	_, err = mem.Delete("a")
+	require.NoError(t, err)
+
+	backup1, err := os.ReadFile(tempFile + ".1")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version":2,"checksum":"cc025dee5777ac2bac9607df7f7afae8f183d704a09a7b47942aceb1f8d03f8d","data":{"a":"MQ==","b":"Mg==","c":"Mw=="}}`, string(backup1))
+
+	backup2, err := os.ReadFile(tempFile + ".2")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version":2,"checksum":"e4d28c3a2b9cc7f573c68c2de8067a97606f7417fb99f648f470dec0746ece09","data":{"a":"MQ==","b":"Mg=="}}`, string(backup2))
+
+	assert.NoFileExists(t, tempFile+".3")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithBackups_FailureIsNotFatal(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".1")
+
+	// a directory cannot be overwritten with the backup
+	require.NoError(t, os.Mkdir(tempFile+".1", 0755))
+
+	core, logs := observer.New(zap.WarnLevel)
+	mem, err := NewMemory(tempFile, WithBackups(1), WithLogger(zap.New(core)))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("1")))
+	require.NoError(t, mem.Set("b", []byte("2")))
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version":2,"checksum":"e4d28c3a2b9cc7f573c68c2de8067a97606f7417fb99f648f470dec0746ece09","data":{"a":"MQ==","b":"Mg=="}}`, string(content))
+	assert.Equal(t, 1, logs.FilterMessage("Failed to write backup of memory file").Len())
+}
+
+func TestWithBackups_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithBackups(0))
+	require.EqualError(t, err, "number of backups must be positive but got 0")
+}
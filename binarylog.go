@@ -0,0 +1,199 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// binaryRecordMarker is the first byte of each binary record of the append log
+// (see WithBinaryAppendLog). JSON records always start with "{", so both kinds
+// of records can be mixed in a single log.
+const binaryRecordMarker = 0xb1
+
+// flags of a binary record
+const (
+	binaryRecordDeleted = 1 << iota
+	binaryRecordNil
+	binaryRecordVersion
+	binaryRecordExpires
+	binaryRecordCRC32
+	binaryRecordType
+)
+
+// errTruncatedRecord is returned by decodeBinaryRecord if the content ends
+// before the record is complete.
+var errTruncatedRecord = errors.New("truncated record")
+
+// checkBinaryLogOptions returns an error if WithBinaryAppendLog() is used
+// without an append log.
+func (m *Storage) checkBinaryLogOptions() error {
+	if m.binaryLog && m.logRatio == 0 {
+		return errors.New("a binary append log requires an append log")
+	}
+
+	return nil
+}
+
+// appendBinaryRecord appends the binary encoding of the record to buf. A record
+// consists of the marker, the length of its payload, the payload and the CRC-32
+// checksum of the payload, so a record that was only written partially is
+// detected when the log is replayed. The payload starts with the flags of the
+// record and the length-prefixed key, followed by the length-prefixed value
+// and the version, expiry, value checksum and content type if the record has
+// them.
+func appendBinaryRecord(buf []byte, r logRecord) []byte {
+	var flags byte
+	switch {
+	case r.Deleted:
+		flags |= binaryRecordDeleted
+	case r.Value == nil:
+		flags |= binaryRecordNil
+	}
+	if r.Version != nil {
+		flags |= binaryRecordVersion
+	}
+	if r.Expires != nil {
+		flags |= binaryRecordExpires
+	}
+	if r.CRC32 != nil {
+		flags |= binaryRecordCRC32
+	}
+	if r.Type != "" {
+		flags |= binaryRecordType
+	}
+
+	payload := []byte{flags}
+	payload = binary.AppendUvarint(payload, uint64(len(r.Key)))
+	payload = append(payload, r.Key...)
+	if flags&(binaryRecordDeleted|binaryRecordNil) == 0 {
+		payload = binary.AppendUvarint(payload, uint64(len(r.Value)))
+		payload = append(payload, r.Value...)
+	}
+	if r.Version != nil {
+		payload = binary.AppendUvarint(payload, *r.Version)
+	}
+	if r.Expires != nil {
+		payload = binary.AppendVarint(payload, r.Expires.UnixNano())
+	}
+	if r.CRC32 != nil {
+		payload = binary.LittleEndian.AppendUint32(payload, *r.CRC32)
+	}
+	if r.Type != "" {
+		payload = binary.AppendUvarint(payload, uint64(len(r.Type)))
+		payload = append(payload, r.Type...)
+	}
+
+	buf = append(buf, binaryRecordMarker)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	buf = append(buf, payload...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+}
+
+// decodeBinaryRecord decodes the binary record at the start of the content and
+// returns it together with its length in bytes. If the content ends before the
+// record is complete, errTruncatedRecord is returned.
+func decodeBinaryRecord(content []byte) (logRecord, int, error) {
+	size, n := binary.Uvarint(content[1:])
+	if n < 0 {
+		return logRecord{}, 0, errors.New("invalid record length")
+	}
+	if n == 0 {
+		return logRecord{}, 0, errTruncatedRecord
+	}
+
+	// the size is not trusted, so adding to it could overflow
+	start := 1 + n
+	if len(content)-start < crc32.Size || size > uint64(len(content)-start-crc32.Size) {
+		return logRecord{}, 0, errTruncatedRecord
+	}
+
+	end := start + int(size)
+	payload := content[start:end]
+	if binary.LittleEndian.Uint32(content[end:]) != crc32.ChecksumIEEE(payload) {
+		return logRecord{}, 0, errors.New("record checksum mismatch")
+	}
+
+	r, err := decodeBinaryPayload(payload)
+	return r, end + crc32.Size, err
+}
+
+// decodeBinaryPayload decodes the payload of a binary record that was written
+// by appendBinaryRecord.
+func decodeBinaryPayload(payload []byte) (logRecord, error) {
+	var r logRecord
+	if len(payload) == 0 {
+		return r, errors.New("empty record")
+	}
+
+	flags, payload := payload[0], payload[1:]
+	r.Deleted = flags&binaryRecordDeleted != 0
+
+	key, payload, err := readBinaryBytes(payload)
+	if err != nil {
+		return r, fmt.Errorf("invalid key: %w", err)
+	}
+	r.Key = string(key)
+
+	if flags&(binaryRecordDeleted|binaryRecordNil) == 0 {
+		r.Value, payload, err = readBinaryBytes(payload)
+		if err != nil {
+			return r, fmt.Errorf("invalid value of key %q: %w", r.Key, err)
+		}
+		r.Value = append([]byte{}, r.Value...)
+	}
+
+	if flags&binaryRecordVersion != 0 {
+		version, n := binary.Uvarint(payload)
+		if n <= 0 {
+			return r, fmt.Errorf("invalid version of key %q", r.Key)
+		}
+		r.Version, payload = &version, payload[n:]
+	}
+
+	if flags&binaryRecordExpires != 0 {
+		nanos, n := binary.Varint(payload)
+		if n <= 0 {
+			return r, fmt.Errorf("invalid expiry of key %q", r.Key)
+		}
+		expires := time.Unix(0, nanos).UTC()
+		r.Expires, payload = &expires, payload[n:]
+	}
+
+	if flags&binaryRecordCRC32 != 0 {
+		if len(payload) < crc32.Size {
+			return r, fmt.Errorf("invalid value checksum of key %q", r.Key)
+		}
+		checksum := binary.LittleEndian.Uint32(payload)
+		r.CRC32, payload = &checksum, payload[crc32.Size:]
+	}
+
+	if flags&binaryRecordType != 0 {
+		var contentType []byte
+		contentType, payload, err = readBinaryBytes(payload)
+		if err != nil {
+			return r, fmt.Errorf("invalid content type of key %q: %w", r.Key, err)
+		}
+		r.Type = string(contentType)
+	}
+
+	if len(payload) > 0 {
+		return r, fmt.Errorf("unexpected content after record of key %q", r.Key)
+	}
+
+	return r, nil
+}
+
+// readBinaryBytes reads a length-prefixed byte slice from the payload and
+// returns it together with the rest of the payload.
+func readBinaryBytes(payload []byte) (b, rest []byte, err error) {
+	size, n := binary.Uvarint(payload)
+	if n <= 0 || uint64(len(payload)-n) < size {
+		return nil, nil, errors.New("invalid length")
+	}
+
+	end := n + int(size)
+	return payload[n:end], payload[end:], nil
+}
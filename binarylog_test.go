@@ -0,0 +1,173 @@
+package file
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryRecord(t *testing.T) {
+	version := uint64(3)
+	expires := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	checksum := uint32(42)
+
+	records := []logRecord{
+		{Key: "foo", Value: []byte("bar")},
+		{Key: "nil", Value: nil},
+		{Key: "empty", Value: []byte{}},
+		{Key: "deleted", Deleted: true},
+		{Key: "meta", Value: []byte{0, 1, 2}, Version: &version, Expires: &expires, CRC32: &checksum},
+		{Key: "typed", Value: []byte("{}"), Type: "application/json"},
+	}
+
+	for _, r := range records {
+		b := appendBinaryRecord(nil, r)
+		decoded, n, err := decodeLogRecord(b)
+		require.NoError(t, err, r.Key)
+		assert.Equal(t, len(b), n, r.Key)
+		assert.Equal(t, r, decoded, r.Key)
+
+		_, _, err = decodeLogRecord(b[:len(b)-1])
+		assert.Equal(t, errTruncatedRecord, err, r.Key)
+
+		b[len(b)-crc32.Size-1] ^= 0xff
+		_, _, err = decodeLogRecord(b)
+		assert.EqualError(t, err, "record checksum mismatch", r.Key)
+	}
+}
+
+func TestBinaryRecord_CorruptLength(t *testing.T) {
+	b := append([]byte{binaryRecordMarker}, binary.AppendUvarint(nil, ^uint64(0))...)
+	b = append(b, make([]byte, 8)...)
+	_, _, err := decodeLogRecord(b)
+	assert.Equal(t, errTruncatedRecord, err)
+
+	// a varint that does not fit into 64 bits
+	b = append([]byte{binaryRecordMarker}, bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64)...)
+	b = append(b, 0x01)
+	_, _, err = decodeLogRecord(b)
+	assert.EqualError(t, err, "invalid record length")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithAppendLog_CorruptBinaryLength(t *testing.T) {
+	path := tempFilePath()
+	defer os.Remove(path)
+	defer os.Remove(path + ".log")
+
+	b := append([]byte{binaryRecordMarker}, binary.AppendUvarint(nil, ^uint64(0))...)
+	b = append(b, make([]byte, 8)...)
+	require.NoError(t, os.WriteFile(path+".log", b, 0600))
+
+	// the log is not written with WithBinaryAppendLog, but may still contain
+	// binary records
+	mem, err := NewMemory(path, WithAppendLog(2))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithBinaryAppendLog(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	// the log may contain JSON records that were written without the option
+	mem, err := NewMemory(tempFile, WithAppendLog(1))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("json", []byte("foo")))
+	require.NoError(t, mem.Close())
+
+	clock := &fakeClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	mem, err = NewMemory(tempFile, WithAppendLog(1), WithBinaryAppendLog(), WithClock(clock))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("binary", []byte("bar")))
+	require.NoError(t, mem.SetWithTTL("ttl", []byte("baz"), time.Hour))
+	_, err = mem.Delete("json")
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	// only the log is written
+	assert.NoFileExists(t, tempFile)
+
+	// a partially written record is skipped
+	f, err := os.OpenFile(tempFile+".log", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	b := appendBinaryRecord(nil, logRecord{Key: "partial", Value: []byte("qux")})
+	_, err = f.Write(b[:len(b)/2])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	mem, err = NewMemory(tempFile, WithAppendLog(1), WithClock(clock))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"binary", "ttl"}, keys)
+
+	value, ok, err := mem.Get("ttl")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "baz", string(value))
+
+	// the expiry of the key was kept as well
+	clock.Advance(2 * time.Hour)
+	_, ok, err = mem.Get("ttl")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestWithBinaryAppendLog_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithBinaryAppendLog())
+	require.EqualError(t, err, "a binary append log requires an append log")
+}
+
+// BenchmarkMemory_SetAppendLog measures the cost of a Set for memories of
+// different sizes. With an append log, the cost does not depend on the number
+// of keys, unlike rewriting the entire memory file on each change.
+func BenchmarkMemory_SetAppendLog(b *testing.B) {
+	modes := map[string][]Option{
+		"rewrite": nil,
+		"json":    {WithAppendLog(0.5)},
+		"binary":  {WithAppendLog(0.5), WithBinaryAppendLog()},
+	}
+
+	for _, name := range []string{"rewrite", "json", "binary"} {
+		for _, size := range []int{1000, 10000, 100000} {
+			b.Run(fmt.Sprintf("%s/keys=%d", name, size), func(b *testing.B) {
+				path := filepath.Join(b.TempDir(), "memory.json")
+				data := make(map[string][]byte, size)
+				for i := 0; i < size; i++ {
+					data[fmt.Sprintf("key-%d", i)] = []byte("some value of a key")
+				}
+
+				mem, err := NewMemory(path, modes[name]...)
+				require.NoError(b, err)
+				require.NoError(b, mem.SetMany(data))
+				defer mem.Close()
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					err := mem.Set(fmt.Sprintf("key-%d", i%size), []byte(fmt.Sprintf("value %d", i)))
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package file
+
+// ChangeOp is the kind of operation that is described by a ChangeEvent.
+type ChangeOp int
+
+// The operations that are reported via WithChangeHook(…).
+const (
+	ChangeSet ChangeOp = iota + 1
+	ChangeDelete
+)
+
+// String returns a human readable name of the operation.
+func (op ChangeOp) String() string {
+	switch op {
+	case ChangeSet:
+		return "set"
+	case ChangeDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// ChangeEvent describes a change of the memory that was written to the memory
+// file (see WithChangeHook).
+type ChangeEvent struct {
+	Op    ChangeOp
+	Key   string
+	Value []byte // the new value or nil if the key was deleted
+}
+
+// changed records a change of the given key that was applied successfully. It
+// marks the key as modified, informs the watchers of the key and passes the
+// change to the change hooks. The caller must hold the write lock.
+func (m *Storage) changed(key string, event WatchEvent) {
+	m.markModified(key)
+	m.notifyWatchers(key, event)
+
+	if len(m.changeHooks) == 0 {
+		return
+	}
+
+	change := ChangeEvent{Op: ChangeSet, Key: key, Value: event.Value}
+	if event.Deleted {
+		change.Op = ChangeDelete
+	}
+
+	if m.dirty {
+		// the change is reported once it was flushed to disk
+		m.pendingChanges = append(m.pendingChanges, change)
+		return
+	}
+
+	m.runChangeHooks(change)
+}
+
+// flushChanges passes all changes that were waiting for a flush to the change
+// hooks. The caller must hold the write lock.
+func (m *Storage) flushChanges() {
+	changes := m.pendingChanges
+	m.pendingChanges = nil
+	for _, change := range changes {
+		m.runChangeHooks(change)
+	}
+}
+
+// runChangeHooks calls all change hooks with the given event. A panic in a hook
+// is recovered and logged so it does not affect the other hooks.
+func (m *Storage) runChangeHooks(event ChangeEvent) {
+	for _, hook := range m.changeHooks {
+		func() {
+			defer func() { _ = m.recoverPanic("change hook", recover()) }()
+			hook(event)
+		}()
+	}
+}
@@ -0,0 +1,87 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithChangeHook(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	var first, second []ChangeEvent
+	mem, err := NewMemory(tempFile,
+		WithChangeHook(func(event ChangeEvent) { first = append(first, event) }),
+		WithChangeHook(func(event ChangeEvent) { second = append(second, event) }),
+		WithMaxSerializedSize(200),
+	)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+
+	// rejected changes are never reported
+	err = mem.Set("foo", make([]byte, 200))
+	assert.True(t, errors.Is(err, ErrMaxSerializedSize))
+
+	expected := []ChangeEvent{
+		{Op: ChangeSet, Key: "foo", Value: []byte("bar")},
+		{Op: ChangeDelete, Key: "foo"},
+	}
+	assert.Equal(t, expected, first)
+	assert.Equal(t, expected, second)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithChangeHook_FlushInterval(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	var events []ChangeEvent
+	mem, err := NewMemory(tempFile,
+		WithChangeHook(func(event ChangeEvent) { events = append(events, event) }),
+		WithFlushInterval(time.Hour),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	assert.Empty(t, events)
+
+	require.NoError(t, mem.Close())
+	assert.Equal(t, []ChangeEvent{{Op: ChangeSet, Key: "foo", Value: []byte("bar")}}, events)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithChangeHook_Panic(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	var called bool
+	core, logs := observer.New(zap.ErrorLevel)
+	mem, err := NewMemory(tempFile,
+		WithChangeHook(func(ChangeEvent) { panic("hook is broken") }),
+		WithChangeHook(func(ChangeEvent) { called = true }),
+		WithLogger(zap.New(core)),
+	)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	assert.True(t, called)
+	assert.Equal(t, 1, logs.FilterField(zap.String("source", "change hook")).Len())
+}
+
+func TestWithChangeHook_Nil(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithChangeHook(nil))
+	require.EqualError(t, err, "change hook must not be nil")
+}
@@ -0,0 +1,260 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync/atomic"
+)
+
+// Change describes a single modification of a memory. If Deleted is true, the
+// key is removed from the memory and Value is ignored.
+type Change struct {
+	Key     string
+	Value   []byte
+	Deleted bool
+}
+
+// ApplyChangeset applies all changes in the given order and then persists the
+// memory once. This can be used to mirror the changes of one memory into
+// another one without transferring the entire file. Other operations cannot
+// observe the memory while only some of the changes have been applied.
+//
+// If any key is invalid, no change is applied. If the memory file cannot be
+// written because the change was rejected (e.g. via WithMaxSerializedSize), all
+// changes are reverted. If the file could not be written for any other reason,
+// the error is returned but the changes stay applied in memory.
+func (m *Storage) ApplyChangeset(changes []Change) error {
+	changes, stored, err := m.prepareChanges(changes)
+	if err != nil {
+		return err
+	}
+
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	return m.apply(changes, stored)
+}
+
+// prepareChanges normalizes the keys of the changes and validates them. It
+// returns the normalized changes together with their stored (i.e. sealed)
+// values.
+func (m *Storage) prepareChanges(changes []Change) ([]Change, [][]byte, error) {
+	if m.keyNormalizer != nil || m.keyHasher != nil {
+		normalized := make([]Change, len(changes))
+		for i, c := range changes {
+			c.Key = m.normalizeKey(c.Key)
+			normalized[i] = c
+		}
+		changes = normalized
+	}
+
+	stored := make([][]byte, len(changes))
+	for i, c := range changes {
+		if c.Deleted {
+			if err := m.validateKey(c.Key); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if err := m.checkKey(c.Key); err != nil {
+			return nil, nil, err
+		}
+
+		if err := m.checkValue(c.Key, c.Value); err != nil {
+			return nil, nil, err
+		}
+
+		m.inspectValue(c.Key, c.Value)
+
+		var err error
+		stored[i], err = m.sealValue(c.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return changes, stored, nil
+}
+
+// apply applies the changes using the given stored (i.e. sealed) values and
+// then persists the memory once. The caller must hold the write lock.
+func (m *Storage) apply(changes []Change, stored [][]byte) error {
+	if err := m.checkChangeLimits(changes, stored); err != nil {
+		return err
+	}
+
+	// remember the state before any change so we can revert all of them
+	prev := map[string]entry{}
+	for _, c := range changes {
+		if _, ok := prev[c.Key]; !ok {
+			prev[c.Key] = m.entry(c.Key)
+		}
+	}
+
+	for i, c := range changes {
+		if c.Deleted {
+			m.remove(c.Key)
+		} else {
+			m.put(c.Key, stored[i])
+		}
+	}
+
+	err := m.commit(context.Background())
+	if isRejected(err) {
+		// the file was not written so we revert the changes to stay consistent
+		for key, p := range prev {
+			m.restore(key, p)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, c := range changes {
+		if c.Deleted {
+			m.changed(c.Key, WatchEvent{Deleted: true})
+		} else {
+			m.changed(c.Key, WatchEvent{Value: c.Value})
+		}
+	}
+
+	return nil
+}
+
+// SetMany assigns all given keys to their values and then persists the memory
+// once, instead of rewriting the memory file for each key. If the change is
+// rejected (e.g. via WithMaxSerializedSize), no value is changed. If the file
+// could not be written for any other reason, the error is returned but the
+// values are updated in memory, just like with Set.
+func (m *Storage) SetMany(values map[string][]byte) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := make([]Change, len(keys))
+	for i, key := range keys {
+		changes[i] = Change{Key: key, Value: values[key]}
+	}
+
+	return m.ApplyChangeset(changes)
+}
+
+// ConditionalSet assigns all keys of writes to their values and persists the
+// memory once, but only if each key of conds currently has the given value.
+// This is like CompareAndSwap for multiple keys: the conditions are checked and
+// the values are written while the memory is locked, so no other operation can
+// change any of the keys in between. The boolean return value indicates whether
+// all conditions matched and the values were written. If any condition does
+// not match, nothing is changed and false is returned.
+//
+// Just like with CompareAndSwap, an empty (or nil) value in conds matches a key
+// that does not exist, while a non-empty value never matches an absent key.
+// Expired keys do not exist (see SetWithTTL).
+//
+// An error is returned if the memory was closed already, if any key or value is
+// invalid or if the memory file could not be written. If the write was rejected
+// (e.g. via WithMaxSerializedSize), no value is changed and false is returned.
+func (m *Storage) ConditionalSet(conds, writes map[string][]byte) (bool, error) {
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := make([]Change, len(keys))
+	for i, key := range keys {
+		changes[i] = Change{Key: key, Value: writes[key]}
+	}
+
+	changes, stored, err := m.prepareChanges(changes)
+	if err != nil {
+		return false, err
+	}
+
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.unlock()
+
+	now := m.now()
+	for key, expected := range conds {
+		key = m.normalizeKey(key)
+		value, ok := m.data[key]
+		if !ok || m.isExpired(key, now) {
+			if len(expected) > 0 {
+				return false, nil
+			}
+			continue
+		}
+
+		current, err := m.openValue(value)
+		if err != nil {
+			return false, err
+		}
+
+		if !bytes.Equal(current, expected) {
+			return false, nil
+		}
+	}
+
+	err = m.apply(changes, stored)
+	if isRejected(err) {
+		return false, err
+	}
+
+	return true, err
+}
+
+// GetMany returns the values of all given keys that exist in the memory, while
+// acquiring the lock of the memory only once instead of once for each key.
+// Missing keys are not contained in the returned map, so it is empty if none of
+// the keys exist.
+//
+// An error is only returned if this function is called after the memory was
+// closed already or if the memory file could not be loaded in the background
+// (see WithBackgroundLoad).
+func (m *Storage) GetMany(keys []string) (map[string][]byte, error) {
+	if err := m.awaitLoad(); err != nil {
+		return nil, err
+	}
+
+	if err := m.rlock(); err != nil {
+		return nil, err
+	}
+	defer m.runlock()
+
+	atomic.AddUint64(&m.numGets, uint64(len(keys)))
+	now := m.now()
+	result := make(map[string][]byte, len(keys))
+	for _, requested := range keys {
+		key := m.normalizeKey(requested)
+		stored, ok := m.data[key]
+		if !ok || m.isExpired(key, now) {
+			continue
+		}
+
+		if ok, err := m.verifyValue(key, stored); !ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		value, err := m.openValue(stored)
+		if err != nil {
+			return nil, err
+		}
+
+		m.touch(key)
+		result[requested] = value
+	}
+
+	return result, nil
+}
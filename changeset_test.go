@@ -0,0 +1,193 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_ApplyChangeset(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	writeMemoryFile(t, tempFile, map[string][]byte{
+		"foo": []byte("bar"),
+		"old": []byte("value"),
+	})
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	err = mem.ApplyChangeset([]Change{
+		{Key: "foo", Value: []byte("baz")},
+		{Key: "new", Value: []byte("first")},
+		{Key: "new", Value: []byte("second")},
+		{Key: "old", Deleted: true},
+	})
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo", "new"}, keys)
+
+	val, _, err := mem.Get("new")
+	require.NoError(t, err)
+	require.Equal(t, "second", string(val))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_ApplyChangesetRollback(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	// {"version":2,"checksum":"…","data":{"foo":"YmFy"}}\n has 114 bytes
+	mem, err := NewMemory(tempFile, WithMaxSerializedSize(114))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	err = mem.ApplyChangeset([]Change{
+		{Key: "foo", Deleted: true},
+		{Key: "other", Value: []byte("too long")},
+	})
+	require.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, keys)
+
+	val, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(val))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetMany(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	err = mem.SetMany(map[string][]byte{
+		"foo": []byte("1"),
+		"bar": []byte("2"),
+	})
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, `{"version":2,"checksum":"6e6fcb27fea419f4be08c75f61943bab9bc9a47da1eb2796b3102316ddb50f4c","data":{"bar":"Mg==","foo":"MQ=="}}`+"\n", string(content))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_GetMany(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.SetMany(map[string][]byte{
+		"foo": []byte("1"),
+		"bar": []byte("2"),
+		"baz": []byte("3"),
+	}))
+
+	values, err := mem.GetMany([]string{"foo", "bar", "missing"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"foo": []byte("1"), "bar": []byte("2")}, values)
+
+	values, err = mem.GetMany([]string{"missing"})
+	require.NoError(t, err)
+	require.NotNil(t, values)
+	require.Empty(t, values)
+
+	require.NoError(t, mem.Close())
+	_, err = mem.GetMany([]string{"foo"})
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_ConditionalSet(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.SetMany(map[string][]byte{"owner": []byte("me"), "version": []byte("5")}))
+
+	writes := map[string][]byte{"a": []byte("1"), "b": []byte("2"), "version": []byte("6")}
+
+	// a single condition that does not match rejects all writes
+	for _, conds := range []map[string][]byte{
+		{"owner": []byte("me"), "version": []byte("4")},
+		{"owner": []byte("you"), "version": []byte("5")},
+		{"owner": []byte("me"), "missing": []byte("x")},
+		{"owner": nil},
+	} {
+		ok, err := mem.ConditionalSet(conds, writes)
+		require.NoError(t, err)
+		require.False(t, ok, conds)
+
+		keys, err := mem.Keys()
+		require.NoError(t, err)
+		require.Equal(t, []string{"owner", "version"}, keys)
+
+		value, _, err := mem.Get("version")
+		require.NoError(t, err)
+		require.Equal(t, "5", string(value))
+	}
+
+	// all conditions match, including a key that must not exist
+	events, cancel := mem.Watch("b")
+	defer cancel()
+	ok, err := mem.ConditionalSet(map[string][]byte{"owner": []byte("me"), "version": []byte("5"), "b": nil}, writes)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, WatchEvent{Value: []byte("2")}, <-events)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	values, err := mem.GetMany([]string{"a", "b", "owner", "version"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"a": []byte("1"), "b": []byte("2"), "owner": []byte("me"), "version": []byte("6")}, values)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_ConditionalSetRejected(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMaxSerializedSize(200))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("owner", []byte("me")))
+
+	ok, err := mem.ConditionalSet(
+		map[string][]byte{"owner": []byte("me")},
+		map[string][]byte{"owner": []byte("you"), "big": make([]byte, 200)},
+	)
+	require.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+	require.False(t, ok)
+
+	value, _, err := mem.Get("owner")
+	require.NoError(t, err)
+	require.Equal(t, "me", string(value))
+}
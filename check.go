@@ -0,0 +1,111 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// CheckReport is the result of checking the integrity of a memory file via
+// Check(…).
+type CheckReport struct {
+	Path    string // the path of the checked memory file
+	NumKeys int    // the number of keys in the file, including corrupt ones
+
+	// ChecksumMismatch is true if the data of the file does not match the
+	// checksum that was stored when the file was written.
+	ChecksumMismatch bool
+
+	// CorruptKeys contains the sorted keys whose values do not match their
+	// checksums (see WithValueChecksums).
+	CorruptKeys []string
+
+	// Repaired is true if the file was rewritten without the corrupt keys.
+	Repaired bool
+}
+
+// OK returns true if no issue was found.
+func (r *CheckReport) OK() bool {
+	return !r.ChecksumMismatch && len(r.CorruptKeys) == 0
+}
+
+// Check verifies the integrity of the memory file at the given path without
+// starting a memory, so it can be used while no bot is running (e.g. after a
+// crash or after the file was edited by hand). It verifies that the file can
+// be decoded with the given options, that its data matches the stored checksum
+// and that each value matches its own checksum, if the file contains value
+// checksums (see WithValueChecksums). All issues are returned in the report.
+//
+// If repair is true and any issue was found, the file is rewritten in the
+// format of the given options without the corrupt values and with a new
+// checksum, just like Normalize(…). Since a checksum mismatch of the entire
+// file cannot be attributed to specific keys without value checksums, such a
+// file is rewritten with all of its keys.
+//
+// An error is returned if the file does not exist or cannot be decoded at all.
+func Check(path string, repair bool, opts ...Option) (*CheckReport, error) {
+	memory, err := newMemory(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	path = memory.path
+
+	defer memory.releaseFileLock()
+
+	if memory.shards > 0 {
+		return nil, errors.New("sharded memories cannot be checked")
+	}
+
+	if memory.logRatio > 0 {
+		return nil, errors.New("memories with an append log cannot be checked")
+	}
+
+	report := &CheckReport{Path: path}
+
+	// the checksum of the file is verified even if there are value checksums
+	valueChecksums := memory.valueChecksums
+	memory.valueChecksums = false
+	f, err := memory.readFile(path)
+	if errors.Is(err, ErrChecksumMismatch) {
+		report.ChecksumMismatch = true
+		memory.skipChecksum = true
+		f, err = memory.readFile(path)
+	}
+	memory.valueChecksums = valueChecksums
+	if err != nil {
+		return nil, err
+	}
+
+	if f == nil {
+		return nil, fmt.Errorf("memory file %q does not exist", path)
+	}
+
+	report.NumKeys = len(f.data)
+	for key, value := range f.data {
+		if checksum, ok := f.checksums[key]; ok && valueChecksum(value) != checksum {
+			report.CorruptKeys = append(report.CorruptKeys, key)
+		}
+	}
+	sort.Strings(report.CorruptKeys)
+
+	if !repair || report.OK() {
+		return report, nil
+	}
+
+	for _, key := range report.CorruptKeys {
+		delete(f.data, key)
+	}
+
+	memory.data = f.data
+	memory.useFile(f)
+	memory.indexValues()
+	err = memory.persist(context.Background())
+	if err != nil {
+		return report, err
+	}
+
+	report.Repaired = true
+	return report, nil
+}
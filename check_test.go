@@ -0,0 +1,101 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestCheck(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithValueChecksums(false))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("ok", []byte("qux")))
+	require.NoError(t, mem.Close())
+
+	report, err := Check(tempFile, false)
+	require.NoError(t, err)
+	assert.Equal(t, &CheckReport{Path: tempFile, NumKeys: 2}, report)
+	assert.True(t, report.OK())
+
+	// corrupt the value of foo
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	content = bytes.Replace(content, []byte(`"YmFy"`), []byte(`"YmF6"`), 1)
+	require.NoError(t, os.WriteFile(tempFile, content, 0600))
+
+	report, err = Check(tempFile, false)
+	require.NoError(t, err)
+	assert.Equal(t, &CheckReport{
+		Path:             tempFile,
+		NumKeys:          2,
+		ChecksumMismatch: true,
+		CorruptKeys:      []string{"foo"},
+	}, report)
+	assert.False(t, report.OK())
+
+	// the file is not changed without repair
+	unchanged, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, content, unchanged)
+
+	report, err = Check(tempFile, true, WithValueChecksums(false))
+	require.NoError(t, err)
+	assert.True(t, report.Repaired)
+	assert.Equal(t, []string{"foo"}, report.CorruptKeys)
+
+	report, err = Check(tempFile, false)
+	require.NoError(t, err)
+	assert.Equal(t, &CheckReport{Path: tempFile, NumKeys: 1}, report)
+
+	mem, err = NewMemory(tempFile, WithValueChecksums(false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ok"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestCheck_ChecksumMismatch(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"version":2,"checksum":"invalid","data":{"foo":"YmFy"}}`), 0600))
+
+	report, err := Check(tempFile, true)
+	require.NoError(t, err)
+	assert.Equal(t, &CheckReport{Path: tempFile, NumKeys: 1, ChecksumMismatch: true, Repaired: true}, report)
+
+	// the file was rewritten with a valid checksum
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+}
+
+func TestCheck_Invalid(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	_, err := Check(tempFile, false)
+	assert.EqualError(t, err, `memory file "`+tempFile+`" does not exist`)
+
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":`), 0600))
+	_, err = Check(tempFile, true)
+	assert.Error(t, err)
+
+	_, err = Check(tempFile, false, WithAppendLog(1))
+	assert.EqualError(t, err, "memories with an append log cannot be checked")
+}
@@ -0,0 +1,26 @@
+package file
+
+import "time"
+
+// Clock tells the memory what time it is (see WithClock).
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the default Clock which returns the current time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// now returns the current time of the configured clock. It is used for all
+// points in time that affect the memory or the memory file (e.g. expiries and
+// timestamps) but not to measure how long an operation took.
+func (m *Storage) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+
+	return m.clock.Now()
+}
@@ -0,0 +1,71 @@
+package file
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock that only moves forward when the test advances it.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithClock(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	clock := &fakeClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	mem, err := NewMemory(tempFile, WithClock(clock), WithTimestampHeader())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.SetWithTTL("code", []byte("1234"), time.Hour))
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.True(t, strings.Contains(string(content), `"written_at":"2020-01-02T03:04:05Z"`), string(content))
+
+	clock.Advance(time.Hour - time.Second)
+	_, ok, err := mem.Get("code")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	watch, unsubscribe := mem.Watch("code")
+	defer unsubscribe()
+
+	clock.Advance(time.Second)
+	_, ok, err = mem.Get("code")
+	require.NoError(t, err)
+	require.False(t, ok, "expired values must not be returned")
+
+	mem.expire()
+	require.Equal(t, WatchEvent{Deleted: true}, <-watch)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestWithClock_Nil(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithClock(nil))
+	require.EqualError(t, err, "clock must not be nil")
+}
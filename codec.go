@@ -0,0 +1,30 @@
+package file
+
+import (
+	"fmt"
+	"io"
+)
+
+// Codec encodes the data of a memory into the content of the memory file and
+// decodes it again (see WithCodec).
+type Codec interface {
+	Marshal(data map[string][]byte) ([]byte, error)
+	Unmarshal(content []byte, data *map[string][]byte) error
+}
+
+// decodeWithCodec reads the entire content from r and decodes it with the
+// configured codec.
+func (m *Storage) decodeWithCodec(r io.Reader) (*document, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	doc := &document{Version: formatVersion}
+	err = m.codec.Unmarshal(content, &doc.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode data: %w", err)
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,60 @@
+package file
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(data map[string][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(data)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(content []byte, data *map[string][]byte) error {
+	return gob.NewDecoder(bytes.NewReader(content)).Decode(data)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCodec(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithCodec(gobCodec{}))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+
+	var data map[string][]byte
+	require.NoError(t, gobCodec{}.Unmarshal(content, &data))
+	require.Equal(t, map[string][]byte{"foo": []byte("bar")}, data)
+
+	mem, err = NewMemory(tempFile, WithCodec(gobCodec{}))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	val, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(val))
+
+	_, err = mem.SetWithVersion("foo", []byte("baz"), 0)
+	require.EqualError(t, err, "versions can only be persisted in the JSON format")
+}
+
+func TestWithCodec_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithCodec(nil))
+	require.EqualError(t, err, "codec must not be nil")
+
+	_, err = NewMemory(tempFilePath(), WithCodec(gobCodec{}), WithTimestampHeader())
+	require.EqualError(t, err, "a custom codec cannot be combined with options that require the JSON format")
+}
@@ -0,0 +1,97 @@
+package file
+
+import "sort"
+
+// Compact deletes all keys for which remove returns true and then persists the
+// memory once. If remove is nil, all keys with an empty value are deleted,
+// which is useful if empty values are used to mark keys as deleted. Unlike
+// Delete, this selects the keys by their values, so stale entries can be
+// cleaned up in bulk (e.g. periodically). The number of deleted keys is
+// returned. If no key was deleted, the memory file is not written.
+//
+// The memory is locked while remove is called, so it must not call any method
+// of the memory or it will deadlock. Just like with ApplyChangeset(…), all
+// deletions are reverted if they are rejected by persist.
+func (m *Storage) Compact(remove func(key string, value []byte) bool) (int, error) {
+	if remove == nil {
+		remove = func(_ string, value []byte) bool { return len(value) == 0 }
+	}
+
+	return m.deleteWhere(func(key string, stored []byte) (bool, error) {
+		value, err := m.openValue(stored)
+		if err != nil {
+			return false, err
+		}
+
+		return remove(key, value), nil
+	})
+}
+
+// Clear deletes all keys and persists the now empty memory. Unlike Close, the
+// memory can still be used afterwards. Just like with ApplyChangeset(…), the
+// memory is not changed if the file cannot be written because the change was
+// rejected (e.g. via WithConflictDetection).
+func (m *Storage) Clear() error {
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := make([]Change, len(keys))
+	for i, key := range keys {
+		changes[i] = Change{Key: key, Deleted: true}
+	}
+
+	err := m.apply(changes, make([][]byte, len(changes)))
+	if len(m.data) == 0 {
+		// release the memory of the old map
+		m.data = map[string][]byte{}
+	}
+
+	return err
+}
+
+// deleteWhere deletes all keys for which match returns true with a single
+// persist and returns the number of deleted keys. Match is called with the
+// stored (i.e. sealed) values while the write lock is held.
+func (m *Storage) deleteWhere(match func(key string, stored []byte) (bool, error)) (int, error) {
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.unlock()
+
+	var keys []string
+	for key, stored := range m.data {
+		ok, err := match(key, stored)
+		if err != nil {
+			return 0, err
+		}
+
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	sort.Strings(keys)
+	changes := make([]Change, len(keys))
+	for i, key := range keys {
+		changes[i] = Change{Key: key, Deleted: true}
+	}
+
+	err := m.apply(changes, make([][]byte, len(changes)))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
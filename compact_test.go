@@ -0,0 +1,76 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Compact(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.SetMany(map[string][]byte{
+		"empty":   {},
+		"nil":     nil,
+		"foo":     []byte("bar"),
+		"deleted": []byte("tombstone"),
+	}))
+
+	n, err := mem.Compact(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = mem.Compact(func(_ string, value []byte) bool {
+		return bytes.Equal(value, []byte("tombstone"))
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = mem.Compact(nil)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	reloaded, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	keys, err := reloaded.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Clear(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.SetMany(map[string][]byte{"foo": []byte("bar"), "baz": nil}))
+	require.NoError(t, mem.Clear())
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	require.NoError(t, mem.Set("foo", []byte("new")))
+
+	reloaded, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	keys, err = reloaded.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+}
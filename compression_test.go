@@ -0,0 +1,112 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithCompression(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	// existing uncompressed files can still be loaded
+	writeMemoryFile(t, tempFile, map[string][]byte{"foo": []byte("bar")})
+
+	mem, err := NewMemory(tempFile, WithCompression(), WithTimestampHeader())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("baz", bytes.Repeat([]byte("x"), 1000)))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, gzipMagic, content[:2])
+	require.Less(t, len(content), 1000)
+
+	// compressed files are detected even without the option
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"baz", "foo"}, keys)
+
+	_, err = FileAge(tempFile)
+	require.NoError(t, err)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCompression_Encrypted(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	key := bytes.Repeat([]byte{42}, 32)
+	value := bytes.Repeat([]byte("x"), 1000)
+	mem, err := NewMemory(tempFile, WithCompression(), WithEncryptionKey(key))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("large", value))
+	require.NoError(t, mem.Close())
+
+	// the data is compressed before it is encrypted, so the file is neither
+	// JSON nor gzip but still smaller than the value
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.False(t, json.Valid(content))
+	require.NotEqual(t, gzipMagic, content[:2])
+	require.Less(t, len(content), len(value))
+
+	// the order of the options does not matter
+	mem, err = NewMemory(tempFile, WithEncryptionKey(key), WithCompression())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	val, ok, err := mem.Get("large")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, value, val)
+
+	val, ok, err = mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(val))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCompressionLevel(t *testing.T) {
+	value := bytes.Repeat([]byte("some value "), 1000)
+
+	sizes := map[int]int{}
+	for _, level := range []int{gzip.NoCompression, gzip.BestCompression} {
+		tempFile := tempFilePath()
+		defer os.Remove(tempFile)
+
+		mem, err := NewMemory(tempFile, WithCompressionLevel(level))
+		require.NoError(t, err)
+		require.NoError(t, mem.Set("foo", value))
+		require.NoError(t, mem.Close())
+
+		content, err := os.ReadFile(tempFile)
+		require.NoError(t, err)
+		require.Equal(t, gzipMagic, content[:2])
+		sizes[level] = len(content)
+
+		mem, err = NewMemory(tempFile)
+		require.NoError(t, err)
+		got, _, err := mem.Get("foo")
+		require.NoError(t, err)
+		require.Equal(t, value, got)
+		require.NoError(t, mem.Close())
+	}
+
+	require.Less(t, sizes[gzip.BestCompression], sizes[gzip.NoCompression])
+
+	_, err := NewMemory(tempFilePath(), WithCompressionLevel(10))
+	require.EqualError(t, err, "compression level must be between -2 and 9 but got 10")
+}
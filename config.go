@@ -0,0 +1,100 @@
+package file
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+// MemoryOptions describes the effective configuration of a memory (see
+// Options).
+type MemoryOptions struct {
+	// Path is the absolute path of the memory file (see Path).
+	Path string
+
+	// Name is the name of the memory in log messages (see WithName).
+	Name string
+
+	// Codec is "json" for the default format or the Go type of the custom
+	// codec of WithCodec(…).
+	Codec string
+
+	Compression    bool // see WithCompression
+	Encryption     bool // see WithEncryptionKey
+	LazyDecryption bool // see WithLazyDecryption
+	RawStrings     bool // see WithRawStrings
+	ReadOnly       bool // see WithReadOnly
+	LazyLoad       bool // see WithLazyLoad
+	Sync           bool // see WithSync
+	ValueChecksums bool // see WithValueChecksums
+
+	FlushInterval     time.Duration // see WithFlushInterval
+	Shards            int           // see WithShards
+	AppendLog         bool          // see WithAppendLog
+	Backups           int           // see WithBackups
+	MaxFileAge        time.Duration // see WithMaxFileAge
+	MaxArchives       int           // see WithMaxArchives
+	MaxSerializedSize int64         // see WithMaxSerializedSize
+	MaxKeys           int           // see WithMaxKeys
+	MaxBytes          int64         // see WithMaxBytes
+	MaxValueBytes     int           // see WithMaxValueBytes
+	MaxKeyLength      int           // see WithMaxKeyLength
+}
+
+// Path returns the absolute path of the memory file after the path that was
+// passed to NewMemory(…) was expanded (see WithPathExpansion). It is empty if
+// the memory does not have a memory file, e.g. because a custom store is used.
+// The memory file might not exist yet.
+func (m *Storage) Path() string {
+	return m.absPath
+}
+
+// Options returns the effective configuration of the memory, so operators can
+// verify their setup. The configuration of a memory never changes, so this can
+// be called at any time, even after the memory was closed.
+func (m *Storage) Options() MemoryOptions {
+	codec := "json"
+	if m.codec != nil {
+		codec = fmt.Sprintf("%T", m.codec)
+	}
+
+	return MemoryOptions{
+		Path:              m.absPath,
+		Name:              m.name,
+		Codec:             codec,
+		Compression:       m.compress,
+		Encryption:        m.aead != nil,
+		LazyDecryption:    m.sealed,
+		RawStrings:        m.rawStrings,
+		ReadOnly:          m.readOnly,
+		LazyLoad:          m.lazyLoad,
+		Sync:              m.syncWrites,
+		ValueChecksums:    m.valueChecksums,
+		FlushInterval:     m.flushInterval,
+		Shards:            m.shards,
+		AppendLog:         m.logRatio > 0,
+		Backups:           m.backups,
+		MaxFileAge:        m.maxFileAge,
+		MaxArchives:       m.maxArchives,
+		MaxSerializedSize: m.maxSerializedSize,
+		MaxKeys:           m.maxKeys,
+		MaxBytes:          m.maxBytes,
+		MaxValueBytes:     m.maxValueBytes,
+		MaxKeyLength:      m.maxKeyLength,
+	}
+}
+
+// absolutePath returns the absolute form of the given path or the path itself
+// if it cannot be determined.
+func absolutePath(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	return abs
+}
@@ -0,0 +1,60 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Path(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("MEMORY_DIR", "data")
+	require.NoError(t, os.Mkdir("data", 0755))
+
+	mem, err := NewMemory("$MEMORY_DIR/joe.json", WithPathExpansion())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(wd, "data", "joe.json"), mem.Path())
+
+	// the path is not touched
+	_, err = os.Stat(mem.Path())
+	assert.True(t, os.IsNotExist(err))
+
+	ephemeral, err := NewMemory("")
+	require.NoError(t, err)
+	defer ephemeral.Close()
+	assert.Equal(t, "", ephemeral.Path())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Options(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile,
+		WithName("users"),
+		WithCompression(),
+		WithFlushInterval(time.Minute),
+		WithMaxKeys(10),
+	)
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	assert.Equal(t, MemoryOptions{
+		Path:          absolutePath(tempFile),
+		Name:          "users",
+		Codec:         "json",
+		Compression:   true,
+		FlushInterval: time.Minute,
+		MaxKeys:       10,
+	}, mem.Options())
+}
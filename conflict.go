@@ -0,0 +1,51 @@
+package file
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"go.uber.org/zap"
+)
+
+// detectConflict compares the current content of the memory file with the
+// content that was last read or written by this memory. If the file has been
+// modified in the meantime, the user callback decides whether the file may be
+// overwritten. The caller must hold the write lock.
+func (m *Storage) detectConflict() error {
+	var checksum [sha256.Size]byte
+	content, err := m.readAll(m.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// a missing file is represented by the zero checksum
+	case err != nil:
+		return fmt.Errorf("failed to read file to detect conflicts: %w", err)
+	default:
+		checksum = sha256.Sum256(content)
+	}
+
+	if checksum == m.diskChecksum {
+		return nil
+	}
+
+	m.logger.Warn("Memory file was modified by another process", zap.String("path", m.path))
+	err = m.callOnConflict()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+
+	return nil
+}
+
+// callOnConflict invokes the user callback. If the callback panics, the panic
+// is returned as error so the write is aborted.
+func (m *Storage) callOnConflict() (err error) {
+	defer func() {
+		if perr := m.recoverPanic("conflict handler", recover()); perr != nil {
+			err = perr
+		}
+	}()
+
+	return m.onConflict(m.path)
+}
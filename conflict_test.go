@@ -0,0 +1,93 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithConflictDetection(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	var conflicts []string
+	allowOverwrite := false
+	onConflict := func(path string) error {
+		conflicts = append(conflicts, path)
+		if allowOverwrite {
+			return nil
+		}
+		return errors.New("refusing to overwrite")
+	}
+
+	mem1, err := NewMemory(tempFile, WithConflictDetection(onConflict))
+	require.NoError(t, err)
+	defer mem1.Close()
+
+	mem2, err := NewMemory(tempFile, WithConflictDetection(onConflict))
+	require.NoError(t, err)
+	defer mem2.Close()
+
+	// consecutive writes of the same memory are no conflict
+	require.NoError(t, mem1.Set("foo", []byte("bar")))
+	require.NoError(t, mem1.Set("foo", []byte("baz")))
+	require.Empty(t, conflicts)
+
+	// the second memory has not seen the changes of the first one
+	err = mem2.Set("foo", []byte("qux"))
+	require.True(t, errors.Is(err, ErrConflict), err)
+	require.Equal(t, []string{tempFile}, conflicts)
+
+	// the rejected change was reverted
+	val, ok, err := mem2.Get("foo")
+	require.NoError(t, err)
+	require.False(t, ok, "unexpected value %q", val)
+
+	allowOverwrite = true
+	require.NoError(t, mem2.Set("foo", []byte("qux")))
+	require.Len(t, conflicts, 2)
+
+	// after overwriting, the second memory is in sync again
+	allowOverwrite = false
+	require.NoError(t, mem2.Set("foo", []byte("quux")))
+	require.Len(t, conflicts, 2)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithConflictDetection_DeleteRollback(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	reject := func(string) error { return errors.New("refusing to overwrite") }
+
+	mem1, err := NewMemory(tempFile, WithConflictDetection(reject))
+	require.NoError(t, err)
+	defer mem1.Close()
+	require.NoError(t, mem1.Set("foo", []byte("bar")))
+
+	mem2, err := NewMemory(tempFile, WithConflictDetection(reject))
+	require.NoError(t, err)
+	defer mem2.Close()
+
+	require.NoError(t, mem1.Set("other", []byte("x")))
+
+	values, unsubscribe := mem2.Watch("foo")
+	defer unsubscribe()
+
+	_, err = mem2.Delete("foo")
+	require.True(t, errors.Is(err, ErrConflict), err)
+
+	val, ok, err := mem2.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(val))
+
+	select {
+	case v := <-values:
+		t.Fatalf("unexpected notification %+v", v)
+	default:
+	}
+}
@@ -0,0 +1,110 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// DefaultContentType is the content type that GetTyped(…) reports for values
+// that were not written via SetTyped(…).
+const DefaultContentType = "application/octet-stream"
+
+// SetTyped is like Set but also stores the given content type of the value
+// (e.g. "application/json" or "text/plain"), so other tools that read the
+// memory file know how to interpret the value. The content types are stored in
+// a separate field of the memory file, so files with typed values can still be
+// read by older versions, which simply ignore the types. Setting the key again
+// via Set removes its content type. An empty content type is the same as
+// DefaultContentType.
+func (m *Storage) SetTyped(key string, value []byte, contentType string) error {
+	if contentType == "" || contentType == DefaultContentType {
+		return m.Set(key, value)
+	}
+
+	key = m.normalizeKey(key)
+
+	if err := m.checkKey(key); err != nil {
+		return err
+	}
+
+	if m.codec != nil {
+		return errors.New("content types can only be persisted in the JSON format")
+	}
+
+	if err := m.checkValue(key, value); err != nil {
+		return err
+	}
+
+	m.inspectValue(key, value)
+	stored, err := m.sealValue(value)
+	if err != nil {
+		return err
+	}
+
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	atomic.AddUint64(&m.numSets, 1)
+	if err := m.checkLimits(key, stored); err != nil {
+		return err
+	}
+
+	prev := m.entry(key)
+	m.put(key, stored)
+	m.setContentType(key, contentType)
+
+	err = m.commit(context.Background())
+	if isRejected(err) {
+		// the file was not written so we revert the change to stay consistent
+		m.restore(key, prev)
+	}
+
+	if err == nil {
+		m.changed(key, WatchEvent{Value: value})
+	}
+
+	return err
+}
+
+// GetTyped is like Get but also returns the content type of the value (see
+// SetTyped). Values that were set without a content type are reported as
+// DefaultContentType.
+//
+// An error is only returned if this function is called after the memory was
+// closed already.
+func (m *Storage) GetTyped(key string) (value []byte, contentType string, ok bool, err error) {
+	key = m.normalizeKey(key)
+
+	if err := m.awaitLoad(); err != nil {
+		return nil, "", false, err
+	}
+
+	if err := m.rlock(); err != nil {
+		return nil, "", false, err
+	}
+	defer m.runlock()
+
+	value, ok = m.data[key]
+	if !ok || m.isExpired(key, m.now()) {
+		return nil, "", false, nil
+	}
+
+	if ok, err := m.verifyValue(key, value); !ok {
+		return nil, "", false, err
+	}
+
+	value, err = m.openValue(value)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	contentType, ok = m.types[key]
+	if !ok {
+		contentType = DefaultContentType
+	}
+
+	return value, contentType, true, nil
+}
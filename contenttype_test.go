@@ -0,0 +1,118 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetTyped(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.SetTyped("json", []byte(`{"a":1}`), "application/json"))
+	require.NoError(t, mem.SetTyped("text", []byte("foo"), "text/plain"))
+	require.NoError(t, mem.Set("plain", []byte("bar")))
+
+	value, contentType, ok, err := mem.GetTyped("json")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, `{"a":1}`, string(value))
+	assert.Equal(t, "application/json", contentType)
+
+	// Set removes the content type of the key
+	require.NoError(t, mem.Set("text", []byte("baz")))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, contentType, ok, err = mem.GetTyped("json")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "application/json", contentType)
+
+	for _, key := range []string{"text", "plain"} {
+		_, contentType, ok, err = mem.GetTyped(key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, DefaultContentType, contentType, key)
+	}
+
+	_, contentType, ok, err = mem.GetTyped("missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, contentType)
+
+	// deleted keys lose their content type
+	_, err = mem.Delete("json")
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("json", []byte("{}")))
+	_, contentType, _, err = mem.GetTyped("json")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultContentType, contentType)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetTyped_File(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.SetTyped("foo", []byte("bar"), "text/plain"))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+
+	doc, err := decodeDocument(bytes.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "text/plain"}, doc.ContentTypes)
+
+	// files without content types are read as before
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":"YmFy"}`), 0600))
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, contentType, ok, err := mem.GetTyped("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+	assert.Equal(t, DefaultContentType, contentType)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetTyped_AppendLog(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	for _, opts := range [][]Option{
+		{WithAppendLog(1)},
+		{WithAppendLog(1), WithBinaryAppendLog()},
+	} {
+		mem, err := NewMemory(tempFile, opts...)
+		require.NoError(t, err)
+		require.NoError(t, mem.SetTyped("foo", []byte("bar"), "text/plain"))
+		require.NoError(t, mem.Close())
+
+		mem, err = NewMemory(tempFile, opts...)
+		require.NoError(t, err)
+
+		_, contentType, ok, err := mem.GetTyped("foo")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "text/plain", contentType)
+		require.NoError(t, mem.Close())
+	}
+}
@@ -0,0 +1,37 @@
+package file
+
+import "context"
+
+// withContext runs the given operation and waits until it has finished or the
+// context is done, whichever happens first. If the context is done, the
+// operation keeps running in the background and its result is discarded. An
+// operation is never started if the context is done already.
+func withContext[T any](ctx context.Context, op func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	if ctx.Done() == nil {
+		// the context can never be canceled, so we can avoid the goroutine
+		return op()
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := op()
+		done <- result{value, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,86 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingCodec is a JSON codec whose Marshal signals that a write has started
+// and then blocks until it is released.
+type blockingCodec struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (c blockingCodec) Marshal(data map[string][]byte) ([]byte, error) {
+	c.started <- struct{}{}
+	<-c.release
+	return json.Marshal(data)
+}
+
+func (blockingCodec) Unmarshal(content []byte, data *map[string][]byte) error {
+	return json.Unmarshal(content, data)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetContextCanceled(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	codec := blockingCodec{started: make(chan struct{}), release: make(chan struct{})}
+	mem, err := NewMemory(tempFile, WithCodec(codec))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- mem.SetContext(ctx, "foo", []byte("bar")) }()
+
+	<-codec.started
+	cancel()
+	assert.Equal(t, context.Canceled, <-result)
+
+	// the write continues in the background and the change is not rolled back
+	close(codec.release)
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+
+	ok, err = mem.DeleteContext(ctx, "foo")
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, ok)
+
+	// an operation is not started if the context is done already
+	value, ok, err = mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_DeleteContext(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.NoError(t, mem.SetContext(ctx, "foo", []byte("bar")))
+	ok, err := mem.DeleteContext(ctx, "foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = mem.DeleteContext(ctx, "foo")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,84 @@
+package file
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CorruptFilePolicy decides how a memory behaves if its memory file exists but
+// cannot be decoded, e.g. because the process crashed while writing it.
+type CorruptFilePolicy int
+
+// The available corrupt file policies.
+const (
+	// FailFast returns the error of decoding the file, so the memory cannot
+	// be created.
+	FailFast CorruptFilePolicy = iota
+
+	// StartEmpty logs the error, moves the corrupt file aside and continues
+	// with an empty memory.
+	StartEmpty
+
+	// StartEmptyKeep logs the error and continues with an empty memory but
+	// leaves the corrupt file in place. The file is overwritten by the next
+	// change, unless it is preserved via WithBackups(…).
+	StartEmptyKeep
+)
+
+// corruptFileError marks an error that was caused by the content of a memory
+// file rather than by accessing it.
+type corruptFileError struct {
+	error
+}
+
+func (err corruptFileError) Unwrap() error {
+	return err.error
+}
+
+// corruptPath returns the path a corrupt memory file is moved to. The path
+// contains the current time so repeated corruptions do not overwrite each other.
+func (m *Storage) corruptPath(now time.Time) string {
+	return fmt.Sprintf("%s.corrupt.%s", m.path, now.UTC().Format("20060102T150405.000000000Z"))
+}
+
+// recoverCorruptFile applies the configured CorruptFilePolicy to an error of
+// reading the memory file. If the policy allows to continue, it returns the
+// empty content the memory should use instead. Otherwise the error is returned
+// unchanged.
+func (m *Storage) recoverCorruptFile(err error) (*fileContent, error) {
+	var corrupt corruptFileError
+	if m.corruptFilePolicy == FailFast || !errors.As(err, &corrupt) {
+		return nil, err
+	}
+
+	f := &fileContent{data: map[string][]byte{}}
+	switch m.corruptFilePolicy {
+	case StartEmpty:
+		dest := m.corruptPath(m.now())
+		if err := m.fsys.Rename(m.path, dest); err != nil {
+			return nil, fmt.Errorf("failed to move corrupt memory file aside: %w", err)
+		}
+
+		m.logger.Error("Memory file is corrupt. Moved it aside and continuing with empty memory",
+			zap.String("path", m.path),
+			zap.String("corrupt_path", dest),
+			zap.Error(err),
+		)
+	case StartEmptyKeep:
+		// the file stays in place, so it must not be reported as a conflict
+		if content, err := m.readAll(m.path); err == nil {
+			f.checksum = sha256.Sum256(content)
+		}
+
+		m.logger.Error("Memory file is corrupt. Continuing with empty memory",
+			zap.String("path", m.path),
+			zap.Error(err),
+		)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,115 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCorruptFilePolicy_FailFast(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":`), 0660))
+
+	_, err := NewMemory(tempFile)
+	require.EqualError(t, err, "failed decode data as JSON: unexpected EOF")
+	assert.FileExists(t, tempFile)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCorruptFilePolicy_StartEmpty(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	corruptFiles := func() []string {
+		matches, err := filepath.Glob(tempFile + ".corrupt.*")
+		require.NoError(t, err)
+		return matches
+	}
+	defer func() {
+		for _, path := range corruptFiles() {
+			os.Remove(path)
+		}
+	}()
+
+	for i := 1; i <= 2; i++ {
+		require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":`), 0660))
+
+		mem, err := NewMemory(tempFile, WithCorruptFilePolicy(StartEmpty))
+		require.NoError(t, err)
+
+		keys, err := mem.Keys()
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+		assert.NoFileExists(t, tempFile)
+		require.NoError(t, mem.Close())
+
+		// repeated corruptions must not overwrite each other
+		assert.Len(t, corruptFiles(), i)
+	}
+
+	content, err := os.ReadFile(corruptFiles()[0])
+	require.NoError(t, err)
+	assert.Equal(t, `{"foo":`, string(content))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCorruptFilePolicy_StartEmptyKeep(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".1")
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":`), 0660))
+
+	mem, err := NewMemory(tempFile,
+		WithCorruptFilePolicy(StartEmptyKeep),
+		WithBackups(1),
+		WithConflictDetection(func(string) error { return ErrConflict }),
+	)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, `{"foo":`, string(content))
+
+	require.NoError(t, mem.Set("bar", []byte("1")))
+
+	backup, err := os.ReadFile(tempFile + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, `{"foo":`, string(backup))
+}
+
+func TestWithCorruptFilePolicy_BackgroundLoad(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":`), 0660))
+
+	mem, err := NewMemory(tempFile,
+		WithCorruptFilePolicy(StartEmptyKeep),
+		WithBackgroundLoad(map[string][]byte{"seed": []byte("1")}, WaitForLoad),
+	)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"seed"}, keys)
+}
+
+func TestWithCorruptFilePolicy_AccessErrorsAreReturned(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	require.NoError(t, os.Mkdir(tempFile, 0755))
+
+	_, err := NewMemory(tempFile, WithCorruptFilePolicy(StartEmpty))
+	require.Error(t, err)
+	assert.DirExists(t, tempFile)
+}
+
+func TestWithCorruptFilePolicy_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithCorruptFilePolicy(42))
+	require.EqualError(t, err, "invalid corrupt file policy 42")
+}
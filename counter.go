@@ -0,0 +1,80 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// Increment adds delta to the integer that is stored as decimal string at the
+// given key and returns the new total. A key that does not exist (or has
+// expired) is treated as zero. Reading, updating and persisting the value
+// happens under the write lock, so concurrent increments never get lost.
+//
+// If the current value is not a valid integer or the result would overflow, an
+// error is returned and the value is not changed. An error is also returned if
+// the memory was closed already or if the memory file could not be written. If
+// the write was rejected (e.g. via WithMaxSerializedSize), the value is not
+// changed either.
+func (m *Storage) Increment(key string, delta int64) (int64, error) {
+	key = m.normalizeKey(key)
+
+	if err := m.checkKey(key); err != nil {
+		return 0, err
+	}
+
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.unlock()
+
+	prev := m.entry(key)
+	var current int64
+	if prev.exists && !m.isExpired(key, m.now()) {
+		value, err := m.openValue(prev.value)
+		if err != nil {
+			return 0, err
+		}
+
+		current, err = strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("value of key %q is not an integer: %w", key, err)
+		}
+	}
+
+	total := current + delta
+	if (delta > 0 && total < current) || (delta < 0 && total > current) {
+		return 0, fmt.Errorf("incrementing key %q by %d overflows", key, delta)
+	}
+
+	value := []byte(strconv.FormatInt(total, 10))
+	if err := m.checkValue(key, value); err != nil {
+		return 0, err
+	}
+
+	m.inspectValue(key, value)
+	stored, err := m.sealValue(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.checkLimits(key, stored); err != nil {
+		return 0, err
+	}
+
+	m.put(key, stored)
+
+	err = m.commit(context.Background())
+	if isRejected(err) {
+		// the file was not written so we revert the change to stay consistent
+		m.restore(key, prev)
+		return 0, err
+	}
+
+	if err != nil {
+		return total, err
+	}
+
+	m.changed(key, WatchEvent{Value: value})
+	return total, nil
+}
@@ -0,0 +1,92 @@
+package file
+
+import (
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Increment(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	total, err := mem.Increment("counter", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), total)
+
+	total, err = mem.Increment("counter", -7)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-2), total)
+
+	value, _, err := mem.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, "-2", string(value))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := mem.Increment("counter", 1)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	value, _, err = mem.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, "8", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_IncrementInvalid(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("text", []byte("foo")))
+	_, err = mem.Increment("text", 1)
+	assert.EqualError(t, err, `value of key "text" is not an integer: strconv.ParseInt: parsing "foo": invalid syntax`)
+
+	value, _, err := mem.Get("text")
+	require.NoError(t, err)
+	assert.Equal(t, "foo", string(value))
+
+	require.NoError(t, mem.Set("max", []byte(strconv.FormatInt(math.MaxInt64, 10))))
+	_, err = mem.Increment("max", 1)
+	assert.EqualError(t, err, `incrementing key "max" by 1 overflows`)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_IncrementRollback(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMaxSerializedSize(120))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, err = mem.Increment("a", 1)
+	require.NoError(t, err)
+
+	_, err = mem.Increment("a", 1000000000000)
+	assert.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+
+	value, _, err := mem.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+}
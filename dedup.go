@@ -0,0 +1,176 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+)
+
+// minDedupSize is the minimum size of values that are deduplicated (see
+// WithDedup). Smaller values are always stored inline, since the reference to
+// a shared value would not be much shorter than the value itself.
+const minDedupSize = 256
+
+// dedupValue is a unique value of WithDedup() together with the number of keys
+// that are assigned to it.
+type dedupValue struct {
+	value []byte
+	refs  int
+}
+
+// dedupValues is the encoding of the values that are shared by multiple keys
+// (see WithDedup). Each shared value is stored once by its hash and the keys
+// reference the hash instead of repeating the value in the data field.
+type dedupValues struct {
+	Values map[string][]byte `json:"values"`
+	Refs   map[string]string `json:"refs"`
+}
+
+// checkDedupOptions returns an error if WithDedup() is combined with options
+// that do not support it.
+func (m *Storage) checkDedupOptions() error {
+	if !m.dedup {
+		return nil
+	}
+
+	switch {
+	case m.codec != nil:
+		return errors.New("deduplication cannot be combined with a custom codec")
+	case m.sealed:
+		return errors.New("deduplication cannot be combined with lazy decryption")
+	case m.externalThreshold > 0:
+		return errors.New("deduplication cannot be combined with external values")
+	case m.shards > 0:
+		return errors.New("deduplication cannot be combined with sharding")
+	case m.logRatio > 0:
+		return errors.New("deduplication cannot be combined with an append log")
+	case m.lazyLoad:
+		return errors.New("deduplication cannot be combined with lazy loading")
+	case m.loaded != nil:
+		return errors.New("deduplication cannot be combined with a background load")
+	}
+
+	return nil
+}
+
+// internValue assigns the stored value to the key in the table of unique
+// values and returns the value that should be stored for the key, which is the
+// copy of the table if another key has an equal value. Any value the key was
+// assigned to before is released. The caller must hold the write lock.
+func (m *Storage) internValue(key string, stored []byte) []byte {
+	if !m.dedup {
+		return stored
+	}
+
+	m.releaseValue(key)
+	if len(stored) < minDedupSize {
+		return stored
+	}
+
+	name := hashName(stored)
+	v, ok := m.dedupValues[name]
+	if !ok {
+		// values are not copied by Set, so the table needs its own copy
+		// before other keys share it
+		v = &dedupValue{value: append([]byte{}, stored...)}
+		if m.dedupValues == nil {
+			m.dedupValues = map[string]*dedupValue{}
+			m.dedupKeys = map[string]string{}
+		}
+		m.dedupValues[name] = v
+	}
+
+	v.refs++
+	m.dedupKeys[key] = name
+	return v.value
+}
+
+// releaseValue removes the key from the table of unique values. A value is
+// removed from the table once no key is assigned to it anymore. The caller
+// must hold the write lock.
+func (m *Storage) releaseValue(key string) {
+	name, ok := m.dedupKeys[key]
+	if !ok {
+		return
+	}
+
+	delete(m.dedupKeys, key)
+	v := m.dedupValues[name]
+	v.refs--
+	if v.refs == 0 {
+		delete(m.dedupValues, name)
+	}
+}
+
+// indexValues rebuilds the table of unique values from all values of the
+// memory. It must be called whenever the data of the memory is replaced
+// entirely (e.g. when the memory file is loaded). The caller must hold the
+// write lock.
+func (m *Storage) indexValues() {
+	if !m.dedup {
+		return
+	}
+
+	m.dedupValues = nil
+	m.dedupKeys = nil
+	for key, value := range m.data {
+		m.data[key] = m.internValue(key, value)
+	}
+}
+
+// packValues returns the encoding of all values of the given data which are
+// shared by multiple keys together with the remaining data that is stored
+// inline. Values are only packed if they are still the values of the table, so
+// data that does not belong to the memory itself is never deduplicated. If no
+// value is shared, it returns nil and the data as is.
+func (m *Storage) packValues(data map[string][]byte) (*dedupValues, map[string][]byte) {
+	var packed *dedupValues
+	for key, value := range data {
+		name, ok := m.dedupKeys[key]
+		if !ok {
+			continue
+		}
+
+		v := m.dedupValues[name]
+		if v.refs < 2 || len(value) != len(v.value) || &value[0] != &v.value[0] {
+			continue
+		}
+
+		if packed == nil {
+			packed = &dedupValues{Values: map[string][]byte{}, Refs: map[string]string{}}
+		}
+		packed.Values[name] = v.value
+		packed.Refs[key] = name
+	}
+
+	if packed == nil {
+		return nil, data
+	}
+
+	inline := make(map[string][]byte, len(data)-len(packed.Refs))
+	for key, value := range data {
+		if _, ok := packed.Refs[key]; !ok {
+			inline[key] = value
+		}
+	}
+
+	return packed, inline
+}
+
+// unpack adds the shared values to the given data, so all keys that reference
+// the same value share the same slice.
+func (d *dedupValues) unpack(data map[string][]byte) error {
+	for key, name := range d.Refs {
+		value, ok := d.Values[name]
+		if !ok {
+			return fmt.Errorf("key %q references missing value %q", key, name)
+		}
+
+		if _, ok := data[key]; ok {
+			return fmt.Errorf("key %q is both in the data and the deduplicated values", key)
+		}
+
+		data[key] = value
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package file
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithDedup(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	template := bytes.Repeat([]byte("template "), 100)
+	other := bytes.Repeat([]byte("other "), 100)
+
+	mem, err := NewMemory(tempFile, WithDedup())
+	require.NoError(t, err)
+
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, mem.Set(key, append([]byte{}, template...)))
+	}
+	require.NoError(t, mem.Set("other", other))
+	require.NoError(t, mem.Set("small", []byte("foo")))
+
+	assertRefs(t, mem, map[string]int{hashName(template): 3, hashName(other): 1})
+	assert.Same(t, &mem.data["a"][0], &mem.data["c"][0])
+	require.NoError(t, mem.Close())
+
+	// the shared value is only written once
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(content, []byte(base64.StdEncoding.EncodeToString(template))))
+	assert.Equal(t, 1, bytes.Count(content, []byte(base64.StdEncoding.EncodeToString(other))))
+
+	// the file can be read without the option
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	value, ok, err := mem.Get("b")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, template, value)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithDedup())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	assertRefs(t, mem, map[string]int{hashName(template): 3, hashName(other): 1})
+	assert.Same(t, &mem.data["a"][0], &mem.data["b"][0])
+
+	for _, key := range []string{"a", "b", "c", "other", "small"} {
+		_, ok, err := mem.Get(key)
+		require.NoError(t, err)
+		assert.True(t, ok, key)
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithDedup_RefCounts(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	template := bytes.Repeat([]byte("template "), 100)
+	other := bytes.Repeat([]byte("other "), 100)
+
+	mem, err := NewMemory(tempFile, WithDedup())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.SetMany(map[string][]byte{"a": template, "b": template, "c": template}))
+	assertRefs(t, mem, map[string]int{hashName(template): 3})
+
+	// setting a key to another value releases its reference
+	require.NoError(t, mem.Set("a", other))
+	assertRefs(t, mem, map[string]int{hashName(template): 2, hashName(other): 1})
+
+	// setting a key to the same value again does not count it twice
+	require.NoError(t, mem.Set("b", template))
+	assertRefs(t, mem, map[string]int{hashName(template): 2, hashName(other): 1})
+
+	// small values are not part of the table
+	require.NoError(t, mem.Set("b", []byte("foo")))
+	assertRefs(t, mem, map[string]int{hashName(template): 1, hashName(other): 1})
+
+	// the value is removed once the last key that references it is deleted
+	_, err = mem.Delete("c")
+	require.NoError(t, err)
+	assertRefs(t, mem, map[string]int{hashName(other): 1})
+
+	_, err = mem.Rename("a", "d", false)
+	require.NoError(t, err)
+	assertRefs(t, mem, map[string]int{hashName(other): 1})
+	assert.Equal(t, map[string]string{"d": hashName(other)}, mem.dedupKeys)
+
+	require.NoError(t, mem.Clear())
+	assertRefs(t, mem, map[string]int{})
+	assert.Empty(t, mem.dedupKeys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithDedup_Rejected(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	template := bytes.Repeat([]byte("template "), 100)
+
+	mem, err := NewMemory(tempFile, WithDedup(), WithMaxSerializedSize(2000))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", template))
+	require.NoError(t, mem.Set("b", template))
+
+	// the reverted change restores the reference counts
+	err = mem.Set("a", bytes.Repeat([]byte("x"), 2000))
+	require.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+	assertRefs(t, mem, map[string]int{hashName(template): 2})
+	assert.Same(t, &mem.data["a"][0], &mem.data["b"][0])
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithDedup_ModifiedBuffer(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithDedup())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	buf := bytes.Repeat([]byte("template "), 100)
+	require.NoError(t, mem.Set("a", buf))
+	require.NoError(t, mem.Set("b", bytes.Repeat([]byte("template "), 100)))
+
+	// changing the value of a key in place does not change the other key
+	buf[0] = 'T'
+	require.NoError(t, mem.Set("a", buf))
+
+	value, _, err := mem.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, byte('t'), value[0])
+
+	value, _, err = mem.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, byte('T'), value[0])
+}
+
+func TestWithDedup_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithDedup(), WithAppendLog(1))
+	require.EqualError(t, err, "deduplication cannot be combined with an append log")
+
+	_, err = NewMemory(tempFilePath(), WithDedup(), WithShards(2))
+	require.EqualError(t, err, "deduplication cannot be combined with sharding")
+}
+
+func TestDecodeDocument_Dedup(t *testing.T) {
+	doc, err := decodeDocument(bytes.NewReader([]byte(`{"version":2,"dedup":{"values":{"x":"Zm9v"},"refs":{"a":"x","b":"x"}},"data":{"c":"YmFy"}}`)))
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"a": []byte("foo"), "b": []byte("foo"), "c": []byte("bar")}, doc.Data)
+
+	_, err = decodeDocument(bytes.NewReader([]byte(`{"version":2,"dedup":{"values":{},"refs":{"a":"x"}},"data":{}}`)))
+	assert.EqualError(t, err, `invalid dedup field: key "a" references missing value "x"`)
+
+	_, err = decodeDocument(bytes.NewReader([]byte(`{"version":2,"dedup":{"values":{"x":"Zm9v"},"refs":{"a":"x"}},"data":{"a":"YmFy"}}`)))
+	assert.EqualError(t, err, `invalid dedup field: key "a" is both in the data and the deduplicated values`)
+}
+
+// assertRefs asserts that the table of unique values of the memory contains
+// exactly the given hashes with the given reference counts.
+func assertRefs(t *testing.T, mem *Storage, expected map[string]int) {
+	t.Helper()
+
+	mem.mu.RLock()
+	defer mem.mu.RUnlock()
+
+	refs := map[string]int{}
+	for name, v := range mem.dedupValues {
+		refs[name] = v.refs
+	}
+	assert.Equal(t, expected, refs)
+}
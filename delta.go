@@ -0,0 +1,69 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// deltaValues is a compact encoding of integer values. The keys are sorted and
+// each value is stored as the difference to the value of the previous key,
+// packed as zig-zag encoded varints (see WithDeltaNumericValues).
+type deltaValues struct {
+	Keys   []string `json:"keys"`
+	Values []byte   `json:"values"`
+}
+
+// packDeltas returns the delta encoding of the given data or false if not all
+// values are integers that round-trip exactly through strconv.
+func packDeltas(data map[string][]byte) (*deltaValues, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	packed := &deltaValues{Keys: keys}
+	buf := make([]byte, binary.MaxVarintLen64)
+	var prev int64
+	for _, key := range keys {
+		n, err := strconv.ParseInt(string(data[key]), 10, 64)
+		if err != nil || strconv.FormatInt(n, 10) != string(data[key]) {
+			return nil, false
+		}
+
+		l := binary.PutVarint(buf, n-prev)
+		packed.Values = append(packed.Values, buf[:l]...)
+		prev = n
+	}
+
+	return packed, true
+}
+
+// unpack decodes all values and adds them to the given data.
+func (d *deltaValues) unpack(data map[string][]byte) error {
+	values := d.Values
+	var prev int64
+	for _, key := range d.Keys {
+		delta, l := binary.Varint(values)
+		if l <= 0 {
+			return errors.New("truncated delta encoded values")
+		}
+
+		values = values[l:]
+		prev += delta
+		data[key] = []byte(strconv.FormatInt(prev, 10))
+	}
+
+	if len(values) > 0 {
+		return fmt.Errorf("%d unexpected bytes after delta encoded values", len(values))
+	}
+
+	return nil
+}
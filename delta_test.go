@@ -0,0 +1,71 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithDeltaNumericValues(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	values := map[string][]byte{
+		"2026-10-14T10:00": []byte("1000000"),
+		"2026-10-14T10:01": []byte("1000005"),
+		"2026-10-14T10:02": []byte("999990"),
+		"2026-10-14T10:03": []byte("-42"),
+	}
+
+	mem, err := NewMemory(tempFile, WithDeltaNumericValues())
+	require.NoError(t, err)
+	for key, value := range values {
+		require.NoError(t, mem.Set(key, value))
+	}
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"delta":`)
+	require.Contains(t, string(content), `"data":{}`)
+
+	// the compact format can be read without the option
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	for key, value := range values {
+		actual, ok, err := mem.Get(key)
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, string(value), string(actual))
+	}
+	require.NoError(t, mem.Close())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithDeltaNumericValues_Fallback(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithDeltaNumericValues())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("1")))
+
+	// values that would not round-trip exactly are not delta encoded
+	require.NoError(t, mem.Set("b", []byte("007")))
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, `{"version":2,"checksum":"12cbef9ca51ae81ee4a353a6bc2568832313aa35af1cd120266909207e6c0471","data":{"a":"MQ==","b":"MDA3"}}`+"\n", string(content))
+}
+
+func TestDeltaValues_Unpack(t *testing.T) {
+	packed, ok := packDeltas(map[string][]byte{"a": []byte("1"), "b": []byte("300")})
+	require.True(t, ok)
+
+	packed.Values = packed.Values[:len(packed.Values)-1]
+	require.EqualError(t, packed.unpack(map[string][]byte{}), "truncated delta encoded values")
+}
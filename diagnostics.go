@@ -0,0 +1,139 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// diagnosticBytes is the number of bytes at the start and end of a memory file
+// that are reported in a DecodeError.
+const diagnosticBytes = 32
+
+// DecodeError is returned when the content of a memory file cannot be decoded.
+// Besides the error of the decoder, it describes what the file looks like, so a
+// mismatch between the file and the options of the memory (e.g. an encrypted
+// file that is loaded without an encryption key) can be told apart from a file
+// that is actually corrupt.
+type DecodeError struct {
+	Path       string // the path of the file
+	Size       int64  // the size of the file in bytes
+	Head       string // the first bytes of the file, with non-printable characters replaced by "."
+	Tail       string // the last bytes of the file, sanitized like Head
+	Compressed bool   // the file starts with a gzip header
+	Binary     bool   // the start of the file is not text, e.g. because it is encrypted
+	Hint       string // a likely cause of the error, if any
+	Err        error  // the error of the decoder
+}
+
+func (err *DecodeError) Error() string {
+	if err.Hint == "" {
+		return err.Err.Error()
+	}
+
+	return err.Err.Error() + " (" + err.Hint + ")"
+}
+
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
+
+// diagnosticReader remembers the first and the last bytes that were read from
+// r (see DecodeError).
+type diagnosticReader struct {
+	r    io.Reader
+	head []byte
+	tail []byte
+}
+
+func (r *diagnosticReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	b := p[:n]
+	if missing := diagnosticBytes - len(r.head); missing > 0 {
+		r.head = append(r.head, b[:min(missing, len(b))]...)
+	}
+
+	r.tail = append(r.tail, b[max(0, len(b)-diagnosticBytes):]...)
+	if len(r.tail) > diagnosticBytes {
+		r.tail = append(r.tail[:0], r.tail[len(r.tail)-diagnosticBytes:]...)
+	}
+
+	return n, err
+}
+
+// decodeError returns the error for a memory file at the given path that could
+// not be decoded. If the file itself could not be read, the error is returned
+// as is. Otherwise, the rest of the file is read so the returned DecodeError
+// can describe the entire file, and it is marked as caused by a corrupt file.
+func (m *Storage) decodeError(path string, diag *diagnosticReader, counter *countingReader, err error) error {
+	if counter.err != nil {
+		return err
+	}
+
+	_, _ = io.Copy(io.Discard, counter)
+	if counter.err != nil {
+		return err
+	}
+
+	decodeErr := &DecodeError{
+		Path:       path,
+		Size:       counter.n,
+		Head:       sanitize(diag.head),
+		Tail:       sanitize(diag.tail),
+		Compressed: bytes.HasPrefix(diag.head, gzipMagic),
+		Binary:     isBinary(diag.head),
+		Err:        err,
+	}
+	decodeErr.Hint = m.decodeHint(decodeErr)
+
+	return corruptFileError{decodeErr}
+}
+
+// decodeHint returns a likely cause of the DecodeError given the options of
+// the memory or an empty string.
+func (m *Storage) decodeHint(err *DecodeError) string {
+	switch {
+	case err.Compressed && m.codec != nil && !m.compress:
+		return "the file is compressed, but compression is not enabled"
+	case err.Compressed:
+		return ""
+	case err.Binary && m.codec == nil && m.aead == nil:
+		return "the file contains binary data, it might be encrypted or written with a custom codec"
+	case m.codec != nil && strings.HasPrefix(strings.TrimSpace(err.Head), "{"):
+		return "the file looks like JSON, but a custom codec is used"
+	}
+
+	return ""
+}
+
+// sanitize returns the bytes as string with all characters that are not
+// printable replaced by ".".
+func sanitize(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c < ' ' || c > '~' {
+			c = '.'
+		}
+		sb.WriteByte(c)
+	}
+
+	return sb.String()
+}
+
+// isBinary returns true if the bytes are not UTF-8 encoded text. Multi-byte
+// characters that were cut off at the end are ignored.
+func isBinary(b []byte) bool {
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			return utf8.FullRune(b)
+		}
+		if r < ' ' && r != '\n' && r != '\r' && r != '\t' {
+			return true
+		}
+		b = b[size:]
+	}
+
+	return false
+}
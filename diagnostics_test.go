@@ -0,0 +1,70 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeError_Truncated(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	content := []byte(`{"version":2,"data":{"foo":"` + string(bytes.Repeat([]byte("x"), 100)))
+	require.NoError(t, os.WriteFile(tempFile, content, 0600))
+
+	_, err := NewMemory(tempFile)
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr), err)
+	assert.Equal(t, tempFile, decodeErr.Path)
+	assert.Equal(t, int64(len(content)), decodeErr.Size)
+	assert.Equal(t, string(content[:diagnosticBytes]), decodeErr.Head)
+	assert.Equal(t, string(bytes.Repeat([]byte("x"), diagnosticBytes)), decodeErr.Tail)
+	assert.False(t, decodeErr.Compressed)
+	assert.False(t, decodeErr.Binary)
+	assert.Empty(t, decodeErr.Hint)
+	assert.EqualError(t, err, "failed decode data as JSON: invalid data field: unexpected EOF")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestDecodeError_Encrypted(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithEncryptionKey(bytes.Repeat([]byte{42}, 32)))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	_, err = NewMemory(tempFile)
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr), err)
+	assert.True(t, decodeErr.Binary)
+	assert.Len(t, decodeErr.Head, diagnosticBytes)
+	assert.Contains(t, err.Error(), "(the file contains binary data, it might be encrypted or written with a custom codec)")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestDecodeError_Codec(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	writeMemoryFile(t, tempFile, map[string][]byte{"foo": []byte("bar")})
+
+	_, err := NewMemory(tempFile, WithCodec(gobCodec{}))
+	var decodeErr *DecodeError
+	require.True(t, errors.As(err, &decodeErr), err)
+	assert.Equal(t, "the file looks like JSON, but a custom codec is used", decodeErr.Hint)
+}
+
+func TestIsBinary(t *testing.T) {
+	assert.False(t, isBinary([]byte("{\n\t\"foo\": \"bär\"}")))
+	assert.False(t, isBinary([]byte("foo \xc3"))) // cut off multi-byte character
+	assert.True(t, isBinary([]byte("foo\x00")))
+	assert.True(t, isBinary([]byte("\xff\xfe")))
+	assert.Equal(t, "foo.bar.", sanitize([]byte("foo\nbar\xff")))
+}
@@ -0,0 +1,88 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// DiskDiff compares the data in memory with the data of the memory file on
+// disk, which is read again for this purpose. It returns the keys that only
+// exist in memory (added) and the keys whose values differ (changed), both with
+// their values in memory, as well as the keys that only exist on disk (removed)
+// with their values on disk. This helps to debug changes which have not been
+// persisted (e.g. because of WithFlushInterval or a failed write) or changes of
+// the memory file by other processes.
+//
+// Neither the memory nor the memory file are changed. An error is returned if
+// the memory file cannot be decoded or if the memory was closed already.
+func (m *Storage) DiskDiff() (added, removed, changed map[string]string, err error) {
+	if m.shards > 0 || m.logRatio > 0 {
+		return nil, nil, nil, errors.New("memories with shards or an append log cannot be compared with the disk")
+	}
+
+	if err := m.awaitLoad(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := m.rlock(); err != nil {
+		return nil, nil, nil, err
+	}
+	defer m.runlock()
+
+	f, err := m.readFile(m.path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read memory file: %w", err)
+	}
+
+	disk := map[string][]byte{}
+	if f != nil {
+		disk = f.data
+	}
+
+	added = map[string]string{}
+	removed = map[string]string{}
+	changed = map[string]string{}
+	now := m.now()
+
+	for key, stored := range m.data {
+		if m.isExpired(key, now) {
+			continue
+		}
+
+		value, err := m.openValue(stored)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		storedOnDisk, ok := disk[key]
+		if !ok {
+			added[key] = string(value)
+			continue
+		}
+
+		onDisk, err := m.openValue(storedOnDisk)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if !bytes.Equal(value, onDisk) {
+			changed[key] = string(value)
+		}
+	}
+
+	for key, stored := range disk {
+		if _, ok := m.data[key]; ok && !m.isExpired(key, now) {
+			continue
+		}
+
+		value, err := m.openValue(stored)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		removed[key] = string(value)
+	}
+
+	return added, removed, changed, nil
+}
@@ -0,0 +1,55 @@
+package file
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_DiskDiff(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("same", []byte("1")))
+	require.NoError(t, mem.Set("changed", []byte("2")))
+	require.NoError(t, mem.Set("removed", []byte("3")))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("changed", []byte("two")))
+	require.NoError(t, mem.Set("added", []byte("4")))
+	_, err = mem.Delete("removed")
+	require.NoError(t, err)
+
+	before, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+
+	added, removed, changed, err := mem.DiskDiff()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"added": "4"}, added)
+	assert.Equal(t, map[string]string{"removed": "3"}, removed)
+	assert.Equal(t, map[string]string{"changed": "two"}, changed)
+
+	// neither the memory nor the file are changed
+	after, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"added", "changed", "same"}, keys)
+
+	require.NoError(t, mem.Close())
+	added, removed, changed, err = mem.DiskDiff()
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.Nil(t, added)
+	assert.Nil(t, removed)
+	assert.Nil(t, changed)
+}
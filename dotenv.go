@@ -0,0 +1,237 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// DotEnvCodec is a Codec that stores the memory as lines of KEY=value pairs,
+// just like a .env file, so other tools can read the memory file directly. The
+// lines are sorted by key.
+//
+// Values that only consist of printable ASCII characters without quotes,
+// backslashes, dollar signs or comment characters are written as is. All other
+// values are written in double quotes and escaped, so arbitrary bytes survive
+// a round trip: backslashes, quotes and dollar signs are escaped with a
+// backslash, newlines, carriage returns and tabs are written as \n, \r and \t,
+// and all other control characters and invalid UTF-8 are written as \xHH. Keys
+// that contain any character apart from letters, digits and "_", ".", ":", "/"
+// and "-" are quoted the same way, although other tools might not support such
+// keys.
+//
+// Blank lines and lines starting with "#" are ignored when the content is
+// decoded. Note that the format does not distinguish a nil value from an empty
+// one.
+type DotEnvCodec struct{}
+
+// Marshal implements Codec.
+func (DotEnvCodec) Marshal(data map[string][]byte) ([]byte, error) {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		if isPlainDotEnvKey(key) {
+			buf.WriteString(key)
+		} else {
+			writeDotEnvQuoted(&buf, []byte(key))
+		}
+
+		buf.WriteByte('=')
+
+		value := data[key]
+		if isPlainDotEnvValue(value) {
+			buf.Write(value)
+		} else {
+			writeDotEnvQuoted(&buf, value)
+		}
+
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Unmarshal implements Codec.
+func (DotEnvCodec) Unmarshal(content []byte, data *map[string][]byte) error {
+	result := map[string][]byte{}
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		}
+
+		key, value, err := parseDotEnvLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		if _, ok := result[key]; ok {
+			return fmt.Errorf("line %d: duplicate key %q", i+1, key)
+		}
+
+		result[key] = value
+	}
+
+	*data = result
+	return nil
+}
+
+// parseDotEnvLine returns the key and value of a single KEY=value line.
+func parseDotEnvLine(line []byte) (string, []byte, error) {
+	var key []byte
+	var err error
+	if len(line) > 0 && line[0] == '"' {
+		key, line, err = parseDotEnvQuoted(line)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid key: %w", err)
+		}
+		if len(line) == 0 || line[0] != '=' {
+			return "", nil, errors.New(`missing "=" after key`)
+		}
+		line = line[1:]
+	} else {
+		i := bytes.IndexByte(line, '=')
+		if i < 0 {
+			return "", nil, errors.New(`missing "=" after key`)
+		}
+		key, line = line[:i], line[i+1:]
+	}
+
+	if len(line) == 0 || line[0] != '"' {
+		return string(key), append([]byte{}, line...), nil
+	}
+
+	value, rest, err := parseDotEnvQuoted(line)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid value of key %q: %w", key, err)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return "", nil, fmt.Errorf("unexpected content after value of key %q", key)
+	}
+
+	return string(key), value, nil
+}
+
+// parseDotEnvQuoted decodes the double quoted string at the start of s and
+// returns it together with the remaining content after the closing quote.
+func parseDotEnvQuoted(s []byte) (value, rest []byte, err error) {
+	value = []byte{}
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return value, s[i+1:], nil
+		case '\\':
+			if i+1 >= len(s) {
+				return nil, nil, errors.New("unterminated escape sequence")
+			}
+
+			i++
+			switch s[i] {
+			case '\\', '"', '$':
+				value = append(value, s[i])
+			case 'n':
+				value = append(value, '\n')
+			case 'r':
+				value = append(value, '\r')
+			case 't':
+				value = append(value, '\t')
+			case 'x':
+				if i+2 >= len(s) {
+					return nil, nil, errors.New("incomplete \\x escape sequence")
+				}
+
+				b, err := strconv.ParseUint(string(s[i+1:i+3]), 16, 8)
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid \\x escape sequence %q", s[i-1:i+3])
+				}
+
+				value = append(value, byte(b))
+				i += 2
+			default:
+				return nil, nil, fmt.Errorf("invalid escape sequence %q", s[i-1:i+1])
+			}
+		default:
+			value = append(value, c)
+		}
+	}
+
+	return nil, nil, errors.New("missing closing quote")
+}
+
+// writeDotEnvQuoted writes the given value in double quotes and escapes all
+// characters that would otherwise break the line or be interpreted by other
+// tools.
+func writeDotEnvQuoted(buf *bytes.Buffer, value []byte) {
+	const hex = "0123456789abcdef"
+
+	buf.WriteByte('"')
+	for len(value) > 0 {
+		r, size := utf8.DecodeRune(value)
+		switch {
+		case r == '\\', r == '"', r == '$':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == utf8.RuneError && size == 1, r < 0x20, r >= 0x7f && r < 0xa0:
+			// invalid UTF-8 and control characters are escaped byte by byte
+			for _, b := range value[:size] {
+				buf.WriteString(`\x`)
+				buf.WriteByte(hex[b>>4])
+				buf.WriteByte(hex[b&0xf])
+			}
+		default:
+			buf.Write(value[:size])
+		}
+
+		value = value[size:]
+	}
+	buf.WriteByte('"')
+}
+
+// isPlainDotEnvKey returns true if the key can be written without quotes.
+func isPlainDotEnvKey(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_', c == '.', c == ':', c == '/', c == '-':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// isPlainDotEnvValue returns true if the value can be written without quotes.
+func isPlainDotEnvValue(value []byte) bool {
+	for _, c := range value {
+		if c <= ' ' || c >= 0x7f {
+			return false
+		}
+
+		switch c {
+		case '"', '\'', '\\', '#', '$', '`':
+			return false
+		}
+	}
+
+	return true
+}
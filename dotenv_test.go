@@ -0,0 +1,93 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDotEnvCodec(t *testing.T) {
+	data := map[string][]byte{
+		"NAME":         []byte("joe"),
+		"joe.greeting": []byte("hello world"),
+		"EMPTY":        {},
+		"multi":        []byte("line 1\nline 2\r\n\tindented"),
+		"quotes":       []byte(`say "hi" \ $HOME`),
+		"binary":       {0x00, 0xff, 0x10, 'a', 0x7f},
+		"unicode":      []byte("grüße ✓"),
+		"key with = ":  []byte("a=b"),
+		"":             []byte("#not a comment"),
+	}
+
+	content, err := DotEnvCodec{}.Marshal(data)
+	require.NoError(t, err)
+	assert.Equal(t, `""="#not a comment"
+EMPTY=
+NAME=joe
+binary="\x00\xff\x10a\x7f"
+joe.greeting="hello world"
+"key with = "=a=b
+multi="line 1\nline 2\r\n\tindented"
+quotes="say \"hi\" \\ \$HOME"
+unicode="grüße ✓"
+`, string(content))
+
+	var decoded map[string][]byte
+	require.NoError(t, DotEnvCodec{}.Unmarshal(content, &decoded))
+	assert.Equal(t, data, decoded)
+}
+
+func TestDotEnvCodec_Unmarshal(t *testing.T) {
+	content := "# written by hand\r\n\nFOO=bar\r\nURL=https://example.com/?a=b\n"
+
+	var data map[string][]byte
+	require.NoError(t, DotEnvCodec{}.Unmarshal([]byte(content), &data))
+	assert.Equal(t, map[string][]byte{
+		"FOO": []byte("bar"),
+		"URL": []byte("https://example.com/?a=b"),
+	}, data)
+
+	cases := map[string]string{
+		"FOO":             `line 1: missing "=" after key`,
+		`"FOO`:            `line 1: invalid key: missing closing quote`,
+		`"FOO"bar`:        `line 1: missing "=" after key`,
+		`FOO="bar`:        `line 1: invalid value of key "FOO": missing closing quote`,
+		`FOO="bar" baz`:   `line 1: unexpected content after value of key "FOO"`,
+		`FOO="\q"`:        `line 1: invalid value of key "FOO": invalid escape sequence "\\q"`,
+		`FOO="\x4"`:       `line 1: invalid value of key "FOO": invalid \x escape sequence "\\x4\""`,
+		"FOO=a\nFOO=b":    `line 2: duplicate key "FOO"`,
+		"FOO=a\n\nBAR=\"": `line 3: invalid value of key "BAR": missing closing quote`,
+	}
+
+	for content, expected := range cases {
+		err := DotEnvCodec{}.Unmarshal([]byte(content), &data)
+		assert.EqualError(t, err, expected, content)
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithDotEnvCodec(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithDotEnvCodec())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("TOKEN", []byte("secret")))
+	require.NoError(t, mem.Set("motd", []byte("hello\nworld")))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, "TOKEN=secret\nmotd=\"hello\\nworld\"\n", string(content))
+
+	mem, err = NewMemory(tempFile, WithDotEnvCodec())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("motd")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "hello\nworld", string(value))
+}
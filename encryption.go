@@ -0,0 +1,126 @@
+package file
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrDecryptionFailed is returned when an encrypted memory file cannot be
+// decrypted, either because the wrong key was used or because the file is not
+// encrypted or was corrupted (see WithEncryptionKey).
+var ErrDecryptionFailed = errors.New("failed to decrypt memory file")
+
+// encrypt seals the given plaintext with the AEAD of the memory (AES-GCM
+// unless WithEncryptionCipher is used). The random nonce is prepended to the
+// returned ciphertext.
+func (m *Storage) encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, m.aead.NonceSize(), m.aead.NonceSize()+len(plaintext)+m.aead.Overhead())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return m.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// decryptReader reads the entire ciphertext from r and returns a reader for
+// the decrypted content.
+func (m *Storage) decryptReader(r io.Reader) (io.Reader, error) {
+	ciphertext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	plaintext, err := m.decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(plaintext), nil
+}
+
+// decrypt opens a ciphertext that was created via encrypt.
+func (m *Storage) decrypt(ciphertext []byte) ([]byte, error) {
+	n := m.aead.NonceSize()
+	if len(ciphertext) < n {
+		return nil, fmt.Errorf("%w: ciphertext is too short", ErrDecryptionFailed)
+	}
+
+	plaintext, err := m.aead.Open(nil, ciphertext[:n], ciphertext[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrong key or corrupted data", ErrDecryptionFailed)
+	}
+
+	return plaintext, nil
+}
+
+// sealValue returns the representation of a value in the data map. If lazy
+// decryption is enabled, the value is encrypted. Otherwise it is returned as is.
+func (m *Storage) sealValue(value []byte) ([]byte, error) {
+	if !m.sealed {
+		return value, nil
+	}
+
+	return m.encrypt(value)
+}
+
+// openValue is the inverse of sealValue.
+func (m *Storage) openValue(value []byte) ([]byte, error) {
+	if !m.sealed {
+		return value, nil
+	}
+
+	return m.decrypt(value)
+}
+
+// sealedSize returns the length of the plain value of a value in the data map.
+func (m *Storage) sealedSize(value []byte) int {
+	if !m.sealed {
+		return len(value)
+	}
+
+	return len(value) - m.aead.NonceSize() - m.aead.Overhead()
+}
+
+// convertSealedValues makes sure the values of a loaded document are sealed if
+// and only if lazy decryption is enabled. This allows to switch lazy decryption
+// on for an existing memory file.
+func (m *Storage) convertSealedValues(doc *document) error {
+	switch {
+	case doc.SealedValues == m.sealed:
+		return nil
+	case doc.SealedValues:
+		return fmt.Errorf("%w: file contains individually encrypted values but lazy decryption is not enabled",
+			ErrDecryptionFailed,
+		)
+	}
+
+	for key, value := range doc.Data {
+		sealed, err := m.sealValue(value)
+		if err != nil {
+			return err
+		}
+		doc.Data[key] = sealed
+	}
+
+	return nil
+}
+
+// newAEAD returns the AES-GCM cipher for the given AES-256 key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must have 32 bytes but got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
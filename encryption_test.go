@@ -0,0 +1,149 @@
+package file
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithEncryptionKey(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	key := bytes.Repeat([]byte{42}, 32)
+	mem, err := NewMemory(tempFile, WithEncryptionKey(key))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("token", []byte("secret")))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.NotContains(t, string(content), "token")
+
+	mem, err = NewMemory(tempFile, WithEncryptionKey(key))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	val, ok, err := mem.Get("token")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "secret", string(val))
+
+	// a different key cannot decrypt the file
+	_, err = NewMemory(tempFile, WithEncryptionKey(bytes.Repeat([]byte{1}, 32)))
+	require.True(t, errors.Is(err, ErrDecryptionFailed), err)
+
+	// neither can we load it without any key
+	_, err = NewMemory(tempFile)
+	require.Error(t, err)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithEncryptionKey_Plaintext(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	writeMemoryFile(t, tempFile, map[string][]byte{"foo": []byte("bar")})
+
+	_, err := NewMemory(tempFile, WithEncryptionKey(bytes.Repeat([]byte{42}, 32)))
+	require.True(t, errors.Is(err, ErrDecryptionFailed), err)
+}
+
+func TestWithEncryptionKey_InvalidKey(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithEncryptionKey([]byte("too short")))
+	require.EqualError(t, err, "encryption key must have 32 bytes but got 9")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithEncryptionCipher(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	// AES-GCM with a larger nonce stands in for any other AEAD
+	newCipher := func(key []byte) (cipher.AEAD, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCMWithNonceSize(block, 16)
+	}
+
+	key := bytes.Repeat([]byte{42}, 16)
+	mem, err := NewMemory(tempFile, WithEncryptionCipher(key, newCipher))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("token", []byte("secret")))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithEncryptionCipher(key, newCipher))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	val, ok, err := mem.Get("token")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "secret", string(val))
+
+	// the default cipher cannot decrypt the file
+	_, err = NewMemory(tempFile, WithEncryptionKey(bytes.Repeat([]byte{42}, 32)))
+	require.True(t, errors.Is(err, ErrDecryptionFailed), err)
+
+	_, err = NewMemory(tempFilePath(), WithEncryptionCipher([]byte("invalid"), newCipher))
+	require.EqualError(t, err, "crypto/aes: invalid key size 7")
+
+	_, err = NewMemory(tempFilePath(), WithEncryptionCipher(key, nil))
+	require.EqualError(t, err, "cipher must not be nil")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithLazyDecryption(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	key := bytes.Repeat([]byte{42}, 32)
+	mem, err := NewMemory(tempFile, WithEncryptionKey(key))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("old", []byte("plain")))
+	require.NoError(t, mem.Close())
+
+	// an existing file is converted when it is loaded
+	mem, err = NewMemory(tempFile, WithEncryptionKey(key), WithLazyDecryption())
+	require.NoError(t, err)
+	require.NotEqual(t, "plain", string(mem.data["old"]), "values must be encrypted in memory")
+
+	require.NoError(t, mem.Set("token", []byte("secret")))
+	require.NotEqual(t, "secret", string(mem.data["token"]), "values must be encrypted in memory")
+
+	val, ok, err := mem.Get("token")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "secret", string(val))
+
+	sizes, err := mem.KeySizes()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"old": 5, "token": 6}, sizes)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithEncryptionKey(key), WithLazyDecryption())
+	require.NoError(t, err)
+
+	val, ok, err = mem.Get("old")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "plain", string(val))
+	require.NoError(t, mem.Close())
+
+	// the file cannot be loaded without lazy decryption
+	_, err = NewMemory(tempFile, WithEncryptionKey(key))
+	require.True(t, errors.Is(err, ErrDecryptionFailed), err)
+}
+
+func TestWithLazyDecryption_NoKey(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithLazyDecryption())
+	require.EqualError(t, err, "lazy decryption requires an encryption key")
+}
@@ -0,0 +1,140 @@
+package file
+
+import (
+	"bytes"
+	"time"
+)
+
+// entry is the complete state of a single key, including its metadata. It is
+// used to revert a change if the memory file could not be written.
+type entry struct {
+	value   []byte
+	exists  bool
+	version uint64
+	hasVer  bool
+	expires time.Time
+	hasTTL  bool
+	ctype   string
+	hasType bool
+}
+
+// entry returns the current state of the key. The caller must hold the lock.
+func (m *Storage) entry(key string) entry {
+	var e entry
+	e.value, e.exists = m.data[key]
+	e.version, e.hasVer = m.versions[key]
+	e.expires, e.hasTTL = m.expires[key]
+	e.ctype, e.hasType = m.types[key]
+	return e
+}
+
+// restore reverts the key to a state that was returned by entry. The caller
+// must hold the write lock.
+func (m *Storage) restore(key string, e entry) {
+	m.remove(key)
+	if e.exists {
+		m.data[key] = m.internValue(key, e.value)
+	}
+	if e.hasVer {
+		m.setVersion(key, e.version)
+	}
+	if e.hasTTL {
+		m.setExpiry(key, e.expires)
+	}
+	if e.hasType {
+		m.setContentType(key, e.ctype)
+	}
+}
+
+// unchanged returns true if setting the key to the given plain value would not
+// change its state, so the change does not need to be persisted. Keys with a
+// version, TTL or content type are never unchanged, since put updates their
+// metadata.
+func (m *Storage) unchanged(e entry, value []byte) bool {
+	if !e.exists || e.hasVer || e.hasTTL || e.hasType {
+		return false
+	}
+
+	current, err := m.openValue(e.value)
+	if err != nil || (current == nil) != (value == nil) {
+		// a nil value is encoded differently than an empty one
+		return false
+	}
+
+	if len(current) > 0 && len(value) > 0 && &current[0] == &value[0] {
+		// values are not copied, so the caller might have changed the value
+		// of the previous Set in place
+		return false
+	}
+
+	return bytes.Equal(current, value)
+}
+
+// put assigns the stored (i.e. sealed) value to the key. If the key is
+// versioned, its version is incremented. Any expiry and content type of the
+// key is removed. The caller must hold the write lock.
+func (m *Storage) put(key string, stored []byte) {
+	m.markChanged(key)
+	m.touch(key)
+	delete(m.loadedChecksums, key)
+	delete(m.externalNames, key)
+	m.data[key] = m.internValue(key, stored)
+	if version, ok := m.versions[key]; ok {
+		m.versions[key] = version + 1
+	}
+	delete(m.expires, key)
+	delete(m.types, key)
+}
+
+// remove deletes the key and all of its metadata. The caller must hold the
+// write lock.
+func (m *Storage) remove(key string) {
+	m.markChanged(key)
+	delete(m.loadedChecksums, key)
+	delete(m.externalNames, key)
+	delete(m.data, key)
+	m.releaseValue(key)
+	delete(m.versions, key)
+	delete(m.expires, key)
+	delete(m.types, key)
+}
+
+func (m *Storage) setVersion(key string, version uint64) {
+	m.markChanged(key)
+	if m.versions == nil {
+		m.versions = map[string]uint64{}
+	}
+	m.versions[key] = version
+}
+
+func (m *Storage) setExpiry(key string, t time.Time) {
+	m.markChanged(key)
+	if m.expires == nil {
+		m.expires = map[string]time.Time{}
+	}
+	m.expires[key] = t
+}
+
+func (m *Storage) setContentType(key, contentType string) {
+	m.markChanged(key)
+	if m.types == nil {
+		m.types = map[string]string{}
+	}
+	m.types[key] = contentType
+}
+
+// markChanged remembers that the key must be written by the next persist if
+// only parts of the memory are written (see WithShards and WithAppendLog). The
+// caller must hold the write lock.
+func (m *Storage) markChanged(key string) {
+	if m.shards > 0 {
+		m.markShard(key)
+	}
+
+	if m.logRatio > 0 {
+		if m.logKeys == nil {
+			m.logKeys = map[string]struct{}{}
+		}
+		m.logKeys[key] = struct{}{}
+	}
+}
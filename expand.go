@@ -0,0 +1,76 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// expandPath replaces a leading ~/ with the home directory of the current user
+// and references to environment variables ($NAME or ${NAME}) with their
+// values (see WithPathExpansion). Unlike os.ExpandEnv, anything that is not a
+// reference to a variable that is set is kept literally, so a path that happens
+// to contain a $ is not changed.
+func expandPath(path string) (string, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = home + path[1:]
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '$' {
+			b.WriteByte(path[i])
+			continue
+		}
+
+		name, n := envName(path[i+1:])
+		value, ok := os.LookupEnv(name)
+		if n == 0 || !ok {
+			b.WriteByte('$')
+			continue
+		}
+
+		b.WriteString(value)
+		i += n
+	}
+
+	return b.String(), nil
+}
+
+// envName returns the name of the environment variable at the start of s and
+// the number of bytes of its reference, which includes the braces of ${NAME}.
+// If s does not start with a valid name, n is zero.
+func envName(s string) (name string, n int) {
+	braces := strings.HasPrefix(s, "{")
+	if braces {
+		s = s[1:]
+	}
+
+	for n < len(s) && isNameChar(s[n], n == 0) {
+		n++
+	}
+
+	switch {
+	case n == 0:
+		return "", 0
+	case !braces:
+		return s[:n], n
+	case n < len(s) && s[n] == '}':
+		return s[:n], n + 2
+	default:
+		return "", 0
+	}
+}
+
+// isNameChar returns true if c may be part of the name of an environment
+// variable. Names must not start with a digit.
+func isNameChar(c byte, first bool) bool {
+	return c == '_' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(!first && c >= '0' && c <= '9')
+}
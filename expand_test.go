@@ -0,0 +1,49 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	t.Setenv("MEMORY_DIR", "/var/lib/bot")
+	t.Setenv("MEMORY_FILE", "state.json")
+
+	cases := map[string]string{
+		"$MEMORY_DIR/state.json":   "/var/lib/bot/state.json",
+		"${MEMORY_DIR}/bot.json":   "/var/lib/bot/bot.json",
+		"/tmp/$MEMORY_FILE":        "/tmp/state.json",
+		"~/state.json":             filepath.Join(home, "state.json"),
+		"/tmp/$UNDEFINED_VAR.json": "/tmp/$UNDEFINED_VAR.json",
+		"/tmp/price$.json":         "/tmp/price$.json",
+		"/tmp/${MEMORY_DIR":        "/tmp/${MEMORY_DIR",
+		"/tmp/$1.json":             "/tmp/$1.json",
+		"~user/state.json":         "~user/state.json",
+	}
+
+	for path, expected := range cases {
+		actual, err := expandPath(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual, path)
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithPathExpansion(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MEMORY_DIR", dir)
+
+	mem, err := NewMemory("$MEMORY_DIR/joe.json", WithPathExpansion())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	assert.FileExists(t, filepath.Join(dir, "joe.json"))
+}
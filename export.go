@@ -0,0 +1,123 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Export writes all keys and values of the memory to the given writer as a
+// JSON document. Unlike SnapshotTo(…), the output does not depend on the
+// options of the memory: it is never compressed or encrypted and does not use a
+// custom codec, so it can be imported into any other memory via Import(…).
+// Versions (see SetWithVersion) and expiries (see SetWithTTL) are not
+// exported.
+func (m *Storage) Export(w io.Writer) error {
+	if err := m.awaitLoad(); err != nil {
+		return err
+	}
+
+	if err := m.rlock(); err != nil {
+		return err
+	}
+	defer m.runlock()
+
+	data := make(map[string][]byte, len(m.data))
+	for key, value := range m.data {
+		if ok, err := m.verifyValue(key, value); !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, err := m.openValue(value)
+		if err != nil {
+			return err
+		}
+		data[key] = value
+	}
+
+	doc := &document{
+		Version:  formatVersion,
+		Checksum: dataChecksum(data),
+		Data:     data,
+	}
+
+	err := json.NewEncoder(w).Encode(doc)
+	if err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return nil
+}
+
+// Import reads the keys and values that were written via Export(…) and
+// persists the memory once. If replace is true, all keys that are not part of
+// the import are deleted. Otherwise the imported values are merged into the
+// memory and all other keys are left untouched. Imported values behave as if
+// they were assigned via Set, so they do not expire.
+//
+// The imported keys are normalized like the keys of Set (see
+// WithKeyNormalizer). If any imported key is invalid, no change is applied.
+// Just like with ApplyChangeset(…), all changes are reverted if they are
+// rejected by persist.
+func (m *Storage) Import(r io.Reader, replace bool) error {
+	doc, err := decodeDocument(r)
+	if err != nil {
+		return fmt.Errorf("failed to decode import: %w", err)
+	}
+
+	if doc.SealedValues {
+		return errors.New("failed to decode import: values are encrypted")
+	}
+
+	if doc.Checksum != "" && doc.Checksum != dataChecksum(doc.Data) {
+		return fmt.Errorf("failed to decode import: %w", ErrChecksumMismatch)
+	}
+
+	keys := make([]string, 0, len(doc.Data))
+	for key := range doc.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	changes := make([]Change, len(keys))
+	for i, key := range keys {
+		changes[i] = Change{Key: key, Value: doc.Data[key]}
+	}
+
+	changes, stored, err := m.prepareChanges(changes)
+	if err != nil {
+		return err
+	}
+
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	if replace {
+		imported := make(map[string]bool, len(changes))
+		for _, c := range changes {
+			imported[c.Key] = true
+		}
+
+		var removed []string
+		for key := range m.data {
+			if !imported[key] {
+				removed = append(removed, key)
+			}
+		}
+		sort.Strings(removed)
+
+		for _, key := range removed {
+			changes = append(changes, Change{Key: key, Deleted: true})
+			stored = append(stored, nil)
+		}
+	}
+
+	return m.apply(changes, stored)
+}
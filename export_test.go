@@ -0,0 +1,105 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestExportImport(t *testing.T) {
+	source, err := NewMemory(tempFilePath(), WithCompression(), WithLazyDecryption(), WithEncryptionKey(bytes.Repeat([]byte{1}, 32)))
+	require.NoError(t, err)
+	defer os.Remove(source.path)
+	defer source.Close()
+
+	require.NoError(t, source.Set("foo", []byte("bar")))
+	require.NoError(t, source.Set("nil", nil))
+
+	var buf bytes.Buffer
+	require.NoError(t, source.Export(&buf))
+
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	target, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer target.Close()
+
+	require.NoError(t, target.Set("foo", []byte("old")))
+	require.NoError(t, target.Set("other", []byte("kept")))
+	require.NoError(t, target.Import(bytes.NewReader(buf.Bytes()), false))
+
+	assert.Equal(t, map[string][]byte{
+		"foo":   []byte("bar"),
+		"nil":   nil,
+		"other": []byte("kept"),
+	}, target.data)
+
+	require.NoError(t, target.Import(bytes.NewReader(buf.Bytes()), true))
+	assert.Equal(t, map[string][]byte{
+		"foo": []byte("bar"),
+		"nil": nil,
+	}, target.data)
+
+	// the import was persisted
+	reloaded, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer reloaded.Close()
+	assert.Equal(t, target.data, reloaded.data)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestImport_ChecksumMismatch(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	input := `{"version":2,"checksum":"0000","data":{"foo":"YmFy"}}`
+	err = mem.Import(strings.NewReader(input), false)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestImport_NormalizesKeys(t *testing.T) {
+	source, err := NewMemory("")
+	require.NoError(t, err)
+	defer source.Close()
+
+	require.NoError(t, source.Set("Foo", []byte("bar")))
+
+	var buf bytes.Buffer
+	require.NoError(t, source.Export(&buf))
+
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	target, err := NewMemory(tempFile, WithCaseInsensitiveKeys(false))
+	require.NoError(t, err)
+	defer target.Close()
+
+	require.NoError(t, target.Set("FOO", []byte("old")))
+	require.NoError(t, target.Set("other", []byte("removed")))
+	require.NoError(t, target.Import(bytes.NewReader(buf.Bytes()), true))
+
+	value, ok, err := target.Get("Foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bar"), value)
+
+	keys, err := target.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+}
@@ -0,0 +1,249 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// externalSuffix is the file extension of the side files that contain the
+// values of WithExternalValues(…).
+const externalSuffix = ".value"
+
+// externalRefPrefix is used instead of the value of an external key when the
+// checksum of a document is computed, so the checksum covers the references.
+// The values themselves are covered by the names of their side files.
+const externalRefPrefix = "external:"
+
+// externalName caches the name of the side file of a value so the hash of a
+// large value is only computed once. It is only valid as long as the key is
+// still assigned to the same slice, and put removes it whenever the key is
+// assigned again since the caller may have changed the slice in place.
+type externalName struct {
+	ptr  *byte
+	n    int
+	name string
+}
+
+// checkExternalOptions returns an error if external values are combined with
+// options that do not support them.
+func (m *Storage) checkExternalOptions() error {
+	if m.externalThreshold == 0 {
+		return nil
+	}
+
+	switch {
+	case m.codec != nil:
+		return errors.New("external values cannot be combined with a custom codec")
+	case m.aead != nil:
+		return errors.New("external values cannot be combined with encryption")
+	case m.deltaNumeric:
+		return errors.New("external values cannot be combined with delta encoded values")
+	case m.backups > 0:
+		return errors.New("external values cannot be combined with backups")
+	case m.shards > 0:
+		return errors.New("external values cannot be combined with sharding")
+	case m.logRatio > 0:
+		return errors.New("external values cannot be combined with an append log")
+	case m.loaded != nil:
+		return errors.New("external values cannot be combined with a background load")
+	}
+
+	return nil
+}
+
+// externalRefs returns the names of the side files of all values that exceed
+// the threshold of WithExternalValues(…) by their keys. The caller must hold
+// the write lock.
+func (m *Storage) externalRefs() map[string]string {
+	refs := map[string]string{}
+	names := map[string]externalName{}
+	for key, value := range m.data {
+		if len(value) <= m.externalThreshold {
+			continue
+		}
+
+		n, ok := m.externalNames[key]
+		if !ok || n.ptr != &value[0] || n.n != len(value) {
+			n = externalName{ptr: &value[0], n: len(value), name: hashName(value)}
+		}
+
+		names[key] = n
+		refs[key] = n.name
+	}
+
+	m.externalNames = names
+	return refs
+}
+
+// hashName returns the name of the side file of the given value. Since the
+// name is the hash of the value, the integrity of a side file can always be
+// verified and equal values share the same file.
+func hashName(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// isHashName returns true if the name could have been returned by hashName.
+func isHashName(name string) bool {
+	b, err := hex.DecodeString(name)
+	return err == nil && len(b) == sha256.Size && strings.ToLower(name) == name
+}
+
+func (m *Storage) externalPath(name string) string {
+	return filepath.Join(m.externalDir, name+externalSuffix)
+}
+
+// writeExternalValues writes all side files of the given references that do
+// not exist yet. This must happen before the memory file is written, so the
+// memory file never references a side file that does not exist. The caller
+// must hold the write lock.
+func (m *Storage) writeExternalValues(refs map[string]string) error {
+	for key, name := range refs {
+		if m.externalFiles[name] {
+			continue
+		}
+
+		err := m.writeFile(m.externalPath(name), m.data[key])
+		if err != nil {
+			return fmt.Errorf("failed to write external value of key %q: %w", key, err)
+		}
+
+		if m.externalFiles == nil {
+			m.externalFiles = map[string]bool{}
+		}
+		m.externalFiles[name] = true
+	}
+
+	return nil
+}
+
+// removeExternalValues removes all side files that are not referenced anymore
+// after the memory file with the given references was written. The caller must
+// hold the write lock.
+func (m *Storage) removeExternalValues(refs map[string]string) {
+	referenced := make(map[string]bool, len(refs))
+	for _, name := range refs {
+		referenced[name] = true
+	}
+
+	for name := range m.externalFiles {
+		if referenced[name] {
+			continue
+		}
+
+		err := m.fsys.Remove(m.externalPath(name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			// the file is removed on the next start at the latest
+			m.logger.Warn("Failed to remove external value", zap.String("name", name), zap.Error(err))
+			continue
+		}
+
+		delete(m.externalFiles, name)
+	}
+}
+
+// readExternalValues replaces the references of the document with the values
+// of their side files. An error is returned if a side file is missing or if
+// its content does not match its name.
+func (m *Storage) readExternalValues(doc *document) error {
+	if len(doc.External) == 0 {
+		return nil
+	}
+
+	if m.externalThreshold == 0 {
+		return fmt.Errorf("memory file references %d external values but external values are not enabled",
+			len(doc.External),
+		)
+	}
+
+	for key, name := range doc.External {
+		if !isHashName(name) {
+			return fmt.Errorf("invalid reference %q of external value of key %q", name, key)
+		}
+
+		value, err := m.readAll(m.externalPath(name))
+		if err != nil {
+			return fmt.Errorf("failed to read external value of key %q: %w", key, err)
+		}
+
+		if _, ok := doc.Checksums[key]; hashName(value) != name && !(m.valueChecksums && ok) {
+			return fmt.Errorf("external value of key %q: %w", key, ErrChecksumMismatch)
+		}
+
+		doc.Data[key] = value
+	}
+
+	return nil
+}
+
+// useExternalValues remembers the side files that are referenced by the given
+// content of the memory file.
+func (m *Storage) useExternalValues(f *fileContent) {
+	m.externalFiles = map[string]bool{}
+	m.externalNames = map[string]externalName{}
+	if f == nil {
+		return
+	}
+
+	for key, name := range f.external {
+		m.externalFiles[name] = true
+		if value := f.data[key]; len(value) > 0 {
+			m.externalNames[key] = externalName{ptr: &value[0], n: len(value), name: name}
+		}
+	}
+}
+
+// collectOrphans removes all side files in the directory of the external
+// values which are not referenced by the memory file. Such files are left
+// behind if the process stopped after a memory file was written but before the
+// side files that it does not reference anymore were removed. Files that were
+// not written by this package are never removed.
+func (m *Storage) collectOrphans() {
+	entries, err := m.readDir(m.externalDir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			m.logger.Warn("Failed to list external values", zap.String("dir", m.externalDir), zap.Error(err))
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), externalSuffix)
+		if entry.IsDir() || name == entry.Name() || !isHashName(name) || m.externalFiles[name] {
+			continue
+		}
+
+		err := m.fsys.Remove(m.externalPath(name))
+		if err != nil {
+			m.logger.Warn("Failed to remove orphaned external value", zap.String("name", name), zap.Error(err))
+			continue
+		}
+
+		m.logger.Debug("Removed orphaned external value", zap.String("name", name))
+	}
+}
+
+// withReferences returns the data of a document with the references of all
+// external values, which is the data that the checksum of a document covers.
+func withReferences(data map[string][]byte, refs map[string]string) map[string][]byte {
+	if len(refs) == 0 {
+		return data
+	}
+
+	result := make(map[string][]byte, len(data)+len(refs))
+	for key, value := range data {
+		result[key] = value
+	}
+	for key, name := range refs {
+		result[key] = []byte(externalRefPrefix + name)
+	}
+
+	return result
+}
@@ -0,0 +1,180 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithExternalValues(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+	valuesDir := filepath.Join(dir, "values")
+	large := bytes.Repeat([]byte("x"), 100)
+
+	mem, err := NewMemory(tempFile, WithExternalValues(10, valuesDir))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("small", []byte("bar")))
+	require.NoError(t, mem.Set("large", large))
+	require.NoError(t, mem.Set("copy", large))
+
+	side := filepath.Join(valuesDir, hashName(large)+externalSuffix)
+	content, err := os.ReadFile(side)
+	require.NoError(t, err)
+	assert.Equal(t, large, content)
+
+	content, err = os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "eHh4") // base64 of "xxx"
+	assert.Contains(t, string(content), hashName(large))
+
+	value, ok, err := mem.Get("large")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, large, value)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithExternalValues(10, valuesDir))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err = mem.Get("large")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, large, value)
+
+	// the side file is shared until the last key that references it is gone
+	_, err = mem.Delete("large")
+	require.NoError(t, err)
+	assert.FileExists(t, side)
+
+	_, err = mem.Delete("copy")
+	require.NoError(t, err)
+	assert.NoFileExists(t, side)
+
+	value, ok, err = mem.Get("small")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bar"), value)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithExternalValues_Update(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+	valuesDir := filepath.Join(dir, "values")
+	first := bytes.Repeat([]byte("a"), 20)
+	second := bytes.Repeat([]byte("b"), 20)
+
+	mem, err := NewMemory(tempFile, WithExternalValues(10, valuesDir))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", first))
+	require.NoError(t, mem.Set("foo", second))
+
+	assert.NoFileExists(t, filepath.Join(valuesDir, hashName(first)+externalSuffix))
+	assert.FileExists(t, filepath.Join(valuesDir, hashName(second)+externalSuffix))
+
+	// values that shrink below the threshold are stored inline again
+	require.NoError(t, mem.Set("foo", []byte("small")))
+	entries, err := os.ReadDir(valuesDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithExternalValues_ReusedBuffer(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+	valuesDir := filepath.Join(dir, "values")
+	buf := bytes.Repeat([]byte("A"), 20)
+
+	mem, err := NewMemory(tempFile, WithExternalValues(4, valuesDir))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("k", buf))
+	copy(buf, bytes.Repeat([]byte("B"), len(buf)))
+	require.NoError(t, mem.Set("k", buf))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithExternalValues(4, valuesDir))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("k")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, bytes.Repeat([]byte("B"), 20), value)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithExternalValues_Orphans(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+	valuesDir := filepath.Join(dir, "values")
+	large := bytes.Repeat([]byte("x"), 100)
+
+	mem, err := NewMemory(tempFile, WithExternalValues(10, valuesDir))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("large", large))
+	require.NoError(t, mem.Close())
+
+	orphan := filepath.Join(valuesDir, hashName([]byte("orphan"))+externalSuffix)
+	require.NoError(t, os.WriteFile(orphan, []byte("orphan"), 0644))
+	unrelated := filepath.Join(valuesDir, "notes.value")
+	require.NoError(t, os.WriteFile(unrelated, []byte("keep me"), 0644))
+
+	mem, err = NewMemory(tempFile, WithExternalValues(10, valuesDir))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	assert.NoFileExists(t, orphan)
+	assert.FileExists(t, unrelated)
+	assert.FileExists(t, filepath.Join(valuesDir, hashName(large)+externalSuffix))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithExternalValues_BrokenReference(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+	valuesDir := filepath.Join(dir, "values")
+	large := bytes.Repeat([]byte("x"), 100)
+	side := filepath.Join(valuesDir, hashName(large)+externalSuffix)
+
+	mem, err := NewMemory(tempFile, WithExternalValues(10, valuesDir))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("large", large))
+	require.NoError(t, mem.Close())
+
+	_, err = NewMemory(tempFile)
+	assert.EqualError(t, err, `failed to load "`+tempFile+`": memory file references 1 external values but external values are not enabled`)
+
+	require.NoError(t, os.WriteFile(side, []byte("modified"), 0644))
+	_, err = NewMemory(tempFile, WithExternalValues(10, valuesDir))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	require.NoError(t, os.Remove(side))
+	_, err = NewMemory(tempFile, WithExternalValues(10, valuesDir))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestWithExternalValues_Options(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+
+	_, err := NewMemory(tempFile, WithExternalValues(0, dir))
+	assert.EqualError(t, err, "external value threshold must be positive but got 0")
+
+	_, err = NewMemory(tempFile, WithExternalValues(10, ""))
+	assert.EqualError(t, err, "directory of external values must not be empty")
+
+	_, err = NewMemory(tempFile, WithExternalValues(10, dir), WithShards(2))
+	assert.EqualError(t, err, "external values cannot be combined with sharding")
+}
@@ -0,0 +1,51 @@
+package file
+
+import "fmt"
+
+// getFallback reads a key that is missing in this memory from the fallback
+// memory (see WithFallback).
+func (m *Storage) getFallback(key string) ([]byte, bool, error) {
+	value, ok, err := m.fallback.Get(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read key %q from fallback memory: %w", key, err)
+	}
+
+	return value, ok, nil
+}
+
+// fallbackKeyList returns the keys of the fallback memory if they should be
+// included in Keys() (see WithFallback). Otherwise it returns no keys.
+func (m *Storage) fallbackKeyList() ([]string, error) {
+	if m.fallback == nil || !m.fallbackKeys {
+		return nil, nil
+	}
+
+	keys, err := m.fallback.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys of fallback memory: %w", err)
+	}
+
+	return keys, nil
+}
+
+// unionKeys appends all keys of the fallback memory that are not contained in
+// the given keys yet.
+func unionKeys(keys, fallback []string) []string {
+	if len(fallback) == 0 {
+		return keys
+	}
+
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		seen[key] = struct{}{}
+	}
+
+	for _, key := range fallback {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,92 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithFallback(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	base, err := NewMemory("")
+	require.NoError(t, err)
+	require.NoError(t, base.Set("greeting", []byte("hello")))
+	require.NoError(t, base.Set("color", []byte("blue")))
+
+	mem, err := NewMemory(tempFile, WithFallback(base, false))
+	require.NoError(t, err)
+
+	value, ok, err := mem.Get("greeting")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "hello", string(value))
+
+	// the memory shadows the fallback
+	require.NoError(t, mem.Set("color", []byte("red")))
+	value, ok, err = mem.Get("color")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "red", string(value))
+
+	// changes never modify the fallback
+	value, ok, err = base.Get("color")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "blue", string(value))
+
+	// deleting the override makes the fallback visible again
+	ok, err = mem.Delete("color")
+	require.NoError(t, err)
+	require.True(t, ok)
+	value, ok, err = mem.Get("color")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "blue", string(value))
+
+	_, ok, err = mem.Get("missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, mem.Set("name", []byte("joe")))
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"name"}, keys)
+
+	require.NoError(t, mem.Close())
+	require.NoError(t, base.Close())
+}
+
+func TestWithFallback_UnionKeys(t *testing.T) {
+	base, err := NewMemory("")
+	require.NoError(t, err)
+	require.NoError(t, base.Set("greeting", []byte("hello")))
+	require.NoError(t, base.Set("color", []byte("blue")))
+
+	mem, err := NewMemory("", WithFallback(base, true))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("color", []byte("red")))
+	require.NoError(t, mem.Set("name", []byte("joe")))
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"color", "greeting", "name"}, keys)
+
+	// errors of the fallback are returned
+	require.NoError(t, base.Close())
+	_, err = mem.Keys()
+	assert.True(t, errors.Is(err, ErrClosed))
+	_, _, err = mem.Get("greeting")
+	assert.True(t, errors.Is(err, ErrClosed))
+
+	require.NoError(t, mem.Close())
+}
+
+func TestWithFallback_Invalid(t *testing.T) {
+	_, err := NewMemory("", WithFallback(nil, false))
+	assert.EqualError(t, err, "fallback memory must not be nil")
+}
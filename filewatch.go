@@ -0,0 +1,76 @@
+package file
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// watchFile polls the memory file for changes by other processes until the
+// memory is closed (see WithFileWatch).
+func (m *Storage) watchFile(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.fileWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.reloadIfChanged()
+		}
+	}
+}
+
+// reloadIfChanged reloads the memory file if it was modified since it was last
+// read or written by this memory. The size and modification time of the file
+// are used to avoid reading the file on every poll, while the checksum of its
+// content filters out the writes of the memory itself.
+func (m *Storage) reloadIfChanged() {
+	info, err := m.fsys.Stat(m.path)
+	if err != nil {
+		// the file was not written yet or it was removed by another process
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data == nil || !m.isLoaded() {
+		return
+	}
+
+	if info.ModTime().Equal(m.watchedModTime) && info.Size() == m.watchedSize {
+		return
+	}
+
+	m.watchedModTime = info.ModTime()
+	m.watchedSize = info.Size()
+
+	f, err := m.readFile(m.path)
+	if err == nil && f != nil {
+		err = m.checkLoadedKeys(m.path, f.data)
+	}
+
+	if err != nil {
+		m.logger.Error("Failed to reload modified memory file; keeping current data", zap.Error(err))
+		return
+	}
+
+	if f == nil || f.checksum == m.diskChecksum {
+		return
+	}
+
+	if m.dirty {
+		m.logger.Warn("Ignoring modified memory file because there are changes that were not persisted yet",
+			zap.String("path", m.path),
+		)
+		return
+	}
+
+	m.logger.Info("Reloading memory file that was modified by another process", zap.String("path", m.path))
+	m.notifyReload(m.data, f.data)
+	m.data = f.data
+	m.useFile(f)
+	m.indexValues()
+}
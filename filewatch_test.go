@@ -0,0 +1,74 @@
+package file
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithFileWatch(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	core, logs := observer.New(zap.InfoLevel)
+
+	// polling never happens during the test, instead we trigger it manually
+	mem, err := NewMemory(tempFile, WithLogger(zap.New(core)), WithFileWatch(time.Hour))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	watch, unsubscribe := mem.Watch("foo")
+	defer unsubscribe()
+
+	// writes of the memory itself do not trigger a reload
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	<-watch
+	mem.reloadIfChanged()
+	require.Equal(t, 0, logs.FilterMessage("Reloading memory file that was modified by another process").Len())
+
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":"YmF6IQ=="}`), 0600))
+	mem.reloadIfChanged()
+	require.Equal(t, 1, logs.FilterMessage("Reloading memory file that was modified by another process").Len())
+	require.Equal(t, WatchEvent{Value: []byte("baz!")}, <-watch)
+
+	val, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "baz!", string(val))
+
+	// invalid files are ignored
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{invalid`), 0600))
+	mem.reloadIfChanged()
+	require.Equal(t, 1, logs.FilterMessage("Failed to reload modified memory file; keeping current data").Len())
+
+	val, ok, err = mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "baz!", string(val))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithFileWatch_Poll(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFileWatch(time.Millisecond))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":"YmFy"}`), 0600))
+	eventually(t, func() bool {
+		val, ok, err := mem.Get("foo")
+		return err == nil && ok && string(val) == "bar"
+	})
+}
+
+func TestWithFileWatch_InvalidInterval(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithFileWatch(0))
+	require.EqualError(t, err, "poll interval must be positive but got 0s")
+}
@@ -0,0 +1,127 @@
+package file
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// commit persists a change of the memory. If a flush interval is configured or
+// the memory is paused (see Pause), the memory is only marked as dirty and
+// persisted later. The memory also stays dirty if the file could not be
+// written, e.g. because the disk is full, so Close() can try again to make the
+// file match the data in memory. If the memory exceeds its LRU limit (see
+// WithLRU), the least recently used keys are evicted first. The caller must
+// hold the write lock.
+func (m *Storage) commit(ctx context.Context) error {
+	evicted := m.evictLRU()
+	err := m.commitData(ctx)
+	if len(evicted) > 0 {
+		m.finishEviction(evicted, err)
+	}
+
+	return err
+}
+
+// commitData implements commit without evicting any keys.
+func (m *Storage) commitData(ctx context.Context) error {
+	if m.paused {
+		// the change is persisted by Resume (or Close)
+		m.dirty = true
+		return nil
+	}
+
+	if m.flushInterval > 0 {
+		if !m.dirty && m.maxFlushDelay > 0 {
+			m.flushTimer = time.AfterFunc(m.maxFlushDelay, m.flushDelayed)
+		}
+		m.dirty = true
+		return nil
+	}
+
+	err := m.persistData(ctx, m.canUnlockPersist())
+	switch {
+	case err == nil:
+		m.dirty = false
+	case !isRejected(err):
+		err = m.handlePersistError(err)
+	}
+
+	return err
+}
+
+// flushPeriodically persists the memory at most once per flush interval if it
+// has been changed in the meantime.
+func (m *Storage) flushPeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.mu.Lock()
+			if m.data != nil && !m.paused {
+				_ = m.flush()
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// flush persists the memory if it is dirty. Errors are logged and the memory
+// stays dirty so the flush is retried later. The caller must hold the write
+// lock.
+func (m *Storage) flush() error {
+	if !m.dirty {
+		return nil
+	}
+
+	err := m.persist(context.Background())
+	if err != nil {
+		m.logger.Error("Failed to flush memory to disk", zap.String("path", m.path), zap.Error(err))
+		return err
+	}
+
+	m.dirty = false
+	if m.flushTimer != nil {
+		m.flushTimer.Stop()
+		m.flushTimer = nil
+	}
+
+	m.flushChanges()
+	return nil
+}
+
+// Flush writes all changes that have not been persisted yet to the memory file
+// immediately, without waiting for the next flush (see WithFlushInterval and
+// WithMaxFlushDelay) or for Resume() if the memory is paused. Once Flush
+// returned nil, the memory file contains all changes that were made before it
+// was called. If there are no such changes (e.g. because each change is
+// persisted immediately), Flush does nothing and returns nil.
+//
+// An error is returned if this function is called after the memory was closed
+// already or if the memory file could not be written.
+func (m *Storage) Flush() error {
+	if err := m.lockData(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	return m.flush()
+}
+
+// flushDelayed flushes the memory once its oldest change that has not been
+// persisted yet has reached the maximum delay (see WithMaxFlushDelay). If the
+// flush fails, it is retried by the periodic flush.
+func (m *Storage) flushDelayed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.flushTimer = nil
+	if m.data != nil && !m.paused {
+		_ = m.flush()
+	}
+}
@@ -0,0 +1,155 @@
+package file
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithFlushInterval(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFlushInterval(time.Millisecond))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	eventually(t, func() bool {
+		content, err := os.ReadFile(tempFile)
+		return err == nil && string(content) == `{"version":2,"checksum":"a3cab5fa8b0c31746e3bd912ca859bce37777fd8d29b991e946c3baba9fbdd49","data":{"foo":"YmFy"}}`+"\n"
+	})
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithFlushInterval_Close(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	_, err = mem.Delete("baz")
+	require.NoError(t, err)
+
+	_, err = os.Stat(tempFile)
+	require.True(t, os.IsNotExist(err), "changes must not be written before the flush")
+
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, `{"version":2,"checksum":"a3cab5fa8b0c31746e3bd912ca859bce37777fd8d29b991e946c3baba9fbdd49","data":{"foo":"YmFy"}}`+"\n", string(content))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithFlushInterval_CloseError(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFlushInterval(time.Hour), WithMaxSerializedSize(5))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	err = mem.Close()
+	require.ErrorIs(t, err, ErrMaxSerializedSize)
+}
+
+func TestWithFlushInterval_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithFlushInterval(0))
+	require.EqualError(t, err, "flush interval must be positive but got 0s")
+}
+
+func (m *Storage) pendingFlushTimer() *time.Timer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flushTimer
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMaxFlushDelay(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	const delay = 250 * time.Millisecond
+	mem, err := NewMemory(tempFile, WithFlushInterval(time.Hour), WithMaxFlushDelay(delay))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	start := time.Now()
+	require.NoError(t, mem.Set("counter", []byte("0")))
+	timer := mem.pendingFlushTimer()
+	require.NotNil(t, timer)
+
+	// a steady stream of changes must not postpone the write
+	for i := 1; ; i++ {
+		require.NoError(t, mem.Set("counter", []byte(strconv.Itoa(i))))
+		if _, err := os.Stat(tempFile); err == nil {
+			break
+		}
+
+		// the timer is only gone if it fired right after the file was checked
+		if current := mem.pendingFlushTimer(); current != nil {
+			require.Same(t, timer, current, "a later change must not restart the timer")
+		}
+		require.Less(t, time.Since(start), 2*delay, "memory file was not written within the max flush delay")
+		time.Sleep(time.Millisecond)
+	}
+
+	// the write happens once the oldest change is d old, long before the
+	// periodic flush is due
+	elapsed := time.Since(start)
+	require.GreaterOrEqual(t, elapsed, delay)
+	require.Less(t, elapsed, 2*delay)
+
+	// whichever flush happens first stops the other one from writing again
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NotNil(t, mem.pendingFlushTimer())
+	require.NoError(t, mem.Flush())
+	require.Nil(t, mem.pendingFlushTimer())
+}
+
+func TestWithMaxFlushDelay_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithMaxFlushDelay(0))
+	require.EqualError(t, err, "max flush delay must be positive but got 0s")
+
+	_, err = NewMemory(tempFilePath(), WithMaxFlushDelay(time.Second))
+	require.EqualError(t, err, "max flush delay requires a longer flush interval")
+
+	_, err = NewMemory(tempFilePath(), WithFlushInterval(time.Second), WithMaxFlushDelay(time.Second))
+	require.EqualError(t, err, "max flush delay requires a longer flush interval")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Flush(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoFileExists(t, tempFile)
+
+	require.NoError(t, mem.Flush())
+	require.FileExists(t, tempFile)
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"foo":"YmFy"`)
+
+	// nothing is written if there are no pending changes
+	require.NoError(t, os.Remove(tempFile))
+	require.NoError(t, mem.Flush())
+	require.NoFileExists(t, tempFile)
+
+	require.NoError(t, mem.Close())
+	require.ErrorIs(t, mem.Flush(), ErrClosed)
+}
@@ -0,0 +1,472 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// The versions of the file format. Version 1 is a plain JSON object that maps
+// each key to its base64 encoded value. Files that were written before values
+// were stored as bytes (i.e. before v0.3.0) use the same object but map each key
+// to its value as plain string, so they are read as version 1 as well (see
+// decodeLegacyData). Version 2 wraps this object in a document that carries
+// additional header fields. Files are always written in the latest version,
+// while older files are migrated when they are loaded.
+const (
+	legacyFormatVersion = 1
+	formatVersion       = 2
+)
+
+// ErrNoHeader is returned by FileAge(…) if the memory file was not written
+// with a header (see WithTimestampHeader).
+var ErrNoHeader = errors.New("memory file has no timestamp header")
+
+// ErrUnsupportedFormatVersion is returned when a memory file was written in a
+// newer format than this version of the package can read. This usually means a
+// bot was downgraded after a newer version has written its memory file.
+var ErrUnsupportedFormatVersion = errors.New("unsupported memory file format version")
+
+// ErrChecksumMismatch is returned when the data of a memory file does not match
+// the checksum that was stored when the file was written. This indicates that
+// the file was corrupted on disk or edited by hand. A hand-edited file can be
+// repaired via Normalize(…).
+var ErrChecksumMismatch = errors.New("memory file checksum mismatch")
+
+// document is the structure of the memory file.
+// The order of the fields matters because the header fields must be written
+// before the data so they can be read without decoding the entire file.
+type document struct {
+	Version      int                  `json:"version"`
+	WrittenAt    *time.Time           `json:"written_at,omitempty"`
+	Versions     map[string]uint64    `json:"versions,omitempty"`
+	Expires      map[string]time.Time `json:"expires,omitempty"`
+	ContentTypes map[string]string    `json:"content_types,omitempty"` // see SetTyped
+	SealedValues bool                 `json:"sealed_values,omitempty"` // each value is encrypted individually
+	Delta        *deltaValues         `json:"delta,omitempty"`
+	Dedup        *dedupValues         `json:"dedup,omitempty"` // see WithDedup
+	Metadata     *metadata            `json:"metadata,omitempty"`
+	Checksum     string               `json:"checksum,omitempty"`        // see dataChecksum
+	RawStrings   bool                 `json:"raw_strings,omitempty"`     // see rawStringsDocument
+	External     map[string]string    `json:"external,omitempty"`        // see WithExternalValues
+	Checksums    map[string]uint32    `json:"value_checksums,omitempty"` // see WithValueChecksums
+	Data         map[string][]byte    `json:"data"`
+}
+
+// metadata is purely informational and describes when, where and what was
+// written (see WithMetadataHeader). It is ignored when the file is loaded.
+type metadata struct {
+	WrittenAt string `json:"written_at"` // RFC 3339
+	Hostname  string `json:"hostname,omitempty"`
+	NumKeys   int    `json:"num_keys"`
+}
+
+// newMetadata returns the metadata for the given data that is written at the
+// given time.
+func newMetadata(data map[string][]byte, now time.Time) *metadata {
+	hostname, _ := os.Hostname() // the hostname is omitted if it is unknown
+	return &metadata{
+		WrittenAt: now.UTC().Format(time.RFC3339),
+		Hostname:  hostname,
+		NumKeys:   len(data),
+	}
+}
+
+// decodeDocument reads a memory file in any of the supported formats. The file
+// is decoded as a stream of JSON tokens so the values of the data field are
+// decoded one by one, without holding the encoded data of the entire file in
+// memory. This requires that the header fields which change the encoding of the
+// data (i.e. raw_strings) are written before the data, just like the document
+// type does.
+func decodeDocument(r io.Reader) (*document, error) {
+	return decodeDocumentWith(r, nil, false)
+}
+
+// decodeDocumentWith implements decodeDocument. If index is not nil, the values
+// of the data field are not decoded at all. Instead, the position of each value
+// in the stream is added to the index and the data of the document is empty
+// (see WithLazyLoad). If strict is true, unknown and duplicate fields are
+// rejected (see WithStrictDecoding).
+func decodeDocumentWith(r io.Reader, index map[string]lazyValue, strict bool) (*document, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok != json.Delim('{') {
+		return nil, errors.New("memory file does not contain a JSON object")
+	}
+
+	raw := map[string]json.RawMessage{}
+	var data map[string][]byte // only set if the data field is an object
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		name := tok.(string) // object keys are always strings
+		if _, ok := raw[name]; strict && (ok || (name == "data" && data != nil)) {
+			return nil, fmt.Errorf("duplicate %s field", name)
+		}
+
+		if name == "data" {
+			data, err = decodeDataField(dec, raw, index, strict)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var value json.RawMessage
+		err = dec.Decode(&value)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if name == "raw_strings" && data != nil && isTrue(value) {
+			return nil, errors.New("invalid raw_strings field: must be written before the data field")
+		}
+
+		raw[name] = value
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	doc := new(document)
+	if !isVersioned(raw, data != nil) {
+		if data != nil {
+			return nil, errors.New(`invalid value for key "data": value must be a string`)
+		}
+
+		doc.Version = legacyFormatVersion
+		doc.Data, err = decodeLegacyData(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		return doc, nil
+	}
+
+	fields := map[string]interface{}{
+		"version":         &doc.Version,
+		"written_at":      &doc.WrittenAt,
+		"versions":        &doc.Versions,
+		"expires":         &doc.Expires,
+		"content_types":   &doc.ContentTypes,
+		"delta":           &doc.Delta,
+		"dedup":           &doc.Dedup,
+		"sealed_values":   &doc.SealedValues,
+		"checksum":        &doc.Checksum,
+		"raw_strings":     &doc.RawStrings,
+		"external":        &doc.External,
+		"value_checksums": &doc.Checksums,
+	}
+
+	if strict {
+		for name := range raw {
+			if _, ok := fields[name]; !ok && name != "metadata" {
+				return nil, fmt.Errorf("unknown %s field", name)
+			}
+		}
+	}
+
+	for name, dest := range fields {
+		value, ok := raw[name]
+		if !ok {
+			continue
+		}
+
+		err := unmarshalField(value, dest, strict)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s field: %w", name, err)
+		}
+	}
+
+	doc.Data = data
+	if doc.Version > formatVersion {
+		return nil, fmt.Errorf("%w: file has version %d but only versions up to %d are supported",
+			ErrUnsupportedFormatVersion, doc.Version, formatVersion,
+		)
+	}
+
+	if doc.Delta != nil {
+		err := doc.Delta.unpack(doc.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid delta field: %w", err)
+		}
+	}
+
+	if doc.Dedup != nil {
+		if doc.Data == nil {
+			doc.Data = map[string][]byte{}
+		}
+		err := doc.Dedup.unpack(doc.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dedup field: %w", err)
+		}
+	}
+
+	return doc, nil
+}
+
+// unmarshalField decodes the raw value of a header field into dest. If strict
+// is true, unknown fields of nested objects are rejected and numbers are only
+// decoded into the exact type of dest.
+func unmarshalField(value json.RawMessage, dest interface{}, strict bool) error {
+	if !strict {
+		return json.Unmarshal(value, dest)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	return dec.Decode(dest)
+}
+
+// decodeLegacyData decodes the values of a legacy file. Usually its values are
+// base64 encoded, but files that were written before values were stored as
+// bytes contain the values as plain strings. If any value is not valid base64,
+// all values are decoded as plain strings instead. A file of plain strings that
+// all happen to be valid base64 cannot be told apart and is decoded as base64.
+func decodeLegacyData(raw map[string]json.RawMessage) (map[string][]byte, error) {
+	data := make(map[string][]byte, len(raw))
+	var base64Err error
+	for key, value := range raw {
+		var b []byte
+		err := json.Unmarshal(value, &b)
+		if err != nil {
+			base64Err = fmt.Errorf("invalid value for key %q: %w", key, err)
+			break
+		}
+		data[key] = b
+	}
+
+	if base64Err == nil {
+		return data, nil
+	}
+
+	for key, value := range raw {
+		var str *string
+		if err := json.Unmarshal(value, &str); err != nil {
+			// neither base64 nor plain strings, so report the original error
+			return nil, base64Err
+		}
+
+		if str == nil {
+			data[key] = nil
+		} else {
+			data[key] = []byte(*str)
+		}
+	}
+
+	return data, nil
+}
+
+// decodeDataField reads the value of the data field from the decoder. If the
+// value is an object, it is decoded key by key using the encoding that is
+// indicated by the header fields that were read already and the decoded data
+// is returned. Any other value is stored in raw instead, since it can only be a
+// key of a legacy file. If index is not nil, the values are indexed instead
+// (see decodeDocumentWith). If strict is true, duplicate keys are rejected.
+func decodeDataField(dec *json.Decoder, raw map[string]json.RawMessage, index map[string]lazyValue, strict bool) (map[string][]byte, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	if _, ok := tok.(json.Delim); !ok {
+		value, err := json.Marshal(tok)
+		if err != nil {
+			return nil, err
+		}
+		raw["data"] = value
+		return nil, nil
+	}
+
+	if tok != json.Delim('{') {
+		return nil, errors.New("invalid data field: value must be an object")
+	}
+
+	rawStrings := isTrue(raw["raw_strings"])
+	data := map[string][]byte{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		key := tok.(string)
+		if _, ok := data[key]; strict && ok {
+			return nil, fmt.Errorf("invalid data field: duplicate key %q", key)
+		}
+		if _, ok := index[key]; strict && ok {
+			return nil, fmt.Errorf("invalid data field: duplicate key %q", key)
+		}
+
+		if index != nil {
+			// the value starts after the separator that follows the key
+			start := dec.InputOffset()
+			var value json.RawMessage
+			err = dec.Decode(&value)
+			index[key] = lazyValue{offset: start, length: dec.InputOffset() - start}
+		} else if rawStrings {
+			var value textValue
+			err = dec.Decode(&value)
+			data[key] = value
+		} else {
+			var value []byte
+			err = dec.Decode(&value)
+			data[key] = value
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid data field: %w", unexpectedEOF(err))
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	return data, nil
+}
+
+// unexpectedEOF converts io.EOF into io.ErrUnexpectedEOF since the decoder
+// only reports io.EOF if the JSON object of a memory file was not complete.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// isTrue returns true if the raw JSON value is the literal true.
+func isTrue(value json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(value), []byte("true"))
+}
+
+// dataChecksum returns the hex encoded SHA-256 checksum of the given data. The
+// checksum is computed over the decoded keys and values instead of the encoded
+// file, so it does not depend on how the data is represented in the file
+// (e.g. via WithDeltaNumericValues). Keys are hashed in sorted order and each
+// key and value is prefixed with its length so the encoding is unambiguous.
+func dataChecksum(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, key := range keys {
+		hash.Write(buf[:binary.PutUvarint(buf, uint64(len(key)))])
+		hash.Write([]byte(key))
+
+		value := data[key]
+		if value == nil {
+			// null and empty values are different in JSON
+			hash.Write([]byte{0})
+			continue
+		}
+
+		hash.Write([]byte{1})
+		hash.Write(buf[:binary.PutUvarint(buf, uint64(len(value)))])
+		hash.Write(value)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// isVersioned returns true if the decoded JSON object is a versioned document
+// rather than a legacy file. Legacy files only contain string or null values,
+// so a numeric version field together with an object in the data field is
+// unambiguous.
+func isVersioned(raw map[string]json.RawMessage, hasData bool) bool {
+	version := bytes.TrimSpace(raw["version"])
+	if len(version) == 0 || !hasData {
+		return false
+	}
+
+	return version[0] == '-' || (version[0] >= '0' && version[0] <= '9')
+}
+
+// FileAge returns how long ago the memory file at the given path was written.
+// This only reads the header of the file and not the entire data, so it is a
+// cheap way to check from outside of the process whether a bot is still
+// updating its memory. The file must have been written with the
+// WithTimestampHeader() option, otherwise ErrNoHeader is returned.
+func FileAge(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	defer f.Close()
+
+	r, err := maybeDecompress(f)
+	if err != nil {
+		return 0, err
+	}
+
+	writtenAt, err := readTimestampHeader(json.NewDecoder(r))
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Since(writtenAt), nil
+}
+
+// readTimestampHeader reads the first fields of a versioned document until it
+// finds the timestamp header.
+func readTimestampHeader(dec *json.Decoder) (time.Time, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	if tok != json.Delim('{') {
+		return time.Time{}, errors.New("memory file does not contain a JSON object")
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read header: %w", err)
+		}
+
+		switch tok {
+		case "version":
+			var version json.RawMessage
+			err = dec.Decode(&version)
+			if err != nil || bytes.HasPrefix(version, []byte(`"`)) {
+				// a string value means this is just a key in a legacy file
+				return time.Time{}, ErrNoHeader
+			}
+		case "written_at":
+			var writtenAt time.Time
+			err = dec.Decode(&writtenAt)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid timestamp header: %w", err)
+			}
+			return writtenAt, nil
+		default:
+			// the header fields are always written first
+			return time.Time{}, ErrNoHeader
+		}
+	}
+
+	return time.Time{}, ErrNoHeader
+}
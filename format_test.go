@@ -0,0 +1,473 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithTimestampHeader(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithTimestampHeader())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	age, err := FileAge(tempFile)
+	require.NoError(t, err)
+	require.True(t, age >= 0 && age < time.Minute, age)
+
+	// the file can be loaded with and without the option
+	for _, opts := range [][]Option{nil, {WithTimestampHeader()}} {
+		mem, err = NewMemory(tempFile, opts...)
+		require.NoError(t, err)
+		val, ok, err := mem.Get("foo")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "bar", string(val))
+		require.NoError(t, mem.Close())
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMetadataHeader(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMetadataHeader())
+	require.NoError(t, err)
+	require.NoError(t, mem.SetMany(map[string][]byte{"foo": []byte("bar"), "baz": nil}))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+
+	var doc struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	require.NoError(t, json.Unmarshal(content, &doc))
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	require.Equal(t, hostname, doc.Metadata["hostname"])
+	require.Equal(t, float64(2), doc.Metadata["num_keys"])
+
+	writtenAt, err := time.Parse(time.RFC3339, doc.Metadata["written_at"].(string))
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), writtenAt, time.Minute)
+
+	// the file can be loaded with and without the option
+	for _, opts := range [][]Option{nil, {WithMetadataHeader()}} {
+		mem, err = NewMemory(tempFile, opts...)
+		require.NoError(t, err)
+		keys, err := mem.Keys()
+		require.NoError(t, err)
+		require.Equal(t, []string{"baz", "foo"}, keys)
+		require.NoError(t, mem.Close())
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestFileAge_NoHeader(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	// a legacy file with a key that happens to be called "version"
+	err := os.WriteFile(tempFile, []byte(`{"version":"MQ==","written_at":"MQ=="}`), 0660)
+	require.NoError(t, err)
+
+	_, err = FileAge(tempFile)
+	require.True(t, errors.Is(err, ErrNoHeader), err)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"version", "written_at"}, keys)
+	require.NoError(t, mem.Close())
+
+	_, err = FileAge(tempFile + ".does-not-exist")
+	require.Error(t, err)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestNormalize(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	handEdited := "{\r\n  \"foo\" :   \"YmFy\",\n\t\"bar\":\"Zm9v\"   }\n\n"
+	require.NoError(t, os.WriteFile(tempFile, []byte(handEdited), 0660))
+
+	require.NoError(t, Normalize(tempFile))
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, `{"version":2,"checksum":"7c5b1e8faad80d9904a013a28725dfd0ffe98f370c35c5c4f4d003569509543a","data":{"bar":"Zm9v","foo":"YmFy"}}`+"\n", string(content))
+
+	require.NoError(t, Normalize(tempFile, WithTimestampHeader()))
+	_, err = FileAge(tempFile)
+	require.NoError(t, err)
+
+	err = Normalize(tempFile + ".does-not-exist")
+	require.Error(t, err)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestLegacyFile_NullVersionKey(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("user:1", []byte("alice")))
+	require.NoError(t, mem.Set("version", nil))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, `{"version":2,"checksum":"9f2175f4b0a4046b42b2b258a6547f8a8b22015b51f8e8cf99950faecb23e150","data":{"user:1":"YWxpY2U=","version":null}}`+"\n", string(content))
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"user:1", "version"}, keys)
+	require.NoError(t, mem.Close())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestNewMemory_UnsupportedFormatVersion(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	content := `{"version":3,"data":{"foo":"YmFy"}}`
+	require.NoError(t, os.WriteFile(tempFile, []byte(content), 0600))
+
+	_, err := NewMemory(tempFile)
+	require.True(t, errors.Is(err, ErrUnsupportedFormatVersion), err)
+	require.NotContains(t, err.Error(), "JSON")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestLegacyFile_Migration(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":"YmFy"}`), 0600))
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	val, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(val))
+
+	// the next write migrates the file to the current format
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, `{"version":2,"checksum":"7589127533e44b97c13b9beda6b7e2104a8af32387bd074dfc2b0284f7594001","data":{"baz":"cXV4","foo":"YmFy"}}`+"\n", string(content))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestLegacyFile_PlainStrings(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	// files written before v0.3.0 contain the values as plain strings
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{"foo":"bar","hello":"Hello, World!","empty":"","null":null}`), 0600))
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	values, err := mem.GetMany([]string{"foo", "hello", "empty", "null"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{
+		"foo":   []byte("bar"),
+		"hello": []byte("Hello, World!"),
+		"empty": []byte(""),
+		"null":  nil,
+	}, values)
+
+	// the next write migrates the file to the current format
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Contains(t, string(content), `"data":{"baz":"cXV4","empty":"","foo":"YmFy","hello":"SGVsbG8sIFdvcmxkIQ==","null":null}`)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	value, ok, err := mem.Get("hello")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "Hello, World!", string(value))
+	require.NoError(t, mem.Close())
+}
+
+func TestDecodeDocument_LegacyInvalidValue(t *testing.T) {
+	_, err := decodeDocument(bytes.NewReader([]byte(`{"foo":42}`)))
+	require.EqualError(t, err, `invalid value for key "foo": json: cannot unmarshal number into Go value of type []uint8`)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestNewMemory_ChecksumMismatch(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	writeMemoryFile(t, tempFile, map[string][]byte{"foo": []byte("hello")})
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+
+	// "aGVsbG8=" is "hello" and "bGVsbG8=" is still valid base64
+	flipped := bytes.Replace(content, []byte("aGVsbG8="), []byte("bGVsbG8="), 1)
+	require.NotEqual(t, content, flipped)
+	require.NoError(t, os.WriteFile(tempFile, flipped, 0660))
+
+	_, err = NewMemory(tempFile)
+	require.True(t, errors.Is(err, ErrChecksumMismatch), err)
+
+	// a checksum mismatch is treated like any other corruption
+	mem, err := NewMemory(tempFile, WithCorruptFilePolicy(StartEmptyKeep))
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	// the hand-edited file can be repaired
+	require.NoError(t, Normalize(tempFile))
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "lello", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestChecksum_DeltaNumericValues(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithDeltaNumericValues())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("a", []byte("1")))
+	require.NoError(t, mem.Set("b", []byte("2")))
+	require.NoError(t, mem.Close())
+
+	// the checksum covers the decoded values
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, _, err := mem.Get("b")
+	require.NoError(t, err)
+	require.Equal(t, "2", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithIndent(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithIndent("", "  "), WithTimestampHeader())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "\n  \"data\": {\n    \"baz\": \"cXV4\",\n    \"foo\": \"YmFy\"\n  }\n}\n")
+
+	_, err = FileAge(tempFile)
+	require.NoError(t, err)
+
+	// both indented and compact files can be loaded with and without the option
+	for _, opts := range [][]Option{nil, {WithIndent("", "\t")}} {
+		mem, err = NewMemory(tempFile, opts...)
+		require.NoError(t, err)
+		value, _, err := mem.Get("foo")
+		require.NoError(t, err)
+		require.Equal(t, "bar", string(value))
+		require.NoError(t, mem.Set("foo", []byte("bar")))
+		require.NoError(t, mem.Close())
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithLineEnding(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithIndent("", "  "), WithLineEnding(CRLF))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar\nbaz")))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Contains(t, string(content), "\r\n  \"data\": {\r\n    \"foo\": \"YmFyCmJheg==\"\r\n  }\r\n}\r\n")
+	require.NotContains(t, strings.ReplaceAll(string(content), "\r\n", ""), "\n")
+
+	// files with either line ending can be loaded
+	for _, style := range []LineEnding{LF, CRLF} {
+		mem, err = NewMemory(tempFile, WithIndent("", "  "), WithLineEnding(style))
+		require.NoError(t, err)
+		value, ok, err := mem.Get("foo")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "bar\nbaz", string(value))
+
+		// each memory rewrites the file with its own line ending
+		require.NoError(t, mem.Set("style", []byte{byte(style)}))
+		require.NoError(t, mem.Close())
+
+		content, err = os.ReadFile(tempFile)
+		require.NoError(t, err)
+		require.Equal(t, style == CRLF, strings.Contains(string(content), "\r\n"))
+	}
+
+	_, err = NewMemory(tempFile, WithLineEnding(42))
+	require.EqualError(t, err, "invalid line ending 42")
+
+	_, err = NewMemory(tempFile, WithLineEnding(CRLF))
+	require.EqualError(t, err, "CRLF line endings require an indented memory file")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_StableOutput(t *testing.T) {
+	keys := []string{"foo", "bar", "baz", "qux", "a", "z"}
+
+	var files [][]byte
+	for _, order := range [][]int{{0, 1, 2, 3, 4, 5}, {5, 3, 1, 4, 2, 0}} {
+		tempFile := tempFilePath()
+		defer os.Remove(tempFile)
+
+		mem, err := NewMemory(tempFile, WithIndent("", "  "))
+		require.NoError(t, err)
+		for _, i := range order {
+			require.NoError(t, mem.SetTyped(keys[i], []byte("<"+keys[i]+">"), "text/"+keys[i]))
+		}
+		require.NoError(t, mem.Close())
+
+		content, err := os.ReadFile(tempFile)
+		require.NoError(t, err)
+		files = append(files, content)
+	}
+
+	// the keys are always written in sorted order, regardless of the order in
+	// which they were set
+	require.Equal(t, string(files[0]), string(files[1]))
+	require.True(t, bytes.HasSuffix(files[0], []byte("\n")))
+}
+
+func TestDecodeDocument_RawStringsAfterData(t *testing.T) {
+	_, err := decodeDocument(bytes.NewReader([]byte(`{"version":2,"data":{"foo":"YmFy"},"raw_strings":true}`)))
+	require.EqualError(t, err, "invalid raw_strings field: must be written before the data field")
+}
+
+func TestDecodeDocument_Legacy(t *testing.T) {
+	doc, err := decodeDocument(bytes.NewReader([]byte(`{"version":"MQ==","data":"YmFy","foo":null}`)))
+	require.NoError(t, err)
+	require.Equal(t, legacyFormatVersion, doc.Version)
+	require.Equal(t, map[string][]byte{
+		"version": []byte("1"),
+		"data":    []byte("bar"),
+		"foo":     nil,
+	}, doc.Data)
+}
+
+func TestDecodeDocument_Strict(t *testing.T) {
+	cases := map[string]string{
+		`{"version":2,"foo":1,"data":{}}`:                  "unknown foo field",
+		`{"version":2,"version":2,"data":{}}`:              "duplicate version field",
+		`{"version":2,"data":{},"data":{}}`:                "duplicate data field",
+		`{"version":2,"data":{"foo":"YmFy","foo":"YmF6"}}`: `invalid data field: duplicate key "foo"`,
+		`{"version":2,"delta":{"unknown":true},"data":{}}`: `invalid delta field: json: unknown field "unknown"`,
+	}
+
+	for content, msg := range cases {
+		_, err := decodeDocument(bytes.NewReader([]byte(content)))
+		require.NoError(t, err, content)
+
+		_, err = decodeDocumentWith(bytes.NewReader([]byte(content)), nil, true)
+		require.Error(t, err, content)
+		require.Contains(t, err.Error(), msg, content)
+	}
+
+	doc, err := decodeDocumentWith(bytes.NewReader([]byte(`{"version":2,"metadata":{"num_keys":1},"data":{"foo":"YmFy"}}`)), nil, true)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"foo": []byte("bar")}, doc.Data)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithStrictDecoding(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	content := `{"version":2,"data":{"foo":"YmFy"},"extra":true}`
+	require.NoError(t, os.WriteFile(tempFile, []byte(content), 0600))
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	_, err = NewMemory(tempFile, WithStrictDecoding())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown extra field")
+
+	_, err = NewMemory(tempFile, WithStrictDecoding(), WithCodec(DotEnvCodec{}))
+	require.EqualError(t, err, "a custom codec cannot be combined with options that require the JSON format")
+}
+
+// BenchmarkDecodeDocument compares the streaming decoder of decodeDocument with
+// decoding the entire document at once, as it was done before.
+func BenchmarkDecodeDocument(b *testing.B) {
+	data := make(map[string][]byte, 10000)
+	for i := 0; i < 10000; i++ {
+		data[fmt.Sprintf("key-%d", i)] = bytes.Repeat([]byte{byte(i)}, 100)
+	}
+
+	content, err := json.Marshal(&document{Version: formatVersion, Data: data})
+	require.NoError(b, err)
+
+	b.Run("streaming", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_, err := decodeDocument(bytes.NewReader(content))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("unmarshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var raw map[string]json.RawMessage
+			err := json.NewDecoder(bytes.NewReader(content)).Decode(&raw)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			doc := new(document)
+			err = json.Unmarshal(raw["data"], &doc.Data)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// FS is the file system that a memory uses to access its memory file and all
+// other files next to it (e.g. backups, the append log or shards). The default
+// FS uses the os package. A custom FS can be set via WithFS(…), e.g. to keep
+// the files in memory in unit tests or to simulate errors of the disk.
+//
+// Errors for files that do not exist must wrap fs.ErrNotExist. If the FS also
+// has a ReadDir(name string) ([]os.DirEntry, error) method, it is used to find
+// files that are left over from earlier runs (e.g. shards or external values
+// that are not needed anymore). Without it, such files are not cleaned up.
+type FS interface {
+	Open(name string) (File, error)
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Stat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// File is a file that was opened via an FS.
+type File interface {
+	io.ReadWriteCloser
+	Sync() error
+}
+
+// checkFSOptions returns an error if a custom FS was combined with an option
+// that needs a file of the operating system.
+func (m *Storage) checkFSOptions() error {
+	if _, ok := m.fsys.(osFS); ok {
+		return nil
+	}
+
+	switch {
+	case m.lazyLoad:
+		return errors.New("a custom file system cannot be combined with lazy loading")
+	case m.exclusiveLock:
+		return errors.New("a custom file system cannot be combined with an exclusive lock")
+	case m.noSymlink:
+		return errors.New("a custom file system cannot be combined with refusing symlinks")
+	}
+
+	return nil
+}
+
+// osFS is the FS of the operating system.
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// readAll returns the entire content of the file at the given path, just like
+// os.ReadFile but via the FS of the memory.
+func (m *Storage) readAll(path string) ([]byte, error) {
+	f, err := m.fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// writeAll replaces the content of the file at the given path, just like
+// os.WriteFile but via the FS of the memory.
+func (m *Storage) writeAll(path string, content []byte, perm os.FileMode) error {
+	f, err := m.fsys.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// readDir returns the entries of the given directory if the FS of the memory
+// can list directories. Otherwise it returns no entries.
+func (m *Storage) readDir(dir string) ([]os.DirEntry, error) {
+	lister, ok := m.fsys.(interface {
+		ReadDir(name string) ([]os.DirEntry, error)
+	})
+	if !ok {
+		return nil, nil
+	}
+
+	return lister.ReadDir(dir)
+}
@@ -0,0 +1,232 @@
+package file
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memFS is an FS that keeps all files in memory. If writeErr is set, all writes
+// fail with this error.
+type memFS struct {
+	mu       sync.Mutex
+	files    map[string][]byte
+	writeErr error
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string][]byte{}}
+}
+
+func (fsys *memFS) Open(name string) (File, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	content, ok := fsys.files[name]
+	if !ok && !fsys.isDir(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &memFile{r: bytes.NewReader(content), name: name}, nil
+}
+
+// isDir returns true if any file is contained in the directory of the given
+// name. The caller must hold the lock.
+func (fsys *memFS) isDir(name string) bool {
+	for file := range fsys.files {
+		if strings.HasPrefix(file, name+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (fsys *memFS) OpenFile(name string, flag int, _ os.FileMode) (File, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	content, ok := fsys.files[name]
+	switch {
+	case ok && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case flag&os.O_TRUNC != 0:
+		content = nil
+	}
+
+	fsys.files[name] = content
+	return &memFile{fsys: fsys, name: name}, nil
+}
+
+func (fsys *memFS) Rename(oldpath, newpath string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	content, ok := fsys.files[oldpath]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+
+	delete(fsys.files, oldpath)
+	fsys.files[newpath] = content
+	return nil
+}
+
+func (fsys *memFS) Remove(name string) error {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	if _, ok := fsys.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	delete(fsys.files, name)
+	return nil
+}
+
+func (fsys *memFS) Stat(name string) (os.FileInfo, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	content, ok := fsys.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return memFileInfo{name: path.Base(name), size: int64(len(content))}, nil
+}
+
+func (fsys *memFS) MkdirAll(string, os.FileMode) error {
+	return nil
+}
+
+func (fsys *memFS) content(name string) ([]byte, bool) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+
+	content, ok := fsys.files[name]
+	return content, ok
+}
+
+// memFile is a File of a memFS. Writes are appended to the file directly.
+type memFile struct {
+	r    *bytes.Reader
+	fsys *memFS
+	name string
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	return f.r.Read(p)
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if f.fsys == nil {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	f.fsys.mu.Lock()
+	defer f.fsys.mu.Unlock()
+
+	if f.fsys.writeErr != nil {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: f.fsys.writeErr}
+	}
+
+	f.fsys.files[f.name] = append(f.fsys.files[f.name], p...)
+	return len(p), nil
+}
+
+func (f *memFile) Sync() error  { return nil }
+func (f *memFile) Close() error { return nil }
+
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return 0660 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+func TestWithFS(t *testing.T) {
+	fsys := newMemFS()
+	memoryFile := "/memory/joe.json"
+
+	mem, err := NewMemory(memoryFile, WithFS(fsys), WithBackups(1), WithSync())
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	require.NoError(t, mem.Close())
+
+	content, ok := fsys.content(memoryFile)
+	require.True(t, ok)
+	assert.Contains(t, string(content), `"data":{"baz":"cXV4","foo":"YmFy"}`)
+
+	_, ok = fsys.content(memoryFile + ".1")
+	assert.True(t, ok, "backup was not written to the file system")
+	assert.NoFileExists(t, memoryFile)
+
+	mem, err = NewMemory(memoryFile, WithFS(fsys))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+}
+
+func TestWithFS_DiskFull(t *testing.T) {
+	fsys := newMemFS()
+	memoryFile := "/memory/joe.json"
+
+	mem, err := NewMemory(memoryFile, WithFS(fsys), WithPersistErrorPolicy(Rollback))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	fsys.writeErr = syscall.ENOSPC
+	err = mem.Set("foo", []byte("changed"))
+	require.ErrorIs(t, err, ErrPersist)
+	require.ErrorIs(t, err, syscall.ENOSPC)
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+
+	// the failed write did not replace the memory file
+	content, ok := fsys.content(memoryFile)
+	require.True(t, ok)
+	assert.Contains(t, string(content), `"foo":"YmFy"`)
+}
+
+func TestWithFS_Invalid(t *testing.T) {
+	_, err := NewMemory("/memory/joe.json", WithFS(nil))
+	require.EqualError(t, err, "file system must not be nil")
+
+	_, err = NewMemory("/memory/joe.json", WithFS(newMemFS()), WithLazyLoad())
+	require.EqualError(t, err, "a custom file system cannot be combined with lazy loading")
+
+	_, err = NewMemory("/memory/joe.json", WithFS(newMemFS()), WithExclusiveLock())
+	require.EqualError(t, err, "a custom file system cannot be combined with an exclusive lock")
+}
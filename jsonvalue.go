@@ -0,0 +1,43 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyNotFound is returned by GetJSON(…) if the requested key does not exist.
+var ErrKeyNotFound = errors.New("key not found")
+
+// SetJSON encodes the given value as JSON and stores it under the given key via
+// Set(…). This is only a convenience so callers do not need to encode
+// structured values themselves. The value is stored like any other value, so
+// the format of the memory file does not change.
+func (m *Storage) SetJSON(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode value of key %q as JSON: %w", key, err)
+	}
+
+	return m.Set(key, value)
+}
+
+// GetJSON retrieves the value of the given key via Get(…) and decodes it as
+// JSON into v. If the key does not exist, an error that wraps ErrKeyNotFound is
+// returned and v is not changed.
+func (m *Storage) GetJSON(key string, v interface{}) error {
+	value, ok, err := m.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+
+	if err := json.Unmarshal(value, v); err != nil {
+		return fmt.Errorf("failed to decode value of key %q as JSON: %w", key, err)
+	}
+
+	return nil
+}
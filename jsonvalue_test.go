@@ -0,0 +1,56 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_SetJSON(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	type user struct {
+		Name  string   `json:"name"`
+		Score int      `json:"score"`
+		Tags  []string `json:"tags"`
+	}
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	expected := user{Name: "Alice", Score: 42, Tags: []string{"admin"}}
+	require.NoError(t, mem.SetJSON("user", expected))
+
+	value, ok, err := mem.Get("user")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.JSONEq(t, `{"name":"Alice","score":42,"tags":["admin"]}`, string(value))
+
+	var actual user
+	require.NoError(t, mem.GetJSON("user", &actual))
+	require.Equal(t, expected, actual)
+
+	err = mem.GetJSON("missing", &actual)
+	require.ErrorIs(t, err, ErrKeyNotFound)
+	require.Equal(t, expected, actual)
+
+	require.NoError(t, mem.Set("invalid", []byte("not json")))
+	require.Error(t, mem.GetJSON("invalid", &actual))
+
+	require.Error(t, mem.SetJSON("func", func() {}))
+	_, ok, err = mem.Get("func")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+
+	actual = user{}
+	require.NoError(t, mem.GetJSON("user", &actual))
+	require.Equal(t, expected, actual)
+	require.NoError(t, mem.Close())
+}
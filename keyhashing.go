@@ -0,0 +1,81 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrKeysHashed is returned by operations that need the original keys of the
+// memory, such as prefix queries, if the keys are hashed (see WithKeyHashing).
+var ErrKeysHashed = errors.New("keys are hashed")
+
+// sha256Key is the default hash function of WithKeyHashing(…). It returns the
+// hex encoded SHA-256 hash of the key.
+func sha256Key(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkKeyHashingOptions returns an error if WithKeyHashing(…) is combined
+// with options that need the original keys of the memory.
+func (m *Storage) checkKeyHashingOptions() error {
+	if m.keyHasher == nil {
+		return nil
+	}
+
+	switch {
+	case m.maxKeyLength > 0:
+		return errors.New("a max key length cannot be combined with key hashing")
+	case m.keyValidator != nil:
+		return errors.New("a key validator cannot be combined with key hashing")
+	case m.fallback != nil:
+		return errors.New("a fallback memory cannot be combined with key hashing")
+	}
+
+	return nil
+}
+
+// hashKey returns the key as it is stored in the memory file if the keys are
+// hashed (see WithKeyHashing). Otherwise, the key is returned as is.
+func (m *Storage) hashKey(key string) string {
+	if m.keyHasher == nil {
+		return key
+	}
+
+	return m.keyHasher(key)
+}
+
+// hashSeedKeys hashes all keys of the given seed file, since seed files contain
+// the original keys, unlike the memory file itself.
+func (m *Storage) hashSeedKeys(f *fileContent) {
+	if m.keyHasher == nil {
+		return
+	}
+
+	data := make(map[string][]byte, len(f.data))
+	origins := make(map[string]string, len(f.data))
+	for key, value := range f.data {
+		hashed := m.keyHasher(key)
+		origins[hashed] = key
+		data[hashed] = value
+	}
+
+	f.data = data
+	f.versions = normalizeMetadata(f.versions, origins)
+	f.expires = normalizeMetadata(f.expires, origins)
+	f.types = normalizeMetadata(f.types, origins)
+	f.external = normalizeMetadata(f.external, origins)
+	f.checksums = normalizeMetadata(f.checksums, origins)
+}
+
+// errKeysHashed returns an error for the given operation if the keys are
+// hashed (see WithKeyHashing).
+func (m *Storage) errKeysHashed(operation string) error {
+	if m.keyHasher == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s is not supported", ErrKeysHashed, operation)
+}
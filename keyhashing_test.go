@@ -0,0 +1,111 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithKeyHashing(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithKeyHashing(nil))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("user:1234", []byte("foo")))
+
+	value, ok, err := mem.Get("user:1234")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "foo", string(value))
+
+	hashed := sha256Key("user:1234")
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{hashed}, keys)
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Contains(t, string(content), hashed)
+	require.NotContains(t, string(content), "user:1234")
+
+	mem, err = NewMemory(tempFile, WithKeyHashing(nil))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	ok, err = mem.Delete("user:1234")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithKeyHashing_Prefix(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithKeyHashing(strings.ToUpper))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, err = mem.GetPrefix("user:")
+	require.True(t, errors.Is(err, ErrKeysHashed), err)
+
+	_, err = mem.DeletePrefix("user:")
+	require.True(t, errors.Is(err, ErrKeysHashed), err)
+
+	ns := mem.Namespace("user")
+	require.NoError(t, ns.Set("foo", []byte("bar")))
+
+	value, ok, err := ns.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(value))
+
+	_, err = ns.Keys()
+	require.True(t, errors.Is(err, ErrKeysHashed), err)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"USER:FOO"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithKeyHashing_Seed(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	fsys := fstest.MapFS{
+		"defaults.json": &fstest.MapFile{Data: []byte(`{"test":"Zm9v"}`)},
+	}
+
+	mem, err := NewMemory(tempFile, WithSeedFS(fsys, "defaults.json"), WithKeyHashing(strings.ToUpper))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("test")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "foo", string(value))
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"TEST"}, keys)
+}
+
+func TestWithKeyHashing_Invalid(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	_, err := NewMemory(tempFile, WithKeyHashing(nil), WithMaxKeyLength(10, false))
+	require.EqualError(t, err, "a max key length cannot be combined with key hashing")
+
+	_, err = NewMemory(tempFile, WithKeyHashing(nil), WithKeyValidator(ValidateKeys(0)))
+	require.EqualError(t, err, "a key validator cannot be combined with key hashing")
+}
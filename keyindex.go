@@ -0,0 +1,33 @@
+package file
+
+import (
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// writeKeyIndex writes the sorted keys of the memory to the key index sidecar
+// file, one key per line (see WithKeyIndexSidecar). The index is purely
+// informational, so an error is only logged. The caller must hold the lock.
+func (m *Storage) writeKeyIndex() {
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteByte('\n')
+	}
+
+	err := m.writeAll(m.keyIndexPath, []byte(b.String()), 0660)
+	if err != nil {
+		m.logger.Warn("Failed to write key index",
+			zap.String("path", m.keyIndexPath),
+			zap.Error(err),
+		)
+	}
+}
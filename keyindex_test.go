@@ -0,0 +1,39 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithKeyIndexSidecar(t *testing.T) {
+	tempFile := tempFilePath()
+	indexFile := tempFile + ".keys"
+	defer os.Remove(tempFile)
+	defer os.Remove(indexFile)
+
+	mem, err := NewMemory(tempFile, WithKeyIndexSidecar(indexFile))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("secret")))
+	require.NoError(t, mem.Set("bar", []byte("secret")))
+
+	content, err := os.ReadFile(indexFile)
+	require.NoError(t, err)
+	require.Equal(t, "bar\nfoo\n", string(content))
+
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+
+	content, err = os.ReadFile(indexFile)
+	require.NoError(t, err)
+	require.Equal(t, "bar\n", string(content))
+}
+
+func TestWithKeyIndexSidecar_Empty(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithKeyIndexSidecar(""))
+	require.EqualError(t, err, "key index path must not be empty")
+}
@@ -0,0 +1,198 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"unicode"
+
+	"go.uber.org/zap"
+)
+
+// ErrKeyTooLong is returned when a key exceeds the length that was configured
+// via WithMaxKeyLength(…).
+var ErrKeyTooLong = errors.New("key is too long")
+
+// ErrInvalidKey is returned by the key validator of ValidateKeys(…) for empty
+// keys and keys that contain control characters such as newlines.
+var ErrInvalidKey = errors.New("invalid key")
+
+// checkKey returns an error if the key cannot be stored in this memory.
+func (m *Storage) checkKey(key string) error {
+	if err := m.checkKeyLength(key); err != nil {
+		return err
+	}
+
+	return m.validateKey(key)
+}
+
+// checkKeyLength returns ErrKeyTooLong if the key exceeds the length of
+// WithMaxKeyLength(…). Unlike checkKey, it does not run the key validator.
+func (m *Storage) checkKeyLength(key string) error {
+	if m.maxKeyLength > 0 && len(key) > m.maxKeyLength {
+		return fmt.Errorf("%w: key %q has %d bytes but only %d bytes are allowed",
+			ErrKeyTooLong, key, len(key), m.maxKeyLength,
+		)
+	}
+
+	return nil
+}
+
+// validateKey returns the error of the key validator (see WithKeyValidator). A
+// panic in the validator is recovered and returned as error so the key is
+// rejected.
+func (m *Storage) validateKey(key string) (err error) {
+	if m.keyValidator == nil {
+		return nil
+	}
+
+	defer func() {
+		if perr := m.recoverPanic("key validator", recover()); perr != nil {
+			err = perr
+		}
+	}()
+
+	return m.keyValidator(key)
+}
+
+// ValidateKeys returns a key validator for WithKeyValidator(…) that rejects
+// empty keys, keys that contain control characters (e.g. newlines) and keys
+// that are longer than maxLength bytes. If maxLength is zero, the length of the
+// keys is not limited.
+func ValidateKeys(maxLength int) func(key string) error {
+	return func(key string) error {
+		switch {
+		case key == "":
+			return fmt.Errorf("%w: key must not be empty", ErrInvalidKey)
+		case maxLength > 0 && len(key) > maxLength:
+			return fmt.Errorf("%w: key %q has %d bytes but only %d bytes are allowed",
+				ErrKeyTooLong, key, len(key), maxLength,
+			)
+		}
+
+		for _, r := range key {
+			if unicode.IsControl(r) {
+				return fmt.Errorf("%w: key %q contains the control character %U", ErrInvalidKey, key, r)
+			}
+		}
+
+		return nil
+	}
+}
+
+// checkLoadedKeys checks the length of all keys that were loaded from the file
+// at the given path (see WithMaxKeyLength). The key validator is not used for
+// loaded keys. Keys that are too long are logged as warnings, unless strict key
+// checking is enabled in which case an error is returned. Afterwards the values are
+// validated as well (see checkLoadedValues).
+func (m *Storage) checkLoadedKeys(path string, data map[string][]byte) error {
+	if m.maxKeyLength == 0 {
+		return m.checkLoadedValues(path, data)
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		err := m.checkKeyLength(key)
+		if err == nil {
+			continue
+		}
+
+		if m.strictKeys {
+			return fmt.Errorf("invalid key in %q: %w", path, err)
+		}
+
+		m.logger.Warn("Memory file contains invalid key",
+			zap.String("path", path),
+			zap.String("key", key),
+			zap.Error(err),
+		)
+	}
+
+	return m.checkLoadedValues(path, data)
+}
+
+// normalizeKey returns the key as it is stored in the memory (see
+// WithKeyNormalizer and WithKeyHashing).
+func (m *Storage) normalizeKey(key string) string {
+	return m.hashKey(m.normalizePrefix(key))
+}
+
+// normalizePrefix returns the prefix of keys as it is stored in the memory.
+// Unlike normalizeKey, the prefix is never hashed.
+func (m *Storage) normalizePrefix(prefix string) string {
+	if m.keyNormalizer == nil {
+		return prefix
+	}
+
+	return m.keyNormalizer(prefix)
+}
+
+// normalizeFileKeys normalizes all keys of the content that was loaded from the
+// file at the given path. If multiple keys of the file normalize to the same
+// key, the collision is either logged as a warning and the first key in sorted
+// order wins, or an error is returned (see WithKeyNormalizer).
+func (m *Storage) normalizeFileKeys(path string, f *fileContent) error {
+	if m.keyNormalizer == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(f.data))
+	for key := range f.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data := make(map[string][]byte, len(keys))
+	origins := make(map[string]string, len(keys))
+	for _, key := range keys {
+		normalized := m.keyNormalizer(key)
+		if other, ok := origins[normalized]; ok {
+			if m.strictCollisions {
+				return fmt.Errorf("keys %q and %q in %q are the same key %q after normalization",
+					other, key, path, normalized,
+				)
+			}
+
+			m.logger.Warn("Memory file contains keys that are the same after normalization",
+				zap.String("path", path),
+				zap.String("key", key),
+				zap.String("other_key", other),
+				zap.String("normalized_key", normalized),
+			)
+			continue
+		}
+
+		origins[normalized] = key
+		data[normalized] = f.data[key]
+	}
+
+	f.data = data
+	f.versions = normalizeMetadata(f.versions, origins)
+	f.expires = normalizeMetadata(f.expires, origins)
+	f.types = normalizeMetadata(f.types, origins)
+	f.external = normalizeMetadata(f.external, origins)
+	f.checksums = normalizeMetadata(f.checksums, origins)
+	return nil
+}
+
+// normalizeMetadata returns the metadata of all keys that were kept by
+// normalizeFileKeys, using their normalized keys.
+func normalizeMetadata[V any](metadata map[string]V, origins map[string]string) map[string]V {
+	if metadata == nil {
+		return nil
+	}
+
+	normalized := make(map[string]V, len(metadata))
+	for key, origin := range origins {
+		if v, ok := metadata[origin]; ok {
+			normalized[key] = v
+		}
+	}
+
+	return normalized
+}
@@ -0,0 +1,220 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithMaxKeyLength(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMaxKeyLength(5, false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("12345", []byte("foo")))
+
+	err = mem.Set("123456", []byte("foo"))
+	require.True(t, errors.Is(err, ErrKeyTooLong), err)
+
+	_, err = mem.SetWithVersion("123456", []byte("foo"), 0)
+	require.True(t, errors.Is(err, ErrKeyTooLong), err)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"12345"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMaxKeyLength_Load(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	long := strings.Repeat("x", 10)
+	writeMemoryFile(t, tempFile, map[string][]byte{long: []byte("foo")})
+
+	core, logs := observer.New(zap.WarnLevel)
+	mem, err := NewMemory(tempFile, WithLogger(zap.New(core)), WithMaxKeyLength(5, false))
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	entries := logs.FilterMessage("Memory file contains invalid key").All()
+	require.Len(t, entries, 1)
+	require.Equal(t, long, entries[0].ContextMap()["key"])
+
+	_, err = NewMemory(tempFile, WithMaxKeyLength(5, true))
+	require.True(t, errors.Is(err, ErrKeyTooLong), err)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMaxKeyLength_LoadWithKeyValidator(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	writeMemoryFile(t, tempFile, map[string][]byte{"a\nb": []byte("foo")})
+
+	// loaded keys are never validated, even if their length is checked
+	mem, err := NewMemory(tempFile, WithMaxKeyLength(5, true), WithKeyValidator(ValidateKeys(0)))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a\nb"}, keys)
+}
+
+func TestWithMaxKeyLength_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithMaxKeyLength(0, false))
+	require.EqualError(t, err, "max key length must be positive but got 0")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithKeyValidator(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithKeyValidator(ValidateKeys(5)))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	err = mem.Set("", []byte("bar"))
+	require.True(t, errors.Is(err, ErrInvalidKey), err)
+
+	err = mem.Set("a\nb", []byte("bar"))
+	require.True(t, errors.Is(err, ErrInvalidKey), err)
+	require.EqualError(t, err, `invalid key: key "a\nb" contains the control character U+000A`)
+
+	err = mem.Set("123456", []byte("bar"))
+	require.True(t, errors.Is(err, ErrKeyTooLong), err)
+
+	err = mem.SetMany(map[string][]byte{"foo": nil, "": nil})
+	require.True(t, errors.Is(err, ErrInvalidKey), err)
+
+	_, err = mem.Delete("")
+	require.True(t, errors.Is(err, ErrInvalidKey), err)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithKeyValidator_Panic(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	core, logs := observer.New(zap.ErrorLevel)
+	mem, err := NewMemory(tempFile,
+		WithLogger(zap.New(core)),
+		WithKeyValidator(func(string) error { panic("validator is broken") }),
+	)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	err = mem.Set("foo", []byte("bar"))
+	require.EqualError(t, err, "panic in key validator: validator is broken")
+	require.Equal(t, 1, logs.Len())
+}
+
+func TestWithKeyValidator_Nil(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithKeyValidator(nil))
+	require.EqualError(t, err, "key validator must not be nil")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCaseInsensitiveKeys(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithCaseInsensitiveKeys(false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("UserID", []byte("1")))
+	require.NoError(t, mem.Set("userID", []byte("2")))
+
+	value, ok, err := mem.Get("USERID")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "2", string(value))
+
+	values, err := mem.GetMany([]string{"UserId"})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"UserId": []byte("2")}, values)
+
+	prefixed, err := mem.GetPrefix("USER")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"userid": []byte("2")}, prefixed)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"userid"}, keys)
+
+	ok, err = mem.Delete("UserID")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCaseInsensitiveKeys_Load(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	writeMemoryFile(t, tempFile, map[string][]byte{
+		"UserID": []byte("1"),
+		"userid": []byte("2"),
+		"Other":  []byte("3"),
+	})
+
+	_, err := NewMemory(tempFile, WithCaseInsensitiveKeys(true))
+	require.EqualError(t, err, fmt.Sprintf(`keys "UserID" and "userid" in %q are the same key "userid" after normalization`, tempFile))
+
+	core, logs := observer.New(zap.WarnLevel)
+	mem, err := NewMemory(tempFile, WithLogger(zap.New(core)), WithCaseInsensitiveKeys(false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	entries := logs.FilterMessage("Memory file contains keys that are the same after normalization").All()
+	require.Len(t, entries, 1)
+	require.Equal(t, "userid", entries[0].ContextMap()["key"])
+	require.Equal(t, "UserID", entries[0].ContextMap()["other_key"])
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"other", "userid"}, keys)
+
+	value, ok, err := mem.Get("userid")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "1", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithKeyNormalizer(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithKeyNormalizer(strings.TrimSpace, false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set(" foo ", []byte("bar")))
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(value))
+
+	_, err = NewMemory(tempFile, WithKeyNormalizer(nil, false))
+	require.EqualError(t, err, "key normalizer must not be nil")
+}
@@ -0,0 +1,26 @@
+package file
+
+// KV is a minimal key-value store interface that is independent of the joe
+// bot library. It is implemented by the Storage type so the file memory can be
+// used in other projects as well.
+type KV interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte) error
+	Delete(key string) (bool, error)
+	Keys() ([]string, error)
+	Close() error
+}
+
+var _ KV = (*Storage)(nil)
+
+// NewKV creates a new file based KV store at the given path. It accepts the
+// same options as NewMemory(…). All methods of the returned KV are safe for
+// concurrent use.
+func NewKV(path string, opts ...Option) (KV, error) {
+	memory, err := NewMemory(path, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return memory, nil
+}
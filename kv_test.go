@@ -0,0 +1,48 @@
+package file
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestNewKV(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	kv, err := NewKV(tempFile)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			require.NoError(t, kv.Set(key, []byte(key)))
+		}(key)
+	}
+	wg.Wait()
+
+	keys, err := kv.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c"}, keys)
+
+	ok, err := kv.Delete("b")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, ok, err = kv.Get("b")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, kv.Close())
+}
+
+func TestNewKV_Error(t *testing.T) {
+	kv, err := NewKV(tempFilePath(), WithMaxKeyLength(-1, false))
+	require.Error(t, err)
+	require.Nil(t, kv)
+}
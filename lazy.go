@@ -0,0 +1,246 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.uber.org/zap"
+)
+
+// lazyIndex contains the positions of all values in the memory file that have
+// not been decoded yet (see WithLazyLoad). The file is kept open, so the values
+// can still be read if another process replaces the memory file.
+type lazyIndex struct {
+	file       *os.File
+	values     map[string]lazyValue
+	expired    map[string]lazyValue // dropped when loaded but covered by the checksum
+	rawStrings bool                 // the values are encoded as textValue
+	checksum   string               // checksum of the document, see dataChecksum
+}
+
+// lazyValue is the position of an encoded value in the memory file. The value
+// may be preceded by the separator between the key and the value.
+type lazyValue struct {
+	offset, length int64
+}
+
+// checkLazyOptions returns an error if lazy loading is combined with options
+// that need to decode the entire memory file or that replace the data when the
+// memory file changes.
+func (m *Storage) checkLazyOptions() error {
+	if !m.lazyLoad {
+		return nil
+	}
+
+	switch {
+	case m.path == "":
+		return errors.New("lazy loading requires a memory file")
+	case m.codec != nil:
+		return errors.New("lazy loading cannot be combined with a custom codec")
+	case m.compress:
+		return errors.New("lazy loading cannot be combined with compression")
+	case m.aead != nil:
+		return errors.New("lazy loading cannot be combined with encryption")
+	case m.shards > 0:
+		return errors.New("lazy loading cannot be combined with sharding")
+	case m.logRatio > 0:
+		return errors.New("lazy loading cannot be combined with an append log")
+	case m.loaded != nil:
+		return errors.New("lazy loading cannot be combined with a background load")
+	case m.fileWatchInterval > 0:
+		return errors.New("lazy loading cannot be combined with a file watch")
+	case m.versionFile:
+		return errors.New("lazy loading cannot be combined with a version file")
+	case m.externalThreshold > 0:
+		return errors.New("lazy loading cannot be combined with external values")
+	case m.keyNormalizer != nil:
+		return errors.New("lazy loading cannot be combined with a key normalizer")
+	case m.validateLoaded:
+		return errors.New("lazy loading cannot be combined with validating loaded values")
+	}
+
+	return nil
+}
+
+// loadLazy indexes the values of the memory file without decoding them. It
+// returns false if the memory file must be loaded as usual instead, e.g.
+// because it does not exist, it cannot be decoded or its format does not allow
+// to read its values individually.
+func (m *Storage) loadLazy() (bool, error) {
+	f, err := os.Open(m.path)
+	if err != nil {
+		// the regular load handles missing files and reports all other errors
+		return false, nil
+	}
+
+	index, err := m.indexFile(f)
+	if err != nil || index == nil {
+		_ = f.Close()
+		if err != nil {
+			m.logger.Debug("Cannot load memory file lazily", zap.String("path", m.path), zap.Error(err))
+		}
+		return false, nil
+	}
+
+	keys := make(map[string][]byte, len(index.values))
+	for key := range index.values {
+		keys[key] = nil
+	}
+
+	if err := m.checkLoadedKeys(m.path, keys); err != nil {
+		_ = f.Close()
+		return false, err
+	}
+
+	m.lazy = index
+	m.logger.Debug("Indexed memory file for lazy loading",
+		zap.String("path", m.path),
+		zap.Int("num_keys", len(index.values)),
+	)
+
+	return true, nil
+}
+
+// indexFile reads the given memory file and returns the index of its values.
+// A nil index is returned if the file cannot be loaded lazily.
+func (m *Storage) indexFile(f *os.File) (*lazyIndex, error) {
+	magic := make([]byte, len(gzipMagic))
+	if n, _ := f.ReadAt(magic, 0); n == len(magic) && bytes.Equal(magic, gzipMagic) {
+		return nil, nil
+	}
+
+	hash := sha256.New()
+	r := io.TeeReader(f, hash)
+
+	values := map[string]lazyValue{}
+	doc, err := decodeDocumentWith(r, values, m.strictDecoding)
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Version == legacyFormatVersion || doc.Delta != nil || doc.SealedValues || len(doc.External) > 0 {
+		return nil, nil
+	}
+
+	// consume any trailing whitespace so the checksum covers the entire file
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return nil, err
+	}
+
+	expired := map[string]lazyValue{}
+	now := m.now()
+	for key, t := range doc.Expires {
+		if v, ok := values[key]; ok && !now.Before(t) {
+			expired[key] = v
+			delete(values, key)
+			delete(doc.Expires, key)
+		}
+	}
+
+	content := &fileContent{data: map[string][]byte{}, versions: doc.Versions, expires: doc.Expires, types: doc.ContentTypes}
+	copy(content.checksum[:], hash.Sum(nil))
+	m.useFile(content)
+
+	return &lazyIndex{
+		file:       f,
+		values:     values,
+		expired:    expired,
+		rawStrings: doc.RawStrings,
+		checksum:   doc.Checksum,
+	}, nil
+}
+
+// read decodes the value of the given key from the memory file. The boolean
+// return value is false if the index does not contain the key.
+func (idx *lazyIndex) read(key string) ([]byte, bool, error) {
+	v, ok := idx.values[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return idx.decode(key, v)
+}
+
+// decode reads the value at the given position of the memory file.
+func (idx *lazyIndex) decode(key string, v lazyValue) ([]byte, bool, error) {
+	b := make([]byte, v.length)
+	_, err := idx.file.ReadAt(b, v.offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read value of key %q from memory file: %w", key, err)
+	}
+
+	b = bytes.TrimLeft(b, " \t\r\n:")
+	if idx.rawStrings {
+		var value textValue
+		err = json.Unmarshal(b, &value)
+		return value, err == nil, err
+	}
+
+	var value []byte
+	err = json.Unmarshal(b, &value)
+	return value, err == nil, err
+}
+
+// materialize decodes all values that have not been decoded yet, which is
+// required before the data can be changed or iterated. The caller must hold the
+// write lock.
+func (m *Storage) materialize() error {
+	if m.lazy == nil {
+		return nil
+	}
+
+	data := make(map[string][]byte, len(m.lazy.values)+len(m.data))
+	for key := range m.lazy.values {
+		value, _, err := m.lazy.read(key)
+		if err != nil {
+			return err
+		}
+		data[key] = value
+	}
+
+	if m.lazy.checksum != "" && !m.skipChecksum {
+		all := make(map[string][]byte, len(data)+len(m.lazy.expired))
+		for key, value := range data {
+			all[key] = value
+		}
+		for key, v := range m.lazy.expired {
+			value, _, err := m.lazy.decode(key, v)
+			if err != nil {
+				return err
+			}
+			all[key] = value
+		}
+
+		if m.lazy.checksum != dataChecksum(all) {
+			return fmt.Errorf("failed to load %q: %w", m.path, ErrChecksumMismatch)
+		}
+	}
+
+	for key, value := range m.data {
+		data[key] = value
+	}
+
+	m.logger.Debug("Decoded all values of lazily loaded memory file",
+		zap.String("path", m.path),
+		zap.Int("num_keys", len(m.lazy.values)),
+	)
+
+	_ = m.lazy.file.Close()
+	m.lazy = nil
+	m.data = data
+	return nil
+}
+
+// closeLazy closes the memory file if it is still open for lazy loading. The
+// caller must hold the write lock.
+func (m *Storage) closeLazy() {
+	if m.lazy != nil {
+		_ = m.lazy.file.Close()
+		m.lazy = nil
+	}
+}
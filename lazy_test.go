@@ -0,0 +1,129 @@
+package file
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithLazyLoad(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"base64":      nil,
+		"raw strings": {WithRawStrings(), WithIndent("", "  ")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tempFile := tempFilePath()
+			defer os.Remove(tempFile)
+
+			mem, err := NewMemory(tempFile, opts...)
+			require.NoError(t, err)
+			require.NoError(t, mem.Set("foo", []byte("bar")))
+			require.NoError(t, mem.Set("binary", []byte{0xff, 0x00}))
+			require.NoError(t, mem.Set("empty", nil))
+			require.NoError(t, mem.SetWithTTL("expired", []byte("old"), time.Hour))
+			require.NoError(t, mem.Close())
+
+			clock := &fakeClock{now: time.Now().Add(2 * time.Hour)}
+			mem, err = NewMemory(tempFile, WithLazyLoad(), WithClock(clock))
+			require.NoError(t, err)
+			defer mem.Close()
+
+			keys, err := mem.Keys()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"binary", "empty", "foo"}, keys)
+
+			value, ok, err := mem.Get("foo")
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, []byte("bar"), value)
+
+			value, ok, err = mem.Get("binary")
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, []byte{0xff, 0x00}, value)
+
+			_, ok, err = mem.Get("expired")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			_, ok, err = mem.Get("missing")
+			require.NoError(t, err)
+			assert.False(t, ok)
+			assert.NotNil(t, mem.lazy, "reading values must not decode the entire file")
+
+			// changes decode all values so they are persisted again
+			require.NoError(t, mem.Set("baz", []byte("qux")))
+			assert.Nil(t, mem.lazy)
+			require.NoError(t, mem.Close())
+
+			mem, err = NewMemory(tempFile)
+			require.NoError(t, err)
+			defer mem.Close()
+
+			keys, err = mem.Keys()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"baz", "binary", "empty", "foo"}, keys)
+		})
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithLazyLoad_Checksum(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	writeMemoryFile(t, tempFile, map[string][]byte{"foo": []byte("bar")})
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	content = []byte(strings.Replace(string(content), "YmFy", "YmF6", 1)) // "baz"
+	require.NoError(t, os.WriteFile(tempFile, content, 0644))
+
+	mem, err := NewMemory(tempFile, WithLazyLoad())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	// the checksum is only verified once all values are decoded
+	err = mem.ForEach(func(string, []byte) error { return nil })
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	err = mem.Set("foo", []byte("bar"))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithLazyLoad_Fallback(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithCompression())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	// compressed files are loaded as usual
+	mem, err = NewMemory(tempFile, WithLazyLoad())
+	require.NoError(t, err)
+	defer mem.Close()
+	assert.Nil(t, mem.lazy)
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bar"), value)
+}
+
+func TestWithLazyLoad_Options(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithLazyLoad(), WithCompression())
+	assert.EqualError(t, err, "lazy loading cannot be combined with compression")
+
+	_, err = NewMemory("", WithLazyLoad())
+	assert.EqualError(t, err, "lazy loading requires a memory file")
+
+	_, err = NewMemoryFromFiles(tempFilePath(), nil, WithLazyLoad())
+	assert.EqualError(t, err, "lazy loading cannot be combined with multiple files")
+}
@@ -0,0 +1,305 @@
+package file
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrClosed is returned by all operations that are called after the memory was
+// closed.
+var ErrClosed = errors.New("brain was already shut down")
+
+// ErrPersist is matched via errors.Is by all errors that are returned because
+// the memory file could not be written (see PersistError).
+var ErrPersist = errors.New("failed to persist memory")
+
+// PersistError is returned when a change was applied in memory but the memory
+// file could not be written, e.g. because the disk is full. It is not returned
+// if the change was rejected before the memory file was written (e.g. because
+// of ErrMaxSerializedSize), since such changes are reverted. Use errors.As to
+// access the underlying cause or errors.Is(err, ErrPersist) to detect it.
+type PersistError struct {
+	Path string // the path of the memory file
+	Err  error
+}
+
+// Error returns the message of the underlying error.
+func (e *PersistError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *PersistError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is ErrPersist.
+func (e *PersistError) Is(target error) bool {
+	return target == ErrPersist
+}
+
+// ErrMemoryClosing is returned by all operations that are started after
+// CloseWithTimeout(…) was called and before the memory was closed. Afterwards,
+// operations return ErrClosed.
+var ErrMemoryClosing = errors.New("memory is closing")
+
+// ErrMemoryDraining is returned by all operations that would change the memory
+// after Drain() was called.
+var ErrMemoryDraining = errors.New("memory is draining")
+
+// enter registers the start of an operation. It returns ErrMemoryClosing if the
+// memory is closing already and ErrClosed once it was closed. Each successful
+// call must be followed by a call to leave once the operation has finished.
+func (m *Storage) enter() error {
+	m.lifecycleMu.Lock()
+	defer m.lifecycleMu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	if m.closing {
+		return ErrMemoryClosing
+	}
+
+	m.inflight++
+	return nil
+}
+
+// leave registers the end of an operation that was started via enter.
+func (m *Storage) leave() {
+	m.lifecycleMu.Lock()
+	defer m.lifecycleMu.Unlock()
+
+	m.inflight--
+	if m.inflight == 0 && m.idle != nil {
+		close(m.idle)
+		m.idle = nil
+	}
+}
+
+// CloseWithTimeout closes the memory gracefully. Operations that were started
+// before CloseWithTimeout was called get up to the given grace period to
+// complete, while any operation that is started afterwards fails with
+// ErrMemoryClosing. Once all operations have finished or the grace period has
+// passed, the memory is closed via Close() which also persists any changes that
+// have not been flushed yet (see WithFlushInterval).
+//
+// Operations that are still running after the grace period fail with the usual
+// error of a closed memory, just like all operations once the memory was
+// closed. Just like Close(), calling CloseWithTimeout again returns nil.
+func (m *Storage) CloseWithTimeout(d time.Duration) error {
+	if !m.release() {
+		return nil
+	}
+
+	m.lifecycleMu.Lock()
+	if m.closing {
+		m.lifecycleMu.Unlock()
+		return nil
+	}
+
+	m.closing = true
+	idle := make(chan struct{})
+	if m.inflight == 0 {
+		close(idle)
+	} else {
+		m.idle = idle
+	}
+	m.lifecycleMu.Unlock()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+	case <-timer.C:
+		m.logger.Warn("Closing memory while operations are still in progress",
+			zap.Duration("grace_period", d),
+		)
+	}
+
+	err := m.Close()
+
+	m.lifecycleMu.Lock()
+	m.closed = true
+	m.lifecycleMu.Unlock()
+
+	return err
+}
+
+// Drain is the first step of a two-phase shutdown. It waits for all changes
+// that are in progress, writes all changes that have not been persisted yet
+// (see WithFlushInterval and Pause) and then rejects all further changes with
+// ErrMemoryDraining, while reads keep working. Close() then releases the
+// memory as usual. Thereby the memory passes through the states open, draining
+// and closed in this order, although an open memory can also be closed right
+// away. There is no way back to an earlier state.
+//
+// Drain returns ErrMemoryDraining if the memory is draining already,
+// ErrMemoryClosing if it is closing (see CloseWithTimeout) and ErrClosed if it
+// was closed already. If the memory file could not be
+// written, the error is returned but the memory keeps draining, so Flush() can
+// retry the write. Close() retries it as well. Note that a shared instance is
+// drained for all of its users (see WithSharedInstance).
+func (m *Storage) Drain() error {
+	if err := m.lockData(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	if m.draining {
+		return ErrMemoryDraining
+	}
+
+	m.draining = true
+
+	// wait for changes that are written without holding the lock
+	m.awaitWrites()
+	if m.readOnly {
+		return nil
+	}
+
+	return m.flush()
+}
+
+// IsOpen reports whether the memory can still be used, i.e. whether it has not
+// been closed yet. This allows callers to check the state of the memory without
+// triggering the error of a closed memory. Note that the memory may be closed
+// concurrently right after IsOpen returned true.
+func (m *Storage) IsOpen() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.data != nil
+}
+
+// WasCreated reports whether the memory started empty because its memory file
+// did not exist, e.g. so callers can run one-time setup on the first run of a
+// bot. It returns false if an existing memory file was loaded, even if it did
+// not contain any keys. A memory without a path never loads a file, so it
+// always reports true. A memory that was seeded via WithSeedFS(…) reports true
+// as well, since its memory file did not exist either. If the memory is loaded
+// in the background (see WithBackgroundLoad), the result is only known once the
+// load has finished, so WasCreated waits for it.
+func (m *Storage) WasCreated() bool {
+	if m.loaded != nil {
+		select {
+		case <-m.loaded:
+		case <-m.stop:
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.created
+}
+
+// lock registers a new operation and acquires the write lock in order to change
+// the memory. A read-only memory always returns ErrReadOnly (see
+// WithReadOnly) and a draining memory returns ErrMemoryDraining (see Drain).
+// All other errors are the same as for lockData. Each
+// successful call must be followed by a call to unlock.
+func (m *Storage) lock() error {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := m.lockData(); err != nil {
+		return err
+	}
+
+	if m.draining {
+		m.unlock()
+		return ErrMemoryDraining
+	}
+
+	return nil
+}
+
+// lockData registers a new operation and acquires the write lock. An error is
+// returned if the memory is closing or was closed already or if the memory
+// file is still loading in the background (see WithBackgroundLoad). Unlike
+// lock, this also succeeds for read-only memories, so it must only be used by
+// operations that never write the memory file. Each successful call must be
+// followed by a call to unlock.
+func (m *Storage) lockData() error {
+	if err := m.enter(); err != nil {
+		return err
+	}
+
+	// changes are only persisted once we know the entire memory file
+	if err := m.awaitLoad(); err != nil {
+		m.leave()
+		return err
+	}
+
+	m.mu.Lock()
+	if m.data == nil {
+		m.mu.Unlock()
+		m.leave()
+		return ErrClosed
+	}
+
+	// changes must be applied to all values (see WithLazyLoad)
+	if err := m.materialize(); err != nil {
+		m.mu.Unlock()
+		m.leave()
+		return err
+	}
+
+	return nil
+}
+
+// unlock releases the write lock that was acquired via lock.
+func (m *Storage) unlock() {
+	m.mu.Unlock()
+	m.leave()
+}
+
+// rlock registers a new operation and acquires the read lock. An error is
+// returned if the memory is closing or was closed already. If the memory is
+// loaded lazily (see WithLazyLoad), all values are decoded first. Each
+// successful call must be followed by a call to runlock.
+func (m *Storage) rlock() error {
+	if err := m.rlockIndex(); err != nil {
+		return err
+	}
+
+	if m.lazy == nil {
+		return nil
+	}
+
+	// the values are decoded only once, so the read lock is enough afterwards
+	m.runlock()
+	if err := m.lockData(); err != nil {
+		return err
+	}
+	m.unlock()
+
+	return m.rlockIndex()
+}
+
+// rlockIndex is like rlock but it does not decode the values of a lazily
+// loaded memory, so the caller must read them via the lazy index if they are
+// not contained in the data.
+func (m *Storage) rlockIndex() error {
+	if err := m.enter(); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	if m.data == nil {
+		m.mu.RUnlock()
+		m.leave()
+		return ErrClosed
+	}
+
+	return nil
+}
+
+// runlock releases the read lock that was acquired via rlock.
+func (m *Storage) runlock() {
+	m.mu.RUnlock()
+	m.leave()
+}
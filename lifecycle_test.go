@@ -0,0 +1,328 @@
+package file
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func (m *Storage) numInflight() int {
+	m.lifecycleMu.Lock()
+	defer m.lifecycleMu.Unlock()
+	return m.inflight
+}
+
+func (m *Storage) isClosing() bool {
+	m.lifecycleMu.Lock()
+	defer m.lifecycleMu.Unlock()
+	return m.closing
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_CloseWithTimeout(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	// block the memory so the next operation stays in flight
+	mem.mu.Lock()
+	setErr := make(chan error)
+	go func() { setErr <- mem.Set("foo", []byte("bar")) }()
+	eventually(t, func() bool { return mem.numInflight() == 1 })
+
+	closeErr := make(chan error)
+	go func() { closeErr <- mem.CloseWithTimeout(time.Minute) }()
+	eventually(t, mem.isClosing)
+
+	_, _, err = mem.Get("foo")
+	require.True(t, errors.Is(err, ErrMemoryClosing), err)
+
+	mem.mu.Unlock()
+	require.NoError(t, <-setErr)
+	require.NoError(t, <-closeErr)
+
+	_, _, err = mem.Get("foo")
+	require.True(t, errors.Is(err, ErrClosed), err)
+	require.NoError(t, mem.CloseWithTimeout(time.Minute))
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	val, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(val))
+	require.NoError(t, mem.Close())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_CloseWithTimeout_Expired(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	core, logs := observer.New(zap.WarnLevel)
+	mem, err := NewMemory(tempFile, WithLogger(zap.New(core)))
+	require.NoError(t, err)
+
+	// block the memory so the next operation stays in flight
+	mem.mu.Lock()
+	getErr := make(chan error)
+	go func() {
+		_, _, err := mem.Get("foo")
+		getErr <- err
+	}()
+	eventually(t, func() bool { return mem.numInflight() == 1 })
+
+	closeErr := make(chan error)
+	go func() { closeErr <- mem.CloseWithTimeout(time.Millisecond) }()
+
+	// wait until the grace period has expired
+	eventually(t, func() bool {
+		return logs.FilterMessage("Closing memory while operations are still in progress").Len() == 1
+	})
+
+	mem.mu.Unlock()
+	<-getErr
+	require.NoError(t, <-closeErr)
+
+	_, _, err = mem.Get("foo")
+	require.True(t, errors.Is(err, ErrClosed), err)
+	require.NoError(t, mem.CloseWithTimeout(time.Second))
+	require.NoError(t, mem.Close())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_IsOpen(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.True(t, mem.IsOpen())
+
+	require.NoError(t, mem.Close())
+	require.False(t, mem.IsOpen())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_CloseTwice(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+	require.NoError(t, mem.Close())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_CloseConcurrently(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	errs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- mem.Close() }()
+	}
+
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+	require.False(t, mem.IsOpen())
+
+	// the pending change was flushed exactly once before closing
+	reloaded, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	value, ok, err := reloaded.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("bar"), value)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestErrClosed(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	err = mem.Set("foo", []byte("bar"))
+	require.True(t, errors.Is(err, ErrClosed), err)
+	require.EqualError(t, err, "brain was already shut down")
+
+	_, _, err = mem.Get("foo")
+	require.True(t, errors.Is(err, ErrClosed), err)
+
+	_, err = mem.Delete("foo")
+	require.True(t, errors.Is(err, ErrClosed), err)
+
+	_, err = mem.Keys()
+	require.True(t, errors.Is(err, ErrClosed), err)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestPersistError(t *testing.T) {
+	dir := t.TempDir()
+	mem, err := NewMemory(dir + "/missing/joe.json")
+	require.NoError(t, err)
+	defer mem.Close()
+
+	err = mem.Set("foo", []byte("bar"))
+	require.True(t, errors.Is(err, ErrPersist), err)
+
+	var perr *PersistError
+	require.True(t, errors.As(err, &perr))
+	require.Equal(t, dir+"/missing/joe.json", perr.Path)
+	require.True(t, errors.Is(err, fs.ErrNotExist), err)
+
+	// rejected changes are not persist errors
+	mem, err = NewMemory(dir+"/joe.json", WithMaxSerializedSize(1))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	err = mem.Set("foo", []byte("bar"))
+	require.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+	require.False(t, errors.Is(err, ErrPersist), err)
+}
+
+func TestMemory_WasCreated(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.True(t, mem.WasCreated())
+	require.NoError(t, mem.Set("test", []byte("foo")))
+	_, err = mem.Delete("test")
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	// an existing file is loaded even if it does not contain any keys
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	require.False(t, mem.WasCreated())
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithBackgroundLoad(nil, FailNotReady))
+	require.NoError(t, err)
+	require.False(t, mem.WasCreated())
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory("")
+	require.NoError(t, err)
+	require.True(t, mem.WasCreated())
+	require.NoError(t, mem.Close())
+}
+
+func TestMemory_WasCreated_Seeded(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	fsys := fstest.MapFS{
+		"defaults.json": {Data: []byte(`{"test":"Zm9v"}`)},
+	}
+
+	mem, err := NewMemory(tempFile, WithSeedFS(fsys, "defaults.json"))
+	require.NoError(t, err)
+	require.True(t, mem.WasCreated())
+	require.NoError(t, mem.Close())
+}
+
+func TestMemory_WasCreated_Shards(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "memory.json")
+
+	mem, err := NewMemory(path, WithShards(2))
+	require.NoError(t, err)
+	require.True(t, mem.WasCreated())
+	require.NoError(t, mem.Set("test", []byte("foo")))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(path, WithShards(2))
+	require.NoError(t, err)
+	require.False(t, mem.WasCreated())
+	require.NoError(t, mem.Close())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Drain(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoFileExists(t, tempFile)
+
+	// pending changes are flushed
+	require.NoError(t, mem.Drain())
+	require.FileExists(t, tempFile)
+
+	err = mem.Set("foo", []byte("baz"))
+	require.True(t, errors.Is(err, ErrMemoryDraining), err)
+	_, err = mem.Delete("foo")
+	require.True(t, errors.Is(err, ErrMemoryDraining), err)
+
+	// reads keep working
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(value))
+	require.True(t, mem.IsOpen())
+
+	require.True(t, errors.Is(mem.Drain(), ErrMemoryDraining))
+	require.NoError(t, mem.Close())
+	require.True(t, errors.Is(mem.Drain(), ErrClosed))
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, _, err = mem.Get("foo")
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_DrainWhileClosing(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	// block the memory so the drain stays in flight
+	mem.mu.Lock()
+	drainErr := make(chan error)
+	go func() { drainErr <- mem.Drain() }()
+	eventually(t, func() bool { return mem.numInflight() == 1 })
+
+	closeErr := make(chan error)
+	go func() { closeErr <- mem.CloseWithTimeout(time.Minute) }()
+	eventually(t, mem.isClosing)
+
+	require.True(t, errors.Is(mem.Drain(), ErrMemoryClosing))
+
+	mem.mu.Unlock()
+	require.NoError(t, <-drainErr)
+	require.NoError(t, <-closeErr)
+	require.FileExists(t, tempFile)
+}
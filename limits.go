@@ -0,0 +1,97 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMemoryFull is returned when a change was rejected because it would grow
+// the memory beyond the limits of WithMaxKeys(…) or WithMaxBytes(…).
+var ErrMemoryFull = errors.New("memory is full")
+
+// ErrValueTooLarge is returned when a value exceeds the size that was
+// configured via WithMaxValueBytes(…).
+var ErrValueTooLarge = errors.New("value is too large")
+
+// checkValue returns an error if the value cannot be stored in this memory.
+func (m *Storage) checkValue(key string, value []byte) error {
+	if m.maxValueBytes > 0 && len(value) > m.maxValueBytes {
+		return fmt.Errorf("%w: value of key %q has %d bytes but only %d bytes are allowed",
+			ErrValueTooLarge, key, len(value), m.maxValueBytes,
+		)
+	}
+
+	return m.validateValue(key, value)
+}
+
+// checkLimits returns an error that wraps ErrMemoryFull if assigning the stored
+// (i.e. sealed) value to the key would exceed the limits of the memory. The
+// caller must hold the write lock.
+func (m *Storage) checkLimits(key string, stored []byte) error {
+	return m.checkChangeLimits([]Change{{Key: key}}, [][]byte{stored})
+}
+
+// checkChangeLimits returns an error that wraps ErrMemoryFull if applying the
+// changes with the given stored values would exceed the limits of the memory.
+// Changes that do not grow the memory are always allowed, even if the memory
+// exceeds a limit already (e.g. because the limit was lowered). The caller
+// must hold the write lock.
+func (m *Storage) checkChangeLimits(changes []Change, stored [][]byte) error {
+	if m.maxKeys == 0 && m.maxBytes == 0 {
+		return nil
+	}
+
+	// only the last change of each key matters
+	last := make(map[string]int, len(changes))
+	for i, c := range changes {
+		last[c.Key] = i
+	}
+
+	numKeys := len(m.data)
+	var growth int64
+	for key, i := range last {
+		old, exists := m.data[key]
+		if exists {
+			growth -= m.footprint(key, old)
+		}
+
+		if changes[i].Deleted {
+			if exists {
+				numKeys--
+			}
+			continue
+		}
+
+		if !exists {
+			numKeys++
+		}
+		growth += m.footprint(key, stored[i])
+	}
+
+	if m.maxKeys > 0 && numKeys > m.maxKeys && numKeys > len(m.data) {
+		return fmt.Errorf("%w: %d keys would exceed the limit of %d keys",
+			ErrMemoryFull, numKeys, m.maxKeys,
+		)
+	}
+
+	if m.maxBytes > 0 && growth > 0 {
+		var size int64
+		for key, value := range m.data {
+			size += m.footprint(key, value)
+		}
+
+		if size+growth > m.maxBytes {
+			return fmt.Errorf("%w: %d bytes would exceed the limit of %d bytes",
+				ErrMemoryFull, size+growth, m.maxBytes,
+			)
+		}
+	}
+
+	return nil
+}
+
+// footprint returns the number of bytes of the key and its stored value that
+// count towards the limit of WithMaxBytes(…).
+func (m *Storage) footprint(key string, stored []byte) int64 {
+	return int64(len(key) + m.sealedSize(stored))
+}
@@ -0,0 +1,114 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithMaxKeys(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMaxKeys(2))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("1")))
+	require.NoError(t, mem.Set("b", []byte("2")))
+
+	err = mem.Set("c", []byte("3"))
+	assert.True(t, errors.Is(err, ErrMemoryFull))
+	assert.EqualError(t, err, "memory is full: 3 keys would exceed the limit of 2 keys")
+
+	err = mem.SetMany(map[string][]byte{"c": []byte("3"), "d": []byte("4")})
+	assert.True(t, errors.Is(err, ErrMemoryFull))
+
+	// existing keys can still be updated
+	require.NoError(t, mem.Set("a", []byte("updated")))
+
+	// a changeset that does not add more keys than it deletes is allowed
+	require.NoError(t, mem.ApplyChangeset([]Change{
+		{Key: "b", Deleted: true},
+		{Key: "c", Value: []byte("3")},
+	}))
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, keys)
+
+	// rejected changes are never written to disk
+	reloaded, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer reloaded.Close()
+	assert.Equal(t, mem.data, reloaded.data)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMaxBytes(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMaxBytes(10))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	err = mem.Set("baz", []byte("12345"))
+	assert.True(t, errors.Is(err, ErrMemoryFull))
+	assert.EqualError(t, err, "memory is full: 14 bytes would exceed the limit of 10 bytes")
+
+	_, ok, err := mem.Get("baz")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// replacing a value with one of the same size or smaller is allowed
+	require.NoError(t, mem.Set("foo", []byte("qux")))
+	require.NoError(t, mem.Set("foo", []byte("q")))
+	require.NoError(t, mem.Set("x", []byte("12345")))
+}
+
+func TestWithMaxKeys_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithMaxKeys(0))
+	assert.EqualError(t, err, "max keys must be positive but got 0")
+
+	_, err = NewMemory(tempFilePath(), WithMaxBytes(-1))
+	assert.EqualError(t, err, "max bytes must be positive but got -1")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMaxValueBytes(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMaxValueBytes(4))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("1234")))
+
+	err = mem.Set("foo", []byte("12345"))
+	assert.True(t, errors.Is(err, ErrValueTooLarge))
+	assert.EqualError(t, err, `value is too large: value of key "foo" has 5 bytes but only 4 bytes are allowed`)
+
+	err = mem.SetMany(map[string][]byte{"bar": nil, "baz": []byte("12345")})
+	assert.True(t, errors.Is(err, ErrValueTooLarge))
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1234"), value)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+}
+
+func TestWithMaxValueBytes_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithMaxValueBytes(0))
+	assert.EqualError(t, err, "max value bytes must be positive but got 0")
+}
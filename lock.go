@@ -0,0 +1,49 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrLocked is returned by NewMemory(…) if WithExclusiveLock() is used and
+// another process holds the lock of the same memory file.
+var ErrLocked = errors.New("memory file is locked by another process")
+
+// lockPath returns the path of the file that is locked via WithExclusiveLock.
+// The memory file itself cannot be locked because it is atomically replaced
+// by a new file on every write.
+func (m *Storage) lockPath() string {
+	return m.path + ".lock"
+}
+
+// acquireFileLock takes the exclusive lock of the memory file. The lock is held
+// until releaseFileLock is called or the process exits.
+func (m *Storage) acquireFileLock() error {
+	f, err := os.OpenFile(m.lockPath(), os.O_RDWR|os.O_CREATE, m.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	err = lockFile(f)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to lock %q: %w", m.path, err)
+	}
+
+	m.lockFile = f
+	return nil
+}
+
+// releaseFileLock releases the lock that was taken via acquireFileLock. It does
+// nothing if the lock is not held. The lock file is not removed, since another
+// process might already be waiting to lock it.
+func (m *Storage) releaseFileLock() {
+	if m.lockFile == nil {
+		return
+	}
+
+	_ = unlockFile(m.lockFile)
+	_ = m.lockFile.Close()
+	m.lockFile = nil
+}
@@ -0,0 +1,57 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows
+
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithExclusiveLock(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".lock")
+
+	mem, err := NewMemory(tempFile, WithExclusiveLock())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	_, err = NewMemory(tempFile, WithExclusiveLock())
+	assert.True(t, errors.Is(err, ErrLocked), err)
+
+	// memories without the option are not affected by the lock
+	other, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithExclusiveLock())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+}
+
+func TestWithExclusiveLock_ReleasedOnError(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".lock")
+	require.NoError(t, os.WriteFile(tempFile, []byte("{"), 0660))
+
+	_, err := NewMemory(tempFile, WithExclusiveLock())
+	require.Error(t, err)
+
+	// the memory could not be created so its lock must not be held anymore
+	require.NoError(t, os.Remove(tempFile))
+	mem, err := NewMemory(tempFile, WithExclusiveLock())
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// touch marks the key as the most recently used key (see WithLRU). The caller
+// must hold at least the read lock.
+func (m *Storage) touch(key string) {
+	if m.lruMaxEntries == 0 {
+		return
+	}
+
+	m.lruMu.Lock()
+	defer m.lruMu.Unlock()
+
+	if m.lruTicks == nil {
+		m.lruTicks = map[string]uint64{}
+	}
+
+	m.lruClock++
+	m.lruTicks[key] = m.lruClock
+}
+
+// evictLRU removes the least recently used keys until the memory does not
+// exceed the limit of WithLRU(…) anymore and returns the previous state of the
+// evicted keys. Keys that were not used since the memory file was loaded are
+// considered equally old and evicted in sorted order. The caller must hold the
+// write lock.
+func (m *Storage) evictLRU() map[string]entry {
+	if m.lruMaxEntries == 0 || len(m.data) <= m.lruMaxEntries {
+		return nil
+	}
+
+	m.lruMu.Lock()
+	defer m.lruMu.Unlock()
+
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ti, tj := m.lruTicks[keys[i]], m.lruTicks[keys[j]]
+		if ti != tj {
+			return ti < tj
+		}
+		return keys[i] < keys[j]
+	})
+
+	evicted := make(map[string]entry, len(keys)-m.lruMaxEntries)
+	for _, key := range keys[:len(keys)-m.lruMaxEntries] {
+		evicted[key] = m.entry(key)
+		m.remove(key)
+	}
+
+	// forget keys that were deleted since the last eviction
+	for key := range m.lruTicks {
+		if _, ok := m.data[key]; !ok {
+			delete(m.lruTicks, key)
+		}
+	}
+
+	return evicted
+}
+
+// finishEviction reverts the evicted keys if the change that caused their
+// eviction was rejected with the given error. If the change was committed, the
+// eviction is reported like the deletion of the keys. The caller must hold the
+// write lock.
+func (m *Storage) finishEviction(evicted map[string]entry, err error) {
+	if isRejected(err) {
+		for key, e := range evicted {
+			m.restore(key, e)
+		}
+		return
+	}
+
+	if err != nil {
+		return
+	}
+
+	keys := make([]string, 0, len(evicted))
+	for key := range evicted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		m.logger.Debug("Evicted least recently used key", zap.String("key", key))
+		m.changed(key, WatchEvent{Deleted: true})
+	}
+}
@@ -0,0 +1,90 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithLRU(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithLRU(2))
+	require.NoError(t, err)
+
+	events, cancel := mem.Watch("a")
+	defer cancel()
+
+	require.NoError(t, mem.Set("a", []byte("1")))
+	require.NoError(t, mem.Set("b", []byte("2")))
+	<-events
+
+	// reading "a" makes "b" the least recently used key
+	_, _, err = mem.Get("a")
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("c", []byte("3")))
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, keys)
+
+	// updating a key does not evict anything
+	require.NoError(t, mem.Set("c", []byte("4")))
+	require.NoError(t, mem.Set("d", []byte("5")))
+
+	keys, err = mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, keys)
+	assert.Equal(t, WatchEvent{Deleted: true}, <-events)
+	require.NoError(t, mem.Close())
+
+	// the eviction was persisted and all loaded keys are equally old
+	mem, err = NewMemory(tempFile, WithLRU(2))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err = mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, keys)
+
+	values, err := mem.GetMany([]string{"d"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"d": []byte("5")}, values)
+
+	require.NoError(t, mem.Set("e", []byte("6")))
+	keys, err = mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "e"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithLRU_Rejected(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithLRU(1), WithMaxSerializedSize(200))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("1")))
+
+	err = mem.Set("b", make([]byte, 200))
+	require.ErrorIs(t, err, ErrMaxSerializedSize)
+
+	// the evicted key was restored together with the rejected change
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys)
+}
+
+func TestWithLRU_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithLRU(0))
+	require.EqualError(t, err, "max LRU entries must be positive but got 0")
+
+	_, err = NewMemory(tempFilePath(), WithLRU(1), WithMaxKeys(1))
+	require.EqualError(t, err, "LRU eviction cannot be combined with a maximum number of keys")
+}
@@ -0,0 +1,59 @@
+package file
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// MatchKeys returns all keys that match the given glob pattern in sorted
+// order. The pattern has the syntax of path.Match, e.g. "session:*:expired"
+// matches "session:42:expired". Note that "*" and "?" never match a "/". An
+// invalid pattern returns an error that wraps path.ErrBadPattern. Just like
+// with GetPrefix, an error is returned if the keys are hashed (see
+// WithKeyHashing).
+func (m *Storage) MatchKeys(pattern string) ([]string, error) {
+	if err := m.errKeysHashed("MatchKeys"); err != nil {
+		return nil, err
+	}
+
+	// path.Match reports a malformed pattern even if it does not match
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	return m.matchKeys(func(key string) bool {
+		ok, _ := path.Match(pattern, key)
+		return ok
+	})
+}
+
+// MatchKeysRegexp returns all keys that match the given regular expression in
+// sorted order. The expression matches any part of the key unless it is
+// anchored via "^" and "$". Just like with GetPrefix, an error is returned if
+// the keys are hashed (see WithKeyHashing).
+func (m *Storage) MatchKeysRegexp(re *regexp.Regexp) ([]string, error) {
+	if err := m.errKeysHashed("MatchKeysRegexp"); err != nil {
+		return nil, err
+	}
+
+	return m.matchKeys(re.MatchString)
+}
+
+// matchKeys returns all keys of the memory for which match returns true.
+func (m *Storage) matchKeys(match func(key string) bool) ([]string, error) {
+	keys, err := m.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	// the keys are sorted already, so the matches are sorted as well
+	matches := []string{}
+	for _, key := range keys {
+		if match(key) {
+			matches = append(matches, key)
+		}
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,80 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_MatchKeys(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.SetMany(map[string][]byte{
+		"session:1:expired": nil,
+		"session:2:active":  nil,
+		"session:3:expired": nil,
+		"user:1":            nil,
+		"a/b":               nil,
+	}))
+
+	cases := map[string][]string{
+		"session:*:expired": {"session:1:expired", "session:3:expired"},
+		"*:1*":              {"session:1:expired", "user:1"},
+		"session:?:*":       {"session:1:expired", "session:2:active", "session:3:expired"},
+		"user:[0-9]":        {"user:1"},
+		"*":                 {"session:1:expired", "session:2:active", "session:3:expired", "user:1"},
+		"a*":                {},
+		"missing":           {},
+	}
+
+	for pattern, expected := range cases {
+		keys, err := mem.MatchKeys(pattern)
+		require.NoError(t, err, pattern)
+		require.Equal(t, expected, keys, pattern)
+	}
+
+	_, err = mem.MatchKeys("session:[")
+	require.True(t, errors.Is(err, path.ErrBadPattern), err)
+
+	// the pattern is checked even if no key could match it
+	_, err = mem.MatchKeys("x[")
+	require.True(t, errors.Is(err, path.ErrBadPattern), err)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_MatchKeysRegexp(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.SetMany(map[string][]byte{
+		"session:1:expired": nil,
+		"session:22:active": nil,
+		"user:1":            nil,
+	}))
+
+	keys, err := mem.MatchKeysRegexp(regexp.MustCompile(`^session:\d+:`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"session:1:expired", "session:22:active"}, keys)
+
+	keys, err = mem.MatchKeysRegexp(regexp.MustCompile(`:1$`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"user:1"}, keys)
+
+	keys, err = mem.MatchKeysRegexp(regexp.MustCompile(`^admin`))
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
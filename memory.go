@@ -1,24 +1,213 @@
 package file
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-joe/joe"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-// memory is an implementation of a joe.Memory which stores all values as a JSON
-// encoded file. Note that there is no need for a joe.Memory to handle
-// synchronization for concurrent access (e.g. via locks) because this is
-// automatically handled by the joe.Brain.
-type memory struct {
-	path   string
-	logger *zap.Logger
-	data   map[string][]byte
+// ErrMaxSerializedSize is returned when a change to the memory was rejected
+// because the encoded memory file would exceed the size that was configured
+// via WithMaxSerializedSize(…).
+var ErrMaxSerializedSize = errors.New("memory file would exceed maximum size")
+
+// ErrConflict is returned when the memory file was modified by another process
+// and the change was rejected by the WithConflictDetection(…) callback.
+var ErrConflict = errors.New("memory file was modified by another process")
+
+// Storage is an implementation of a joe.Memory which stores all values as a
+// JSON encoded file. While the joe.Brain already synchronizes concurrent
+// access to its memory, the Storage guards its data with a lock as well,
+// because some options update the memory from background goroutines.
+//
+// Apart from the joe.Memory interface, a Storage offers additional methods
+// that are not available through the joe.Brain. You can get a *Storage by
+// creating it directly via NewMemory(…).
+type Storage struct {
+	// operation counters, accessed atomically and kept at the start of the
+	// struct to guarantee 64-bit alignment on 32-bit platforms
+	numSets, numGets, numDeletes uint64
+
+	path    string
+	absPath string // see Path
+	store   Store  // the memory file at path unless a custom store is used
+	logger  *zap.Logger
+	name    string // added to all log messages (see WithName)
+
+	mu       sync.RWMutex
+	data     map[string][]byte
+	versions map[string]uint64    // only contains keys written via SetWithVersion
+	expires  map[string]time.Time // only contains keys written via SetWithTTL
+	types    map[string]string    // only contains keys written via SetTyped
+	watchers map[string]map[*watcher]struct{}
+	modified map[string]struct{} // keys changed since the memory was loaded
+	lazy     *lazyIndex          // values that were not decoded yet (see WithLazyLoad)
+
+	mergePolicy       MergePolicy
+	reloadPolicy      ReloadPolicy
+	maxSerializedSize int64
+	maxKeys           int
+	maxBytes          int64
+	maxValueBytes     int
+	maxKeyLength      int
+	keyValidator      func(key string) error
+	keyNormalizer     func(key string) string
+	keyHasher         func(key string) string // see WithKeyHashing
+	strictKeys        bool
+	strictCollisions  bool // fail loading files with keys that normalize to the same key
+	checkWritable     bool
+	writableFallback  bool // switch to a temporary file if the memory file is not writable
+	fellBack          bool // the memory file was switched to the writable fallback
+	createDirs        bool
+	fsys              FS
+	fileMode          os.FileMode
+	tempDir           string
+	openFlags         int  // flags of os.OpenFile to write a file (see WithOpenFlags)
+	noSymlink         bool // refuse to read or write files that are symlinks
+	syncWrites        bool // fsync the memory file after each write
+	operationTimeout  time.Duration
+	expandPaths       bool // see WithPathExpansion
+	readOnly          bool // reject all changes
+	lazyLoad          bool // see WithLazyLoad
+	sharedInstance    bool // see WithSharedInstance
+	timestampHeader   bool
+	metadataHeader    bool
+	deltaNumeric      bool
+	rawStrings        bool
+	rawHTML           bool         // do not escape HTML characters in strings
+	strictDecoding    bool         // reject unknown and duplicate fields (see WithStrictDecoding)
+	indent            *indentation // nil means the JSON is written compactly
+	lineEnding        LineEnding
+	keyIndexPath      string
+	mirrorPath        string
+	strictMirror      bool // fail persist if the mirror cannot be written
+	externalThreshold int  // values larger than this are written to side files
+	externalDir       string
+	externalFiles     map[string]bool         // side files that exist on disk
+	externalNames     map[string]externalName // side file names by key
+	persistRefs       map[string]string       // external references of the file that is persisted
+	dedup             bool                    // see WithDedup
+	dedupValues       map[string]*dedupValue  // unique values by their hash
+	dedupKeys         map[string]string       // hashes of the deduplicated values by key
+	backups           int                     // number of previous files to keep
+	maxFileAge        time.Duration           // archive older memory files when loading
+	maxArchives       int                     // number of archives to keep or zero
+	corruptFilePolicy CorruptFilePolicy
+	shards            int          // number of shard files or zero
+	dirtyShards       map[int]bool // shards that must be written by the next persist
+	skipChecksum      bool         // do not verify the checksum of loaded files
+	valueChecksums    bool         // write a checksum of each value
+	dropCorrupt       bool         // treat values that do not match their checksum as missing
+	loadedChecksums   map[string]uint32
+	fallback          joe.Memory // consulted by Get for keys that are missing
+	fallbackKeys      bool       // Keys also returns the keys of the fallback
+	seedFS            fs.FS
+	seedName          string
+	loadPath          string // see WithReadWriteSplit
+	preferLoad        bool   // read the load path even if the memory file exists
+	valueInspector    func(key string, value []byte) string
+	valueValidator    func(key string, value []byte) error
+	validateLoaded    bool // also validate the values of loaded files
+	loadProgress      func(bytesRead, totalBytes int64)
+
+	logRatio     float64             // compaction ratio of the append log or zero
+	binaryLog    bool                // see WithBinaryAppendLog
+	logKeys      map[string]struct{} // keys that must be appended to the log
+	logSize      int64               // size of the append log
+	snapshotSize int64               // size of the memory file the log is based on
+	compactEvery time.Duration       // see WithSnapshotInterval
+	compactGate  func() bool         // see WithCompactionSchedule
+
+	tracer  trace.Tracer
+	metrics Metrics // nil means no metrics are recorded
+
+	changeHooks    []func(event ChangeEvent)
+	pendingChanges []ChangeEvent // changes that are reported after the next flush
+	auditPath      string
+	auditValues    bool // write values instead of their hashes to the audit log
+
+	codec    Codec       // nil means the built-in JSON format is used
+	compress bool        // compress the memory file with gzip
+	gzLevel  int         // see WithCompressionLevel
+	aead     cipher.AEAD // encrypts the memory file if set
+	sealed   bool        // values are kept encrypted in memory
+
+	onConflict   func(path string) error
+	diskChecksum [sha256.Size]byte // checksum of the file we last read or wrote
+	created      bool              // the memory file did not exist when it was loaded
+	lastPersist  time.Time         // time of the last successful write
+	persistErr   error             // error of the last attempt to write (see LastPersistError)
+	persistMu    sync.Mutex        // serializes writes of the memory file
+	persistDone  *sync.Cond        // signals that a write has completed
+	persistSeq   uint64            // number of the latest snapshot of the data
+	writtenSeq   uint64            // number of the latest snapshot on disk, guarded by persistMu
+	doneSeq      uint64            // number of the latest completed write
+	unlocked     sync.WaitGroup    // writes that do not hold the write lock
+	keepLocked   bool              // set by close so all writes hold the write lock
+	clock        Clock             // see WithClock
+	pendingWrite <-chan struct{}   // closed once a file operation that timed out completes
+	registryKey  string            // path of the memory in the registry of open memories
+	refs         int               // number of users of a shared instance, guarded by the registry
+
+	versionFile  bool
+	pollInterval time.Duration
+	version      uint64 // last version we have written or loaded
+
+	fileWatchInterval time.Duration
+	watchedModTime    time.Time // modification time of the file at the last poll
+	watchedSize       int64     // size of the file at the last poll
+
+	flushInterval      time.Duration
+	maxFlushDelay      time.Duration
+	flushTimer         *time.Timer // flushes once the oldest change reached the max delay
+	dirty              bool        // changes have not been persisted yet
+	paused             bool        // changes are only persisted by Resume
+	draining           bool        // changes are rejected (see Drain)
+	persistErrorPolicy PersistErrorPolicy
+
+	seed           map[string][]byte
+	notReadyPolicy NotReadyPolicy
+	loaded         chan struct{} // closed when the background load finished
+	loadErr        error         // only set once loaded is closed
+
+	exclusiveLock bool
+	lockFile      *os.File // holds the lock of WithExclusiveLock
+
+	stop      chan struct{} // closed when the memory is closed
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+
+	inTransaction int32 // set while Transaction is running
+
+	lruMaxEntries int
+	lruMu         sync.Mutex
+	lruTicks      map[string]uint64 // logical time of the last access of each key
+	lruClock      uint64
+
+	lifecycleMu sync.Mutex
+	closing     bool          // set by CloseWithTimeout
+	closed      bool          // set once CloseWithTimeout closed the memory
+	inflight    int           // number of running operations
+	idle        chan struct{} // closed when the last operation finished
 }
 
 // Memory is a joe.Option which is supposed to be passed to joe.New(…) to
@@ -50,139 +239,1676 @@ func Memory(path string) joe.Module {
 // path. If there is already a JSON encoded file at the given path it is loaded
 // and decoded into memory to serve future requests. An error is returned if the
 // file exists but cannot be opened or does not contain a valid JSON object.
-func NewMemory(path string, opts ...Option) (joe.Memory, error) {
-	memory := &memory{
-		path: path,
-		data: map[string][]byte{},
+//
+// If the path is empty, the memory starts empty and only keeps its values in
+// memory, without ever reading or writing a file. This is useful for tests and
+// bots that do not need to remember anything across restarts.
+func NewMemory(path string, opts ...Option) (*Storage, error) {
+	return openMemory(path, opts, func() (*Storage, error) {
+		return loadMemory(path, opts)
+	})
+}
+
+// loadMemory implements NewMemory, apart from the registry of open memories.
+func loadMemory(path string, opts []Option) (*Storage, error) {
+	memory, err := newMemory(path, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, opt := range opts {
-		err := opt(memory)
-		if err != nil {
-			return nil, err
+	path = memory.path
+
+	if memory.loaded != nil {
+		for key, value := range memory.seed {
+			memory.data[memory.hashKey(key)], err = memory.sealValue(value)
+			if err != nil {
+				memory.releaseFileLock()
+				return nil, err
+			}
 		}
+
+		memory.logger.Info("Memory initialized from seed; loading file in background",
+			zap.String("path", path),
+			zap.Int("num_memories", len(memory.data)),
+		)
+
+		memory.startBackgroundLoad()
+		memory.start()
+		return memory, nil
 	}
 
-	if memory.logger == nil {
-		memory.logger = zap.NewNop()
+	var lazy bool
+	if memory.lazyLoad {
+		lazy, err = memory.loadLazy()
 	}
 
-	memory.logger.Debug("Opening memory file", zap.String("path", path))
-	f, err := os.Open(path)
 	switch {
-	case os.IsNotExist(err):
-		memory.logger.Debug("File does not exist. Continuing with empty memory", zap.String("path", path))
-	case err != nil:
-		return nil, fmt.Errorf("failed to open file: %w", err)
+	case err != nil || lazy:
+	case memory.shards > 0:
+		err = memory.loadShards()
 	default:
-		memory.logger.Debug("Decoding JSON from memory file", zap.String("path", path))
-		err := json.NewDecoder(f).Decode(&memory.data)
-		_ = f.Close()
-		if err != nil {
-			return nil, fmt.Errorf("failed decode data as JSON: %w", err)
+		var data map[string][]byte
+		data, err = memory.loadFile(path)
+		if data != nil {
+			memory.data = data
+			memory.indexValues()
 		}
+		if err == nil && memory.logRatio > 0 {
+			err = memory.replayLog()
+		}
+	}
+	if err != nil {
+		memory.releaseFileLock()
+		return nil, err
+	}
+
+	if memory.externalDir != "" && !memory.readOnly {
+		memory.collectOrphans()
+	}
+
+	numMemories := len(memory.data)
+	if memory.lazy != nil {
+		numMemories += len(memory.lazy.values)
 	}
 
 	memory.logger.Info("Memory initialized successfully",
 		zap.String("path", path),
-		zap.Int("num_memories", len(memory.data)),
+		zap.Int("num_memories", numMemories),
 	)
 
+	memory.start()
 	return memory, nil
 }
 
-// Set assign the key to the value and then saves the updated memory to its JSON
-// file. An error is returned if this function is called after the memory was
-// closed already or if the file could not be written or updated.
-func (m *memory) Set(key string, value []byte) error {
-	if m.data == nil {
-		return errors.New("brain was already shut down")
+// NewMemoryFromPaths creates a new Memory instance from the first of the given
+// paths at which a memory file exists and can be loaded. All changes are
+// persisted to this path. Candidates which exist but cannot be loaded are
+// logged and skipped. If there is no memory file at any of the paths, the
+// memory file is created at the first path. If memory files exist but none of
+// them can be loaded, the error of the first one is returned.
+func NewMemoryFromPaths(paths []string, opts ...Option) (*Storage, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("at least one path is required")
+	}
+
+	var firstErr error
+	for _, path := range paths {
+		probe, err := probeOptions(path, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		logger := probe.logger
+
+		// options may change the path (see WithPathExpansion)
+		_, err = probe.fsys.Stat(probe.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			logger.Debug("Skipping missing memory file", zap.String("path", probe.path))
+			continue
+		}
+
+		memory, err := NewMemory(path, opts...)
+		if err != nil {
+			logger.Warn("Skipping memory file that cannot be loaded",
+				zap.String("path", probe.path),
+				zap.Error(err),
+			)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		memory.logger.Info("Using memory file", zap.String("path", memory.path))
+		return memory, nil
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	memory, err := NewMemory(paths[0], opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	m.data[key] = value
-	return m.persist()
+	memory.logger.Info("Creating new memory file at first path", zap.String("path", memory.path))
+	return memory, nil
 }
 
-// Get returns the value that is associated with the given key. The second
-// return value indicates if the key actually existed in the memory.
+// NewMemoryFromFiles creates a new Memory instance that is initialized from
+// the primary file and all extra files. All keys found in the extra files are
+// merged into the memory but all changes are only persisted to the primary
+// file. The extra files are never written to.
 //
-// An error is only returned if this function is called after the memory was
-// closed already.
-func (m *memory) Get(key string) ([]byte, bool, error) {
-	if m.data == nil {
-		return nil, false, errors.New("brain was already shut down")
+// If the same key appears in multiple files, the conflict is resolved using
+// the configured MergePolicy (see WithMergePolicy). By default the value of
+// the primary file wins. A file that does not exist is skipped but any other
+// error during loading is returned.
+func NewMemoryFromFiles(primary string, extra []string, opts ...Option) (*Storage, error) {
+	return openMemory(primary, opts, func() (*Storage, error) {
+		return loadMemoryFromFiles(primary, extra, opts)
+	})
+}
+
+// loadMemoryFromFiles implements NewMemoryFromFiles, apart from the registry of
+// open memories.
+func loadMemoryFromFiles(primary string, extra []string, opts []Option) (*Storage, error) {
+	memory, err := newMemory(primary, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	value, ok := m.data[key]
-	return value, ok, nil
+	if memory.shards > 0 || memory.logRatio > 0 || memory.lazyLoad {
+		memory.releaseFileLock()
+	}
+
+	if memory.shards > 0 {
+		return nil, errors.New("sharding cannot be combined with multiple files")
+	}
+
+	if memory.lazyLoad {
+		return nil, errors.New("lazy loading cannot be combined with multiple files")
+	}
+
+	if memory.logRatio > 0 {
+		return nil, errors.New("an append log cannot be combined with multiple files")
+	}
+
+	paths := append([]string{memory.path}, extra...)
+	if memory.expandPaths {
+		for i, path := range paths[1:] {
+			paths[i+1], err = expandPath(path)
+			if err != nil {
+				memory.releaseFileLock()
+				return nil, fmt.Errorf("failed to expand path %q: %w", path, err)
+			}
+		}
+	}
+	for _, path := range paths {
+		data, err := memory.loadFile(path)
+		if err != nil {
+			memory.releaseFileLock()
+			return nil, fmt.Errorf("failed to load %q: %w", path, err)
+		}
+
+		for key, value := range data {
+			_, exists := memory.data[key]
+			if exists && memory.mergePolicy == PrimaryWins {
+				memory.logger.Debug("Ignoring conflicting key from file",
+					zap.String("key", key),
+					zap.String("path", path),
+				)
+				continue
+			}
+
+			memory.data[key] = value
+		}
+	}
+
+	memory.indexValues()
+	memory.logger.Info("Memory initialized successfully from multiple files",
+		zap.String("path", memory.path),
+		zap.Int("num_files", len(paths)),
+		zap.Int("num_memories", len(memory.data)),
+	)
+
+	memory.start()
+	return memory, nil
 }
 
-// Delete removes any value that might have been assigned to the key earlier.
-// The boolean return value indicates if the memory contained the key. If it did
-// not contain the key the function does nothing and returns without an error.
-// If the key existed it is removed and the corresponding JSON file is updated.
-//
-// An error is returned if this function is called after the memory was closed
-// already or if the file could not be written or updated.
-func (m *memory) Delete(key string) (bool, error) {
-	if m.data == nil {
-		return false, errors.New("brain was already shut down")
+// Normalize loads the memory file at the given path and writes it again in the
+// canonical format of this package, applying the given options (e.g. the
+// timestamp header). This is useful to clean up a memory file after it was
+// edited by hand. Normalize does not start a memory, so it can be used while no
+// bot is running. Since editing the file invalidates its checksum, the checksum
+// is not verified but a new one is written. An error is returned if the file
+// does not exist or cannot be decoded.
+func Normalize(path string, opts ...Option) error {
+	memory, err := newMemory(path, opts)
+	if err != nil {
+		return err
 	}
 
-	_, ok := m.data[key]
-	if !ok {
-		return false, nil
+	path = memory.path
+
+	defer memory.releaseFileLock()
+
+	if memory.shards > 0 {
+		return errors.New("sharded memories cannot be normalized")
+	}
+
+	if memory.logRatio > 0 {
+		return errors.New("memories with an append log cannot be normalized")
+	}
+
+	memory.skipChecksum = true
+
+	data, err := memory.loadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		return fmt.Errorf("memory file %q does not exist", path)
 	}
 
-	delete(m.data, key)
-	return ok, m.persist()
+	memory.data = data
+	memory.indexValues()
+	return memory.persist(context.Background())
 }
 
-// Keys returns a list of all keys known to this memory.
-// An error is only returned if this function is called after the memory was
-// closed already.
-func (m *memory) Keys() ([]string, error) {
-	if m.data == nil {
-		return nil, errors.New("brain was already shut down")
+// isRejected returns true if the error indicates that persist refused to write
+// the file or that the change must be reverted because of the Rollback policy
+// (see WithPersistErrorPolicy). In both cases the caller should revert its
+// change of the data.
+func isRejected(err error) bool {
+	return errors.Is(err, ErrMaxSerializedSize) ||
+		errors.Is(err, ErrStaleVersion) ||
+		errors.Is(err, ErrConflict) ||
+		isRolledBack(err)
+}
+
+// compile time check that the Storage actually implements the joe.Memory
+var _ joe.Memory = (*Storage)(nil)
+
+func newMemory(path string, opts []Option) (*Storage, error) {
+	memory := &Storage{
+		path:      path,
+		data:      map[string][]byte{},
+		stop:      make(chan struct{}),
+		tracer:    defaultTracer,
+		fsys:      osFS{},
+		fileMode:  0660,
+		openFlags: defaultOpenFlags,
+		gzLevel:   gzip.DefaultCompression,
 	}
 
-	keys := make([]string, 0, len(m.data))
-	for k := range m.data {
-		keys = append(keys, k)
+	for _, opt := range opts {
+		err := opt(memory)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// provide a stable result
-	sort.Strings(keys)
+	// options may change the path (see WithPathExpansion)
+	path = memory.path
+	memory.absPath = absolutePath(path)
 
-	return keys, nil
+	if memory.logger == nil {
+		memory.logger = zap.NewNop()
+	}
+
+	if memory.name != "" {
+		memory.logger = memory.logger.With(zap.String("memory", memory.name))
+	}
+
+	if memory.sealed && memory.aead == nil {
+		return nil, errors.New("lazy decryption requires an encryption key")
+	}
+
+	if memory.codec != nil && (memory.timestampHeader || memory.metadataHeader || memory.deltaNumeric || memory.sealed || memory.rawStrings || memory.rawHTML || memory.indent != nil || memory.strictDecoding) {
+		return nil, errors.New("a custom codec cannot be combined with options that require the JSON format")
+	}
+
+	if memory.lineEnding == CRLF && memory.indent == nil {
+		return nil, errors.New("CRLF line endings require an indented memory file")
+	}
+
+	if memory.store == nil && path != "" {
+		memory.store = fileStore{memory: memory}
+	}
+
+	if err := memory.checkStoreOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkShardOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkAppendLogOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkBinaryLogOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkSeedOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkSplitOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkReadOnlyOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkExternalOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkLazyOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkValueChecksumOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkFSOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkArchiveOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkWritableFallbackOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkKeyHashingOptions(); err != nil {
+		return nil, err
+	}
+
+	if err := memory.checkDedupOptions(); err != nil {
+		return nil, err
+	}
+
+	if memory.lruMaxEntries > 0 && memory.maxKeys > 0 {
+		return nil, errors.New("LRU eviction cannot be combined with a maximum number of keys")
+	}
+
+	if memory.persistErrorPolicy == Rollback && memory.flushInterval > 0 {
+		return nil, errors.New("the rollback persist error policy cannot be combined with a flush interval")
+	}
+
+	if memory.maxFlushDelay > 0 && memory.maxFlushDelay >= memory.flushInterval {
+		return nil, errors.New("max flush delay requires a longer flush interval")
+	}
+
+	if path != "" {
+		if info, err := memory.fsys.Stat(path); err == nil && info.IsDir() {
+			return nil, fmt.Errorf("memory path %q is a directory, expected a file", path)
+		}
+	}
+
+	if memory.noSymlink {
+		memory.openFlags |= noFollowFlag
+		for _, p := range []string{path, memory.tmpPath(path)} {
+			if err := memory.checkNoSymlink(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if memory.createDirs {
+		err := memory.fsys.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create directory of memory file: %w", err)
+		}
+	}
+
+	if memory.externalDir != "" && !memory.readOnly {
+		err := memory.fsys.MkdirAll(memory.externalDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create directory of external values: %w", err)
+		}
+	}
+
+	if memory.checkWritable {
+		err := memory.verifyWritable()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if memory.exclusiveLock {
+		err := memory.acquireFileLock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if memory.maxFileAge > 0 {
+		err := memory.archiveOldFile()
+		if err != nil {
+			memory.releaseFileLock()
+			return nil, err
+		}
+	}
+
+	return memory, nil
 }
 
-// Close removes all data from the memory. Note that all calls to the memory
-// will fail after this function has been called.
-func (m *memory) Close() error {
-	if m.data == nil {
-		return errors.New("brain was already closed")
+// verifyWritable checks that the memory file can be written. Since the memory
+// file is replaced via a temporary file (see writeFile), this creates the
+// temporary file and removes it again.
+func (m *Storage) verifyWritable() error {
+	tmpPath := m.tmpPath(m.path)
+	f, err := m.fsys.OpenFile(tmpPath, m.openFlags, m.fileMode)
+	if err != nil {
+		return fmt.Errorf("memory file is not writable: %w", err)
 	}
 
-	m.data = nil
+	_ = f.Close()
+	_ = m.fsys.Remove(tmpPath)
 	return nil
 }
 
-func (m *memory) persist() error {
-	f, err := os.OpenFile(m.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
-	if err != nil {
-		return fmt.Errorf("failed to open file to persist data: %w", err)
+// start launches all background goroutines that have been enabled via the
+// options. It must be called exactly once after the initial data was loaded.
+func (m *Storage) start() {
+	m.background(m.expirePeriodically)
+
+	if m.flushInterval > 0 {
+		m.background(m.flushPeriodically)
 	}
 
-	err = json.NewEncoder(f).Encode(m.data)
-	if err != nil {
-		_ = f.Close()
-		return fmt.Errorf("failed to encode data as JSON: %w", err)
+	if m.compactEvery > 0 {
+		m.background(m.compactPeriodically)
 	}
 
-	err = f.Close()
+	if m.fileWatchInterval > 0 {
+		m.background(m.watchFile)
+	}
+
+	if m.versionFile {
+		m.version = m.readVersion()
+		m.background(m.pollVersionFile)
+	}
+}
+
+// background runs the given function in a new goroutine. The function must
+// return when the stop channel is closed. All background goroutines are
+// awaited when the memory is closed. A panic in the function is recovered and
+// logged so it does not crash the bot.
+func (m *Storage) background(fun func(stop <-chan struct{})) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer func() { _ = m.recoverPanic("background goroutine", recover()) }()
+		fun(m.stop)
+	}()
+}
+
+// Set assign the key to the value and then saves the updated memory to its JSON
+// file. An error is returned if this function is called after the memory was
+// closed already or if the file could not be written or updated.
+//
+// If the key already has exactly the given value, Set returns right away
+// without writing the memory file and without notifying any watchers. Keys
+// with a TTL or a version are always written, since Set resets their TTL and
+// increments their version.
+//
+// Other operations are not blocked while the memory file is written, so they
+// might observe the new value before Set returns. This is not the case if an
+// option requires the file to be written while the memory is locked (e.g.
+// WithVersionFile, WithShards or the Rollback persist error policy).
+func (m *Storage) Set(key string, value []byte) error {
+	return m.SetContext(context.Background(), key, value)
+}
+
+// SetContext is like Set but accepts a context. If tracing is enabled via
+// WithTracerProvider(…), the spans of this operation become children of the
+// span in the given context.
+//
+// If the context is canceled or its deadline passes while the memory file is
+// written, SetContext returns the error of the context without waiting for the
+// write to finish. Note that this does not roll back the change: the value is
+// still set in memory and the write continues in the background.
+func (m *Storage) SetContext(ctx context.Context, key string, value []byte) (err error) {
+	key = m.normalizeKey(key)
+
+	ctx, span := m.startSpan(ctx, "Set", attribute.Int("value_bytes", len(value)))
+	start := time.Now()
+	defer func() {
+		m.observe(func(metrics Metrics) { metrics.ObserveSet(time.Since(start), err) })
+		endSpan(span, err)
+	}()
+
+	if err = m.checkKey(key); err != nil {
+		return err
+	}
+
+	if err = m.checkValue(key, value); err != nil {
+		return err
+	}
+
+	m.inspectValue(key, value)
+	stored, err := m.sealValue(value)
 	if err != nil {
-		return fmt.Errorf("failed to close file; data might not have been fully persisted to disk: %w", err)
+		return err
+	}
+
+	_, err = withContext(ctx, func() (struct{}, error) {
+		_, err := m.set(ctx, key, value, stored)
+		return struct{}{}, err
+	})
+
+	return err
+}
+
+// set assigns the stored representation of the value to the key and persists
+// the memory. It returns the state of the key before it was changed.
+func (m *Storage) set(ctx context.Context, key string, value, stored []byte) (entry, error) {
+	if err := m.lock(); err != nil {
+		return entry{}, err
+	}
+	defer m.unlock()
+
+	atomic.AddUint64(&m.numSets, 1)
+	prev := m.entry(key)
+	if m.unchanged(prev, value) {
+		// idempotent handlers should not rewrite the memory file
+		m.touch(key)
+		return prev, nil
+	}
+
+	if err := m.checkLimits(key, stored); err != nil {
+		return entry{}, err
+	}
+
+	m.put(key, stored)
+
+	err := m.commit(ctx)
+	if isRejected(err) {
+		// the file was not written so we revert the change to stay consistent
+		m.restore(key, prev)
+	}
+
+	if err == nil {
+		m.changed(key, WatchEvent{Value: value})
+	}
+
+	return prev, err
+}
+
+// inspectValue logs a warning if the configured value inspector reports an
+// issue with the given value (see WithValueInspector).
+func (m *Storage) inspectValue(key string, value []byte) {
+	if m.valueInspector == nil {
+		return
+	}
+
+	defer func() { _ = m.recoverPanic("value inspector", recover()) }()
+
+	if warning := m.valueInspector(key, value); warning != "" {
+		m.logger.Warn("Value inspector reported an issue",
+			zap.String("key", key),
+			zap.String("warning", warning),
+		)
+	}
+}
+
+// Get returns the value that is associated with the given key. The second
+// return value indicates if the key actually existed in the memory.
+//
+// An error is only returned if this function is called after the memory was
+// closed already.
+func (m *Storage) Get(key string) ([]byte, bool, error) {
+	return m.GetContext(context.Background(), key)
+}
+
+// GetDefault returns the value of the given key as string or the fallback if
+// the memory does not contain the key. Just like with Get, an error is only
+// returned if this function is called after the memory was closed already, in
+// which case the fallback is not returned.
+func (m *Storage) GetDefault(key, fallback string) (string, error) {
+	value, ok, err := m.Get(key)
+	switch {
+	case err != nil:
+		return "", err
+	case !ok:
+		return fallback, nil
+	default:
+		return string(value), nil
+	}
+}
+
+// GetContext is like Get but accepts a context. If tracing is enabled via
+// WithTracerProvider(…), the span of this operation becomes a child of the
+// span in the given context.
+func (m *Storage) GetContext(ctx context.Context, key string) (value []byte, ok bool, err error) {
+	key = m.normalizeKey(key)
+
+	_, span := m.startSpan(ctx, "Get")
+	start := time.Now()
+	defer func() {
+		m.observe(func(metrics Metrics) { metrics.ObserveGet(time.Since(start), err) })
+		span.SetAttributes(attribute.Bool("found", ok), attribute.Int("value_bytes", len(value)))
+		endSpan(span, err)
+	}()
+
+	atomic.AddUint64(&m.numGets, 1)
+	value, ok, err = m.get(key)
+	if err == nil && !ok && !m.isLoaded() {
+		// the key might still be contained in the file that is loaded in the background
+		if err = m.awaitLoad(); err != nil {
+			return nil, false, err
+		}
+
+		value, ok, err = m.get(key)
+	}
+
+	if err == nil && !ok && m.fallback != nil {
+		return m.getFallback(key)
+	}
+
+	return value, ok, err
+}
+
+func (m *Storage) get(key string) ([]byte, bool, error) {
+	if err := m.rlockIndex(); err != nil {
+		return nil, false, err
+	}
+	defer m.runlock()
+
+	if m.isExpired(key, m.now()) {
+		return nil, false, nil
+	}
+
+	value, ok := m.data[key]
+	if !ok && m.lazy != nil {
+		// the value is decoded on demand (see WithLazyLoad)
+		var err error
+		value, ok, err = m.lazy.read(key)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	if ok, err := m.verifyValue(key, value); !ok {
+		return nil, false, err
+	}
+
+	m.touch(key)
+	value, err := m.openValue(value)
+	return value, err == nil, err
+}
+
+// Delete removes any value that might have been assigned to the key earlier.
+// The boolean return value indicates if the memory contained the key. If it did
+// not contain the key the function does nothing and returns without an error.
+// If the key existed it is removed and the corresponding JSON file is updated.
+//
+// An error is returned if this function is called after the memory was closed
+// already or if the file could not be written or updated.
+func (m *Storage) Delete(key string) (bool, error) {
+	return m.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is like Delete but accepts a context. If tracing is enabled via
+// WithTracerProvider(…), the spans of this operation become children of the
+// span in the given context.
+//
+// Just like with SetContext(…), a canceled context only stops waiting for the
+// memory file to be written. The key is still removed from memory.
+func (m *Storage) DeleteContext(ctx context.Context, key string) (_ bool, err error) {
+	key = m.normalizeKey(key)
+
+	ctx, span := m.startSpan(ctx, "Delete")
+	start := time.Now()
+	defer func() {
+		m.observe(func(metrics Metrics) { metrics.ObserveDelete(time.Since(start), err) })
+		endSpan(span, err)
+	}()
+
+	if err = m.validateKey(key); err != nil {
+		return false, err
+	}
+
+	return withContext(ctx, func() (bool, error) {
+		prev, err := m.delete(ctx, key)
+		return prev.exists, err
+	})
+}
+
+// delete removes the key and persists the memory if it existed. It returns the
+// state of the key before it was removed.
+func (m *Storage) delete(ctx context.Context, key string) (entry, error) {
+	if err := m.lock(); err != nil {
+		return entry{}, err
+	}
+	defer m.unlock()
+
+	atomic.AddUint64(&m.numDeletes, 1)
+	prev := m.entry(key)
+	if !prev.exists {
+		return prev, nil
+	}
+
+	m.remove(key)
+
+	err := m.commit(ctx)
+	if isRejected(err) {
+		// the file was not written so we revert the change to stay consistent
+		m.restore(key, prev)
+	}
+
+	if err == nil {
+		m.changed(key, WatchEvent{Deleted: true})
+	}
+
+	return prev, err
+}
+
+// Keys returns a list of all keys known to this memory. The keys are always
+// sorted, so the result is stable across calls and processes. The keys of the
+// fallback memory are only included if this was enabled via WithFallback(…).
+// If the keys are hashed via WithKeyHashing(…), the hashed keys are returned.
+// An error is only returned if this function is called after the memory was
+// closed already or if the memory file could not be loaded in the background
+// (see WithBackgroundLoad).
+func (m *Storage) Keys() ([]string, error) {
+	if err := m.awaitLoad(); err != nil {
+		return nil, err
+	}
+
+	// the fallback is read without holding our lock (see WithFallback)
+	fallback, err := m.fallbackKeyList()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.rlockIndex(); err != nil {
+		return nil, err
+	}
+	defer m.runlock()
+
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		keys = append(keys, k)
+	}
+
+	if m.lazy != nil {
+		// the keys of values that were not decoded yet (see WithLazyLoad)
+		for k := range m.lazy.values {
+			keys = append(keys, k)
+		}
+	}
+
+	keys = unionKeys(keys, fallback)
+
+	// provide a stable result
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Len returns the number of keys in the memory. Unlike Keys, it does not copy
+// any keys, so it is cheap enough for metrics and health checks. An error is
+// only returned if this function is called after the memory was closed already
+// or if the memory file could not be loaded in the background (see
+// WithBackgroundLoad).
+func (m *Storage) Len() (int, error) {
+	if err := m.awaitLoad(); err != nil {
+		return 0, err
+	}
+
+	if err := m.rlockIndex(); err != nil {
+		return 0, err
+	}
+	defer m.runlock()
+
+	n := len(m.data)
+	if m.lazy != nil {
+		// values that were not decoded yet (see WithLazyLoad)
+		n += len(m.lazy.values)
+	}
+
+	return n, nil
+}
+
+// ForEach calls fn for each key and value in the memory, in no particular
+// order. The iteration stops as soon as fn returns an error, which is then
+// returned by ForEach. Unlike copying all values, this does not allocate memory
+// for the entire data. The values must not be modified by fn.
+//
+// The memory is read locked during the iteration, so fn must not call any
+// method of the memory or it will deadlock.
+func (m *Storage) ForEach(fn func(key string, value []byte) error) error {
+	if err := m.awaitLoad(); err != nil {
+		return err
+	}
+
+	if err := m.rlock(); err != nil {
+		return err
+	}
+	defer m.runlock()
+
+	for key, value := range m.data {
+		if ok, err := m.verifyValue(key, value); !ok {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, err := m.openValue(value)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetPrefix returns all keys and values whose keys start with the given prefix.
+// If no key matches, an empty map is returned.
+//
+// An error is only returned if this function is called after the memory was
+// closed already, if the memory file could not be loaded in the background
+// (see WithBackgroundLoad) or if the keys are hashed (see WithKeyHashing).
+func (m *Storage) GetPrefix(prefix string) (map[string][]byte, error) {
+	if err := m.errKeysHashed("GetPrefix"); err != nil {
+		return nil, err
+	}
+
+	prefix = m.normalizePrefix(prefix)
+	result := map[string][]byte{}
+	err := m.ForEach(func(key string, value []byte) error {
+		if strings.HasPrefix(key, prefix) {
+			result[key] = value
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// DeletePrefix deletes all keys that start with the given prefix and persists
+// the memory once, instead of rewriting the memory file for each key. It
+// returns the number of deleted keys. If no key matches, the memory file is not
+// written. To avoid deleting the entire memory by accident, an empty prefix is
+// rejected. If the keys are hashed (see WithKeyHashing), ErrKeysHashed is
+// returned.
+func (m *Storage) DeletePrefix(prefix string) (int, error) {
+	if prefix == "" {
+		return 0, errors.New("prefix must not be empty")
+	}
+
+	if err := m.errKeysHashed("DeletePrefix"); err != nil {
+		return 0, err
+	}
+
+	prefix = m.normalizePrefix(prefix)
+
+	return m.deleteWhere(func(key string, _ []byte) (bool, error) {
+		return strings.HasPrefix(key, prefix), nil
+	})
+}
+
+// KeySizes returns the length in bytes of the value of each key without
+// copying the values themselves. This is a cheap way to see how the storage is
+// distributed across the keys.
+//
+// An error is only returned if this function is called after the memory was
+// closed already or if the memory file could not be loaded in the background
+// (see WithBackgroundLoad).
+func (m *Storage) KeySizes() (map[string]int, error) {
+	if err := m.awaitLoad(); err != nil {
+		return nil, err
+	}
+
+	if err := m.rlock(); err != nil {
+		return nil, err
+	}
+	defer m.runlock()
+
+	sizes := make(map[string]int, len(m.data))
+	for key, value := range m.data {
+		sizes[key] = m.sealedSize(value)
+	}
+
+	return sizes, nil
+}
+
+// ModifiedSinceLoad returns the sorted keys that were successfully changed via
+// Set or Delete since the memory was created. Changes that were loaded from the
+// memory file (e.g. via WithVersionFile) are not included. This can be used to
+// review or commit only what a bot changed during its current run.
+//
+// An error is only returned if this function is called after the memory was
+// closed already.
+func (m *Storage) ModifiedSinceLoad() ([]string, error) {
+	if err := m.rlock(); err != nil {
+		return nil, err
+	}
+	defer m.runlock()
+
+	keys := make([]string, 0, len(m.modified))
+	for k := range m.modified {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// markModified records that the given key was changed by this memory.
+func (m *Storage) markModified(key string) {
+	if m.modified == nil {
+		m.modified = map[string]struct{}{}
+	}
+
+	m.modified[key] = struct{}{}
+}
+
+// OpCounts returns how many times Set, Get and Delete have been called on this
+// memory while it was open. The counters are updated atomically and can be
+// read at any time, even after the memory was closed.
+func (m *Storage) OpCounts() (sets, gets, deletes uint64) {
+	sets = atomic.LoadUint64(&m.numSets)
+	gets = atomic.LoadUint64(&m.numGets)
+	deletes = atomic.LoadUint64(&m.numDeletes)
+	return sets, gets, deletes
+}
+
+// SnapshotTo writes all keys for which the filter returns true to the given
+// writer, using the same format as the memory file itself. If the filter is
+// nil, all keys are written. This can be used to export a subset of the memory
+// (e.g. excluding any secrets) without touching the memory file.
+func (m *Storage) SnapshotTo(w io.Writer, filter func(key string) bool) error {
+	if err := m.awaitLoad(); err != nil {
+		return err
+	}
+
+	if err := m.rlock(); err != nil {
+		return err
+	}
+	defer m.runlock()
+
+	data := make(map[string][]byte, len(m.data))
+	for key, value := range m.data {
+		if filter == nil || filter(key) {
+			data[key] = value
+		}
+	}
+
+	content, err := m.encode(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+	if err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// BenchmarkPersist measures how long it takes to encode the current state of
+// the memory as it would be persisted. The encoded data is written to
+// io.Discard so the memory file is not touched. Checks that require reading the
+// memory file (e.g. WithConflictDetection) are not included in the
+// measurement. This can be used to estimate how the cost of each write grows
+// with the size of the memory.
+func (m *Storage) BenchmarkPersist() (time.Duration, error) {
+	if err := m.awaitLoad(); err != nil {
+		return 0, err
+	}
+
+	if err := m.rlock(); err != nil {
+		return 0, err
+	}
+	defer m.runlock()
+
+	start := time.Now()
+	content, err := m.encode(m.data)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = io.Discard.Write(content)
+	return time.Since(start), err
+}
+
+// MarshaledSize returns the number of bytes that the memory file would have if
+// the current state of the memory was persisted now. The data is encoded using
+// the same codec, compression and encryption as the memory file, but nothing is
+// written and the memory file is not read. Unlike the size of the file on disk,
+// this includes changes that have not been flushed yet (see WithFlushInterval).
+// This can be used to warn before the memory exceeds a quota, e.g. the limit of
+// WithMaxSerializedSize(…). Note that values which are written to separate
+// files (see WithExternalValues) are included in the returned size.
+func (m *Storage) MarshaledSize() (int, error) {
+	if err := m.awaitLoad(); err != nil {
+		return 0, err
+	}
+
+	if err := m.rlock(); err != nil {
+		return 0, err
+	}
+	defer m.runlock()
+
+	content, err := m.marshal(m.data)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(content), nil
+}
+
+// Close removes all data from the memory and stops all background goroutines.
+// If the memory file does not match the data in memory, either because a flush
+// interval is configured (see WithFlushInterval) or because an earlier write
+// has failed, the data is persisted first and any error of this final write is
+// returned. Note that all calls to the memory will fail after this function has
+// been called.
+//
+// Close is idempotent and safe for concurrent use. Only the first call closes
+// the memory, while all other calls wait until the memory is closed and then
+// return nil. A shared instance (see WithSharedInstance) is only closed once
+// each of its users has called Close, so each user must call it exactly once.
+func (m *Storage) Close() error {
+	if !m.release() {
+		// another user of the shared instance still needs it
+		return nil
+	}
+
+	var err error
+	m.closeOnce.Do(func() {
+		err = m.close()
+	})
+
+	return err
+}
+
+// close implements Close. It must only be called once.
+func (m *Storage) close() error {
+	// writes that released the lock must finish before we write the last time
+	m.mu.Lock()
+	m.keepLocked = true
+	m.mu.Unlock()
+	m.unlocked.Wait()
+
+	m.mu.Lock()
+
+	// write any changes that have not been flushed yet
+	err := m.flush()
+
+	m.data = nil
+	m.dedupValues = nil
+	m.dedupKeys = nil
+	m.closeLazy()
+	m.closeWatchers()
+	m.mu.Unlock()
+
+	close(m.stop)
+	m.wg.Wait()
+
+	// the lock is released last so no other process can write in the meantime
+	m.releaseFileLock()
+	return err
+}
+
+// newDocument returns the document that describes the given data including any
+// metadata this memory has about the keys.
+func (m *Storage) newDocument(data map[string][]byte) *document {
+	doc := &document{
+		Version:      formatVersion,
+		SealedValues: m.sealed,
+		Checksum:     dataChecksum(data),
+		RawStrings:   m.rawStrings,
+		Checksums:    m.checksumsOf(data),
+		Data:         data,
+	}
+
+	if len(m.persistRefs) > 0 {
+		doc.External = m.persistRefs
+		doc.Data = make(map[string][]byte, len(data)-len(m.persistRefs))
+		for key, value := range data {
+			if _, ok := m.persistRefs[key]; !ok {
+				doc.Data[key] = value
+			}
+		}
+		doc.Checksum = dataChecksum(withReferences(doc.Data, doc.External))
+	}
+
+	if m.timestampHeader {
+		now := m.now().UTC()
+		doc.WrittenAt = &now
+	}
+
+	if m.metadataHeader {
+		doc.Metadata = newMetadata(data, m.now())
+	}
+
+	if m.deltaNumeric {
+		if packed, ok := packDeltas(data); ok {
+			doc.Delta = packed
+			doc.Data = map[string][]byte{}
+		}
+	}
+
+	if m.dedup && doc.Delta == nil {
+		doc.Dedup, doc.Data = m.packValues(doc.Data)
+	}
+
+	for key, version := range m.versions {
+		if _, ok := data[key]; !ok {
+			continue
+		}
+		if doc.Versions == nil {
+			doc.Versions = map[string]uint64{}
+		}
+		doc.Versions[key] = version
+	}
+
+	for key, t := range m.expires {
+		if _, ok := data[key]; !ok {
+			continue
+		}
+		if doc.Expires == nil {
+			doc.Expires = map[string]time.Time{}
+		}
+		doc.Expires[key] = t
+	}
+
+	for key, contentType := range m.types {
+		if _, ok := data[key]; !ok {
+			continue
+		}
+		if doc.ContentTypes == nil {
+			doc.ContentTypes = map[string]string{}
+		}
+		doc.ContentTypes[key] = contentType
+	}
+
+	return doc
+}
+
+// loadFile decodes the JSON encoded memory file at the given path. If the file
+// does not exist, loadFile returns a nil map and no error. If the loaded file is
+// the memory file itself, the checksum of its content is remembered so later
+// changes by other processes can be detected (see WithConflictDetection) and
+// the metadata of the file (e.g. key versions) is loaded into the memory.
+func (m *Storage) loadFile(path string) (map[string][]byte, error) {
+	var f *fileContent
+	var err error
+	if path == m.path {
+		f, err = m.readMemoryFile()
+	} else {
+		f, err = m.readFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if f != nil {
+		if err := m.checkLoadedKeys(path, f.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if path == m.path {
+		m.useFile(f)
+	}
+
+	if f == nil {
+		return nil, nil
+	}
+
+	return f.data, nil
+}
+
+// fileContent is the decoded content of a memory file.
+type fileContent struct {
+	data      map[string][]byte
+	versions  map[string]uint64
+	expires   map[string]time.Time
+	types     map[string]string // content types (see SetTyped)
+	external  map[string]string // side files of external values by key
+	checksums map[string]uint32 // checksums of the values (see WithValueChecksums)
+	checksum  [sha256.Size]byte
+	seeded    bool // read from the seed file because the memory file is missing
+}
+
+// readFile decodes the JSON encoded memory file at the given path without
+// changing the memory itself. If the file does not exist, readFile returns nil
+// and no error.
+func (m *Storage) readFile(path string) (_ *fileContent, err error) {
+	_, span := m.startSpan(context.Background(), "load", attribute.String("path", path))
+	defer func() { endSpan(span, err) }()
+
+	m.logger.Debug("Opening memory file", zap.String("path", path))
+	var f io.ReadCloser
+	switch {
+	case path == m.path && m.store == nil:
+		m.logger.Debug("Memory has no path. Continuing with empty memory")
+		return nil, nil
+	case path == m.path:
+		f, err = m.store.Load()
+	default:
+		f, err = m.fsys.Open(path)
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		m.logger.Debug("File does not exist. Continuing with empty memory", zap.String("path", path))
+		return nil, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+
+	defer f.Close()
+
+	return m.decodeFile(m.withLoadProgress(f, path), path, span)
+}
+
+// decodeFile decodes the content of a memory file that is read from r. The
+// path is only used for error messages.
+func (m *Storage) decodeFile(f io.Reader, path string, span trace.Span) (_ *fileContent, err error) {
+	hash := sha256.New()
+	diag := &diagnosticReader{r: f}
+	counter := &countingReader{r: diag}
+	var r io.Reader = io.TeeReader(counter, hash)
+
+	if m.aead != nil {
+		r, err = m.decryptReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %q: %w", path, err)
+		}
+	}
+
+	if m.compress || m.codec == nil {
+		// a custom codec might produce data that looks like a gzip header
+		r, err = maybeDecompress(r)
+		if err != nil {
+			return nil, m.decodeError(path, diag, counter, err)
+		}
+	}
+
+	var doc *document
+	if m.codec != nil {
+		m.logger.Debug("Decoding memory file with custom codec", zap.String("path", path))
+		doc, err = m.decodeWithCodec(r)
+		if err != nil {
+			return nil, m.decodeError(path, diag, counter, err)
+		}
+	} else {
+		m.logger.Debug("Decoding JSON from memory file", zap.String("path", path))
+		doc, err = decodeDocumentWith(r, nil, m.strictDecoding)
+		if errors.Is(err, ErrUnsupportedFormatVersion) {
+			return nil, fmt.Errorf("failed to load %q: %w", path, err)
+		}
+		if err != nil {
+			return nil, m.decodeError(path, diag, counter, fmt.Errorf("failed decode data as JSON: %w", err))
+		}
+		if doc.Checksum != "" && !m.skipChecksum && doc.Checksum != dataChecksum(withReferences(doc.Data, doc.External)) {
+			if !m.valueChecksums || doc.Checksums == nil {
+				return nil, corruptFileError{fmt.Errorf("failed to load %q: %w", path, ErrChecksumMismatch)}
+			}
+
+			// corrupt values are detected individually once they are read
+			m.logger.Warn("Memory file does not match its checksum, verifying each value when it is read",
+				zap.String("path", path),
+			)
+		}
+		if err := m.readExternalValues(doc); err != nil {
+			return nil, fmt.Errorf("failed to load %q: %w", path, err)
+		}
+	}
+
+	content := &fileContent{
+		data:      doc.Data,
+		versions:  doc.Versions,
+		expires:   doc.Expires,
+		types:     doc.ContentTypes,
+		external:  doc.External,
+		checksums: doc.Checksums,
+	}
+	if content.data == nil {
+		content.data = map[string][]byte{}
+	}
+
+	dropExpired(content.data, content.expires, m.now())
+
+	err = m.convertSealedValues(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %q: %w", path, err)
+	}
+
+	if err := m.normalizeFileKeys(path, content); err != nil {
+		return nil, err
+	}
+
+	// consume any trailing whitespace so the checksum covers the entire file
+	_, err = io.Copy(io.Discard, r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	copy(content.checksum[:], hash.Sum(nil))
+	span.SetAttributes(attribute.Int("num_keys", len(content.data)), attribute.Int64("bytes", counter.n))
+
+	return content, nil
+}
+
+// readMemoryFile reads the memory file itself. If the file is corrupt, the
+// CorruptFilePolicy is applied and if it does not exist, the memory is seeded
+// from the file system of WithSeedFS, if any. If a load path is configured (see
+// WithReadWriteSplit), the file at the load path is read instead, depending on
+// which of both files takes precedence.
+func (m *Storage) readMemoryFile() (*fileContent, error) {
+	if m.loadPath != "" && m.preferLoad {
+		f, err := m.readLoadFile()
+		if f != nil || err != nil {
+			return f, err
+		}
+	}
+
+	f, err := m.readFile(m.path)
+	if err != nil {
+		return m.recoverCorruptFile(err)
+	}
+
+	if f == nil && m.loadPath != "" && !m.preferLoad {
+		return m.readLoadFile()
+	}
+
+	if f == nil && m.seedFS != nil {
+		return m.readSeedFile()
+	}
+
+	return f, nil
+}
+
+// useFile remembers the metadata of the given content of the memory file. A
+// nil content means the memory file does not exist.
+func (m *Storage) useFile(f *fileContent) {
+	m.created = f == nil || f.seeded
+	if f == nil {
+		m.diskChecksum = [sha256.Size]byte{}
+		m.versions = nil
+		m.expires = nil
+		m.types = nil
+		m.loadedChecksums = nil
+	} else {
+		m.diskChecksum = f.checksum
+		m.versions = f.versions
+		m.expires = f.expires
+		m.types = f.types
+		m.loadedChecksums = f.checksums
+	}
+
+	if m.externalThreshold > 0 {
+		m.useExternalValues(f)
+	}
+}
+
+// encode returns the content of the memory file for the given data as it would
+// be written to disk.
+func (m *Storage) encode(data map[string][]byte) ([]byte, error) {
+	if m.codec != nil {
+		content, err := m.codec.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode data: %w", err)
+		}
+		return content, nil
+	}
+
+	doc := m.newDocument(data)
+	var v interface{} = doc
+	if m.rawStrings {
+		v = newRawStringsDocument(doc, m.rawHTML)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(!m.rawHTML)
+	if m.indent != nil {
+		enc.SetIndent(m.indent.prefix, m.indent.indent)
+	}
+
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode data as JSON: %w", err)
+	}
+
+	content := buf.Bytes()
+	if m.lineEnding == CRLF {
+		// JSON strings never contain raw newlines so we can safely replace
+		// all of them without changing any of the values.
+		content = bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+	}
+
+	return content, nil
+}
+
+// persist writes the data to the memory file (or shards or append log). The
+// caller must hold the write lock.
+func (m *Storage) persist(ctx context.Context) error {
+	return m.persistData(ctx, false)
+}
+
+// persistData implements persist. If unlock is true, the write lock is
+// released while the memory file is written (see canUnlockPersist).
+func (m *Storage) persistData(ctx context.Context, unlock bool) (err error) {
+	if m.readOnly {
+		return ErrReadOnly
+	}
+
+	if m.store == nil {
+		// the memory has no path, so its values are only kept in memory
+		return nil
+	}
+
+	_, span := m.startSpan(ctx, "persist",
+		attribute.String("path", m.path),
+		attribute.Int("num_keys", len(m.data)),
+	)
+
+	start := time.Now()
+	var n int
+	defer func() {
+		m.observe(func(metrics Metrics) { metrics.ObservePersist(n, time.Since(start), err) })
+		endSpan(span, err)
+	}()
+
+	switch {
+	case m.shards > 0:
+		n, err = m.persistShards(span)
+	case m.logRatio > 0:
+		n, err = m.persistLog(span)
+	default:
+		n, err = m.persistFile(span, unlock)
+		if err != nil && m.switchToWritableFallback(err) {
+			n, err = m.persistFile(span, unlock)
+		}
+	}
+
+	if err != nil && !isRejected(err) {
+		err = &PersistError{Path: m.path, Err: err}
+	}
+
+	if !isRejected(err) {
+		m.persistErr = err
+	}
+
+	return err
+}
+
+// persistFile writes all data to the memory file and returns the number of
+// written bytes. The caller must hold the write lock. If unlock is true, the
+// lock is released while the file is written (see writeInOrder).
+func (m *Storage) persistFile(span trace.Span, unlock bool) (int, error) {
+	var refs map[string]string
+	if m.externalThreshold > 0 {
+		refs = m.externalRefs()
+		m.persistRefs = refs
+		defer func() { m.persistRefs = nil }()
+	}
+
+	content, err := m.serialize(m.data)
+	if err != nil {
+		return 0, err
+	}
+
+	span.SetAttributes(attribute.Int("bytes", len(content)))
+
+	if m.versionFile {
+		err = m.checkVersion()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if m.onConflict != nil {
+		err = m.detectConflict()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if refs != nil {
+		err = m.writeExternalValues(refs)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = m.writeInOrder(content, unlock)
+	if err != nil {
+		return 0, err
+	}
+
+	if refs != nil {
+		m.removeExternalValues(refs)
+	}
+
+	if m.keyIndexPath != "" {
+		m.writeKeyIndex()
+	}
+
+	if m.versionFile {
+		err = m.bumpVersion()
+		if err != nil {
+			return len(content), err
+		}
+	}
+
+	if m.mirrorPath != "" {
+		return len(content), m.writeMirror(content)
+	}
+
+	return len(content), nil
+}
+
+// serialize returns the content of a memory file for the given data as it is
+// written to disk, i.e. encoded, compressed and encrypted. An error that wraps
+// ErrMaxSerializedSize is returned if the content exceeds the size limit.
+func (m *Storage) serialize(data map[string][]byte) ([]byte, error) {
+	content, err := m.marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.maxSerializedSize > 0 && int64(len(content)) > m.maxSerializedSize {
+		return nil, fmt.Errorf("%w: encoded data has %d bytes but only %d bytes are allowed",
+			ErrMaxSerializedSize, len(content), m.maxSerializedSize,
+		)
+	}
+
+	return content, nil
+}
+
+// marshal returns the encoded, compressed and encrypted content of a memory
+// file for the given data without checking its size.
+func (m *Storage) marshal(data map[string][]byte) ([]byte, error) {
+	content, err := m.encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.compress {
+		content, err = compress(content, m.gzLevel)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if m.aead != nil {
+		content, err = m.encrypt(content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return content, nil
+}
+
+// writeFile atomically replaces the file at the given path with the given
+// content. The content is written to a temporary file next to the file which is
+// then renamed to the actual path. Since a rename is atomic on most file
+// systems, a crash leaves either the old or the new file but never a truncated
+// one. If the temporary file is on another file system (see WithTempDir), it is
+// copied instead.
+func (m *Storage) writeFile(path string, content []byte) error {
+	tmpPath := m.tmpPath(path)
+	for _, p := range []string{path, tmpPath} {
+		if err := m.checkNoSymlink(p); err != nil {
+			return err
+		}
+	}
+
+	f, err := m.fsys.OpenFile(tmpPath, m.openFlags, m.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open file to persist data: %w", err)
+	}
+
+	_, err = f.Write(content)
+	if err != nil {
+		_ = f.Close()
+		_ = m.fsys.Remove(tmpPath)
+		return fmt.Errorf("failed to write data to file: %w", err)
+	}
+
+	if m.syncWrites {
+		err = f.Sync()
+		if err != nil {
+			_ = f.Close()
+			_ = m.fsys.Remove(tmpPath)
+			return fmt.Errorf("failed to sync file to disk: %w", err)
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		_ = m.fsys.Remove(tmpPath)
+		return fmt.Errorf("failed to close file; data might not have been fully persisted to disk: %w", err)
+	}
+
+	err = m.fsys.Rename(tmpPath, path)
+	if isCrossDevice(err) {
+		m.logger.Debug("Copying temporary file to another file system", zap.String("path", path))
+		err = m.copyTempFile(tmpPath, path)
+	}
+	if err != nil {
+		_ = m.fsys.Remove(tmpPath)
+		return fmt.Errorf("failed to replace memory file: %w", err)
+	}
+
+	if m.syncWrites {
+		return m.syncDir(filepath.Dir(path))
 	}
 
 	return nil
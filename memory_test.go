@@ -1,19 +1,26 @@
 package file
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-joe/joe"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // noinspection GoUnhandledErrorResult
 func withTempFile(t *testing.T, fun func(mem joe.Memory)) {
-	tempFile := path.Join(os.TempDir(), fmt.Sprintf("test_%d_%d", os.Getpid(), time.Now().UnixNano()))
+	tempFile := tempFilePath()
 	defer os.Remove(tempFile)
 
 	mem, err := NewMemory(tempFile)
@@ -80,14 +87,14 @@ func TestMemory_Delete_NoneAffected(t *testing.T) {
 
 func TestMemory_Keys(t *testing.T) {
 	withTempFile(t, func(mem joe.Memory) {
-		keys := []string{"foo1", "foo2", "foo3"}
-		for _, k := range keys {
+		for _, k := range []string{"foo2", "foo3", "foo1"} {
 			require.NoError(t, mem.Set(k, []byte(k+" value")))
 		}
 
+		// the keys are sorted regardless of the insertion order
 		foundKeys, err := mem.Keys()
 		require.NoError(t, err)
-		require.EqualValues(t, keys, foundKeys)
+		require.EqualValues(t, []string{"foo1", "foo2", "foo3"}, foundKeys)
 
 		for _, k := range foundKeys {
 			v, ok, err := mem.Get(k)
@@ -97,3 +104,821 @@ func TestMemory_Keys(t *testing.T) {
 		}
 	})
 }
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Len(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	n, err := mem.Len()
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	require.NoError(t, mem.Set("foo", []byte("updated")))
+	n, err = mem.Len()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+	_, err = mem.Delete("missing")
+	require.NoError(t, err)
+	n, err = mem.Len()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	require.NoError(t, mem.Close())
+	_, err = mem.Len()
+	require.ErrorIs(t, err, ErrClosed)
+
+	// values of a lazily loaded memory are counted before they are decoded
+	mem, err = NewMemory(tempFile, WithLazyLoad())
+	require.NoError(t, err)
+	defer mem.Close()
+	n, err = mem.Len()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
+
+// TestMemory_ConcurrentAccess uses the memory from multiple goroutines without
+// any external synchronization. Run it with the race detector enabled.
+// noinspection GoUnhandledErrorResult
+func TestMemory_ConcurrentAccess(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key-%d", i%3)
+			for j := 0; j < 20; j++ {
+				require.NoError(t, mem.Set(key, []byte(fmt.Sprint(j))))
+				_, _, err := mem.Get(key)
+				require.NoError(t, err)
+				_, err = mem.Keys()
+				require.NoError(t, err)
+				_, err = mem.Delete(key)
+				require.NoError(t, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, mem.Close())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_OpCounts(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("foo", []byte("baz")))
+	_, _, err = mem.Get("foo")
+	require.NoError(t, err)
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	sets, gets, deletes := mem.OpCounts()
+	require.EqualValues(t, 2, sets)
+	require.EqualValues(t, 1, gets)
+	require.EqualValues(t, 2, deletes)
+}
+
+func TestNewMemory_Directory(t *testing.T) {
+	_, err := NewMemory(os.TempDir())
+	require.EqualError(t, err, fmt.Sprintf("memory path %q is a directory, expected a file", os.TempDir()))
+}
+
+func TestNewMemoryFromPaths(t *testing.T) {
+	dir := t.TempDir()
+	missing := path.Join(dir, "missing.json")
+	corrupt := path.Join(dir, "corrupt.json")
+	valid := path.Join(dir, "valid.json")
+	fallback := path.Join(dir, "fallback.json")
+
+	require.NoError(t, os.WriteFile(corrupt, []byte("{"), 0660))
+	writeMemoryFile(t, valid, map[string][]byte{"foo": []byte("bar")})
+	writeMemoryFile(t, fallback, map[string][]byte{"foo": []byte("fallback")})
+
+	core, logs := observer.New(zap.InfoLevel)
+	mem, err := NewMemoryFromPaths([]string{missing, corrupt, valid, fallback}, WithLogger(zap.New(core)))
+	require.NoError(t, err)
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.Equal(t, []byte("bar"), value)
+	require.Equal(t, 1, logs.FilterMessage("Skipping memory file that cannot be loaded").Len())
+	require.Equal(t, 1, logs.FilterMessage("Using memory file").FilterField(zap.String("path", valid)).Len())
+
+	// changes are written to the chosen file
+	require.NoError(t, mem.Set("foo", []byte("new")))
+	require.NoError(t, mem.Close())
+	require.NoFileExists(t, missing)
+
+	mem, err = NewMemory(valid)
+	require.NoError(t, err)
+	value, _, err = mem.Get("foo")
+	require.NoError(t, err)
+	require.Equal(t, []byte("new"), value)
+	require.NoError(t, mem.Close())
+}
+
+func TestNewMemoryFromPaths_NoneExists(t *testing.T) {
+	dir := t.TempDir()
+	first := path.Join(dir, "first.json")
+
+	mem, err := NewMemoryFromPaths([]string{first, path.Join(dir, "second.json")})
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+	require.FileExists(t, first)
+
+	corrupt := path.Join(dir, "corrupt.json")
+	require.NoError(t, os.WriteFile(corrupt, []byte(`{"foo":`), 0660))
+	_, err = NewMemoryFromPaths([]string{corrupt, path.Join(dir, "missing.json")})
+	require.EqualError(t, err, "failed decode data as JSON: unexpected EOF")
+
+	_, err = NewMemoryFromPaths(nil)
+	require.EqualError(t, err, "at least one path is required")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestNewMemoryFromFiles(t *testing.T) {
+	primary := tempFilePath()
+	extra1 := tempFilePath()
+	extra2 := tempFilePath()
+	defer os.Remove(primary)
+	defer os.Remove(extra1)
+	defer os.Remove(extra2)
+
+	writeMemoryFile(t, primary, map[string][]byte{"a": []byte("primary"), "b": []byte("primary")})
+	writeMemoryFile(t, extra1, map[string][]byte{"b": []byte("extra1"), "c": []byte("extra1")})
+	writeMemoryFile(t, extra2, map[string][]byte{"c": []byte("extra2"), "d": []byte("extra2")})
+
+	cases := map[MergePolicy]map[string]string{
+		PrimaryWins: {"a": "primary", "b": "primary", "c": "extra1", "d": "extra2"},
+		LastWins:    {"a": "primary", "b": "extra1", "c": "extra2", "d": "extra2"},
+	}
+
+	for policy, expected := range cases {
+		mem, err := NewMemoryFromFiles(primary, []string{extra1, extra2, "/does/not/exist"}, WithMergePolicy(policy))
+		require.NoError(t, err)
+
+		for key, value := range expected {
+			actual, ok, err := mem.Get(key)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, value, string(actual), "policy %d key %q", policy, key)
+		}
+
+		require.NoError(t, mem.Close())
+	}
+
+	// writes only go to the primary file
+	mem, err := NewMemoryFromFiles(primary, []string{extra1})
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("e", []byte("new")))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(extra1)
+	require.NoError(t, err)
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "c"}, keys)
+
+	mem, err = NewMemory(primary)
+	require.NoError(t, err)
+	keys, err = mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b", "c", "e"}, keys)
+}
+
+func TestWithMergePolicy_Invalid(t *testing.T) {
+	_, err := NewMemoryFromFiles(tempFilePath(), nil, WithMergePolicy(42))
+	require.EqualError(t, err, "invalid merge policy 42")
+}
+
+var tempFileCounter int
+
+func tempFilePath() string {
+	tempFileCounter++
+	return path.Join(os.TempDir(), fmt.Sprintf("test_%d_%d_%d", os.Getpid(), time.Now().UnixNano(), tempFileCounter))
+}
+
+func writeMemoryFile(t *testing.T, path string, data map[string][]byte) {
+	mem, err := NewMemory(path)
+	require.NoError(t, err)
+	for key, value := range data {
+		require.NoError(t, mem.Set(key, value))
+	}
+	require.NoError(t, mem.Close())
+}
+
+// eventually fails the test if the condition does not become true within one
+// second.
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition was not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMaxSerializedSize(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	// {"version":2,"checksum":"…","data":{"foo":"YmFy"}}\n has 114 bytes
+	mem, err := NewMemory(tempFile, WithMaxSerializedSize(114))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	err = mem.Set("foo", []byte("bar baz"))
+	require.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+
+	err = mem.Set("other", []byte("x"))
+	require.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+
+	// the in-memory changes have been reverted
+	val, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(val))
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, keys)
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, `{"version":2,"checksum":"a3cab5fa8b0c31746e3bd912ca859bce37777fd8d29b991e946c3baba9fbdd49","data":{"foo":"YmFy"}}`+"\n", string(content))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCheckWritable(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithCheckWritable())
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	// the check must not leave an empty file behind
+	_, err = os.Stat(tempFile)
+	require.True(t, os.IsNotExist(err))
+
+	_, err = NewMemory(path.Join(tempFile, "does-not-exist", "memory.json"), WithCheckWritable())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "memory file is not writable")
+}
+
+func TestMemory_SnapshotTo(t *testing.T) {
+	withTempFile(t, func(mem joe.Memory) {
+		require.NoError(t, mem.Set("public", []byte("foo")))
+		require.NoError(t, mem.Set("secret:token", []byte("bar")))
+
+		var buf bytes.Buffer
+		err := mem.(*Storage).SnapshotTo(&buf, func(key string) bool {
+			return !strings.HasPrefix(key, "secret:")
+		})
+		require.NoError(t, err)
+		require.Equal(t, `{"version":2,"checksum":"a16f5dcfe8156cf7de2b3fb1199b6b0bea1aaf105e69e158809c81b43028bb46","data":{"public":"Zm9v"}}`+"\n", buf.String())
+
+		buf.Reset()
+		err = mem.(*Storage).SnapshotTo(&buf, nil)
+		require.NoError(t, err)
+		require.Equal(t, `{"version":2,"checksum":"040ed0bdab8e0722c7e9f1da0ee162e876514ab5ae501adea8772d9bd2a38896","data":{"public":"Zm9v","secret:token":"YmFy"}}`+"\n", buf.String())
+	})
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_ModifiedSinceLoad(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	writeMemoryFile(t, tempFile, map[string][]byte{
+		"unchanged": []byte("foo"),
+		"deleted":   []byte("bar"),
+	})
+
+	mem, err := NewMemory(tempFile, WithMaxSerializedSize(178))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.ModifiedSinceLoad()
+	require.NoError(t, err)
+	require.Empty(t, keys)
+
+	require.NoError(t, mem.Set("new", []byte("baz")))
+	_, err = mem.Delete("deleted")
+	require.NoError(t, err)
+	_, err = mem.Delete("does-not-exist")
+	require.NoError(t, err)
+
+	// rejected changes are not recorded
+	err = mem.Set("rejected", make([]byte, 100))
+	require.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+
+	keys, err = mem.ModifiedSinceLoad()
+	require.NoError(t, err)
+	require.Equal(t, []string{"deleted", "new"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithValueInspector(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	core, logs := observer.New(zap.WarnLevel)
+	inspector := func(key string, value []byte) string {
+		if strings.Contains(string(value), "goroutine ") {
+			return "value looks like a stack trace"
+		}
+		return ""
+	}
+
+	mem, err := NewMemory(tempFile, WithLogger(zap.New(core)), WithValueInspector(inspector))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("ok", []byte("foo")))
+	require.Equal(t, 0, logs.Len())
+
+	require.NoError(t, mem.Set("trace", []byte("goroutine 1 [running]:")))
+	entries := logs.TakeAll()
+	require.Len(t, entries, 1)
+	require.Equal(t, "trace", entries[0].ContextMap()["key"])
+	require.Equal(t, "value looks like a stack trace", entries[0].ContextMap()["warning"])
+
+	// the value is written anyway
+	val, ok, err := mem.Get("trace")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "goroutine 1 [running]:", string(val))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_BenchmarkPersist(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	d, err := mem.BenchmarkPersist()
+	require.NoError(t, err)
+	require.True(t, d >= 0)
+
+	// the memory file is not touched
+	_, err = os.Stat(tempFile)
+	require.True(t, os.IsNotExist(err))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_MarshaledSize(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithCompression(), WithFlushInterval(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	size, err := mem.MarshaledSize()
+	require.NoError(t, err)
+
+	// the memory file is not touched
+	_, err = os.Stat(tempFile)
+	require.True(t, os.IsNotExist(err))
+
+	require.NoError(t, mem.Close())
+	info, err := os.Stat(tempFile)
+	require.NoError(t, err)
+	require.EqualValues(t, info.Size(), size)
+
+	_, err = mem.MarshaledSize()
+	require.ErrorIs(t, err, ErrClosed)
+}
+
+func TestNewMemory_EmptyPath(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	mem, err := NewMemory("")
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []byte("bar"), value)
+
+	ok, err = mem.Delete("baz")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"foo"}, keys)
+	require.NoError(t, mem.Close())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries, "no file must be written")
+}
+
+func TestMemory_KeySizes(t *testing.T) {
+	withTempFile(t, func(mem joe.Memory) {
+		require.NoError(t, mem.Set("foo", []byte("bar")))
+		require.NoError(t, mem.Set("empty", nil))
+
+		sizes, err := mem.(*Storage).KeySizes()
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{"foo": 3, "empty": 0}, sizes)
+	})
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_AtomicWrite(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	_, err = os.Stat(tempFile + ".tmp")
+	require.True(t, os.IsNotExist(err), "temporary file must not be left behind")
+
+	// the memory file cannot be replaced by the temporary file if it is a
+	// directory but the temporary file must still be cleaned up
+	require.NoError(t, os.Remove(tempFile))
+	require.NoError(t, os.Mkdir(tempFile, 0700))
+
+	err = mem.Set("foo", []byte("baz"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to replace memory file")
+
+	_, err = os.Stat(tempFile + ".tmp")
+	require.True(t, os.IsNotExist(err), "temporary file must be removed on error")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithFileMode(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithFileMode(0600))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	info, err := os.Stat(tempFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_CloseFlush(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	// make the next write fail so the memory file is out of date
+	require.NoError(t, os.Remove(tempFile))
+	require.NoError(t, os.Mkdir(tempFile, 0700))
+	require.Error(t, mem.Set("foo", []byte("baz")))
+	require.NoError(t, os.Remove(tempFile))
+
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	val, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "baz", string(val))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithCreateDirs(t *testing.T) {
+	dir := tempFilePath()
+	defer os.RemoveAll(dir)
+
+	tempFile := path.Join(dir, "state", "memory.json")
+	mem, err := NewMemory(tempFile, WithCreateDirs())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	_, err = os.Stat(tempFile)
+	require.NoError(t, err)
+
+	// a file that blocks the directory cannot be replaced
+	_, err = NewMemory(path.Join(tempFile, "memory.json"), WithCreateDirs())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to create directory of memory file")
+}
+
+func TestMemory_ForEach(t *testing.T) {
+	withTempFile(t, func(mem joe.Memory) {
+		require.NoError(t, mem.Set("foo", []byte("1")))
+		require.NoError(t, mem.Set("bar", []byte("2")))
+
+		seen := map[string]string{}
+		err := mem.(*Storage).ForEach(func(key string, value []byte) error {
+			seen[key] = string(value)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"foo": "1", "bar": "2"}, seen)
+
+		stop := errors.New("stop")
+		calls := 0
+		err = mem.(*Storage).ForEach(func(key string, value []byte) error {
+			calls++
+			return stop
+		})
+		require.Equal(t, stop, err)
+		require.Equal(t, 1, calls)
+	})
+}
+
+// noinspection GoUnhandledErrorResult
+// noinspection GoUnhandledErrorResult
+func TestMemory_GetDefault(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("empty", nil))
+
+	value, err := mem.GetDefault("foo", "default")
+	require.NoError(t, err)
+	require.Equal(t, "bar", value)
+
+	value, err = mem.GetDefault("empty", "default")
+	require.NoError(t, err)
+	require.Equal(t, "", value)
+
+	value, err = mem.GetDefault("missing", "default")
+	require.NoError(t, err)
+	require.Equal(t, "default", value)
+
+	require.NoError(t, mem.Close())
+	value, err = mem.GetDefault("missing", "default")
+	require.ErrorIs(t, err, ErrClosed)
+	require.Equal(t, "", value)
+}
+
+func TestMemory_GetPrefix(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("user:123:score", []byte("1")))
+	require.NoError(t, mem.Set("user:123:name", []byte("Alice")))
+	require.NoError(t, mem.Set("user:1234:score", []byte("2")))
+	require.NoError(t, mem.Set("config", []byte("x")))
+
+	values, err := mem.GetPrefix("user:123:")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{
+		"user:123:score": []byte("1"),
+		"user:123:name":  []byte("Alice"),
+	}, values)
+
+	values, err = mem.GetPrefix("nothing")
+	require.NoError(t, err)
+	require.NotNil(t, values)
+	require.Empty(t, values)
+
+	require.NoError(t, mem.Close())
+	_, err = mem.GetPrefix("user:")
+	require.EqualError(t, err, "brain was already shut down")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_DeletePrefix(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.SetMany(map[string][]byte{
+		"user:123:score":  []byte("1"),
+		"user:123:name":   []byte("Alice"),
+		"user:1234:score": []byte("2"),
+		"config":          []byte("x"),
+	}))
+
+	n, err := mem.DeletePrefix("user:123:")
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	n, err = mem.DeletePrefix("nothing")
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	_, err = mem.DeletePrefix("")
+	require.EqualError(t, err, "prefix must not be empty")
+
+	reloaded, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	keys, err := reloaded.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"config", "user:1234:score"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithSync(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithSync())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(value))
+	require.NoFileExists(t, tempFile+".tmp")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithOpenFlags(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithOpenFlags(os.O_WRONLY|os.O_CREATE|os.O_EXCL))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	// the temporary file must not exist yet because of os.O_EXCL
+	require.NoError(t, os.WriteFile(tempFile+".tmp", nil, 0644))
+	err = mem.Set("foo", []byte("bar"))
+	require.ErrorIs(t, err, os.ErrExist)
+
+	require.NoError(t, os.Remove(tempFile+".tmp"))
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithName(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	core, logs := observer.New(zap.DebugLevel)
+	mem, err := NewMemory(tempFile, WithName("users"), WithLogger(zap.New(core)))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	require.NotZero(t, logs.Len())
+	for _, entry := range logs.All() {
+		require.Equal(t, "users", entry.ContextMap()["memory"], entry.Message)
+	}
+
+	_, err = NewMemory(tempFile, WithName(""))
+	require.EqualError(t, err, "name must not be empty")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetUnchanged(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("empty", []byte{}))
+
+	// move the modification time into the past so any write is detected
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(tempFile, past, past))
+
+	events, cancel := mem.Watch("foo")
+	defer cancel()
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("empty", []byte{}))
+
+	info, err := os.Stat(tempFile)
+	require.NoError(t, err)
+	require.True(t, info.ModTime().Equal(past), "file was written: %s", info.ModTime())
+	require.Len(t, events, 0)
+
+	// a nil value is encoded differently than an empty one
+	require.NoError(t, mem.Set("empty", nil))
+	info, err = os.Stat(tempFile)
+	require.NoError(t, err)
+	require.False(t, info.ModTime().Equal(past))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetUnchanged_ReusedBuffer(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	buf := []byte("foo")
+	require.NoError(t, mem.Set("test", buf))
+	copy(buf, "bar")
+	require.NoError(t, mem.Set("test", buf))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, _, err := mem.Get("test")
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func BenchmarkMemory_Set(b *testing.B) {
+	run := func(b *testing.B, value func(i int) []byte) {
+		tempFile := tempFilePath()
+		defer os.Remove(tempFile)
+
+		metrics := new(recordingMetrics)
+		mem, err := NewMemory(tempFile, WithMetrics(metrics))
+		require.NoError(b, err)
+		defer mem.Close()
+
+		for i := 0; i < 1000; i++ {
+			require.NoError(b, mem.Set(fmt.Sprintf("key-%d", i), []byte("value")))
+		}
+
+		metrics.bytes = 0
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := mem.Set("key-0", value(i)); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.ReportMetric(float64(metrics.bytes)/float64(b.N), "written_bytes/op")
+	}
+
+	b.Run("unchanged", func(b *testing.B) {
+		run(b, func(int) []byte { return []byte("value") })
+	})
+
+	b.Run("changed", func(b *testing.B) {
+		run(b, func(i int) []byte { return []byte(strconv.Itoa(i)) })
+	})
+}
@@ -0,0 +1,33 @@
+package file
+
+import "time"
+
+// Metrics receives measurements of the operations of a memory (see
+// WithMetrics). An implementation can forward the measurements to a metrics
+// system such as Prometheus. All methods are called synchronously, so they
+// should return quickly. They may be called concurrently.
+type Metrics interface {
+	// ObserveSet is called after each call of Set.
+	ObserveSet(dur time.Duration, err error)
+
+	// ObserveGet is called after each call of Get.
+	ObserveGet(dur time.Duration, err error)
+
+	// ObserveDelete is called after each call of Delete.
+	ObserveDelete(dur time.Duration, err error)
+
+	// ObservePersist is called after each write of the memory file with the
+	// number of bytes that were written.
+	ObservePersist(bytes int, dur time.Duration, err error)
+}
+
+// observe passes the configured metrics to the given function. A panic in the
+// metrics is recovered and logged so it does not affect the operation.
+func (m *Storage) observe(fun func(Metrics)) {
+	if m.metrics == nil {
+		return
+	}
+
+	defer func() { _ = m.recoverPanic("metrics", recover()) }()
+	fun(m.metrics)
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type recordingMetrics struct {
+	mu       sync.Mutex
+	ops      []string
+	bytes    int
+	failures int
+	panics   bool
+}
+
+func (r *recordingMetrics) record(op string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.panics {
+		panic("metrics are broken")
+	}
+
+	r.ops = append(r.ops, op)
+	if err != nil {
+		r.failures++
+	}
+}
+
+func (r *recordingMetrics) ObserveSet(_ time.Duration, err error)    { r.record("set", err) }
+func (r *recordingMetrics) ObserveGet(_ time.Duration, err error)    { r.record("get", err) }
+func (r *recordingMetrics) ObserveDelete(_ time.Duration, err error) { r.record("delete", err) }
+
+func (r *recordingMetrics) ObservePersist(bytes int, _ time.Duration, err error) {
+	r.record("persist", err)
+	r.mu.Lock()
+	r.bytes += bytes
+	r.mu.Unlock()
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMetrics(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	metrics := new(recordingMetrics)
+	mem, err := NewMemory(tempFile, WithMetrics(metrics), WithMaxSerializedSize(120))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	_, _, err = mem.Get("foo")
+	require.NoError(t, err)
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+
+	err = mem.Set("foo", make([]byte, 100))
+	assert.True(t, errors.Is(err, ErrMaxSerializedSize))
+
+	info, err := os.Stat(tempFile)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"persist", "set", "get", "persist", "delete", "persist", "set"}, metrics.ops)
+	assert.Equal(t, 2, metrics.failures)
+	assert.NotZero(t, metrics.bytes)
+	assert.Less(t, int(info.Size()), metrics.bytes)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMetrics_Panic(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	core, logs := observer.New(zap.ErrorLevel)
+	metrics := &recordingMetrics{panics: true}
+	mem, err := NewMemory(tempFile, WithMetrics(metrics), WithLogger(zap.New(core)))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	assert.NotZero(t, logs.Len())
+}
+
+func TestWithMetrics_Nil(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithMetrics(nil))
+	require.EqualError(t, err, "metrics must not be nil")
+}
@@ -0,0 +1,56 @@
+package file
+
+import (
+	"fmt"
+
+	"github.com/go-joe/joe"
+)
+
+// copyBatchSize is the number of keys that CopyFrom reads from the source
+// before it writes them to the memory.
+const copyBatchSize = 1000
+
+// CopyFrom copies all keys and values of the given memory into this memory and
+// returns the number of copied keys. This can be used to migrate from another
+// joe.Memory implementation (e.g. Redis) to a memory file. The keys are copied
+// in batches and the memory file is written once per batch (see SetMany),
+// so copying a large memory neither holds all values in memory nor rewrites
+// the memory file for every key. Existing keys of this memory are overwritten,
+// while keys that only exist in this memory are kept.
+//
+// Keys that are deleted from the source while they are copied are skipped. If
+// an error is returned, the batches that were written before are not reverted,
+// so the returned number reflects the keys that have been copied successfully.
+func (m *Storage) CopyFrom(src joe.Memory) (int, error) {
+	keys, err := src.Keys()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list keys of source memory: %w", err)
+	}
+
+	var n int
+	for start := 0; start < len(keys); start += copyBatchSize {
+		end := start + copyBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		changes := make([]Change, 0, end-start)
+		for _, key := range keys[start:end] {
+			value, ok, err := src.Get(key)
+			if err != nil {
+				return n, fmt.Errorf("failed to read key %q from source memory: %w", key, err)
+			}
+			if ok {
+				changes = append(changes, Change{Key: key, Value: value})
+			}
+		}
+
+		if err := m.ApplyChangeset(changes); err != nil {
+			return n, err
+		}
+
+		n += len(changes)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,57 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_CopyFrom(t *testing.T) {
+	src, err := NewMemory("")
+	require.NoError(t, err)
+	defer src.Close()
+
+	values := map[string][]byte{}
+	for i := 0; i < copyBatchSize+10; i++ {
+		values[fmt.Sprintf("key-%d", i)] = []byte(fmt.Sprint(i))
+	}
+	require.NoError(t, src.SetMany(values))
+
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	metrics := new(recordingMetrics)
+	dst, err := NewMemory(tempFile, WithMetrics(metrics))
+	require.NoError(t, err)
+	require.NoError(t, dst.Set("existing", []byte("kept")))
+	require.NoError(t, dst.Set("key-0", []byte("overwritten")))
+
+	metrics.ops = nil
+	n, err := dst.CopyFrom(src)
+	require.NoError(t, err)
+	assert.Equal(t, len(values), n)
+	assert.Equal(t, []string{"persist", "persist"}, metrics.ops, "the memory file must be written once per batch")
+	require.NoError(t, dst.Close())
+
+	dst, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer dst.Close()
+
+	keys, err := dst.Keys()
+	require.NoError(t, err)
+	assert.Len(t, keys, len(values)+1)
+
+	value, ok, err := dst.Get("key-0")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("0"), value)
+
+	value, ok, err = dst.Get("existing")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("kept"), value)
+}
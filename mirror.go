@@ -0,0 +1,28 @@
+package file
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// writeMirror writes the content of the memory file that was just persisted to
+// the mirror path as well (see WithMirrorPath). An error is only returned if
+// the mirror is strict, otherwise it is logged. The caller must hold the lock.
+func (m *Storage) writeMirror(content []byte) error {
+	err := m.writeFile(m.mirrorPath, content)
+	if err == nil {
+		return nil
+	}
+
+	if m.strictMirror {
+		return fmt.Errorf("failed to write mirror %q: %w", m.mirrorPath, err)
+	}
+
+	m.logger.Error("Failed to write mirror of memory file",
+		zap.String("path", m.mirrorPath),
+		zap.Error(err),
+	)
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithMirrorPath(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+	mirror := filepath.Join(dir, "mirror.json")
+
+	mem, err := NewMemory(tempFile, WithMirrorPath(mirror, true), WithCompression())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	mirrored, err := os.ReadFile(mirror)
+	require.NoError(t, err)
+	assert.Equal(t, content, mirrored)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithMirrorPath_Error(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+	mirror := filepath.Join(dir, "missing", "mirror.json")
+
+	core, logs := observer.New(zap.ErrorLevel)
+	mem, err := NewMemory(tempFile, WithMirrorPath(mirror, false), WithLogger(zap.New(core)))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	assert.Equal(t, 1, logs.FilterMessage("Failed to write mirror of memory file").Len())
+
+	strict, err := NewMemory(tempFile, WithMirrorPath(mirror, true))
+	require.NoError(t, err)
+	defer strict.Close()
+
+	err = strict.Set("foo", []byte("baz"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to write mirror")
+
+	// the memory file itself was written anyway
+	reloaded, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	value, _, err := reloaded.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("baz"), value)
+}
+
+func TestWithMirrorPath_Empty(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithMirrorPath("", false))
+	require.EqualError(t, err, "mirror path must not be empty")
+}
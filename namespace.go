@@ -0,0 +1,65 @@
+package file
+
+import (
+	"strings"
+
+	"github.com/go-joe/joe"
+)
+
+// namespace is a view of a memory that prefixes all keys (see Namespace).
+type namespace struct {
+	memory *Storage
+	prefix string // including the separator
+}
+
+// Namespace returns a view of the memory for the given prefix, so multiple
+// independent parts of a bot can share a single memory file without clashing
+// keys. The view transparently prepends the prefix and a colon to the keys of
+// Set, Get and Delete. Its Keys() function only returns the keys within the
+// namespace, without the prefix. If the keys of the memory are hashed (see
+// WithKeyHashing), the view hashes the prefixed keys as well and its Keys()
+// function returns ErrKeysHashed.
+//
+// All views share the data and the memory file of this memory and all changes
+// are persisted through it. Note that closing any view closes this memory and
+// thereby all other views as well.
+func (m *Storage) Namespace(prefix string) joe.Memory {
+	return &namespace{memory: m, prefix: m.normalizePrefix(prefix + ":")}
+}
+
+func (n *namespace) Set(key string, value []byte) error {
+	return n.memory.Set(n.prefix+key, value)
+}
+
+func (n *namespace) Get(key string) ([]byte, bool, error) {
+	return n.memory.Get(n.prefix + key)
+}
+
+func (n *namespace) Delete(key string) (bool, error) {
+	return n.memory.Delete(n.prefix + key)
+}
+
+func (n *namespace) Keys() ([]string, error) {
+	if err := n.memory.errKeysHashed("listing the keys of a namespace"); err != nil {
+		return nil, err
+	}
+
+	all, err := n.memory.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	// the keys of the memory are sorted so the result is sorted as well
+	keys := make([]string, 0, len(all))
+	for _, key := range all {
+		if strings.HasPrefix(key, n.prefix) {
+			keys = append(keys, strings.TrimPrefix(key, n.prefix))
+		}
+	}
+
+	return keys, nil
+}
+
+func (n *namespace) Close() error {
+	return n.memory.Close()
+}
@@ -0,0 +1,53 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestNamespace(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("root")))
+
+	a := mem.Namespace("a")
+	b := mem.Namespace("b")
+	require.NoError(t, a.Set("foo", []byte("a")))
+	require.NoError(t, a.Set("bar", []byte("a")))
+	require.NoError(t, b.Set("foo", []byte("b")))
+
+	value, ok, err := a.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("a"), value)
+
+	ok, err = b.Delete("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	keys, err := a.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+
+	keys, err = b.Keys()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	keys, err = mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:bar", "a:foo", "foo"}, keys)
+
+	// closing a view closes the shared memory
+	require.NoError(t, b.Close())
+	_, _, err = a.Get("foo")
+	assert.Error(t, err)
+}
@@ -0,0 +1,7 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package file
+
+// noFollowFlag is zero on platforms without O_NOFOLLOW, where symlinks are only
+// detected via os.Lstat.
+const noFollowFlag = 0
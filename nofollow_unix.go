@@ -0,0 +1,8 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package file
+
+import "syscall"
+
+// noFollowFlag is the flag of os.OpenFile that refuses to open a symlink.
+const noFollowFlag = syscall.O_NOFOLLOW
@@ -0,0 +1,31 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// checkNoSymlink returns an error if the file at the given path is a symlink or
+// anything else apart from a regular file (see WithNoSymlink). A file that does
+// not exist yet is fine.
+func (m *Storage) checkNoSymlink(path string) error {
+	if !m.noSymlink {
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to check file %q: %w", path, err)
+	case info.Mode()&os.ModeSymlink != 0:
+		return fmt.Errorf("refusing to use %q because it is a symlink", path)
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("refusing to use %q because it is not a regular file", path)
+	}
+
+	return nil
+}
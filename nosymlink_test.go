@@ -0,0 +1,49 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithNoSymlink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require special privileges on Windows")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "memory.json")
+	target := filepath.Join(dir, "target")
+	require.NoError(t, os.WriteFile(target, []byte("do not overwrite"), 0600))
+
+	mem, err := NewMemory(path, WithNoSymlink())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	// a symlink that is created after the memory was loaded is not followed
+	require.NoError(t, os.Symlink(target, path+".tmp"))
+	err = mem.Set("foo", []byte("baz"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "because it is a symlink")
+	require.NoError(t, os.Remove(path+".tmp"))
+	require.NoError(t, mem.Close())
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, os.Symlink(target, path))
+	_, err = NewMemory(path, WithNoSymlink())
+	require.EqualError(t, err, "refusing to use \""+path+"\" because it is a symlink")
+
+	content, err := os.ReadFile(target)
+	require.NoError(t, err)
+	assert.Equal(t, "do not overwrite", string(content))
+}
+
+func TestWithNoSymlink_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithNoSymlink(), WithFS(newMemFS()))
+	require.EqualError(t, err, "a custom file system cannot be combined with refusing symlinks")
+}
@@ -2,22 +2,1442 @@
 // https://github.com/go-joe/joe
 package file
 
-import "go.uber.org/zap"
+import (
+	"compress/gzip"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-joe/joe"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
 
 // Option corresponds to a configuration setting of the file memory.
 // All available options are the exported functions of this package that share
 // the prefix "With" in their names.
-type Option func(*memory) error
+type Option func(*Storage) error
 
 // WithLogger is a memory option that allows the caller to set a different
 // logger. By default this option is not required because the file.Memory(…)
 // function automatically uses the logger of the given joe.Config.
 func WithLogger(logger *zap.Logger) Option {
-	return func(memory *memory) error {
+	return func(memory *Storage) error {
 		memory.logger = logger
 		return nil
 	}
 }
 
-// IDEA: encrypted brain?
-// IDEA: only decrypt keys on demand?
+// WithName is a memory option that adds the given name as "memory" field to
+// all messages the memory logs, so the messages of multiple memories in the
+// same process can be told apart. The name has no other effect.
+func WithName(name string) Option {
+	return func(memory *Storage) error {
+		if name == "" {
+			return errors.New("name must not be empty")
+		}
+
+		memory.name = name
+		return nil
+	}
+}
+
+// MergePolicy decides which value is kept when multiple files that are loaded
+// via NewMemoryFromFiles(…) contain the same key.
+type MergePolicy int
+
+// The available merge policies.
+const (
+	// PrimaryWins keeps the value of the first file that contains a key,
+	// starting with the primary file and continuing with the extra files in
+	// the given order.
+	PrimaryWins MergePolicy = iota
+
+	// LastWins keeps the value of the last file that contains a key.
+	LastWins
+)
+
+// WithMergePolicy is a memory option that configures how conflicting keys are
+// resolved when loading multiple files via NewMemoryFromFiles(…). By default
+// the PrimaryWins policy is used.
+func WithMergePolicy(policy MergePolicy) Option {
+	return func(memory *Storage) error {
+		switch policy {
+		case PrimaryWins, LastWins:
+			memory.mergePolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("invalid merge policy %d", policy)
+		}
+	}
+}
+
+// WithVersionFile is a memory option that notifies memories in other processes
+// about changes to the same memory file. Each time the memory persists its
+// data, it increments a version number in a small sidecar file next to the
+// memory file (i.e. the path with an additional ".ver" suffix). Additionally
+// the memory polls the sidecar file in the given interval and reloads the full
+// memory file whenever another process has written a newer version.
+//
+// Polling the tiny version file is much cheaper than repeatedly checking the
+// potentially large memory file itself. Note that all processes that use the
+// file must use this option, otherwise their writes are not noticed.
+//
+// This option does not lock the memory file. If another process has written a
+// version that was not reloaded yet, changes are rejected with an error that
+// wraps ErrStaleVersion instead of overwriting the other process' data. The
+// caller may retry after the next poll has reloaded the file. Two processes
+// that check the version at the very same moment can still overwrite each
+// other.
+func WithVersionFile(pollInterval time.Duration) Option {
+	return func(memory *Storage) error {
+		if pollInterval <= 0 {
+			return errors.New("version file poll interval must be positive")
+		}
+
+		memory.versionFile = true
+		memory.pollInterval = pollInterval
+		return nil
+	}
+}
+
+// WithMaxSerializedSize is a memory option that limits the size of the memory
+// file to n bytes. The limit is checked against the actual encoded data before
+// it is written to disk. If a call to Set would make the file grow beyond this
+// limit, the file is not written, the change is reverted and Set returns an
+// error that wraps ErrMaxSerializedSize.
+func WithMaxSerializedSize(n int64) Option {
+	return func(memory *Storage) error {
+		if n <= 0 {
+			return errors.New("maximum serialized size must be positive")
+		}
+
+		memory.maxSerializedSize = n
+		return nil
+	}
+}
+
+// WithNoSymlink is a memory option that refuses to use a memory file that is a
+// symlink, so an attacker who can create files next to the memory file cannot
+// redirect its writes to another file the bot is allowed to write. When the
+// memory is created and before each write, the memory file and the temporary
+// file that replaces it are checked via os.Lstat. If either is a symlink or
+// anything else apart from a regular file, an error is returned. Files that do
+// not exist yet are fine. On platforms that support it, syscall.O_NOFOLLOW is
+// added to the flags of os.OpenFile as well (see WithOpenFlags), so a symlink
+// that is created after the check is not followed either.
+//
+// Only the last element of the path is checked, so the directories of the path
+// may still be symlinks. This option cannot be combined with a custom FS (see
+// WithFS).
+func WithNoSymlink() Option {
+	return func(memory *Storage) error {
+		memory.noSymlink = true
+		return nil
+	}
+}
+
+// WithCheckWritable is a memory option that verifies that the memory file can
+// be written when the memory is created. Without this option, a missing
+// permission or a read-only mount is only detected when the memory persists its
+// data for the first time which might happen long after the bot was started.
+// The check temporarily creates the file that is used to atomically replace the
+// memory file, which verifies that the directory of the memory file is
+// writable.
+func WithCheckWritable() Option {
+	return func(memory *Storage) error {
+		memory.checkWritable = true
+		return nil
+	}
+}
+
+// WithWritableFallback is a memory option that keeps the bot functioning if its
+// memory file turns out to be unwritable, e.g. on a locked-down host. If the
+// memory file cannot be written because of a missing permission or a read-only
+// file system, the memory logs a warning and switches to a temporary file in
+// os.TempDir() instead. The switch happens at most once. Afterwards all changes
+// are written to the temporary file, so they are lost if the bot is restarted,
+// since the memory loads its original path again. Path() keeps returning the
+// original path.
+func WithWritableFallback() Option {
+	return func(memory *Storage) error {
+		memory.writableFallback = true
+		return nil
+	}
+}
+
+// WithCreateDirs is a memory option that creates all missing parent directories
+// of the memory file when the memory is created. Without this option, a missing
+// directory is only detected when the memory persists its data for the first
+// time.
+func WithCreateDirs() Option {
+	return func(memory *Storage) error {
+		memory.createDirs = true
+		return nil
+	}
+}
+
+// WithConflictDetection is a memory option that detects if the memory file was
+// modified by another process since this memory has read or written it. Before
+// each write, the file is read again and compared to the content that the
+// memory expects. If the content differs, the given callback is invoked with
+// the path of the file. If the callback returns nil, the file is overwritten
+// anyway. Otherwise the write is aborted and an error that wraps ErrConflict
+// is returned.
+//
+// This is a best-effort mechanism for file systems without working advisory
+// locks. It cannot detect a concurrent write that happens between the check
+// and the write itself.
+func WithConflictDetection(onConflict func(path string) error) Option {
+	return func(memory *Storage) error {
+		if onConflict == nil {
+			return errors.New("conflict callback must not be nil")
+		}
+
+		memory.onConflict = onConflict
+		return nil
+	}
+}
+
+// WithTimestampHeader is a memory option that adds a header field to the memory
+// file that records when the file was written. Files with or without this
+// header can always be loaded, regardless of this option. Use FileAge(…) to
+// read the timestamp of such a file.
+func WithTimestampHeader() Option {
+	return func(memory *Storage) error {
+		memory.timestampHeader = true
+		return nil
+	}
+}
+
+// WithStrictDecoding is a memory option that rejects memory files which do not
+// exactly match the file format, instead of silently ignoring the unexpected
+// parts. A file that contains unknown fields, the same field twice or the same
+// key twice is treated as corrupt (see WithCorruptFilePolicy), since this
+// usually indicates a format mismatch, e.g. a file written by a newer version
+// or edited by hand. Numeric fields such as versions are always decoded into
+// integers, so they never lose precision, regardless of this option.
+//
+// Files in the legacy format, which have no header fields, are accepted as
+// before apart from duplicate keys. This option cannot be combined with a
+// custom codec (see WithCodec).
+func WithStrictDecoding() Option {
+	return func(memory *Storage) error {
+		memory.strictDecoding = true
+		return nil
+	}
+}
+
+// WithMetadataHeader is a memory option that adds a metadata block to the
+// memory file that records when the file was written (RFC 3339), the hostname
+// of the machine that wrote it and the number of keys. This helps operators
+// that inspect the memory file by hand. The metadata is purely informational
+// and ignored when the file is loaded, so files with or without it can always
+// be loaded, regardless of this option.
+func WithMetadataHeader() Option {
+	return func(memory *Storage) error {
+		memory.metadataHeader = true
+		return nil
+	}
+}
+
+// WithTracerProvider is a memory option that enables OpenTelemetry tracing. The
+// memory creates spans around loading and persisting its file and around each
+// call of Set, Get and Delete. The spans record the number of keys and the
+// number of bytes that were read or written and the error status of each
+// operation. Without this option tracing is disabled.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(memory *Storage) error {
+		if tp == nil {
+			return errors.New("tracer provider must not be nil")
+		}
+
+		memory.tracer = tp.Tracer(tracerName)
+		return nil
+	}
+}
+
+// WithTracer is like WithTracerProvider(…) but uses the given tracer directly,
+// e.g. to share the tracer of the bot instead of creating a new one for the
+// memory.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(memory *Storage) error {
+		if tracer == nil {
+			return errors.New("tracer must not be nil")
+		}
+
+		memory.tracer = tracer
+		return nil
+	}
+}
+
+// WithBackgroundLoad is a memory option that lets NewMemory(…) return
+// immediately with the given seed data while the memory file is loaded in a
+// background goroutine. Once the file is loaded its content is merged into the
+// seed, where the values from the file win. This trades completeness for a fast
+// startup on huge memory files.
+//
+// While the file is loading, Get returns the seed values right away. All other
+// operations (including a Get for a key that is not part of the seed) either
+// block until the file is loaded or fail with ErrNotReady, depending on the
+// given policy. If the file cannot be loaded, these operations return the error
+// of loading the file.
+func WithBackgroundLoad(seed map[string][]byte, policy NotReadyPolicy) Option {
+	return func(memory *Storage) error {
+		switch policy {
+		case WaitForLoad, FailNotReady:
+		default:
+			return fmt.Errorf("invalid not-ready policy %d", policy)
+		}
+
+		memory.seed = seed
+		memory.notReadyPolicy = policy
+		memory.loaded = make(chan struct{})
+		return nil
+	}
+}
+
+// WithValueInspector is a memory option that passes every value to the given
+// function before it is set. If the function returns a non-empty string, it is
+// logged as a warning together with the key. The value is written regardless,
+// so the inspector can be used for lightweight data-hygiene heuristics (e.g.
+// detecting values that look like stack traces) without rejecting any writes.
+func WithValueInspector(inspect func(key string, value []byte) (warning string)) Option {
+	return func(memory *Storage) error {
+		memory.valueInspector = inspect
+		return nil
+	}
+}
+
+// WithValueValidator is a memory option that calls the given function with
+// every value before it is set. If the function returns an error, the operation
+// fails with an error that wraps ErrInvalidValue and the error of the function
+// before the memory is changed. This way values can be checked against a known
+// shape, e.g. via the JSON Schema library of your choice, without adding such a
+// dependency to this package. If validateLoaded is true, the values of the
+// memory file are validated when it is loaded as well and each invalid value is
+// logged as a warning together with its key.
+//
+// Validating loaded values cannot be combined with WithLazyLoad, since the
+// values are not decoded when the memory file is loaded.
+func WithValueValidator(validate func(key string, value []byte) error, validateLoaded bool) Option {
+	return func(memory *Storage) error {
+		if validate == nil {
+			return errors.New("value validator must not be nil")
+		}
+
+		memory.valueValidator = validate
+		memory.validateLoaded = validateLoaded
+		return nil
+	}
+}
+
+// WithMaxKeyLength is a memory option that limits the length of all keys to n
+// bytes. Setting a key that is longer fails with ErrKeyTooLong. Keys that are
+// loaded from the memory file (e.g. because it was edited by hand) are logged
+// as warnings, unless strict is true in which case loading the file fails.
+func WithMaxKeyLength(n int, strict bool) Option {
+	return func(memory *Storage) error {
+		if n <= 0 {
+			return fmt.Errorf("max key length must be positive but got %d", n)
+		}
+
+		memory.maxKeyLength = n
+		memory.strictKeys = strict
+		return nil
+	}
+}
+
+// WithDeltaNumericValues is a memory option that stores the values in a compact
+// format if all of them are decimal integers (e.g. counters that are keyed by a
+// timestamp). The values are sorted by key and each one is stored as varint
+// encoded difference to the previous value. If any value is not an integer,
+// the normal format is used. The key/value API is not affected by this option
+// and files in the compact format can be read without it.
+func WithDeltaNumericValues() Option {
+	return func(memory *Storage) error {
+		memory.deltaNumeric = true
+		return nil
+	}
+}
+
+// WithDedup is a memory option that stores values which are shared by multiple
+// keys only once, both in memory and in the memory file. Instead of repeating
+// the value, the memory file references a table that contains each shared
+// value once by its SHA-256 hash. A value is removed from the table once the
+// last key that references it was changed or deleted. This shrinks memories
+// that assign the same large value to many keys (e.g. a rendered template that
+// is keyed by many IDs). Values smaller than 256 bytes are always stored
+// inline. Since keys share their values, the values that are returned by Get
+// must not be modified.
+//
+// Files that were written with this option can be read without it, but older
+// versions of this package do not know the table and miss the shared values.
+func WithDedup() Option {
+	return func(memory *Storage) error {
+		memory.dedup = true
+		return nil
+	}
+}
+
+// WithKeyIndexSidecar is a memory option that writes a sorted list of all keys
+// to the file at the given path each time the memory is persisted. The file
+// contains one key per line and no values. It is never read by the memory but
+// gives humans a quick overview of the stored keys. Keys that contain a line
+// break therefore span multiple lines. If the index cannot be written, a
+// warning is logged but the persist still succeeds.
+func WithKeyIndexSidecar(path string) Option {
+	return func(memory *Storage) error {
+		if path == "" {
+			return errors.New("key index path must not be empty")
+		}
+
+		memory.keyIndexPath = path
+		return nil
+	}
+}
+
+// WithFileMode is a memory option that sets the permissions of the memory file.
+// Since the memory file is replaced on every write, the mode is applied each
+// time the memory is persisted. As usual, the mode is subject to the umask of
+// the process. By default the mode 0660 is used.
+func WithFileMode(mode os.FileMode) Option {
+	return func(memory *Storage) error {
+		memory.fileMode = mode.Perm()
+		return nil
+	}
+}
+
+// WithFS is a memory option that accesses the memory file and all other files
+// of the memory via the given file system instead of the os package. This is
+// mostly useful in tests, e.g. to keep all files in memory or to simulate a
+// full disk. A custom file system cannot be combined with WithLazyLoad() and
+// WithExclusiveLock(), which need a file of the operating system.
+func WithFS(fsys FS) Option {
+	return func(memory *Storage) error {
+		if fsys == nil {
+			return errors.New("file system must not be nil")
+		}
+
+		memory.fsys = fsys
+		return nil
+	}
+}
+
+// WithEncryptionKey is a memory option that encrypts the memory file with
+// AES-256-GCM using the given 32 byte key. Each time the memory is persisted,
+// the encoded data is encrypted with a new random nonce which is prepended to
+// the file. The file is decrypted transparently when it is loaded. If it cannot
+// be decrypted, an error that wraps ErrDecryptionFailed is returned.
+//
+// If compression is enabled via WithCompression(), the data is always compressed
+// before it is encrypted and decrypted before it is decompressed, regardless of
+// the order of the options, since encrypted data cannot be compressed.
+//
+// Note that the size limit of WithMaxSerializedSize(…) applies to the encrypted
+// file and that the SnapshotTo(…) output is not encrypted. FileAge(…) cannot
+// read the header of an encrypted file.
+func WithEncryptionKey(key []byte) Option {
+	return WithEncryptionCipher(key, newAEAD)
+}
+
+// WithEncryptionCipher is like WithEncryptionKey but uses the AEAD that is
+// returned by newCipher for the given key instead of AES-256-GCM, e.g.
+// ChaCha20-Poly1305 from golang.org/x/crypto/chacha20poly1305 on platforms
+// without hardware support for AES. A file that was encrypted with one cipher
+// cannot be decrypted with another.
+func WithEncryptionCipher(key []byte, newCipher func(key []byte) (cipher.AEAD, error)) Option {
+	return func(memory *Storage) error {
+		if newCipher == nil {
+			return errors.New("cipher must not be nil")
+		}
+
+		aead, err := newCipher(key)
+		if err != nil {
+			return err
+		}
+
+		memory.aead = aead
+		return nil
+	}
+}
+
+// WithCodec is a memory option that replaces the built-in JSON format of the
+// memory file with the given codec (e.g. to use a more compact binary format).
+// The codec is used both to load and to persist the memory file. A custom codec
+// only encodes the data itself, so it cannot be combined with options that
+// store additional information in the memory file, such as
+// WithTimestampHeader(), WithDeltaNumericValues() or WithLazyDecryption().
+// Encryption via WithEncryptionKey(…) is applied to the encoded data.
+func WithCodec(codec Codec) Option {
+	return func(memory *Storage) error {
+		if codec == nil {
+			return errors.New("codec must not be nil")
+		}
+
+		memory.codec = codec
+		return nil
+	}
+}
+
+// WithDotEnvCodec is a memory option that stores the memory file as lines of
+// KEY=value pairs (see DotEnvCodec) so other tools can read it directly. It is
+// a shortcut for WithCodec(DotEnvCodec{}).
+func WithDotEnvCodec() Option {
+	return WithCodec(DotEnvCodec{})
+}
+
+// WithFileWatch is a memory option that reloads the memory file when it is
+// modified by another process, e.g. because it was edited by hand or updated
+// via version control. The file is checked for changes once per poll interval.
+// The writes of the memory itself never trigger a reload. If the modified file
+// cannot be decoded, the current data is kept and the error is logged.
+//
+// Unlike WithVersionFile(…), this option does not require the cooperation of
+// the other process. Changes that are made to the memory while the file is
+// being modified on disk are not merged. Use WithConflictDetection(…) to detect
+// when this memory is about to overwrite a modified file.
+func WithFileWatch(pollInterval time.Duration) Option {
+	return func(memory *Storage) error {
+		if pollInterval <= 0 {
+			return fmt.Errorf("poll interval must be positive but got %s", pollInterval)
+		}
+
+		memory.fileWatchInterval = pollInterval
+		return nil
+	}
+}
+
+// WithCompression is a memory option that compresses the memory file with gzip.
+// Compressed files are detected automatically when they are loaded, so a memory
+// can switch to compression without converting its file first. If encryption
+// is enabled via WithEncryptionKey(…), the data is compressed before it is
+// encrypted. The size limit of WithMaxSerializedSize(…) applies to the
+// compressed file.
+func WithCompression() Option {
+	return func(memory *Storage) error {
+		memory.compress = true
+		return nil
+	}
+}
+
+// WithCompressionLevel is a memory option that compresses the memory file with
+// the given gzip level (see WithCompression). The level ranges from
+// gzip.HuffmanOnly to gzip.BestCompression, where lower levels are faster and
+// higher levels produce smaller files. gzip.DefaultCompression is used unless
+// this option is given.
+func WithCompressionLevel(level int) Option {
+	return func(memory *Storage) error {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return fmt.Errorf("compression level must be between %d and %d but got %d",
+				gzip.HuffmanOnly, gzip.BestCompression, level,
+			)
+		}
+
+		memory.compress = true
+		memory.gzLevel = level
+		return nil
+	}
+}
+
+// WithFlushInterval is a memory option that batches all changes and persists
+// them at most once per interval instead of rewriting the memory file on every
+// change. This reduces the load on the disk when many keys are changed in quick
+// succession, at the risk of losing the changes of the last interval if the
+// process crashes. Close() persists all pending changes before it returns.
+//
+// Since the file is written in the background, errors of a write (including a
+// rejected write, e.g. via WithMaxSerializedSize) are not returned by the
+// operation that changed the memory. Instead, they are logged and the write is
+// retried after the next interval.
+func WithFlushInterval(d time.Duration) Option {
+	return func(memory *Storage) error {
+		if d <= 0 {
+			return fmt.Errorf("flush interval must be positive but got %s", d)
+		}
+
+		memory.flushInterval = d
+		return nil
+	}
+}
+
+// WithMaxFlushDelay is a memory option that limits how long a change may wait
+// to be persisted if a flush interval is configured (see WithFlushInterval).
+// The memory file is written once the oldest change that has not been
+// persisted yet is d old, even if the next periodic flush is still due later.
+// The periodic flush keeps its fixed schedule, so changes are persisted by
+// whichever of the two happens first and a steady stream of changes can never
+// postpone a write. Since the periodic flush already guarantees that changes
+// are at most one flush interval old, d must be shorter than the interval.
+func WithMaxFlushDelay(d time.Duration) Option {
+	return func(memory *Storage) error {
+		if d <= 0 {
+			return fmt.Errorf("max flush delay must be positive but got %s", d)
+		}
+
+		memory.maxFlushDelay = d
+		return nil
+	}
+}
+
+// defaultOpenFlags are the flags that are used to open a file for writing
+// unless WithOpenFlags(…) is used.
+const defaultOpenFlags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+
+// WithOpenFlags is a memory option that sets the flags that are passed to
+// os.OpenFile when a file is written, e.g. to add syscall.O_NOFOLLOW in
+// hardened environments. By default, files are opened with
+// os.O_WRONLY|os.O_CREATE|os.O_TRUNC. Note that the memory file is written to
+// a temporary file next to it which then replaces the memory file, so the
+// flags apply to the temporary file. The flags replace the
+// default flags, so they should include them as well. Otherwise writes might
+// fail or leave corrupt files behind, which is the responsibility of the
+// caller.
+func WithOpenFlags(flags int) Option {
+	return func(memory *Storage) error {
+		memory.openFlags = flags
+		return nil
+	}
+}
+
+// WithTempDir is a memory option that writes the temporary files, which
+// replace the memory file and its side files (see writeFile), to the given
+// directory instead of next to the files themselves. This is useful if the
+// directory of the memory file has a small quota. If the directory is on a
+// different file system, a file cannot be renamed, so the temporary file is
+// copied over the file instead. Note that copying is not atomic, so a crash
+// while the file is copied can leave a truncated memory file behind.
+func WithTempDir(dir string) Option {
+	return func(memory *Storage) error {
+		if dir == "" {
+			return errors.New("temp dir must not be empty")
+		}
+
+		memory.tempDir = dir
+		return nil
+	}
+}
+
+// WithLazyDecryption is a memory option that keeps all values encrypted while
+// they are held in memory. Each value is encrypted individually when it is set
+// and only decrypted when it is requested via Get, so plaintext secrets are not
+// kept in memory for the entire lifetime of the process. The values stay
+// encrypted in the memory file as well. This option requires an encryption key
+// that is set via WithEncryptionKey(…).
+//
+// An existing memory file is converted when it is loaded, but a file that was
+// written with this option can only be loaded if the option is enabled.
+// Note that SnapshotTo(…) writes the encrypted values.
+func WithLazyDecryption() Option {
+	return func(memory *Storage) error {
+		memory.sealed = true
+		return nil
+	}
+}
+
+// WithBackups is a memory option that keeps the previous n generations of the
+// memory file. Before the memory file is overwritten, its current content is
+// copied to the path with an additional ".1" suffix. Existing backups are
+// shifted to ".2", ".3" and so on, and the oldest backup is discarded once
+// there are n of them. A backup can be restored by copying it over the memory
+// file while the memory is not running.
+//
+// Failing to write a backup does not prevent the memory file from being
+// written. Such errors are only logged.
+func WithBackups(n int) Option {
+	return func(memory *Storage) error {
+		if n <= 0 {
+			return fmt.Errorf("number of backups must be positive but got %d", n)
+		}
+
+		memory.backups = n
+		return nil
+	}
+}
+
+// WithMaxFileAge is a memory option that starts with an empty memory if the
+// memory file was last modified longer than the given duration ago. This is
+// useful for bots whose state naturally rolls over, e.g. daily. When the
+// memory is created, such a file is moved to the path with an additional
+// timestamp suffix (e.g. ".2024-01-02T15-04-05") instead of being loaded. Use
+// WithMaxArchives(…) to limit how many of these archives are kept.
+//
+// Note that the age is only checked when the memory is created, so a memory
+// that keeps running is never reset.
+func WithMaxFileAge(d time.Duration) Option {
+	return func(memory *Storage) error {
+		if d <= 0 {
+			return fmt.Errorf("max file age must be positive but got %s", d)
+		}
+
+		memory.maxFileAge = d
+		return nil
+	}
+}
+
+// WithMaxArchives is a memory option that keeps at most n archives of the
+// memory file that were created via WithMaxFileAge(…). Whenever the memory
+// file is archived, the oldest archives are removed. By default all archives
+// are kept. Archives can only be removed if the FS of the memory can list
+// directories (see WithFS).
+func WithMaxArchives(n int) Option {
+	return func(memory *Storage) error {
+		if n <= 0 {
+			return fmt.Errorf("number of archives must be positive but got %d", n)
+		}
+
+		memory.maxArchives = n
+		return nil
+	}
+}
+
+// WithCorruptFilePolicy is a memory option that decides what happens if the
+// memory file exists but cannot be decoded when the memory is created. By
+// default the FailFast policy is used, which returns the error so the bot does
+// not start. The StartEmpty policy continues with an empty memory and moves the
+// corrupt file to the path with an additional ".corrupt.<timestamp>" suffix so
+// it can be inspected later. Errors that are not caused by the content of the
+// file (e.g. a missing permission or a wrong encryption key) are always
+// returned.
+func WithCorruptFilePolicy(policy CorruptFilePolicy) Option {
+	return func(memory *Storage) error {
+		switch policy {
+		case FailFast, StartEmpty, StartEmptyKeep:
+			memory.corruptFilePolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("invalid corrupt file policy %d", policy)
+		}
+	}
+}
+
+// WithPersistErrorPolicy is a memory option that decides what happens to a
+// change if it was applied in memory but the memory file could not be written.
+// By default the Propagate policy is used, which returns the PersistError but
+// keeps the change in memory. The Rollback policy reverts the change so the
+// memory stays consistent with the file, while the LogAndContinue policy only
+// logs the error and writes the change with the next persist. Changes that are
+// rejected before the file is written (e.g. via WithMaxSerializedSize) are
+// always reverted.
+//
+// The Rollback policy cannot be combined with WithFlushInterval(…), since the
+// changes are not written immediately.
+func WithPersistErrorPolicy(policy PersistErrorPolicy) Option {
+	return func(memory *Storage) error {
+		switch policy {
+		case Propagate, Rollback, LogAndContinue:
+			memory.persistErrorPolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("invalid persist error policy %d", policy)
+		}
+	}
+}
+
+// WithSync is a memory option that flushes the memory file to the disk after
+// each write, so a change is not lost if the machine loses power right after
+// the operation returned. Without this option the data may still be held in
+// the page cache of the operating system for a while. Since the memory file is
+// replaced atomically via a temporary file, both the new file and its
+// directory are synced, which makes every write considerably slower.
+//
+// Syncing the directory makes sure the rename of the temporary file survives a
+// power loss as well. It is only supported on Unix platforms. On Windows, only
+// the file itself is synced, since directories cannot be synced there.
+func WithSync() Option {
+	return func(memory *Storage) error {
+		memory.syncWrites = true
+		return nil
+	}
+}
+
+// WithRawStrings is a memory option that stores each value that is valid UTF-8
+// as plain JSON string instead of base64, which keeps memory files with text
+// values readable and small. All other values are stored as an object with a
+// single "base64" field, so arbitrary bytes are still supported. Files with or
+// without raw strings can always be loaded, regardless of this option.
+func WithRawStrings() Option {
+	return func(memory *Storage) error {
+		memory.rawStrings = true
+		return nil
+	}
+}
+
+// WithShards is a memory option that splits the memory into n files instead of
+// a single memory file. Each key is assigned to one of the shards by its hash
+// and each change only rewrites the shard of the changed key, which reduces the
+// cost of a write for large memories. The shards are stored next to the memory
+// file with the shard index before the ".json" extension, e.g. "joe.0.json" up
+// to "joe.3.json" for the path "joe.json" and four shards. The size limit of
+// WithMaxSerializedSize(…) applies to each shard.
+//
+// When the memory is created, all keys that are not stored in their shard are
+// moved to it. This way an existing unsharded memory file or a memory with a
+// different number of shards is converted automatically. Afterwards, the
+// unsharded memory file and any shards beyond n are removed.
+//
+// Sharding cannot be combined with options that operate on a single memory
+// file, i.e. WithVersionFile, WithConflictDetection, WithFileWatch,
+// WithBackups, WithBackgroundLoad and WithCorruptFilePolicy.
+func WithShards(n int) Option {
+	return func(memory *Storage) error {
+		if n <= 0 {
+			return fmt.Errorf("number of shards must be positive but got %d", n)
+		}
+
+		memory.shards = n
+		return nil
+	}
+}
+
+// WithAppendLog is a memory option that appends each change to a log file next
+// to the memory file (i.e. the path with an additional ".log" suffix) instead
+// of rewriting the entire memory file. This makes the cost of a change
+// independent of the size of the memory. When the memory is created, the log is
+// replayed on top of the memory file.
+//
+// Once the log is larger than the given ratio of the size of the memory file,
+// it is compacted by writing all data to the memory file and removing the log.
+// For example, a ratio of 0.5 compacts the log when it has grown to half the
+// size of the memory file. Logs smaller than a few kilobytes are never
+// compacted. A failed compaction is only logged and retried with the next
+// change, since the change itself is already stored in the log.
+//
+// The log cannot be combined with options that check or limit each write of
+// the memory file, i.e. WithVersionFile, WithConflictDetection,
+// WithFileWatch, WithShards, WithBackgroundLoad, WithMaxSerializedSize,
+// WithEncryptionKey and WithKeyIndexSidecar.
+func WithAppendLog(compactionRatio float64) Option {
+	return func(memory *Storage) error {
+		if compactionRatio <= 0 {
+			return fmt.Errorf("compaction ratio must be positive but got %v", compactionRatio)
+		}
+
+		memory.logRatio = compactionRatio
+		return nil
+	}
+}
+
+// WithLoadProgress is a memory option that reports the progress of loading
+// the memory file, which can take a while for very large files. The callback is
+// called with the number of bytes that were read so far and the size of the
+// file each time another megabyte was read and once the file was read
+// completely. If the size is unknown (e.g. with NewMemoryWithStore), the total
+// is -1. The callback does not change what is loaded.
+func WithLoadProgress(fun func(bytesRead, totalBytes int64)) Option {
+	return func(memory *Storage) error {
+		memory.loadProgress = fun
+		return nil
+	}
+}
+
+// WithSnapshotInterval is a memory option that compacts the append log in the
+// background once per interval instead of when the log exceeds its compaction
+// ratio (see WithAppendLog). Each change is still appended to the log before
+// the operation returns (and synced to disk if WithSync is used), so a change
+// that was acknowledged survives a crash at any point. Only the compaction,
+// which writes the entire memory file, is moved out of the write path. When
+// the memory is created, all records of the log since the last compaction are
+// replayed.
+func WithSnapshotInterval(d time.Duration) Option {
+	return func(memory *Storage) error {
+		if d <= 0 {
+			return fmt.Errorf("snapshot interval must be positive but got %s", d)
+		}
+
+		memory.compactEvery = d
+		return nil
+	}
+}
+
+// WithCompactionSchedule is a memory option that decides when the append log may
+// be compacted (see WithAppendLog), e.g. to keep writing the entire memory file
+// out of peak hours. Whenever the log is due for compaction because it exceeds
+// its compaction ratio or the snapshot interval has passed (see
+// WithSnapshotInterval), the compaction only proceeds if allowed returns true.
+// Otherwise the log keeps growing until a later compaction is allowed. The
+// function is called while the memory is locked, so it must return quickly and
+// must not use the memory.
+func WithCompactionSchedule(allowed func() bool) Option {
+	return func(memory *Storage) error {
+		if allowed == nil {
+			return errors.New("compaction schedule must not be nil")
+		}
+
+		memory.compactGate = allowed
+		return nil
+	}
+}
+
+// WithBinaryAppendLog is a memory option that writes the records of the append
+// log in a compact binary encoding instead of JSON (see WithAppendLog). Values
+// are stored as is instead of base64 encoded and each record carries a CRC-32
+// checksum, so a record that was only partially written is detected reliably.
+// Since the log is much smaller, it is also compacted less often. A log can
+// always be replayed, regardless of this option, so it can be enabled or
+// disabled for an existing memory at any time. This option requires
+// WithAppendLog(…).
+func WithBinaryAppendLog() Option {
+	return func(memory *Storage) error {
+		memory.binaryLog = true
+		return nil
+	}
+}
+
+// WithExclusiveLock is a memory option that prevents multiple processes from
+// using the same memory file at the same time, e.g. because a second instance
+// of a bot was started accidentally. When the memory is created, it takes an
+// advisory lock on a file next to the memory file (i.e. the path with an
+// additional ".lock" suffix) and NewMemory(…) fails with an error that wraps
+// ErrLocked if another process holds the lock already. The lock is released
+// when the memory is closed.
+//
+// The lock is advisory, so it only protects against processes that use this
+// option as well.
+func WithExclusiveLock() Option {
+	return func(memory *Storage) error {
+		memory.exclusiveLock = true
+		return nil
+	}
+}
+
+// WithRawHTML is a memory option that writes the characters <, > and & as they
+// are instead of escaping them (e.g. as \u003c), which keeps keys and values
+// that contain URLs or HTML readable when the memory file is edited by hand.
+// Since values are only written as text if WithRawStrings() is used as well,
+// this option mostly affects keys otherwise. Files with or without escaped
+// characters can always be loaded, regardless of this option.
+func WithRawHTML() Option {
+	return func(memory *Storage) error {
+		memory.rawHTML = true
+		return nil
+	}
+}
+
+// indentation configures how the JSON of the memory file is indented (see
+// WithIndent).
+type indentation struct {
+	prefix, indent string
+}
+
+// WithIndent is a memory option that writes the memory file as indented JSON
+// instead of a single line, which is easier to edit by hand and produces line
+// oriented diffs if the file is kept in version control. Each line starts with
+// the given prefix followed by one or more copies of indent according to the
+// nesting (see json.Encoder.SetIndent). Indented and compact files can always
+// be loaded, regardless of this option. Keys are always written in sorted order,
+// so the same data results in the same file, regardless of the order in which
+// the keys were set.
+func WithIndent(prefix, indent string) Option {
+	return func(memory *Storage) error {
+		memory.indent = &indentation{prefix: prefix, indent: indent}
+		return nil
+	}
+}
+
+// LineEnding selects which newline characters are used in the memory file.
+type LineEnding int
+
+// The available line endings.
+const (
+	// LF uses a single line feed character ("\n") which is the default.
+	LF LineEnding = iota
+
+	// CRLF uses a carriage return followed by a line feed ("\r\n").
+	CRLF
+)
+
+// WithLineEnding is a memory option that sets the newline characters of the
+// indented memory file (see WithIndent). This is useful if the file is edited
+// by hand on different operating systems so it keeps consistent line endings no
+// matter where it was written last. The memory can always load files with
+// either line ending. CRLF requires WithIndent since a compact file only
+// consists of a single line.
+func WithLineEnding(style LineEnding) Option {
+	return func(memory *Storage) error {
+		switch style {
+		case LF, CRLF:
+			memory.lineEnding = style
+			return nil
+		default:
+			return fmt.Errorf("invalid line ending %d", style)
+		}
+	}
+}
+
+// WithSeedFS is a memory option that initializes the memory from the file with
+// the given name in fsys if the memory file does not exist yet. This can be
+// used to ship default values within the binary via an embed.FS. The seed file
+// must use the same format as the memory file. It is only read and never
+// written to. Instead, the memory file is created by the first change.
+//
+// If the seed file cannot be read or decoded, NewMemory(…) returns an error. A
+// seed file cannot be combined with WithShards or WithAppendLog.
+func WithSeedFS(fsys fs.FS, name string) Option {
+	return func(memory *Storage) error {
+		if fsys == nil {
+			return errors.New("seed file system must not be nil")
+		}
+
+		memory.seedFS = fsys
+		memory.seedName = name
+		return nil
+	}
+}
+
+// WithReadWriteSplit is a memory option that loads the memory from the file at
+// loadPath but writes all changes to the memory file, e.g. to start a staging
+// bot from a snapshot of the production memory. The file at loadPath is only
+// read and never written to. If it does not exist, the memory is loaded from
+// the memory file as usual.
+//
+// If preferLoadPath is false, the file at loadPath is only read if the memory
+// file does not exist yet, so a restarted memory continues with its own
+// changes. If preferLoadPath is true, the file at loadPath is always read if it
+// exists and the content of an existing memory file is replaced by the first
+// change. The load path cannot be combined with WithSeedFS, WithShards,
+// WithAppendLog or WithLazyLoad.
+func WithReadWriteSplit(loadPath string, preferLoadPath bool) Option {
+	return func(memory *Storage) error {
+		if loadPath == "" {
+			return errors.New("load path must not be empty")
+		}
+
+		memory.loadPath = loadPath
+		memory.preferLoad = preferLoadPath
+		return nil
+	}
+}
+
+// WithFallback is a memory option that reads keys which are missing in this
+// memory from the given fallback memory, e.g. a read-only knowledge base that
+// is shipped with the bot while this memory only stores the overrides. The
+// memory always takes precedence, so its keys shadow the keys of the fallback.
+// Set, Delete and all other changes only modify this memory, so deleting a key
+// makes the value of the fallback visible again, if it has one.
+//
+// Only Get, GetContext and the functions that are based on them (e.g.
+// GetDefault) consult the fallback. If unionKeys is true, Keys() returns the
+// union of the keys of both memories. Otherwise only the keys of this memory
+// are returned. The fallback is not closed together with this memory.
+func WithFallback(src joe.Memory, unionKeys bool) Option {
+	return func(memory *Storage) error {
+		if src == nil {
+			return errors.New("fallback memory must not be nil")
+		}
+
+		if s, ok := src.(*Storage); ok && s == memory {
+			return errors.New("a memory cannot be its own fallback")
+		}
+
+		memory.fallback = src
+		memory.fallbackKeys = unionKeys
+		return nil
+	}
+}
+
+// WithMetrics is a memory option that reports the duration and result of each
+// call of Set, Get and Delete and of each write of the memory file to the given
+// Metrics. This way the memory can be monitored with any metrics system without
+// adding a dependency to this package. Without this option no metrics are
+// recorded.
+func WithMetrics(metrics Metrics) Option {
+	return func(memory *Storage) error {
+		if metrics == nil {
+			return errors.New("metrics must not be nil")
+		}
+
+		memory.metrics = metrics
+		return nil
+	}
+}
+
+// WithChangeHook is a memory option that calls the given hook for each key that
+// is set or deleted via this memory, once the change was written to the memory
+// file. This way observers only see durable changes. With WithFlushInterval(…)
+// the hook is called after the next flush. The option can be passed multiple
+// times to register multiple hooks, which are called in the given order.
+//
+// Hooks are called synchronously while the memory is locked, so they must
+// return quickly and must not call the memory themselves. A panic in a hook is
+// recovered and logged.
+func WithChangeHook(hook func(event ChangeEvent)) Option {
+	return func(memory *Storage) error {
+		if hook == nil {
+			return errors.New("change hook must not be nil")
+		}
+
+		memory.changeHooks = append(memory.changeHooks, hook)
+		return nil
+	}
+}
+
+// WithAuditLog is a memory option that appends a JSON line for each key that is
+// set or deleted to the audit log at the given path, once the change was
+// written to the memory file (see WithChangeHook). Each line contains the time
+// of the change, the operation, the key and the name of the memory if it was
+// set via WithName(…). Values are only written if logValues is true. Otherwise
+// each line contains the SHA-256 hash of the new value instead, so sensitive
+// data does not end up in the audit log.
+//
+// Unlike backups, the audit log is never truncated or rotated by the memory.
+// If a line cannot be written, the error is logged but the change is kept.
+func WithAuditLog(path string, logValues bool) Option {
+	return func(memory *Storage) error {
+		if path == "" {
+			return errors.New("audit log path must not be empty")
+		}
+
+		memory.auditPath = path
+		memory.auditValues = logValues
+		memory.changeHooks = append(memory.changeHooks, memory.audit)
+		return nil
+	}
+}
+
+// WithReadOnly is a memory option that loads the memory file but rejects all
+// changes. Set, Delete and all other operations that would change the memory
+// return ErrReadOnly and the memory file is never written. This can be used to
+// run a bot against the state file of another bot without risking to modify it.
+// Options that write to the file system (e.g. WithBackups(…)) cannot be
+// combined with a read-only memory.
+func WithReadOnly() Option {
+	return func(memory *Storage) error {
+		memory.readOnly = true
+		return nil
+	}
+}
+
+// WithReloadPolicy is a memory option that configures how conflicting keys are
+// resolved when the memory file is merged into the memory via Reload(). By
+// default the FileWins policy is used.
+func WithReloadPolicy(policy ReloadPolicy) Option {
+	return func(memory *Storage) error {
+		switch policy {
+		case FileWins, MemoryWins:
+			memory.reloadPolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("invalid reload policy %d", policy)
+		}
+	}
+}
+
+// WithMaxKeys is a memory option that limits the number of keys in the memory
+// to n. A change that would add a key beyond this limit fails with an error
+// that wraps ErrMemoryFull and the memory file is not written. Updating or
+// deleting existing keys is always allowed.
+func WithMaxKeys(n int) Option {
+	return func(memory *Storage) error {
+		if n <= 0 {
+			return fmt.Errorf("max keys must be positive but got %d", n)
+		}
+
+		memory.maxKeys = n
+		return nil
+	}
+}
+
+// WithLRU is a memory option that turns the memory into a cache of at most
+// maxEntries keys. If a change adds a key beyond this limit, the least recently
+// used keys are evicted and their removal is persisted together with the
+// change. Setting a key and reading it via Get(…) or GetMany(…) counts as using
+// it. Eviction is silent, i.e. the change succeeds
+// without an error, but watchers and change hooks see the evicted keys as
+// deleted.
+//
+// The order in which keys were used is only kept in memory. Once the memory
+// file is loaded again, all of its keys are considered equally old until they
+// are used. WithLRU cannot be combined with WithMaxKeys(…), which rejects new
+// keys instead of evicting old ones.
+func WithLRU(maxEntries int) Option {
+	return func(memory *Storage) error {
+		if maxEntries <= 0 {
+			return fmt.Errorf("max LRU entries must be positive but got %d", maxEntries)
+		}
+
+		memory.lruMaxEntries = maxEntries
+		return nil
+	}
+}
+
+// WithMaxBytes is a memory option that limits the total size of all keys and
+// values in the memory to b bytes. A change that would grow the memory beyond
+// this limit fails with an error that wraps ErrMemoryFull and the memory file
+// is not written. Changes that do not grow the memory are always allowed.
+// Unlike WithMaxSerializedSize(…), the limit does not depend on the encoding of
+// the memory file and it is checked without serializing the memory.
+func WithMaxBytes(b int64) Option {
+	return func(memory *Storage) error {
+		if b <= 0 {
+			return fmt.Errorf("max bytes must be positive but got %d", b)
+		}
+
+		memory.maxBytes = b
+		return nil
+	}
+}
+
+// WithKeyValidator is a memory option that calls the given function with the
+// key at the start of each operation that sets or deletes a key. If the
+// function returns an error, the operation fails with this error before the
+// memory is changed. This way a policy for keys (e.g. see ValidateKeys(…)) can
+// be enforced in a single place. Keys that are loaded from the memory file are
+// not validated.
+func WithKeyValidator(validate func(key string) error) Option {
+	return func(memory *Storage) error {
+		if validate == nil {
+			return errors.New("key validator must not be nil")
+		}
+
+		memory.keyValidator = validate
+		return nil
+	}
+}
+
+// WithValueChecksums is a memory option that writes a CRC-32 checksum of each
+// value to the memory file (or the append log, see WithAppendLog), so a single
+// corrupt value does not render the entire memory unusable. If the memory file
+// does not match its overall checksum when it is loaded, it is loaded anyway
+// and each value is verified once it is read via Get(…) or any other read
+// operation. This also applies to external values (see WithExternalValues).
+//
+// A value that does not match its checksum fails the read with an error that
+// wraps ErrValueCorrupt, unless dropCorrupt is true in which case the error is
+// logged and the key is treated as missing. The corrupt value is kept in the
+// memory file until the key is set or deleted. Value checksums cannot be
+// combined with WithCodec(…), WithShards(…) or WithLazyLoad().
+func WithValueChecksums(dropCorrupt bool) Option {
+	return func(memory *Storage) error {
+		memory.valueChecksums = true
+		memory.dropCorrupt = dropCorrupt
+		return nil
+	}
+}
+
+// WithKeyNormalizer is a memory option that passes all keys through the given
+// function before they are used, so keys that are considered equal by the
+// application are stored as the same key. The function is applied to the keys
+// of Set, Get, Delete and all other operations as well as to the prefixes of
+// GetPrefix, DeletePrefix and Namespace. It must be idempotent, i.e. normalizing
+// a normalized key must not change it.
+//
+// The keys of the memory file are normalized when it is loaded. If multiple
+// keys of the file normalize to the same key (e.g. because the file was edited
+// by hand), the collision is logged as a warning and only the first key in
+// sorted order is kept, unless strict is true in which case loading the file
+// fails. The file is written with the normalized keys by the next change.
+func WithKeyNormalizer(normalize func(key string) string, strict bool) Option {
+	return func(memory *Storage) error {
+		if normalize == nil {
+			return errors.New("key normalizer must not be nil")
+		}
+
+		memory.keyNormalizer = normalize
+		memory.strictCollisions = strict
+		return nil
+	}
+}
+
+// WithCaseInsensitiveKeys is a memory option that treats keys that only differ
+// in their case as the same key by converting all keys to lower case via
+// strings.ToLower. To customize the case folding (e.g. for non-ASCII keys), use
+// WithKeyNormalizer(…) with a different function instead.
+func WithCaseInsensitiveKeys(strict bool) Option {
+	return WithKeyNormalizer(strings.ToLower, strict)
+}
+
+// WithKeyHashing is a memory option that hashes all keys before they are
+// stored, so the memory file does not contain identifiers such as user IDs in
+// plain text, even if the values are encrypted via WithEncryptionKey(…). The
+// given function must be deterministic. If it is nil, the hex encoded SHA-256
+// hash of the key is used. The keys are hashed after they were normalized (see
+// WithKeyNormalizer).
+//
+// Set, Get, Delete and all other operations on individual keys accept the
+// original keys and hash them transparently. The original keys cannot be
+// recovered though, so Keys() returns the hashed keys, as do all other
+// functions that report keys (e.g. ForEach, watchers and change hooks). Prefix
+// queries such as GetPrefix(…) and DeletePrefix(…) fail with ErrKeysHashed.
+// The keys of a seed file (see WithSeedFS) or a background load seed are hashed
+// when they are loaded.
+//
+// Enabling this option for an existing memory file, or changing the hash
+// function, makes all stored keys unreachable. This option cannot be combined
+// with options that check the original keys, such as WithKeyValidator(…) and
+// WithMaxKeyLength(…), or with WithFallback(…).
+func WithKeyHashing(hash func(key string) string) Option {
+	return func(memory *Storage) error {
+		if hash == nil {
+			hash = sha256Key
+		}
+
+		memory.keyHasher = hash
+		return nil
+	}
+}
+
+// WithPathExpansion is a memory option that expands a leading ~/ in the path of
+// the memory file to the home directory of the current user and references to
+// environment variables (e.g. $HOME or ${STATE_DIR}) to their values. This is
+// useful if the path is configured by an operator. References to variables
+// that are not set, as well as any other $, are kept literally. The ~user form
+// is not supported. When loading multiple files via NewMemoryFromFiles(…), the
+// paths of the extra files are expanded as well.
+func WithPathExpansion() Option {
+	return func(memory *Storage) error {
+		path, err := expandPath(memory.path)
+		if err != nil {
+			return fmt.Errorf("failed to expand path of memory file: %w", err)
+		}
+
+		memory.path = path
+		memory.expandPaths = true
+		return nil
+	}
+}
+
+// WithMirrorPath is a memory option that writes each version of the memory
+// file to the given path as well, e.g. on another disk for disaster recovery.
+// The mirror receives exactly the same bytes as the memory file and it is only
+// written after the memory file was written successfully. If strict is false,
+// a failure to write the mirror is logged but the change succeeds anyway.
+// Otherwise the error is returned, even though the memory file itself was
+// updated.
+func WithMirrorPath(path string, strict bool) Option {
+	return func(memory *Storage) error {
+		if path == "" {
+			return errors.New("mirror path must not be empty")
+		}
+
+		memory.mirrorPath = path
+		memory.strictMirror = strict
+		return nil
+	}
+}
+
+// WithMaxValueBytes is a memory option that limits the size of each value to n
+// bytes. Setting a larger value fails with an error that wraps
+// ErrValueTooLarge before the memory is changed. Unlike WithMaxBytes(…), this
+// limits individual values rather than the total size of the memory.
+func WithMaxValueBytes(n int) Option {
+	return func(memory *Storage) error {
+		if n <= 0 {
+			return fmt.Errorf("max value bytes must be positive but got %d", n)
+		}
+
+		memory.maxValueBytes = n
+		return nil
+	}
+}
+
+// WithExternalValues is a memory option that writes each value that is larger
+// than threshold bytes to its own file in dir instead of the memory file, which
+// then only contains a reference to the file. This keeps the memory file small
+// if a few values are large. The values are still kept in memory, so Get does
+// not read the side files and they are only read when the memory file is
+// loaded. Side files are named after the SHA-256 hash of their value, so their
+// integrity is verified when they are read and a memory file that references
+// a missing or modified side file fails to load.
+//
+// Side files are always written before the memory file that references them
+// and removed after the memory file no longer does. If the process stops in
+// between, the orphaned side files are removed the next time the memory is
+// created. Hence dir should not be shared with other memories.
+func WithExternalValues(threshold int, dir string) Option {
+	return func(memory *Storage) error {
+		if threshold <= 0 {
+			return fmt.Errorf("external value threshold must be positive but got %d", threshold)
+		}
+
+		if dir == "" {
+			return errors.New("directory of external values must not be empty")
+		}
+
+		memory.externalThreshold = threshold
+		memory.externalDir = dir
+		return nil
+	}
+}
+
+// WithClock is a memory option that sets the clock which is used to determine
+// the current time, e.g. when keys expire (see SetWithTTL) or for the
+// timestamps that are written to the memory file (see WithTimestampHeader and
+// WithMetadataHeader). This allows tests to control the time instead of
+// sleeping. Note that expired keys are still removed by a background goroutine
+// that runs in real time. By default the system clock is used.
+func WithClock(clock Clock) Option {
+	return func(memory *Storage) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+
+		memory.clock = clock
+		return nil
+	}
+}
+
+// WithSharedInstance is a memory option that makes NewMemory(…) return the
+// memory that is already open for the same memory file in this process, if
+// any, instead of creating a second memory. Two memories of the same file
+// would overwrite each other's changes, since each of them rewrites the entire
+// memory file from its own data. Without this option, opening the same file
+// twice only logs a warning.
+//
+// All other options are ignored if an open memory is returned. The shared
+// memory is closed once each caller has called Close.
+func WithSharedInstance() Option {
+	return func(memory *Storage) error {
+		memory.sharedInstance = true
+		return nil
+	}
+}
+
+// WithOperationTimeout is a memory option that limits how long writing the
+// memory file (or its shards or append log) may take, which is useful if the
+// memory file is stored on an unreliable network file system. If a write does
+// not finish in time, the operation returns an error that wraps
+// ErrOperationTimeout, just like any other error of writing the memory file
+// (see PersistError), and the memory can be used again.
+//
+// Note that a hung write cannot be canceled. It continues in a goroutine that
+// is leaked for as long as the file system blocks it, possibly forever, and it
+// may still complete successfully later. Until then, all writes of the memory
+// file fail immediately with ErrOperationTimeout so two writes never run at the
+// same time. Since the changes stay in memory, they are written again by the
+// next successful write.
+func WithOperationTimeout(d time.Duration) Option {
+	return func(memory *Storage) error {
+		if d <= 0 {
+			return fmt.Errorf("operation timeout must be positive but got %s", d)
+		}
+
+		memory.operationTimeout = d
+		return nil
+	}
+}
+
+// WithLazyLoad is a memory option that does not decode the values of the
+// memory file when the memory is created. Instead, the file is only scanned to
+// find the position of each value, which is decoded from the file each time it
+// is requested via Get. This reduces the startup time and memory usage for
+// large memory files of which only a few keys are used. Keys() does not decode
+// any values either.
+//
+// All other operations decode all values once the first time they are called,
+// most notably each change and each operation that iterates over the values
+// (e.g. ForEach). From then on the memory behaves as if it was not loaded
+// lazily. The checksum of the memory file is only verified at this point, so a
+// corrupt memory file might only be detected then. Files that cannot be read
+// value by value (e.g. compressed files or files in an older format) are
+// loaded as usual.
+func WithLazyLoad() Option {
+	return func(memory *Storage) error {
+		memory.lazyLoad = true
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package file
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// recoverPanic must be called from a deferred function with the result of
+// recover(). The recovered value is logged and returned as error, so the caller
+// can decide how to continue. If there was no panic, nil is returned.
+//
+// User callbacks (e.g. the functions passed to WithValueInspector(…) or
+// WithConflictDetection(…)) should never panic. If they do anyway, the panic is
+// recovered so a misbehaving callback cannot crash the entire bot.
+func (m *Storage) recoverPanic(source string, r interface{}) error {
+	if r == nil {
+		return nil
+	}
+
+	m.logger.Error("Recovered from panic",
+		zap.String("source", source),
+		zap.Any("panic", r),
+		zap.Stack("stack"),
+	)
+
+	return fmt.Errorf("panic in %s: %v", source, r)
+}
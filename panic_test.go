@@ -0,0 +1,75 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_RecoverValueInspectorPanic(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	core, logs := observer.New(zap.ErrorLevel)
+	inspector := func(key string, value []byte) string {
+		panic("inspector failed")
+	}
+
+	mem, err := NewMemory(tempFile, WithLogger(zap.New(core)), WithValueInspector(inspector))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	entries := logs.FilterMessage("Recovered from panic").All()
+	require.Len(t, entries, 1)
+	require.Equal(t, "value inspector", entries[0].ContextMap()["source"])
+	require.Equal(t, "inspector failed", entries[0].ContextMap()["panic"])
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_RecoverConflictHandlerPanic(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	onConflict := func(path string) error {
+		panic("handler failed")
+	}
+
+	mem, err := NewMemory(tempFile, WithConflictDetection(onConflict))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, os.WriteFile(tempFile, []byte(`{}`), 0600))
+
+	err = mem.Set("foo", []byte("bar"))
+	require.True(t, errors.Is(err, ErrConflict), err)
+	require.Contains(t, err.Error(), "handler failed")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_RecoverBackgroundPanic(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	core, logs := observer.New(zap.ErrorLevel)
+	mem, err := NewMemory(tempFile, WithLogger(zap.New(core)))
+	require.NoError(t, err)
+
+	mem.background(func(stop <-chan struct{}) {
+		panic("background failed")
+	})
+
+	eventually(t, func() bool {
+		return logs.FilterMessage("Recovered from panic").Len() == 1
+	})
+
+	// the memory is still usable
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+}
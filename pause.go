@@ -0,0 +1,34 @@
+package file
+
+// Pause stops persisting changes until Resume() is called. While the memory is
+// paused, Set, Delete and all other changes are only applied in memory, which
+// is useful to import a large number of keys without rewriting the memory file
+// for each of them. Unlike Transaction(…), the changes are visible to all
+// readers immediately.
+//
+// If the process crashes while the memory is paused, all changes since the call
+// to Pause are lost. Calling Close() while paused still writes them. Pausing a
+// memory that is already paused or closed has no effect.
+func (m *Storage) Pause() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.paused = true
+}
+
+// Resume persists all changes that were made since Pause() was called and then
+// continues to persist each change as usual. If the memory file could not be
+// written, the error is returned but the memory is resumed anyway, so the
+// changes are written again with the next change or when the memory is closed.
+//
+// An error is also returned if this function is called after the memory was
+// closed already.
+func (m *Storage) Resume() error {
+	if err := m.lockData(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	m.paused = false
+	return m.flush()
+}
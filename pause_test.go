@@ -0,0 +1,78 @@
+package file
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_Pause(t *testing.T) {
+	store := new(bufferStore)
+	mem, err := NewMemoryWithStore(store)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	mem.Pause()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, mem.Set("key"+strconv.Itoa(i), []byte("value")))
+	}
+	_, err = mem.Delete("key0")
+	require.NoError(t, err)
+	assert.Zero(t, store.saves)
+
+	value, ok, err := mem.Get("key1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", string(value))
+
+	require.NoError(t, mem.Resume())
+	assert.Equal(t, 1, store.saves)
+
+	// changes are persisted immediately again
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	assert.Equal(t, 2, store.saves)
+
+	// resuming without changes does not write the file
+	mem.Pause()
+	require.NoError(t, mem.Resume())
+	assert.Equal(t, 2, store.saves)
+
+	mem2, err := NewMemoryWithStore(store)
+	require.NoError(t, err)
+	defer mem2.Close()
+
+	keys, err := mem2.Keys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 100)
+	assert.NotContains(t, keys, "key0")
+}
+
+func TestMemory_Pause_Close(t *testing.T) {
+	tempFile := tempFilePath()
+	// noinspection GoUnhandledErrorResult
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	mem.Pause()
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoFileExists(t, tempFile)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+
+	require.NoError(t, mem.Close())
+	mem.Pause()
+	require.ErrorIs(t, mem.Resume(), ErrClosed)
+}
@@ -0,0 +1,67 @@
+package file
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// PersistErrorPolicy decides what happens to a change if it was applied in
+// memory but the memory file could not be written, e.g. because the disk is
+// full.
+type PersistErrorPolicy int
+
+// The available persist error policies.
+const (
+	// Propagate returns the PersistError but keeps the change in memory, so
+	// the memory and the file differ until the memory is persisted again.
+	Propagate PersistErrorPolicy = iota
+
+	// Rollback returns the PersistError and reverts the change in memory, so
+	// the memory stays consistent with the file.
+	Rollback
+
+	// LogAndContinue logs the error and keeps the change in memory without
+	// returning an error. The change is written with the next change or when
+	// the memory is closed.
+	LogAndContinue
+)
+
+// rolledBackError marks an error of persisting a change that is reverted
+// because of the Rollback policy.
+type rolledBackError struct {
+	error
+}
+
+func (err rolledBackError) Unwrap() error {
+	return err.error
+}
+
+// isRolledBack reports whether the given error was returned for a change that
+// must be reverted because of the Rollback policy.
+func isRolledBack(err error) bool {
+	var rolledBack rolledBackError
+	return errors.As(err, &rolledBack)
+}
+
+// handlePersistError applies the configured PersistErrorPolicy to an error of
+// persisting a change that was not rejected. It returns the error that should
+// be returned to the caller. The caller must hold the write lock.
+func (m *Storage) handlePersistError(err error) error {
+	switch m.persistErrorPolicy {
+	case Rollback:
+		// the caller reverts the change so the memory matches the file again
+		return rolledBackError{err}
+	case LogAndContinue:
+		m.logger.Error("Failed to persist memory, keeping the change in memory",
+			zap.String("path", m.path),
+			zap.Error(err),
+		)
+		m.dirty = true
+		return nil
+	default:
+		// the change was applied in memory but not written to disk
+		m.dirty = true
+		return err
+	}
+}
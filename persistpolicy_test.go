@@ -0,0 +1,106 @@
+package file
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWithPersistErrorPolicy_Propagate(t *testing.T) {
+	store := new(bufferStore)
+	mem, err := NewMemoryWithStore(store, WithPersistErrorPolicy(Propagate))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	store.failing = true
+	err = mem.Set("foo", []byte("changed"))
+	require.ErrorIs(t, err, ErrPersist)
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "changed", string(value))
+}
+
+func TestWithPersistErrorPolicy_Rollback(t *testing.T) {
+	store := new(bufferStore)
+	mem, err := NewMemoryWithStore(store, WithPersistErrorPolicy(Rollback))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	saved := string(store.content)
+
+	store.failing = true
+	err = mem.Set("foo", []byte("changed"))
+	require.ErrorIs(t, err, ErrPersist)
+	assert.EqualError(t, err, "failed to open store to persist data: store is broken")
+
+	err = mem.Set("new", []byte("value"))
+	require.ErrorIs(t, err, ErrPersist)
+
+	_, err = mem.Delete("foo")
+	require.ErrorIs(t, err, ErrPersist)
+
+	err = mem.SetMany(map[string][]byte{"a": []byte("1"), "foo": []byte("2")})
+	require.ErrorIs(t, err, ErrPersist)
+
+	values, err := mem.GetMany([]string{"foo", "new", "a"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"foo": []byte("bar")}, values)
+	assert.Equal(t, saved, string(store.content))
+
+	// nothing is left to write when the memory is closed
+	require.NoError(t, mem.Close())
+}
+
+func TestWithPersistErrorPolicy_LogAndContinue(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	store := new(bufferStore)
+	mem, err := NewMemoryWithStore(store,
+		WithPersistErrorPolicy(LogAndContinue),
+		WithLogger(zap.New(core)),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	store.failing = true
+	require.NoError(t, mem.Set("foo", []byte("changed")))
+	require.Equal(t, 1, logs.FilterMessage("Failed to persist memory, keeping the change in memory").Len())
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "changed", string(value))
+
+	// the change is written once the store works again
+	store.failing = false
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemoryWithStore(store)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err = mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "changed", string(value))
+}
+
+func TestWithPersistErrorPolicy_Invalid(t *testing.T) {
+	_, err := NewMemoryWithStore(new(bufferStore), WithPersistErrorPolicy(42))
+	require.EqualError(t, err, "invalid persist error policy 42")
+
+	_, err = NewMemoryWithStore(new(bufferStore),
+		WithPersistErrorPolicy(Rollback),
+		WithFlushInterval(time.Second),
+	)
+	require.EqualError(t, err, "the rollback persist error policy cannot be combined with a flush interval")
+}
@@ -0,0 +1,67 @@
+package file
+
+import (
+	"context"
+	"time"
+)
+
+// SetAndGetPrevious is like Set but it also returns the value the key had
+// before it was changed. The boolean return value indicates whether the key
+// existed at all, so callers can distinguish an empty value from a new key.
+// Expired keys are reported as absent, just like with Get(…).
+//
+// If the memory file could not be written, the error is returned together
+// with the previous value, since the change is still applied in memory unless
+// it was rejected (e.g. via WithMaxSerializedSize).
+func (m *Storage) SetAndGetPrevious(key string, value []byte) ([]byte, bool, error) {
+	key = m.normalizeKey(key)
+
+	if err := m.checkKey(key); err != nil {
+		return nil, false, err
+	}
+
+	if err := m.checkValue(key, value); err != nil {
+		return nil, false, err
+	}
+
+	m.inspectValue(key, value)
+	stored, err := m.sealValue(value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := m.now()
+	prev, err := m.set(context.Background(), key, value, stored)
+	return m.previous(prev, now, err)
+}
+
+// DeleteAndGet is like Delete but it also returns the value the key had before
+// it was removed. The boolean return value indicates whether the key existed.
+// Expired keys are reported as absent, just like with Get(…).
+func (m *Storage) DeleteAndGet(key string) ([]byte, bool, error) {
+	key = m.normalizeKey(key)
+
+	if err := m.validateKey(key); err != nil {
+		return nil, false, err
+	}
+
+	now := m.now()
+	prev, err := m.delete(context.Background(), key)
+	return m.previous(prev, now, err)
+}
+
+// previous opens the value of the given state that was returned by set or
+// delete. The error of the change is returned as is, unless the value could not
+// be opened.
+func (m *Storage) previous(prev entry, now time.Time, err error) ([]byte, bool, error) {
+	if !prev.exists || (prev.hasTTL && !now.Before(prev.expires)) {
+		return nil, false, err
+	}
+
+	value, openErr := m.openValue(prev.value)
+	if openErr != nil {
+		return nil, false, openErr
+	}
+
+	return value, true, err
+}
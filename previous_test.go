@@ -0,0 +1,91 @@
+package file
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_SetAndGetPrevious(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	prev, existed, err := mem.SetAndGetPrevious("test", []byte("foo"))
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Nil(t, prev)
+
+	prev, existed, err = mem.SetAndGetPrevious("test", []byte("bar"))
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, "foo", string(prev))
+
+	require.NoError(t, mem.Set("empty", []byte{}))
+	prev, existed, err = mem.SetAndGetPrevious("empty", []byte("baz"))
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Empty(t, prev)
+
+	value, ok, err := mem.Get("test")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "bar", string(value))
+
+	require.NoError(t, mem.Close())
+}
+
+func TestMemory_DeleteAndGet(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("test", []byte("foo")))
+
+	value, existed, err := mem.DeleteAndGet("test")
+	require.NoError(t, err)
+	require.True(t, existed)
+	require.Equal(t, "foo", string(value))
+
+	value, existed, err = mem.DeleteAndGet("test")
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Nil(t, value)
+
+	_, ok, err := mem.Get("test")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, mem.Close())
+}
+
+func TestMemory_GetPrevious_Expired(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	clock := &fakeClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	mem, err := NewMemory(tempFile, WithClock(clock))
+	require.NoError(t, err)
+
+	require.NoError(t, mem.SetWithTTL("a", []byte("foo"), time.Minute))
+	require.NoError(t, mem.SetWithTTL("b", []byte("bar"), time.Minute))
+	clock.Advance(time.Hour)
+
+	prev, existed, err := mem.SetAndGetPrevious("a", []byte("new"))
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Nil(t, prev)
+
+	prev, existed, err = mem.DeleteAndGet("b")
+	require.NoError(t, err)
+	require.False(t, existed)
+	require.Nil(t, prev)
+
+	require.NoError(t, mem.Close())
+}
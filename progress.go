@@ -0,0 +1,45 @@
+package file
+
+import "io"
+
+// loadProgressStep is the number of bytes after which the callback of
+// WithLoadProgress(…) is called again.
+const loadProgressStep = 1 << 20
+
+// progressReader calls a callback with the number of bytes that were read so
+// far (see WithLoadProgress).
+type progressReader struct {
+	r        io.Reader
+	n        int64
+	total    int64
+	reported int64
+	progress func(bytesRead, totalBytes int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.n-r.reported >= loadProgressStep || (err == io.EOF && r.n > r.reported) {
+		r.reported = r.n
+		r.progress(r.n, r.total)
+	}
+	return n, err
+}
+
+// withLoadProgress wraps the reader of the file at the given path so the
+// callback of WithLoadProgress(…) is called while it is read. If the size of
+// the file cannot be determined (e.g. with a custom Store), the total is -1.
+func (m *Storage) withLoadProgress(f io.Reader, path string) io.Reader {
+	if m.loadProgress == nil {
+		return f
+	}
+
+	total := int64(-1)
+	if _, ok := m.store.(fileStore); ok || path != m.path {
+		if info, err := m.fsys.Stat(path); err == nil {
+			total = info.Size()
+		}
+	}
+
+	return &progressReader{r: f, total: total, progress: m.loadProgress}
+}
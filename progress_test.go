@@ -0,0 +1,63 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithLoadProgress(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("big", make([]byte, 3*loadProgressStep)))
+	require.NoError(t, mem.Close())
+
+	info, err := os.Stat(tempFile)
+	require.NoError(t, err)
+
+	var calls [][2]int64
+	mem, err = NewMemory(tempFile, WithLoadProgress(func(bytesRead, totalBytes int64) {
+		calls = append(calls, [2]int64{bytesRead, totalBytes})
+	}))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	// the base64 encoded value is larger than four steps
+	require.GreaterOrEqual(t, len(calls), 4)
+	for i, call := range calls {
+		assert.Equal(t, info.Size(), call[1])
+		if i > 0 {
+			assert.Greater(t, call[0], calls[i-1][0])
+		}
+	}
+	assert.Equal(t, info.Size(), calls[len(calls)-1][0])
+
+	value, _, err := mem.Get("big")
+	require.NoError(t, err)
+	assert.Len(t, value, 3*loadProgressStep)
+}
+
+func TestWithLoadProgress_Store(t *testing.T) {
+	store := new(bufferStore)
+	mem, err := NewMemoryWithStore(store)
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	var calls [][2]int64
+	mem, err = NewMemoryWithStore(store, WithLoadProgress(func(bytesRead, totalBytes int64) {
+		calls = append(calls, [2]int64{bytesRead, totalBytes})
+	}))
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	require.Len(t, calls, 1)
+	assert.Equal(t, int64(-1), calls[0][1])
+	assert.Equal(t, int64(len(store.content)), calls[0][0])
+}
@@ -0,0 +1,100 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+)
+
+// rawStringsDocument is the structure of a memory file that was written with
+// WithRawStrings(). Its data field shadows the data field of the document.
+type rawStringsDocument struct {
+	*document
+	Data map[string]json.Marshaler `json:"data"`
+}
+
+// newRawStringsDocument returns the given document with its data encoded as
+// text values. If rawHTML is true, HTML characters in the values are not
+// escaped (see WithRawHTML).
+func newRawStringsDocument(doc *document, rawHTML bool) rawStringsDocument {
+	data := make(map[string]json.Marshaler, len(doc.Data))
+	for key, value := range doc.Data {
+		if rawHTML {
+			data[key] = rawHTMLValue(value)
+		} else {
+			data[key] = textValue(value)
+		}
+	}
+
+	return rawStringsDocument{document: doc, Data: data}
+}
+
+// textValue is a value that is encoded as plain JSON string if it is valid
+// UTF-8. Any other value is encoded as an object with a single "base64" field,
+// so the two encodings can always be told apart.
+type textValue []byte
+
+// binaryValue is the JSON encoding of a textValue that is not valid UTF-8.
+type binaryValue struct {
+	Base64 []byte `json:"base64"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (v textValue) MarshalJSON() ([]byte, error) {
+	switch {
+	case v == nil:
+		return []byte("null"), nil
+	case utf8.Valid(v):
+		return json.Marshal(string(v))
+	default:
+		return json.Marshal(binaryValue{Base64: v})
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *textValue) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	switch {
+	case bytes.Equal(b, []byte("null")):
+		*v = nil
+		return nil
+	case len(b) > 0 && b[0] == '"':
+		var s string
+		err := json.Unmarshal(b, &s)
+		*v = textValue(s)
+		return err
+	case len(b) > 0 && b[0] == '{':
+		var bin binaryValue
+		err := json.Unmarshal(b, &bin)
+		if err == nil && bin.Base64 == nil {
+			return errors.New("binary value has no base64 field")
+		}
+		*v = bin.Base64
+		return err
+	default:
+		return errors.New("value must be a string or an object")
+	}
+}
+
+// rawHTMLValue is a textValue whose plain JSON string does not escape the
+// characters <, > and & (see WithRawHTML).
+type rawHTMLValue []byte
+
+// MarshalJSON implements json.Marshaler.
+func (v rawHTMLValue) MarshalJSON() ([]byte, error) {
+	if v == nil || !utf8.Valid(v) {
+		return textValue(v).MarshalJSON()
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(string(v))
+	if err != nil {
+		return nil, err
+	}
+
+	// the encoder terminates each value with a newline
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,93 @@
+package file
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithRawStrings(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	values := map[string][]byte{
+		"text":    []byte("hello wörld"),
+		"binary":  {0xff, 0x00, 0xfe},
+		"empty":   {},
+		"nil":     nil,
+		"braces":  []byte(`{"base64":"Zm9v"}`),
+		"unicode": []byte("日本語"),
+	}
+
+	mem, err := NewMemory(tempFile, WithRawStrings())
+	require.NoError(t, err)
+	require.NoError(t, mem.SetMany(values))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+
+	var doc struct {
+		RawStrings bool                       `json:"raw_strings"`
+		Data       map[string]json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(content, &doc))
+	assert.True(t, doc.RawStrings)
+	assert.Equal(t, `"hello wörld"`, string(doc.Data["text"]))
+	assert.Equal(t, `{"base64":"/wD+"}`, string(doc.Data["binary"]))
+	assert.Equal(t, `""`, string(doc.Data["empty"]))
+	assert.Equal(t, `null`, string(doc.Data["nil"]))
+
+	// the file can be loaded without the option as well
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	for key, expected := range values {
+		value, ok, err := mem.Get(key)
+		require.NoError(t, err)
+		require.True(t, ok, key)
+		assert.Equal(t, expected, value, key)
+	}
+}
+
+func TestWithRawStrings_InvalidValue(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	content := `{"version":2,"raw_strings":true,"data":{"foo":42}}`
+	require.NoError(t, os.WriteFile(tempFile, []byte(content), 0660))
+
+	_, err := NewMemory(tempFile)
+	require.EqualError(t, err, "failed decode data as JSON: invalid data field: value must be a string or an object")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithRawHTML(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	link := []byte(`<a href="https://example.com/?a=1&b=2">link</a>`)
+	mem, err := NewMemory(tempFile, WithRawStrings(), WithRawHTML())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("<link>", link))
+	require.NoError(t, mem.Close())
+
+	content, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"<link>":"<a href=\"https://example.com/?a=1&b=2\">link</a>"`)
+	assert.NotContains(t, string(content), `\u003c`)
+
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("<link>")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, link, value)
+}
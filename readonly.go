@@ -0,0 +1,38 @@
+package file
+
+import "errors"
+
+// ErrReadOnly is returned by all operations that would change a memory that was
+// created with the WithReadOnly() option.
+var ErrReadOnly = errors.New("memory is read-only")
+
+// checkReadOnlyOptions returns an error if the memory is read-only but was
+// configured with options that write to the file system.
+func (m *Storage) checkReadOnlyOptions() error {
+	if !m.readOnly {
+		return nil
+	}
+
+	switch {
+	case m.flushInterval > 0:
+		return errors.New("a read-only memory cannot be combined with a flush interval")
+	case m.checkWritable:
+		return errors.New("a read-only memory cannot be combined with a writable check")
+	case m.createDirs:
+		return errors.New("a read-only memory cannot be combined with creating directories")
+	case m.backups > 0:
+		return errors.New("a read-only memory cannot be combined with backups")
+	case m.keyIndexPath != "":
+		return errors.New("a read-only memory cannot be combined with a key index sidecar")
+	case m.mirrorPath != "":
+		return errors.New("a read-only memory cannot be combined with a mirror")
+	case m.shards > 0:
+		return errors.New("a read-only memory cannot be combined with sharding")
+	case m.logRatio > 0:
+		return errors.New("a read-only memory cannot be combined with an append log")
+	case m.corruptFilePolicy == StartEmptyKeep:
+		return errors.New("a read-only memory cannot keep a corrupt memory file")
+	}
+
+	return nil
+}
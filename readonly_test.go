@@ -0,0 +1,63 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithReadOnly(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	before, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+
+	mem, err = NewMemory(tempFile, WithReadOnly())
+	require.NoError(t, err)
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bar"), value)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+
+	err = mem.Set("foo", []byte("baz"))
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	_, err = mem.Delete("foo")
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	err = mem.SetMany(map[string][]byte{"a": []byte("b")})
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	_, err = mem.Increment("counter", 1)
+	assert.True(t, errors.Is(err, ErrReadOnly))
+
+	value, _, err = mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	require.NoError(t, mem.Close())
+
+	after, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestWithReadOnly_IncompatibleOptions(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithReadOnly(), WithBackups(2))
+	assert.EqualError(t, err, "a read-only memory cannot be combined with backups")
+}
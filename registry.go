@@ -0,0 +1,103 @@
+package file
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// registry contains all memories of this process that are open, by the
+// absolute paths of their memory files. It is used to detect when the same
+// memory file is opened twice (see WithSharedInstance).
+var registry = struct {
+	sync.Mutex
+	memories map[string]*Storage
+}{memories: map[string]*Storage{}}
+
+// openMemory creates a new memory via the given function, unless a memory for
+// the same memory file is open already. In this case the open memory is
+// returned if WithSharedInstance() is used. Otherwise a warning is logged, since
+// both memories rewrite the entire memory file and thus overwrite each other's
+// changes.
+func openMemory(path string, opts []Option, create func() (*Storage, error)) (*Storage, error) {
+	probe, err := probeOptions(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if probe.path == "" {
+		// memories with a custom store do not have a memory file
+		return create()
+	}
+
+	key := absolutePath(probe.path)
+
+	// the registry is locked while the memory is created so concurrent calls
+	// cannot open the same file twice
+	registry.Lock()
+	defer registry.Unlock()
+
+	if open := registry.memories[key]; open != nil {
+		if probe.sharedInstance {
+			open.refs++
+			probe.logger.Debug("Using shared instance of memory", zap.String("path", key))
+			return open, nil
+		}
+
+		probe.logger.Warn("Memory file is already used by another memory of this process; changes may be lost",
+			zap.String("path", key),
+		)
+		return create()
+	}
+
+	memory, err := create()
+	if err != nil {
+		return nil, err
+	}
+
+	memory.registryKey = key
+	memory.refs = 1
+	registry.memories[key] = memory
+	return memory, nil
+}
+
+// release removes a user of the memory from the registry. It returns true if
+// the memory should be closed, i.e. if there are no users left.
+func (m *Storage) release() bool {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if m.registryKey == "" {
+		return true
+	}
+
+	m.refs--
+	if m.refs > 0 {
+		return false
+	}
+
+	delete(registry.memories, m.registryKey)
+	m.registryKey = ""
+	return true
+}
+
+// probeOptions applies the options to an empty memory so their values can be
+// inspected before the actual memory is created.
+func probeOptions(path string, opts []Option) (*Storage, error) {
+	probe := &Storage{path: path, fsys: osFS{}}
+	for _, opt := range opts {
+		if err := opt(probe); err != nil {
+			return nil, err
+		}
+	}
+
+	if probe.logger == nil {
+		probe.logger = zap.NewNop()
+	}
+
+	if probe.name != "" {
+		probe.logger = probe.logger.With(zap.String("memory", probe.name))
+	}
+
+	return probe, nil
+}
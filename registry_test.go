@@ -0,0 +1,61 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithSharedInstance(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	first, err := NewMemory(tempFile, WithSharedInstance())
+	require.NoError(t, err)
+
+	second, err := NewMemory(tempFile, WithSharedInstance())
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+
+	require.NoError(t, first.Set("foo", []byte("bar")))
+	require.NoError(t, first.Close())
+
+	// the memory stays open until the last user has closed it
+	value, ok, err := second.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bar"), value)
+
+	require.NoError(t, second.Close())
+	assert.False(t, second.IsOpen())
+
+	// a closed memory is never shared
+	third, err := NewMemory(tempFile, WithSharedInstance())
+	require.NoError(t, err)
+	defer third.Close()
+	assert.NotSame(t, first, third)
+	assert.True(t, third.IsOpen())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestNewMemory_SamePathTwice(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	first, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer first.Close()
+
+	core, logs := observer.New(zap.WarnLevel)
+	second, err := NewMemory(tempFile, WithLogger(zap.New(core)))
+	require.NoError(t, err)
+	defer second.Close()
+
+	assert.NotSame(t, first, second)
+	assert.Equal(t, 1, logs.FilterMessage("Memory file is already used by another memory of this process; changes may be lost").Len())
+}
@@ -0,0 +1,119 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ReloadPolicy decides which value is kept when a key that is read via
+// Reload() also exists in memory with a different value.
+type ReloadPolicy int
+
+// The available reload policies.
+const (
+	// FileWins replaces the value in memory with the value of the file.
+	FileWins ReloadPolicy = iota
+
+	// MemoryWins keeps the value in memory and only adds the keys of the file
+	// which do not exist in memory yet.
+	MemoryWins
+)
+
+// Reload reads the memory file again and merges it into the data in memory.
+// This way a long running process can pick up changes that other tools have
+// written to the memory file without a restart. Keys that only exist in memory
+// are kept, so they are written again with the next change. If a key exists in
+// both, the conflict is resolved using the configured ReloadPolicy (see
+// WithReloadPolicy). By default the value of the file wins. Watchers of keys
+// whose values were changed by the reload are notified (see Watch).
+//
+// Reload does not write the memory file and it also works for read-only
+// memories (see WithReadOnly). An error is returned if the file cannot be
+// decoded, in which case the memory is not changed, or if the memory was
+// closed already.
+func (m *Storage) Reload() error {
+	if m.shards > 0 || m.logRatio > 0 {
+		return errors.New("memories with shards or an append log cannot be reloaded")
+	}
+
+	var version uint64
+	if m.versionFile {
+		// read the version first so we never remember a version that is newer
+		// than the data we have read
+		version = m.readVersion()
+	}
+
+	if err := m.lockData(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	// a write that is still in progress would overwrite the reloaded data
+	m.awaitWrites()
+
+	f, err := m.readFile(m.path)
+	if err == nil && f != nil {
+		err = m.checkLoadedKeys(m.path, f.data)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to reload memory file: %w", err)
+	}
+
+	if f == nil {
+		// there is nothing to merge if the file does not exist (yet)
+		return nil
+	}
+
+	data := make(map[string][]byte, len(m.data))
+	for key, value := range m.data {
+		data[key] = value
+	}
+
+	var numChanged int
+	for key, value := range f.data {
+		if _, ok := m.data[key]; ok && m.reloadPolicy == MemoryWins {
+			continue
+		}
+
+		data[key] = value
+		delete(m.versions, key)
+		delete(m.expires, key)
+		delete(m.types, key)
+		if v, ok := f.versions[key]; ok {
+			m.setVersion(key, v)
+		}
+		if t, ok := f.expires[key]; ok {
+			m.setExpiry(key, t)
+		}
+		if contentType, ok := f.types[key]; ok {
+			m.setContentType(key, contentType)
+		}
+		if checksum, ok := f.checksums[key]; ok {
+			if m.loadedChecksums == nil {
+				m.loadedChecksums = map[string]uint32{}
+			}
+			m.loadedChecksums[key] = checksum
+		} else {
+			delete(m.loadedChecksums, key)
+		}
+		numChanged++
+	}
+
+	m.notifyReload(m.data, data)
+	m.data = data
+	m.indexValues()
+	m.diskChecksum = f.checksum
+	if m.versionFile {
+		m.version = version
+	}
+
+	m.logger.Info("Reloaded memory file",
+		zap.String("path", m.path),
+		zap.Int("num_keys_from_file", numChanged),
+	)
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestReload(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("both", []byte("bot")))
+	require.NoError(t, mem.Set("bot-only", []byte("bot")))
+
+	// another tool rewrites the file without the keys of the bot
+	other, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, other.Set("both", []byte("cli")))
+	_, err = other.Delete("bot-only")
+	require.NoError(t, err)
+	require.NoError(t, other.Set("cli-only", []byte("cli")))
+	require.NoError(t, other.Close())
+
+	events, unsubscribe := mem.Watch("both")
+	defer unsubscribe()
+
+	require.NoError(t, mem.Reload())
+	assert.Equal(t, map[string][]byte{
+		"both":     []byte("cli"),
+		"bot-only": []byte("bot"),
+		"cli-only": []byte("cli"),
+	}, mem.data)
+	assert.Equal(t, WatchEvent{Value: []byte("cli")}, <-events)
+
+	require.NoError(t, mem.Close())
+	assert.EqualError(t, mem.Reload(), "brain was already shut down")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestReload_MemoryWins(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithReloadPolicy(MemoryWins))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("both", []byte("bot")))
+
+	other, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, other.Set("both", []byte("cli")))
+	require.NoError(t, other.Set("cli-only", []byte("cli")))
+	require.NoError(t, other.Close())
+
+	require.NoError(t, mem.Reload())
+	assert.Equal(t, map[string][]byte{
+		"both":     []byte("bot"),
+		"cli-only": []byte("cli"),
+	}, mem.data)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestReload_ValueChecksums(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	other, err := NewMemory(tempFile, WithValueChecksums(false))
+	require.NoError(t, err)
+	require.NoError(t, other.Set("k", []byte("old")))
+
+	mem, err := NewMemory(tempFile, WithValueChecksums(false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, other.Set("k", []byte("new")))
+	require.NoError(t, other.Close())
+
+	require.NoError(t, mem.Reload())
+	value, ok, err := mem.Get("k")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("new"), value)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestReload_ReadOnly(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithReadOnly())
+	require.NoError(t, err)
+	defer mem.Close()
+
+	other, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	require.NoError(t, other.Set("foo", []byte("bar")))
+	require.NoError(t, other.Close())
+
+	require.NoError(t, mem.Reload())
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bar"), value)
+}
+
+func TestWithReloadPolicy_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithReloadPolicy(42))
+	assert.EqualError(t, err, "invalid reload policy 42")
+}
@@ -0,0 +1,85 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyExists is returned by Rename(…) if the new key exists already and
+// overwriting it was not allowed.
+var ErrKeyExists = errors.New("key exists already")
+
+// Rename moves the value of oldKey to newKey and persists the memory once, so
+// there is no point in time where both or neither of the keys exist. The value
+// keeps its version and TTL. The boolean return value indicates whether
+// oldKey existed. If it did not exist (or expired already), the function does
+// nothing and returns without an error.
+//
+// If newKey exists already, it is replaced if overwrite is true. Otherwise,
+// nothing is changed and an error that wraps ErrKeyExists is returned. Renaming
+// a key to itself does not change anything.
+//
+// If the memory file cannot be written because the change was rejected (e.g.
+// via WithMaxSerializedSize), both keys are reverted. If the file could not be
+// written for any other reason, the error is returned but the key is renamed
+// in memory, just like with Set.
+func (m *Storage) Rename(oldKey, newKey string, overwrite bool) (bool, error) {
+	oldKey, newKey = m.normalizeKey(oldKey), m.normalizeKey(newKey)
+
+	if err := m.validateKey(oldKey); err != nil {
+		return false, err
+	}
+
+	if err := m.checkKey(newKey); err != nil {
+		return false, err
+	}
+
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.unlock()
+
+	now := m.now()
+	source := m.entry(oldKey)
+	if !source.exists || m.isExpired(oldKey, now) {
+		return false, nil
+	}
+
+	if oldKey == newKey {
+		return true, nil
+	}
+
+	target := m.entry(newKey)
+	if target.exists && !m.isExpired(newKey, now) && !overwrite {
+		return true, fmt.Errorf("failed to rename key %q: %w: %q", oldKey, ErrKeyExists, newKey)
+	}
+
+	value, err := m.openValue(source.value)
+	if err != nil {
+		return true, err
+	}
+
+	changes := []Change{{Key: oldKey, Deleted: true}, {Key: newKey}}
+	if err := m.checkChangeLimits(changes, [][]byte{nil, source.value}); err != nil {
+		return true, err
+	}
+
+	m.remove(oldKey)
+	m.restore(newKey, source)
+	m.touch(newKey)
+
+	err = m.commit(context.Background())
+	if isRejected(err) {
+		// the file was not written so we revert the change to stay consistent
+		m.restore(oldKey, source)
+		m.restore(newKey, target)
+	}
+
+	if err == nil {
+		m.changed(oldKey, WatchEvent{Deleted: true})
+		m.changed(newKey, WatchEvent{Value: value})
+	}
+
+	return true, err
+}
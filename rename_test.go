@@ -0,0 +1,170 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Rename(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("old", []byte("foo")))
+	events, cancel := mem.Watch("new")
+	defer cancel()
+
+	ok, err := mem.Rename("old", "new", false)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, WatchEvent{Value: []byte("foo")}, <-events)
+
+	ok, err = mem.Rename("old", "new", false)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = mem.Rename("new", "new", false)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NoError(t, mem.Close())
+
+	// the rename was persisted
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"new"}, keys)
+
+	value, ok, err := mem.Get("new")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "foo", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Rename_Exists(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("foo")))
+	require.NoError(t, mem.Set("b", []byte("bar")))
+
+	ok, err := mem.Rename("a", "b", false)
+	require.True(t, errors.Is(err, ErrKeyExists), err)
+	require.True(t, ok)
+
+	value, _, err := mem.Get("b")
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(value))
+
+	ok, err = mem.Rename("a", "b", true)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	value, _, err = mem.Get("b")
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(value))
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"b"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Rename_Metadata(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	clock := &fakeClock{now: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	mem, err := NewMemory(tempFile, WithClock(clock))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	version, err := mem.SetWithVersion("versioned", []byte("foo"), 0)
+	require.NoError(t, err)
+
+	ok, err := mem.Rename("versioned", "moved", false)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, got, ok, err := mem.GetWithVersion("moved")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, version, got)
+
+	require.NoError(t, mem.SetWithTTL("ttl", []byte("bar"), time.Minute))
+	ok, err = mem.Rename("ttl", "moved-ttl", false)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	clock.Advance(time.Hour)
+	_, ok, err = mem.Get("moved-ttl")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// expired keys cannot be renamed and can be overwritten
+	require.NoError(t, mem.SetWithTTL("expired", []byte("baz"), time.Minute))
+	clock.Advance(time.Hour)
+	ok, err = mem.Rename("expired", "other", false)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = mem.Rename("moved", "moved-ttl", false)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Rename_MaxKeys(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMaxKeys(2))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("foo")))
+	require.NoError(t, mem.Set("b", []byte("bar")))
+
+	// renaming does not grow the memory, so it is allowed with a full memory
+	ok, err := mem.Rename("a", "c", false)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "c"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Rename_Rejected(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithMaxSerializedSize(120))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("a", []byte("foo")))
+
+	_, err = mem.Rename("a", strings.Repeat("x", 100), false)
+	require.True(t, errors.Is(err, ErrMaxSerializedSize), err)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, keys)
+}
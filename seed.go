@@ -0,0 +1,50 @@
+package file
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// readSeedFile reads the initial content of the memory from the file system of
+// WithSeedFS. The seed is decoded just like the memory file itself, but since
+// it is not the memory file, the memory still considers its file missing.
+func (m *Storage) readSeedFile() (_ *fileContent, err error) {
+	_, span := m.startSpan(context.Background(), "load", attribute.String("seed", m.seedName))
+	defer func() { endSpan(span, err) }()
+
+	f, err := m.seedFS.Open(m.seedName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open seed file: %w", err)
+	}
+
+	defer f.Close()
+
+	content, err := m.decodeFile(f, m.seedName, span)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load seed file: %w", err)
+	}
+
+	m.hashSeedKeys(content)
+	content.checksum = [sha256.Size]byte{}
+	content.seeded = true
+	return content, nil
+}
+
+// checkSeedOptions returns an error if a seed file system was combined with an
+// option that does not load the memory from a single memory file.
+func (m *Storage) checkSeedOptions() error {
+	switch {
+	case m.seedFS == nil:
+		return nil
+	case m.shards > 0:
+		return errors.New("a seed file cannot be combined with sharding")
+	case m.logRatio > 0:
+		return errors.New("a seed file cannot be combined with an append log")
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package file
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithSeedFS(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	fsys := fstest.MapFS{
+		"defaults.json": {Data: []byte(`{"greeting":"aGVsbG8="}`)},
+	}
+
+	mem, err := NewMemory(tempFile, WithSeedFS(fsys, "defaults.json"))
+	require.NoError(t, err)
+
+	value, ok, err := mem.Get("greeting")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(value))
+
+	// the memory file is only written by the first change
+	assert.NoFileExists(t, tempFile)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	// once the memory file exists, the seed is not used anymore
+	fsys["defaults.json"] = &fstest.MapFile{Data: []byte(`{"other":"Zm9v"}`)}
+	mem, err = NewMemory(tempFile, WithSeedFS(fsys, "defaults.json"))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo", "greeting"}, keys)
+}
+
+func TestWithSeedFS_Errors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"broken.json": {Data: []byte(`{"greeting":`)},
+	}
+
+	_, err := NewMemory(tempFilePath(), WithSeedFS(fsys, "broken.json"))
+	assert.EqualError(t, err, "failed to load seed file: failed decode data as JSON: unexpected EOF")
+
+	_, err = NewMemory(tempFilePath(), WithSeedFS(fsys, "missing.json"))
+	assert.EqualError(t, err, "failed to open seed file: open missing.json: file does not exist")
+
+	_, err = NewMemory(tempFilePath(), WithSeedFS(nil, "defaults.json"))
+	assert.EqualError(t, err, "seed file system must not be nil")
+}
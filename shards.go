@@ -0,0 +1,272 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// checkShardOptions returns an error if sharding was combined with an option
+// that only works with a single memory file.
+func (m *Storage) checkShardOptions() error {
+	if m.shards == 0 {
+		return nil
+	}
+
+	switch {
+	case m.versionFile:
+		return errors.New("sharding cannot be combined with a version file")
+	case m.onConflict != nil:
+		return errors.New("sharding cannot be combined with conflict detection")
+	case m.fileWatchInterval > 0:
+		return errors.New("sharding cannot be combined with a file watch")
+	case m.backups > 0:
+		return errors.New("sharding cannot be combined with backups")
+	case m.loaded != nil:
+		return errors.New("sharding cannot be combined with a background load")
+	case m.corruptFilePolicy != FailFast:
+		return errors.New("sharding cannot be combined with a corrupt file policy")
+	case m.mirrorPath != "":
+		return errors.New("sharding cannot be combined with a mirror")
+	}
+
+	return nil
+}
+
+// shardOf returns the index of the shard that stores the given key.
+func (m *Storage) shardOf(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(m.shards))
+}
+
+// shardPrefix returns the common prefix of the paths of all shards.
+func (m *Storage) shardPrefix() string {
+	return strings.TrimSuffix(m.path, ".json") + "."
+}
+
+// shardPath returns the path of the file that stores the given shard.
+func (m *Storage) shardPath(shard int) string {
+	return m.shardPrefix() + strconv.Itoa(shard) + ".json"
+}
+
+// markShard remembers that the shard of the given key must be written by the
+// next persist. The caller must hold the write lock.
+func (m *Storage) markShard(key string) {
+	if m.dirtyShards == nil {
+		m.dirtyShards = map[int]bool{}
+	}
+	m.dirtyShards[m.shardOf(key)] = true
+}
+
+// shardFiles returns the indices of all shard files that exist next to the
+// memory file, including shards beyond the configured number of shards.
+func (m *Storage) shardFiles() ([]int, error) {
+	dir, prefix := filepath.Split(m.shardPrefix())
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := m.readDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shards: %w", err)
+	}
+
+	var shards []int
+	for _, e := range entries {
+		name := strings.TrimPrefix(e.Name(), prefix)
+		if e.IsDir() || len(name) == len(e.Name()) || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+
+		shard, err := strconv.Atoi(strings.TrimSuffix(name, ".json"))
+		if err != nil || shard < 0 || strconv.Itoa(shard)+".json" != name {
+			continue
+		}
+
+		shards = append(shards, shard)
+	}
+
+	sort.Ints(shards)
+	return shards, nil
+}
+
+// loadShards loads all shard files into the memory. Keys that are not stored
+// in the shard they belong to (e.g. because the number of shards has changed or
+// because an unsharded memory file exists) are moved to their shard right away.
+// Afterwards, all files that do not belong to a shard anymore are removed.
+func (m *Storage) loadShards() error {
+	shards, err := m.shardFiles()
+	if err != nil {
+		return err
+	}
+
+	// an unsharded memory file is loaded first and is marked with index -1
+	sources := append([]int{-1}, shards...)
+
+	// the memory file is considered missing if neither it nor any shard exists
+	m.created = true
+	var stale []string
+	for _, shard := range sources {
+		path := m.path
+		if shard >= 0 {
+			path = m.shardPath(shard)
+		}
+
+		f, err := m.readFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to load %q: %w", path, err)
+		}
+		if f == nil {
+			continue
+		}
+
+		m.created = false
+		if err := m.checkLoadedKeys(path, f.data); err != nil {
+			return err
+		}
+
+		for key, value := range f.data {
+			home := m.shardOf(key)
+			if home != shard {
+				m.markShard(key)
+				if _, ok := m.data[key]; ok {
+					// the file of the shard itself wins
+					continue
+				}
+			}
+
+			// the metadata is assigned directly since the key is already
+			// marked if it must be written again
+			m.data[key] = value
+			if version, ok := f.versions[key]; ok {
+				if m.versions == nil {
+					m.versions = map[string]uint64{}
+				}
+				m.versions[key] = version
+			}
+			if t, ok := f.expires[key]; ok {
+				if m.expires == nil {
+					m.expires = map[string]time.Time{}
+				}
+				m.expires[key] = t
+			}
+			if contentType, ok := f.types[key]; ok {
+				if m.types == nil {
+					m.types = map[string]string{}
+				}
+				m.types[key] = contentType
+			}
+		}
+
+		if shard < 0 || shard >= m.shards {
+			stale = append(stale, path)
+		}
+	}
+
+	if len(m.dirtyShards) > 0 {
+		m.logger.Info("Moving keys to their shards",
+			zap.String("path", m.path),
+			zap.Int("num_shards", len(m.dirtyShards)),
+		)
+
+		err := m.persist(context.Background())
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, path := range stale {
+		err := m.fsys.Remove(path)
+		if err != nil {
+			return fmt.Errorf("failed to remove file that does not belong to a shard: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// persistShards writes all shards that have been changed since they were last
+// written and returns the number of written bytes. All shards are encoded
+// before the first shard is written, so a change that is rejected (e.g. via
+// WithMaxSerializedSize) does not write any shard. A shard that could not be
+// written stays dirty so it is written again by the next persist. The caller
+// must hold the write lock.
+func (m *Storage) persistShards(span trace.Span) (int, error) {
+	data := make(map[int]map[string][]byte, len(m.dirtyShards))
+	for shard := range m.dirtyShards {
+		data[shard] = map[string][]byte{}
+	}
+
+	for key, value := range m.data {
+		if d, ok := data[m.shardOf(key)]; ok {
+			d[key] = value
+		}
+	}
+
+	contents := make(map[int][]byte, len(data))
+	var size int
+	for shard, d := range data {
+		content, err := m.serialize(d)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode shard %d: %w", shard, err)
+		}
+
+		contents[shard] = content
+		size += len(content)
+	}
+
+	span.SetAttributes(attribute.Int("bytes", size), attribute.Int("num_shards", len(contents)))
+
+	for shard, content := range contents {
+		path := m.shardPath(shard)
+		err := m.withTimeout(func() error {
+			return m.writeFile(path, content)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to write shard %d: %w", shard, err)
+		}
+
+		delete(m.dirtyShards, shard)
+	}
+
+	m.lastPersist = m.now()
+
+	if m.keyIndexPath != "" {
+		m.writeKeyIndex()
+	}
+
+	return size, nil
+}
+
+// shardsSize returns the total size of all shard files.
+func (m *Storage) shardsSize() (int64, error) {
+	var size int64
+	for shard := 0; shard < m.shards; shard++ {
+		info, err := m.fsys.Stat(m.shardPath(shard))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// the shard was not written yet
+		case err != nil:
+			return 0, fmt.Errorf("failed to get size of shard: %w", err)
+		default:
+			size += info.Size()
+		}
+	}
+
+	return size, nil
+}
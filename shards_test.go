@@ -0,0 +1,119 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func shardFileNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithShards(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "joe.json")
+
+	mem, err := NewMemory(path, WithShards(4))
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		require.NoError(t, mem.Set(key, []byte(key)))
+	}
+
+	assert.Equal(t, []string{"joe.0.json", "joe.1.json", "joe.2.json", "joe.3.json"}, shardFileNames(t, dir))
+
+	before := map[int][]byte{}
+	for shard := 0; shard < 4; shard++ {
+		before[shard], err = os.ReadFile(mem.shardPath(shard))
+		require.NoError(t, err)
+	}
+
+	// a change only rewrites the shard of the key
+	require.NoError(t, mem.Set("key0", []byte("changed")))
+	changed := mem.shardOf("key0")
+	for shard := 0; shard < 4; shard++ {
+		content, err := os.ReadFile(mem.shardPath(shard))
+		require.NoError(t, err)
+		if shard == changed {
+			assert.NotEqual(t, before[shard], content)
+		} else {
+			assert.Equal(t, before[shard], content, "shard %d", shard)
+		}
+	}
+
+	stats, err := mem.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 20, stats.NumKeys)
+	assert.NotZero(t, stats.FileSize)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(path, WithShards(4))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 20)
+
+	value, ok, err := mem.Get("key0")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "changed", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithShards_Resharding(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "joe.json")
+
+	data := map[string][]byte{}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		data[key] = []byte(key)
+	}
+	writeMemoryFile(t, path, data)
+
+	for _, n := range []int{3, 5, 1} {
+		mem, err := NewMemory(path, WithShards(n))
+		require.NoError(t, err)
+
+		for key, expected := range data {
+			value, ok, err := mem.Get(key)
+			require.NoError(t, err)
+			require.True(t, ok, key)
+			assert.Equal(t, expected, value)
+		}
+		require.NoError(t, mem.Close())
+
+		var expected []string
+		for shard := 0; shard < n; shard++ {
+			expected = append(expected, fmt.Sprintf("joe.%d.json", shard))
+		}
+		assert.ElementsMatch(t, expected, shardFileNames(t, dir), "%d shards", n)
+	}
+}
+
+func TestWithShards_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithShards(0))
+	require.EqualError(t, err, "number of shards must be positive but got 0")
+
+	_, err = NewMemory(tempFilePath(), WithShards(2), WithBackups(1))
+	require.EqualError(t, err, "sharding cannot be combined with backups")
+
+	_, err = NewMemoryFromFiles(tempFilePath(), nil, WithShards(2))
+	require.EqualError(t, err, "sharding cannot be combined with multiple files")
+}
@@ -0,0 +1,28 @@
+package file
+
+import "sort"
+
+// Snapshot returns all keys and values of the memory together with the sorted
+// list of its keys. Both are read while the memory is locked once, so unlike
+// separate calls of Keys and GetPrefix, the keys always match the values even
+// if other goroutines change the memory concurrently. Unlike Keys, the keys of
+// the fallback memory are not included (see WithFallback).
+//
+// An error is only returned if this function is called after the memory was
+// closed already, if the memory file could not be loaded in the background
+// (see WithBackgroundLoad) or if a value is corrupt (see WithValueChecksums).
+func (m *Storage) Snapshot() (map[string][]byte, []string, error) {
+	data := map[string][]byte{}
+	var keys []string
+	err := m.ForEach(func(key string, value []byte) error {
+		data[key] = value
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(keys)
+	return data, keys, nil
+}
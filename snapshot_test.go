@@ -0,0 +1,66 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Snapshot(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	data, keys, err := mem.Snapshot()
+	require.NoError(t, err)
+	assert.Empty(t, data)
+	assert.Empty(t, keys)
+
+	require.NoError(t, mem.Set("b", []byte("bar")))
+	require.NoError(t, mem.Set("a", []byte("foo")))
+
+	data, keys, err = mem.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"a": []byte("foo"), "b": []byte("bar")}, data)
+	assert.Equal(t, []string{"a", "b"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Snapshot_Concurrent(t *testing.T) {
+	mem, err := NewMemory("")
+	require.NoError(t, err)
+	defer mem.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("key-%d", i%10)
+			if i%3 == 0 {
+				_, _ = mem.Delete(key)
+			} else {
+				_ = mem.Set(key, []byte("value"))
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		data, keys, err := mem.Snapshot()
+		require.NoError(t, err)
+		require.Len(t, data, len(keys))
+		for _, key := range keys {
+			require.Contains(t, data, key)
+		}
+	}
+
+	wg.Wait()
+}
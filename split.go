@@ -0,0 +1,51 @@
+package file
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// checkSplitOptions returns an error if WithReadWriteSplit(…) is combined with
+// options that load the memory from somewhere else.
+func (m *Storage) checkSplitOptions() error {
+	if m.loadPath == "" {
+		return nil
+	}
+
+	switch {
+	case m.path == "":
+		return errors.New("a load path requires a memory file")
+	case filepath.Clean(m.loadPath) == filepath.Clean(m.path):
+		return errors.New("the load path must differ from the path of the memory file")
+	case m.seedFS != nil:
+		return errors.New("a load path cannot be combined with a seed file")
+	case m.shards > 0:
+		return errors.New("a load path cannot be combined with sharding")
+	case m.logRatio > 0:
+		return errors.New("a load path cannot be combined with an append log")
+	case m.lazyLoad:
+		return errors.New("a load path cannot be combined with lazy loading")
+	}
+
+	return nil
+}
+
+// readLoadFile reads the file at the load path (see WithReadWriteSplit). If
+// the file does not exist, it returns nil and no error. Just like a seed file,
+// the loaded content is not the content of the memory file, so the memory file
+// is considered as created.
+func (m *Storage) readLoadFile() (*fileContent, error) {
+	f, err := m.readFile(m.loadPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %q: %w", m.loadPath, err)
+	}
+
+	if f != nil {
+		f.checksum = [sha256.Size]byte{}
+		f.seeded = true
+	}
+
+	return f, nil
+}
@@ -0,0 +1,93 @@
+package file
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithReadWriteSplit(t *testing.T) {
+	source, staging := tempFilePath(), tempFilePath()
+	defer os.Remove(source)
+	defer os.Remove(staging)
+
+	writeMemoryFile(t, source, map[string][]byte{"foo": []byte("bar")})
+	original, err := os.ReadFile(source)
+	require.NoError(t, err)
+
+	mem, err := NewMemory(staging, WithReadWriteSplit(source, false))
+	require.NoError(t, err)
+	assert.True(t, mem.WasCreated())
+	assert.NoFileExists(t, staging)
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	require.NoError(t, mem.Close())
+
+	// the source is never written to
+	content, err := os.ReadFile(source)
+	require.NoError(t, err)
+	assert.Equal(t, original, content)
+
+	// the memory file takes precedence once it exists
+	mem, err = NewMemory(staging, WithReadWriteSplit(source, false))
+	require.NoError(t, err)
+	assert.False(t, mem.WasCreated())
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"baz", "foo"}, keys)
+	require.NoError(t, mem.Close())
+
+	// unless the load path is preferred
+	mem, err = NewMemory(staging, WithReadWriteSplit(source, true))
+	require.NoError(t, err)
+	keys, err = mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+	require.NoError(t, mem.Close())
+
+	// a missing load path loads the memory file instead
+	require.NoError(t, os.Remove(source))
+	mem, err = NewMemory(staging, WithReadWriteSplit(source, true))
+	require.NoError(t, err)
+	defer mem.Close()
+	keys, err = mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"baz", "foo"}, keys)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithReadWriteSplit_Corrupt(t *testing.T) {
+	source, staging := tempFilePath(), tempFilePath()
+	defer os.Remove(source)
+	defer os.Remove(staging)
+
+	require.NoError(t, os.WriteFile(source, []byte("{"), 0600))
+
+	// a corrupt source is an error and never moved aside
+	_, err := NewMemory(staging, WithReadWriteSplit(source, false), WithCorruptFilePolicy(StartEmpty))
+	require.Error(t, err)
+	assert.FileExists(t, source)
+}
+
+func TestWithReadWriteSplit_Invalid(t *testing.T) {
+	path := tempFilePath()
+
+	_, err := NewMemory(path, WithReadWriteSplit("", false))
+	assert.EqualError(t, err, "load path must not be empty")
+
+	_, err = NewMemory(path, WithReadWriteSplit(path, false))
+	assert.EqualError(t, err, "the load path must differ from the path of the memory file")
+
+	_, err = NewMemory(path, WithReadWriteSplit(tempFilePath(), false), WithAppendLog(1))
+	assert.EqualError(t, err, "a load path cannot be combined with an append log")
+
+	_, err = NewMemory(path, WithReadWriteSplit(tempFilePath(), true), WithLazyLoad())
+	assert.EqualError(t, err, "a load path cannot be combined with lazy loading")
+}
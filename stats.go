@@ -0,0 +1,96 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// MemoryStats describes the state of a memory (see Stats).
+type MemoryStats struct {
+	// NumKeys is the number of keys in the memory.
+	NumKeys int
+
+	// FileSize is the size of the memory file in bytes or zero if the file
+	// does not exist. For a sharded memory, it is the total size of all
+	// shards. If an append log is used, its size is included as well. The
+	// size is always zero if a custom Store is used.
+	FileSize int64
+
+	// LastPersist is the time at which this memory has written its file
+	// successfully for the last time. It is zero if the memory has not
+	// written its file since it was created.
+	LastPersist time.Time
+}
+
+// Stats returns the number of keys, the size of the memory file and the time of
+// the last successful write. This is much cheaper than reading the entire
+// memory and can be used to monitor the growth of the memory or to detect a
+// writer that is stuck. An error is returned if this function is called after
+// the memory was closed already.
+func (m *Storage) Stats() (MemoryStats, error) {
+	if err := m.awaitLoad(); err != nil {
+		return MemoryStats{}, err
+	}
+
+	if err := m.rlock(); err != nil {
+		return MemoryStats{}, err
+	}
+	defer m.runlock()
+
+	stats := MemoryStats{NumKeys: len(m.data), LastPersist: m.lastPersist}
+	if m.shards > 0 {
+		size, err := m.shardsSize()
+		if err != nil {
+			return MemoryStats{}, err
+		}
+
+		stats.FileSize = size
+		return stats, nil
+	}
+
+	if m.path == "" {
+		// the size of a custom store is unknown
+		return stats, nil
+	}
+
+	info, err := m.fsys.Stat(m.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// the memory file was not written yet
+	case err != nil:
+		return MemoryStats{}, fmt.Errorf("failed to get size of memory file: %w", err)
+	default:
+		stats.FileSize = info.Size()
+	}
+
+	stats.FileSize += m.logSize
+
+	return stats, nil
+}
+
+// LastPersistError returns the error of the most recent attempt to write the
+// memory file or nil if it succeeded. Unlike the error that is returned by Set,
+// this also reports failures of writes in the background (e.g. WithFlushInterval
+// or the LogAndContinue persist error policy), so a health check can detect a
+// memory that accepts changes but cannot write them to disk. Changes that were
+// rejected before the file was written (e.g. via WithMaxSerializedSize) do not
+// change the result.
+func (m *Storage) LastPersistError() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.persistErr
+}
+
+// LastPersistTime returns the time at which this memory has written its file
+// successfully for the last time, just like MemoryStats.LastPersist but
+// without checking the size of the file. It is zero if the memory has not
+// written its file since it was created.
+func (m *Storage) LastPersistTime() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastPersist
+}
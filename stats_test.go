@@ -0,0 +1,68 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory_Stats(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	stats, err := mem.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, MemoryStats{}, stats)
+
+	before := time.Now()
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+
+	info, err := os.Stat(tempFile)
+	require.NoError(t, err)
+
+	stats, err = mem.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.NumKeys)
+	assert.Equal(t, info.Size(), stats.FileSize)
+	assert.False(t, stats.LastPersist.Before(before))
+
+	require.NoError(t, mem.Close())
+	_, err = mem.Stats()
+	assert.EqualError(t, err, "brain was already shut down")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_LastPersistError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "memory", "memory.json")
+
+	mem, err := NewMemory(path, WithCreateDirs(), WithPersistErrorPolicy(LogAndContinue))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	assert.NoError(t, mem.LastPersistError())
+	assert.True(t, mem.LastPersistTime().IsZero())
+
+	// the error is only logged because of the persist error policy
+	require.NoError(t, os.RemoveAll(filepath.Dir(path)))
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+
+	var persistErr *PersistError
+	require.True(t, errors.As(mem.LastPersistError(), &persistErr), mem.LastPersistError())
+	assert.Equal(t, path, persistErr.Path)
+	assert.True(t, mem.LastPersistTime().IsZero())
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, mem.Set("foo", []byte("baz")))
+	assert.NoError(t, mem.LastPersistError())
+	assert.False(t, mem.LastPersistTime().IsZero())
+}
@@ -0,0 +1,150 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Store is the storage that holds the content of a memory file. The default
+// store that is used by NewMemory(…) reads and writes a file on disk. A custom
+// store can be used via NewMemoryWithStore(…) to keep the memory somewhere
+// else, e.g. in a buffer in unit tests.
+type Store interface {
+	// Load opens the current content of the store for reading. If nothing was
+	// saved yet, Load must return an error that wraps fs.ErrNotExist.
+	Load() (io.ReadCloser, error)
+
+	// Save returns a writer that replaces the content of the store. The new
+	// content is complete once the writer is closed successfully.
+	Save() (io.WriteCloser, error)
+}
+
+// NewMemoryWithStore creates a new Memory instance that loads its initial data
+// from the given store and saves all changes to it. Options that need the path
+// of a memory file (e.g. WithVersionFile or WithBackups) cannot be used with a
+// custom store.
+func NewMemoryWithStore(store Store, opts ...Option) (*Storage, error) {
+	if store == nil {
+		return nil, errors.New("store must not be nil")
+	}
+
+	// the store must be set before the options are validated
+	opts = append([]Option{func(memory *Storage) error {
+		memory.store = store
+		return nil
+	}}, opts...)
+
+	return NewMemory("", opts...)
+}
+
+// checkStoreOptions returns an error if a custom store or an in-memory memory
+// (see NewMemory) was combined with an option that needs the path of the
+// memory file.
+func (m *Storage) checkStoreOptions() error {
+	if m.path != "" {
+		return nil
+	}
+
+	kind := "a custom store"
+	if m.store == nil {
+		kind = "a memory without a path"
+	}
+
+	switch {
+	case m.versionFile:
+		return fmt.Errorf("%s cannot be combined with a version file", kind)
+	case m.onConflict != nil:
+		return fmt.Errorf("%s cannot be combined with conflict detection", kind)
+	case m.fileWatchInterval > 0:
+		return fmt.Errorf("%s cannot be combined with a file watch", kind)
+	case m.backups > 0:
+		return fmt.Errorf("%s cannot be combined with backups", kind)
+	case m.shards > 0:
+		return fmt.Errorf("%s cannot be combined with sharding", kind)
+	case m.logRatio > 0:
+		return fmt.Errorf("%s cannot be combined with an append log", kind)
+	case m.exclusiveLock:
+		return fmt.Errorf("%s cannot be combined with an exclusive lock", kind)
+	case m.checkWritable:
+		return fmt.Errorf("%s cannot be combined with a writable check", kind)
+	case m.createDirs:
+		return fmt.Errorf("%s cannot be combined with creating directories", kind)
+	case m.corruptFilePolicy == StartEmpty:
+		return fmt.Errorf("%s cannot move a corrupt file aside", kind)
+	case m.maxFileAge > 0:
+		return fmt.Errorf("%s cannot be combined with a max file age", kind)
+	case m.writableFallback:
+		return fmt.Errorf("%s cannot be combined with a writable fallback", kind)
+	}
+
+	if m.store != nil {
+		return nil
+	}
+
+	// a memory without a path never persists anything, so these options
+	// would silently be ignored
+	switch {
+	case m.keyIndexPath != "":
+		return fmt.Errorf("%s cannot be combined with a key index", kind)
+	case m.mirrorPath != "":
+		return fmt.Errorf("%s cannot be combined with a mirror", kind)
+	case m.externalThreshold > 0:
+		return fmt.Errorf("%s cannot be combined with external values", kind)
+	case m.operationTimeout > 0:
+		return fmt.Errorf("%s cannot be combined with an operation timeout", kind)
+	}
+
+	return nil
+}
+
+// fileStore is the Store of a memory file on disk.
+type fileStore struct {
+	memory *Storage
+}
+
+// Load implements Store.
+func (s fileStore) Load() (io.ReadCloser, error) {
+	return s.memory.fsys.Open(s.memory.path)
+}
+
+// Save implements Store. The content is buffered and the memory file is
+// replaced atomically when the writer is closed (see writeFile).
+func (s fileStore) Save() (io.WriteCloser, error) {
+	return &fileWriter{memory: s.memory}, nil
+}
+
+// fileWriter buffers the content of the memory file until it is closed.
+type fileWriter struct {
+	bytes.Buffer
+	memory *Storage
+}
+
+// Close writes the buffered content to the memory file.
+func (w *fileWriter) Close() error {
+	return w.memory.writeFile(w.memory.path, w.Bytes())
+}
+
+// save replaces the content of the store with the given content.
+func (m *Storage) save(content []byte) error {
+	return m.withTimeout(func() error {
+		return m.saveStore(content)
+	})
+}
+
+// saveStore implements save without a timeout.
+func (m *Storage) saveStore(content []byte) error {
+	w, err := m.store.Save()
+	if err != nil {
+		return fmt.Errorf("failed to open store to persist data: %w", err)
+	}
+
+	_, err = w.Write(content)
+	if err != nil {
+		_ = w.Close()
+		return fmt.Errorf("failed to write data to store: %w", err)
+	}
+
+	return w.Close()
+}
@@ -0,0 +1,89 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bufferStore is a Store that keeps its content in memory.
+type bufferStore struct {
+	content []byte
+	saves   int
+	failing bool
+}
+
+func (s *bufferStore) Load() (io.ReadCloser, error) {
+	if s.content == nil {
+		return nil, fs.ErrNotExist
+	}
+
+	return io.NopCloser(bytes.NewReader(s.content)), nil
+}
+
+func (s *bufferStore) Save() (io.WriteCloser, error) {
+	if s.failing {
+		return nil, errors.New("store is broken")
+	}
+
+	return &bufferStoreWriter{store: s}, nil
+}
+
+type bufferStoreWriter struct {
+	bytes.Buffer
+	store *bufferStore
+}
+
+func (w *bufferStoreWriter) Close() error {
+	w.store.content = w.Bytes()
+	w.store.saves++
+	return nil
+}
+
+func TestNewMemoryWithStore(t *testing.T) {
+	store := new(bufferStore)
+	mem, err := NewMemoryWithStore(store)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	assert.Equal(t, 2, store.saves)
+	assert.Contains(t, string(store.content), `"data":{"baz":"cXV4","foo":"YmFy"}`)
+
+	stats, err := mem.Stats()
+	require.NoError(t, err)
+	assert.Zero(t, stats.FileSize)
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemoryWithStore(store)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+
+	store.failing = true
+	err = mem.Set("foo", []byte("changed"))
+	assert.EqualError(t, err, "failed to open store to persist data: store is broken")
+}
+
+func TestNewMemoryWithStore_Invalid(t *testing.T) {
+	_, err := NewMemoryWithStore(nil)
+	require.EqualError(t, err, "store must not be nil")
+
+	_, err = NewMemoryWithStore(new(bufferStore), WithBackups(1))
+	require.EqualError(t, err, "a custom store cannot be combined with backups")
+
+	_, err = NewMemory("", WithBackups(1))
+	require.EqualError(t, err, "a memory without a path cannot be combined with backups")
+
+	_, err = NewMemory("", WithMirrorPath("mirror.json", false))
+	require.EqualError(t, err, "a memory without a path cannot be combined with a mirror")
+}
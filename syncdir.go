@@ -0,0 +1,26 @@
+//go:build !windows
+
+package file
+
+import (
+	"fmt"
+)
+
+// syncDir flushes the directory entry of a renamed file to disk, so the rename
+// itself survives a crash as well. Without this, a power loss may leave the old
+// file in place even though the content of the new file was synced.
+func (m *Storage) syncDir(dir string) error {
+	d, err := m.fsys.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory of memory file: %w", err)
+	}
+
+	defer d.Close()
+
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("failed to sync directory of memory file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package file
+
+// syncDir does nothing on Windows, where a directory cannot be synced.
+func (m *Storage) syncDir(string) error {
+	return nil
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"syscall"
+)
+
+// tmpPath returns the path of the temporary file that is written before it
+// replaces the file at the given path (see writeFile). If a temp directory is
+// configured (see WithTempDir), the name contains a hash of the path, so files
+// with the same name in different directories use different temporary files.
+func (m *Storage) tmpPath(path string) string {
+	if m.tempDir == "" {
+		return path + ".tmp"
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	name := filepath.Base(path) + "-" + hashName([]byte(abs))[:16] + ".tmp"
+	return filepath.Join(m.tempDir, name)
+}
+
+// isCrossDevice returns true if the error indicates that a file could not be
+// renamed because the new path is on a different file system.
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// copyTempFile copies the temporary file to the given path and then removes
+// it. This is used instead of a rename if both files are on different file
+// systems (see WithTempDir). Unlike a rename, the file at the given path is
+// overwritten in place, so a crash while copying can leave a partially written
+// file behind.
+func (m *Storage) copyTempFile(tmpPath, path string) error {
+	src, err := m.fsys.Open(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open temporary file: %w", err)
+	}
+	defer src.Close()
+
+	dst, err := m.fsys.OpenFile(path, m.openFlags, m.fileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+
+	_, err = io.Copy(dst, src)
+	if err == nil && m.syncWrites {
+		err = dst.Sync()
+	}
+	if err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("failed to copy temporary file: %w", err)
+	}
+
+	err = dst.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close file; data might not have been fully persisted to disk: %w", err)
+	}
+
+	_ = src.Close()
+	return m.fsys.Remove(tmpPath)
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// crossDeviceFS is an FS that fails to rename files between directories like
+// an FS whose directories are on different file systems.
+type crossDeviceFS struct {
+	osFS
+	renames int
+}
+
+func (fsys *crossDeviceFS) Rename(oldpath, newpath string) error {
+	if filepath.Dir(oldpath) != filepath.Dir(newpath) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+	}
+
+	fsys.renames++
+	return fsys.osFS.Rename(oldpath, newpath)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithTempDir(t *testing.T) {
+	dir, tempDir := t.TempDir(), t.TempDir()
+	path := filepath.Join(dir, "memory.json")
+
+	mem, err := NewMemory(path, WithTempDir(tempDir), WithCheckWritable())
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	// only the memory file is left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entries, err = os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	mem, err = NewMemory(path)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithTempDir_CrossDevice(t *testing.T) {
+	dir, tempDir := t.TempDir(), t.TempDir()
+	path := filepath.Join(dir, "memory.json")
+
+	fsys := new(crossDeviceFS)
+	mem, err := NewMemory(path, WithFS(fsys), WithTempDir(tempDir))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("foo", []byte("baz")))
+	require.NoError(t, mem.Close())
+
+	// the temporary file was copied instead
+	assert.Zero(t, fsys.renames)
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	mem, err = NewMemory(path)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "baz", string(value))
+}
+
+func TestMemory_TmpPath(t *testing.T) {
+	mem := &Storage{}
+	assert.Equal(t, "dir/memory.json.tmp", mem.tmpPath("dir/memory.json"))
+
+	mem.tempDir = "tmp"
+	a, b := mem.tmpPath("a/memory.json"), mem.tmpPath("b/memory.json")
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, "tmp", filepath.Dir(a))
+
+	_, err := NewMemory(tempFilePath(), WithTempDir(""))
+	assert.EqualError(t, err, "temp dir must not be empty")
+}
@@ -0,0 +1,61 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrOperationTimeout is returned if writing the memory file took longer than
+// the timeout of WithOperationTimeout(…).
+var ErrOperationTimeout = errors.New("memory file operation timed out")
+
+// withTimeout runs the given file operation and waits until it has finished or
+// the timeout of WithOperationTimeout(…) has passed, whichever happens first.
+// If the operation times out, it keeps running in the background and no other
+// operation is started until it has finished, so two writes of the same file
+// never run concurrently. The operation must not access any state of the
+// memory that might change after withTimeout has returned. The caller must
+// hold the write lock.
+func (m *Storage) withTimeout(op func() error) error {
+	if m.operationTimeout <= 0 {
+		return op()
+	}
+
+	if m.pendingWrite != nil {
+		select {
+		case <-m.pendingWrite:
+			m.pendingWrite = nil
+		default:
+			return fmt.Errorf("%w: an earlier operation is still running", ErrOperationTimeout)
+		}
+	}
+
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if perr := m.recoverPanic("file operation", recover()); perr != nil {
+				err = perr
+			}
+		}()
+		err = op()
+	}()
+
+	timer := time.NewTimer(m.operationTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return err
+	case <-timer.C:
+		m.pendingWrite = done
+		m.logger.Error("File operation did not complete in time; continuing in the background",
+			zap.Duration("timeout", m.operationTimeout),
+		)
+		return fmt.Errorf("%w after %s", ErrOperationTimeout, m.operationTimeout)
+	}
+}
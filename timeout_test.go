@@ -0,0 +1,72 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hangingStore is a Store whose Save blocks until it is released.
+type hangingStore struct {
+	release chan struct{}
+	saved   chan []byte
+}
+
+func (s *hangingStore) Load() (io.ReadCloser, error) {
+	return nil, fs.ErrNotExist
+}
+
+func (s *hangingStore) Save() (io.WriteCloser, error) {
+	<-s.release
+	return &hangingStoreWriter{store: s}, nil
+}
+
+type hangingStoreWriter struct {
+	bytes.Buffer
+	store *hangingStore
+}
+
+func (w *hangingStoreWriter) Close() error {
+	w.store.saved <- w.Bytes()
+	return nil
+}
+
+func TestWithOperationTimeout(t *testing.T) {
+	store := &hangingStore{release: make(chan struct{}), saved: make(chan []byte, 2)}
+	mem, err := NewMemoryWithStore(store, WithOperationTimeout(10*time.Millisecond))
+	require.NoError(t, err)
+
+	err = mem.Set("foo", []byte("bar"))
+	require.ErrorIs(t, err, ErrOperationTimeout)
+	require.ErrorIs(t, err, ErrPersist)
+
+	// the memory is not blocked by the hanging write
+	value, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("bar"), value)
+
+	// no other write is started until the hanging write completes
+	err = mem.Set("foo", []byte("baz"))
+	assert.EqualError(t, err, "memory file operation timed out: an earlier operation is still running")
+
+	close(store.release)
+	<-store.saved
+
+	// the write goroutine finishes right after the content was saved
+	require.Eventually(t, func() bool {
+		return mem.Set("foo", []byte("qux")) == nil
+	}, time.Second, time.Millisecond)
+	assert.Contains(t, string(<-store.saved), "cXV4") // base64 of "qux"
+	require.NoError(t, mem.Close())
+}
+
+func TestWithOperationTimeout_Invalid(t *testing.T) {
+	_, err := NewMemoryWithStore(new(bufferStore), WithOperationTimeout(0))
+	assert.EqualError(t, err, "operation timeout must be positive but got 0s")
+}
@@ -0,0 +1,53 @@
+package file
+
+import (
+	"context"
+	"io"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the name of the OpenTelemetry tracer that creates all spans of
+// the file memory.
+const tracerName = "github.com/go-joe/file-memory"
+
+// defaultTracer is used if no trace.TracerProvider was configured via
+// WithTracerProvider(…). It does not record anything.
+var defaultTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// startSpan starts a new span as child of any span in the given context.
+func (m *Storage) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return m.tracer.Start(ctx, "file-memory."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error (if any) as status of the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// countingReader counts the number of bytes that are read from the underlying
+// reader. It also remembers the first error of the underlying reader other than
+// io.EOF, so errors of reading the file can be told apart from errors of
+// decoding its content.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,119 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithTracerProvider(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	mem, err := NewMemory(tempFile, WithTracerProvider(tp))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	_, _, err = mem.Get("foo")
+	require.NoError(t, err)
+	_, err = mem.Delete("foo")
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+
+	spans := exporter.GetSpans()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+
+	require.Equal(t, []string{
+		"file-memory.load",
+		"file-memory.persist", "file-memory.Set",
+		"file-memory.Get",
+		"file-memory.persist", "file-memory.Delete",
+	}, names)
+
+	// persist spans are nested under the operation that triggered them
+	require.Equal(t, spans[2].SpanContext.SpanID(), spans[1].Parent.SpanID())
+	require.Equal(t, spans[5].SpanContext.SpanID(), spans[4].Parent.SpanID())
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithTracerProvider_ParentSpan(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	mem, err := NewMemory(tempFile, WithTracerProvider(tp))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "handler")
+	require.NoError(t, mem.SetContext(ctx, "foo", []byte("bar")))
+	_, _, err = mem.GetContext(ctx, "foo")
+	require.NoError(t, err)
+	_, err = mem.DeleteContext(ctx, "foo")
+	require.NoError(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans.Snapshots() {
+		byName[span.Name()] = span
+	}
+
+	for _, name := range []string{"file-memory.Set", "file-memory.Get", "file-memory.Delete"} {
+		span, ok := byName[name]
+		require.True(t, ok, name)
+		require.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID(), name)
+		require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID(), name)
+	}
+}
+
+func TestWithTracerProvider_Error(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	mem, err := NewMemory(path.Join(tempFilePath(), "does-not-exist"), WithTracerProvider(tp))
+	require.NoError(t, err)
+	require.Error(t, mem.Set("foo", []byte("bar")))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	require.Equal(t, codes.Error, spans[1].Status.Code)
+	require.Equal(t, codes.Error, spans[2].Status.Code)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithTracer(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	mem, err := NewMemory(tempFile, WithTracer(tp.Tracer("bot")))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Close())
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	for _, span := range spans {
+		require.Equal(t, "bot", span.InstrumentationScope.Name)
+	}
+
+	_, err = NewMemory(tempFile, WithTracer(nil))
+	require.EqualError(t, err, "tracer must not be nil")
+}
@@ -0,0 +1,155 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// expiryInterval is how often expired keys are removed from the memory.
+const expiryInterval = time.Minute
+
+// SetWithTTL is like Set but the key expires after the given duration. Get
+// never returns an expired value and expired keys are removed from the memory
+// in the background, so they might still be listed by Keys() for up to a
+// minute. The expiry is stored in the memory file, so it survives restarts and
+// keys that expired while the bot was not running are dropped when the memory
+// file is loaded. Setting the key again via Set removes its expiry.
+func (m *Storage) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	key = m.normalizeKey(key)
+
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive but got %s", ttl)
+	}
+
+	if err := m.checkKey(key); err != nil {
+		return err
+	}
+
+	if m.codec != nil {
+		return errors.New("expiry can only be persisted in the JSON format")
+	}
+
+	if err := m.checkValue(key, value); err != nil {
+		return err
+	}
+
+	m.inspectValue(key, value)
+	stored, err := m.sealValue(value)
+	if err != nil {
+		return err
+	}
+
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	atomic.AddUint64(&m.numSets, 1)
+	if err := m.checkLimits(key, stored); err != nil {
+		return err
+	}
+
+	prev := m.entry(key)
+	m.put(key, stored)
+	m.setExpiry(key, m.now().Add(ttl).UTC())
+
+	err = m.commit(context.Background())
+	if isRejected(err) {
+		// the file was not written so we revert the change to stay consistent
+		m.restore(key, prev)
+	}
+
+	if err == nil {
+		m.changed(key, WatchEvent{Value: value})
+	}
+
+	return err
+}
+
+// isExpired returns true if the key has an expiry that has passed already. The
+// caller must hold the lock.
+func (m *Storage) isExpired(key string, now time.Time) bool {
+	t, ok := m.expires[key]
+	return ok && !now.Before(t)
+}
+
+// dropExpired removes all expired keys from the given data and expiries.
+func dropExpired(data map[string][]byte, expires map[string]time.Time, now time.Time) {
+	for key, t := range expires {
+		if !now.Before(t) {
+			delete(data, key)
+			delete(expires, key)
+		}
+	}
+}
+
+// expirePeriodically removes expired keys until the memory is closed.
+func (m *Storage) expirePeriodically(stop <-chan struct{}) {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.expire()
+		}
+	}
+}
+
+// expire removes all expired keys from the memory and persists it once.
+func (m *Storage) expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data == nil || !m.isLoaded() || m.readOnly || m.draining {
+		// a read-only or draining memory must never write the memory file
+		return
+	}
+
+	now := m.now()
+	var expired []string
+	for key := range m.expires {
+		if m.isExpired(key, now) {
+			expired = append(expired, key)
+		}
+	}
+
+	if len(expired) == 0 {
+		return
+	}
+
+	if err := m.materialize(); err != nil {
+		m.logger.Error("Failed to decode lazily loaded memory to remove expired keys", zap.Error(err))
+		return
+	}
+
+	prev := make(map[string]entry, len(expired))
+	for _, key := range expired {
+		prev[key] = m.entry(key)
+		m.remove(key)
+	}
+
+	err := m.commit(context.Background())
+	if isRejected(err) {
+		for key, e := range prev {
+			m.restore(key, e)
+		}
+	}
+
+	if err != nil {
+		m.logger.Error("Failed to persist memory after removing expired keys", zap.Error(err))
+		return
+	}
+
+	m.logger.Debug("Removed expired keys", zap.Int("num_keys", len(expired)))
+	for _, key := range expired {
+		m.changed(key, WatchEvent{Deleted: true})
+	}
+}
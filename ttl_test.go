@@ -0,0 +1,89 @@
+package file
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-joe/joe"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetWithTTL(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	require.NoError(t, mem.SetWithTTL("code", []byte("1234"), time.Hour))
+	require.NoError(t, mem.SetWithTTL("expired", []byte("5678"), time.Hour))
+	require.NoError(t, mem.Set("forever", []byte("foo")))
+
+	val, ok, err := mem.Get("code")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "1234", string(val))
+
+	// pretend the TTL has passed
+	mem.mu.Lock()
+	mem.setExpiry("expired", time.Now().Add(-time.Second))
+	mem.mu.Unlock()
+
+	_, ok, err = mem.Get("expired")
+	require.NoError(t, err)
+	require.False(t, ok, "expired values must not be returned")
+
+	watch, unsubscribe := mem.Watch("expired")
+	defer unsubscribe()
+
+	mem.expire()
+	require.Equal(t, WatchEvent{Deleted: true}, <-watch)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"code", "forever"}, keys)
+	require.NoError(t, mem.Close())
+
+	// the expiry survives restarts
+	mem, err = NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	mem.mu.RLock()
+	_, hasTTL := mem.expires["code"]
+	mem.mu.RUnlock()
+	require.True(t, hasTTL)
+
+	// setting the key again removes the expiry
+	require.NoError(t, mem.Set("code", []byte("4321")))
+	mem.mu.RLock()
+	_, hasTTL = mem.expires["code"]
+	mem.mu.RUnlock()
+	require.False(t, hasTTL)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_SetWithTTL_ExpiredOnLoad(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	content := `{"version":2,"expires":{"old":"2000-01-01T00:00:00Z"},"data":{"old":"Zm9v","new":"YmFy"}}`
+	require.NoError(t, os.WriteFile(tempFile, []byte(content), 0600))
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"new"}, keys)
+}
+
+func TestMemory_SetWithTTL_Invalid(t *testing.T) {
+	withTempFile(t, func(mem joe.Memory) {
+		err := mem.(*Storage).SetWithTTL("foo", []byte("bar"), 0)
+		require.EqualError(t, err, "ttl must be positive but got 0s")
+	})
+}
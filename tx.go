@@ -0,0 +1,163 @@
+package file
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrNestedTransaction is returned by Transaction(…) if it is called while
+// another transaction of the same memory is running, e.g. from within the
+// function of a transaction.
+var ErrNestedTransaction = errors.New("memory is already running a transaction")
+
+// Tx gives access to the memory within a transaction (see Transaction). All
+// changes are staged until the transaction is committed. A Tx must not be used
+// after the function of the transaction has returned.
+type Tx interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte) error
+	Delete(key string) (bool, error)
+}
+
+// tx implements Tx. The write lock of the memory is held while it is used.
+type tx struct {
+	memory *Storage
+	staged map[string]stagedValue
+	keys   []string // the staged keys in the order they were changed first
+	done   bool
+}
+
+// stagedValue is a change of a key that was not applied to the memory yet.
+type stagedValue struct {
+	value   []byte // the plain value, nil if the key was deleted
+	stored  []byte // the sealed value
+	deleted bool
+}
+
+// Transaction calls fn with a Tx that stages all changes. If fn returns nil,
+// all staged changes are applied to the memory and persisted at once, just
+// like with ApplyChangeset(…). If fn returns an error, all changes are
+// discarded, the memory file is not touched and the error of fn is returned.
+// Reads via the Tx observe the staged changes.
+//
+// The memory is locked while fn is running, so other operations wait until
+// the transaction has finished and fn must only access the memory via the Tx.
+// Transactions cannot be nested. Calling Transaction while another transaction
+// is running returns ErrNestedTransaction instead of waiting for it.
+func (m *Storage) Transaction(fn func(tx Tx) error) error {
+	if !atomic.CompareAndSwapInt32(&m.inTransaction, 0, 1) {
+		return ErrNestedTransaction
+	}
+	defer atomic.StoreInt32(&m.inTransaction, 0)
+
+	if err := m.lock(); err != nil {
+		return err
+	}
+	defer m.unlock()
+
+	t := &tx{memory: m, staged: map[string]stagedValue{}}
+	err := fn(t)
+	t.done = true
+	if err != nil {
+		return err
+	}
+
+	var changes []Change
+	var stored [][]byte
+	for _, key := range t.keys {
+		s := t.staged[key]
+		if _, exists := m.data[key]; s.deleted && !exists {
+			// the key was only set within the transaction
+			continue
+		}
+
+		changes = append(changes, Change{Key: key, Value: s.value, Deleted: s.deleted})
+		stored = append(stored, s.stored)
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return m.apply(changes, stored)
+}
+
+var errTxDone = errors.New("transaction has finished already")
+
+func (t *tx) Get(key string) ([]byte, bool, error) {
+	key = t.memory.normalizeKey(key)
+
+	if t.done {
+		return nil, false, errTxDone
+	}
+
+	if s, ok := t.staged[key]; ok {
+		return s.value, !s.deleted, nil
+	}
+
+	m := t.memory
+	value, ok := m.data[key]
+	if !ok || m.isExpired(key, m.now()) {
+		return nil, false, nil
+	}
+
+	if ok, err := m.verifyValue(key, value); !ok {
+		return nil, false, err
+	}
+
+	value, err := m.openValue(value)
+	return value, err == nil, err
+}
+
+func (t *tx) Set(key string, value []byte) error {
+	key = t.memory.normalizeKey(key)
+
+	if t.done {
+		return errTxDone
+	}
+
+	m := t.memory
+	if err := m.checkKey(key); err != nil {
+		return err
+	}
+
+	if err := m.checkValue(key, value); err != nil {
+		return err
+	}
+
+	m.inspectValue(key, value)
+	stored, err := m.sealValue(value)
+	if err != nil {
+		return err
+	}
+
+	t.stage(key, stagedValue{value: value, stored: stored})
+	return nil
+}
+
+func (t *tx) Delete(key string) (bool, error) {
+	key = t.memory.normalizeKey(key)
+
+	if t.done {
+		return false, errTxDone
+	}
+
+	if err := t.memory.validateKey(key); err != nil {
+		return false, err
+	}
+
+	_, ok, err := t.Get(key)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	t.stage(key, stagedValue{deleted: true})
+	return true, nil
+}
+
+func (t *tx) stage(key string, s stagedValue) {
+	if _, ok := t.staged[key]; !ok {
+		t.keys = append(t.keys, key)
+	}
+	t.staged[key] = s
+}
@@ -0,0 +1,111 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Transaction(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	var events []ChangeEvent
+	mem, err := NewMemory(tempFile, WithChangeHook(func(event ChangeEvent) { events = append(events, event) }))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("from", []byte("100")))
+	require.NoError(t, mem.Set("temp", []byte("x")))
+
+	err = mem.Transaction(func(tx Tx) error {
+		value, ok, err := tx.Get("from")
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		require.NoError(t, tx.Set("to", value))
+		ok, err = tx.Delete("from")
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		// reads observe the staged changes
+		_, ok, err = tx.Get("from")
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		// keys that only exist within the transaction are not reported
+		require.NoError(t, tx.Set("scratch", []byte("y")))
+		_, err = tx.Delete("scratch")
+		return err
+	})
+	require.NoError(t, err)
+
+	keys, err := mem.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"temp", "to"}, keys)
+	assert.Equal(t, []ChangeEvent{
+		{Op: ChangeSet, Key: "from", Value: []byte("100")},
+		{Op: ChangeSet, Key: "temp", Value: []byte("x")},
+		{Op: ChangeSet, Key: "to", Value: []byte("100")},
+		{Op: ChangeDelete, Key: "from"},
+	}, events)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Transaction_Rollback(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	before, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+
+	var leaked Tx
+	errFailed := errors.New("failed")
+	err = mem.Transaction(func(tx Tx) error {
+		leaked = tx
+		require.NoError(t, tx.Set("foo", []byte("changed")))
+		require.NoError(t, tx.Set("new", []byte("value")))
+		return errFailed
+	})
+	require.Equal(t, errFailed, err)
+
+	value, _, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	after, err := os.ReadFile(tempFile)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	err = leaked.Set("foo", nil)
+	assert.EqualError(t, err, "transaction has finished already")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Transaction_Nested(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	err = mem.Transaction(func(tx Tx) error {
+		return mem.Transaction(func(Tx) error { return nil })
+	})
+	assert.True(t, errors.Is(err, ErrNestedTransaction), err)
+
+	// the memory can be used again once the transaction has finished
+	require.NoError(t, mem.Transaction(func(tx Tx) error {
+		return tx.Set("foo", []byte("bar"))
+	}))
+}
@@ -0,0 +1,105 @@
+package file
+
+import (
+	"crypto/sha256"
+	"sync"
+)
+
+// canUnlockPersist returns true if a change may release the write lock while
+// the memory file is written, so reads are not blocked by a slow disk. This is
+// only the case if writing the file does not depend on any other state of the
+// memory and if no failure of the write requires to revert the change (see
+// Rollback). The caller must hold the write lock.
+func (m *Storage) canUnlockPersist() bool {
+	return !m.keepLocked &&
+		m.shards == 0 &&
+		m.logRatio == 0 &&
+		!m.versionFile &&
+		m.onConflict == nil &&
+		m.fileWatchInterval == 0 &&
+		m.externalThreshold == 0 &&
+		m.keyIndexPath == "" &&
+		m.mirrorPath == "" &&
+		m.operationTimeout == 0 &&
+		!m.writableFallback &&
+		m.persistErrorPolicy != Rollback
+}
+
+// writeInOrder writes the given snapshot of the data to the memory file. If
+// unlock is true, the write lock is released during the write, so other
+// operations can read and even change the memory in the meantime. Each
+// snapshot gets a sequence number, so a snapshot is never written after a
+// newer one. If a newer snapshot was written already, the older one is
+// skipped, since the newer one contains all of its changes.
+//
+// Writes complete in the order of their snapshots, so the caller can report
+// its change to watchers once writeInOrder returns without reordering the
+// events of concurrent changes. The caller must hold the write lock.
+func (m *Storage) writeInOrder(content []byte, unlock bool) error {
+	m.persistSeq++
+	seq := m.persistSeq
+
+	if unlock {
+		m.unlocked.Add(1)
+		m.mu.Unlock()
+	}
+
+	written, err := m.writeSnapshot(seq, content)
+
+	if unlock {
+		m.mu.Lock()
+		m.unlocked.Done()
+	}
+
+	m.awaitWritesUntil(seq - 1)
+	m.doneSeq = seq
+	m.persistDone.Broadcast()
+
+	if written {
+		m.diskChecksum = sha256.Sum256(content)
+		m.lastPersist = m.now()
+	}
+
+	return err
+}
+
+// writeSnapshot writes the snapshot with the given sequence number unless a
+// newer snapshot was written already. It returns true if the file was written.
+func (m *Storage) writeSnapshot(seq uint64, content []byte) (bool, error) {
+	m.persistMu.Lock()
+	defer m.persistMu.Unlock()
+
+	if seq < m.writtenSeq {
+		return false, nil
+	}
+
+	if m.backups > 0 {
+		m.rotateBackups()
+	}
+
+	if err := m.save(content); err != nil {
+		return false, err
+	}
+
+	m.writtenSeq = seq
+	return true, nil
+}
+
+// awaitWrites waits until all writes of the memory file that released the
+// write lock have completed. The caller must hold the write lock, which is
+// released while waiting.
+func (m *Storage) awaitWrites() {
+	m.awaitWritesUntil(m.persistSeq)
+}
+
+// awaitWritesUntil waits until all writes up to the given sequence number have
+// completed. The caller must hold the write lock.
+func (m *Storage) awaitWritesUntil(seq uint64) {
+	if m.persistDone == nil {
+		m.persistDone = sync.NewCond(&m.mu)
+	}
+
+	for m.doneSeq < seq {
+		m.persistDone.Wait()
+	}
+}
@@ -0,0 +1,180 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowStore is a Store whose writes block until they are released or take the
+// given delay.
+type slowStore struct {
+	bufferStore
+	mu      sync.Mutex
+	entered chan struct{} // receives a value when a write starts, if possible
+	release chan struct{} // writes complete once this is closed
+	delay   time.Duration
+}
+
+func (s *slowStore) Save() (io.WriteCloser, error) {
+	return &slowStoreWriter{store: s}, nil
+}
+
+type slowStoreWriter struct {
+	bytes.Buffer
+	store *slowStore
+}
+
+func (w *slowStoreWriter) Close() error {
+	s := w.store
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+
+	if s.release != nil {
+		<-s.release
+	}
+	time.Sleep(s.delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content = w.Bytes()
+	s.saves++
+	return nil
+}
+
+func TestMemory_ReadDuringPersist(t *testing.T) {
+	store := &slowStore{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	mem, err := NewMemoryWithStore(store)
+	require.NoError(t, err)
+
+	errs := make(chan error)
+	go func() { errs <- mem.Set("foo", []byte("bar")) }()
+	<-store.entered
+
+	// reads are not blocked by the write and see the new value right away
+	read := make(chan []byte)
+	go func() {
+		value, _, _ := mem.Get("foo")
+		read <- value
+	}()
+
+	select {
+	case value := <-read:
+		assert.Equal(t, "bar", string(value))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get was blocked by the write of the memory file")
+	}
+
+	close(store.release)
+	require.NoError(t, <-errs)
+	require.NoError(t, mem.Close())
+	assert.Contains(t, string(store.content), `"foo":"YmFy"`)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_ConcurrentPersistOrder(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	events, cancel := mem.Watch("shared")
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				assert.NoError(t, mem.Set("shared", []byte(fmt.Sprint(i, j))))
+				assert.NoError(t, mem.Set(fmt.Sprint("key-", i), []byte(fmt.Sprint(j))))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	expected, ok, err := mem.Get("shared")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// the last event is the last change, so watchers end up with the final value
+	var last WatchEvent
+	for len(events) > 0 {
+		last = <-events
+	}
+	assert.Equal(t, string(expected), string(last.Value))
+
+	data := map[string]string{}
+	require.NoError(t, mem.ForEach(func(key string, value []byte) error {
+		data[key] = string(value)
+		return nil
+	}))
+	require.NoError(t, mem.Close())
+
+	// the memory file contains the latest snapshot of the data
+	reloaded, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer reloaded.Close()
+
+	for key, value := range data {
+		actual, ok, err := reloaded.Get(key)
+		require.NoError(t, err)
+		require.True(t, ok, key)
+		assert.Equal(t, value, string(actual), key)
+	}
+}
+
+// BenchmarkMemory_GetDuringSlowPersist measures the latency of a Get while the
+// memory file is written to a slow disk.
+func BenchmarkMemory_GetDuringSlowPersist(b *testing.B) {
+	run := func(b *testing.B, keepLocked bool) {
+		store := &slowStore{entered: make(chan struct{}, 1), delay: time.Millisecond}
+		mem, err := NewMemoryWithStore(store)
+		require.NoError(b, err)
+		require.NoError(b, mem.Set("foo", []byte("bar")))
+		<-store.entered
+
+		// simulates the persist before writes could release the lock
+		mem.mu.Lock()
+		mem.keepLocked = keepLocked
+		mem.mu.Unlock()
+
+		// each iteration includes a slow write, so only the time of the Get
+		// is reported separately
+		var total time.Duration
+		errs := make(chan error)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			go func() { errs <- mem.Set("counter", []byte(fmt.Sprint(i))) }()
+			<-store.entered
+
+			start := time.Now()
+			if _, _, err := mem.Get("foo"); err != nil {
+				b.Fatal(err)
+			}
+			total += time.Since(start)
+
+			if err := <-errs; err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(total.Nanoseconds())/float64(b.N), "get_ns/op")
+
+		require.NoError(b, mem.Close())
+	}
+
+	b.Run("locked", func(b *testing.B) { run(b, true) })
+	b.Run("unlocked", func(b *testing.B) { run(b, false) })
+}
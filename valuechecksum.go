@@ -0,0 +1,83 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"go.uber.org/zap"
+)
+
+// ErrValueCorrupt is returned when a value that was loaded from the memory file
+// does not match its checksum (see WithValueChecksums).
+var ErrValueCorrupt = errors.New("value is corrupt")
+
+// checkValueChecksumOptions returns an error if value checksums were combined
+// with an option that does not write or read them.
+func (m *Storage) checkValueChecksumOptions() error {
+	if !m.valueChecksums {
+		return nil
+	}
+
+	switch {
+	case m.codec != nil:
+		return errors.New("value checksums cannot be combined with a custom codec")
+	case m.shards > 0:
+		return errors.New("value checksums cannot be combined with sharding")
+	case m.lazyLoad:
+		return errors.New("value checksums cannot be combined with lazy loading")
+	}
+
+	return nil
+}
+
+// valueChecksum returns the checksum of a stored (i.e. sealed) value.
+func valueChecksum(stored []byte) uint32 {
+	return crc32.ChecksumIEEE(stored)
+}
+
+// storedChecksum returns the checksum that is written for the stored value of
+// the key. Values that were loaded and not changed since keep the checksum of
+// the file, so a corrupt value stays detectable when the file is rewritten.
+func (m *Storage) storedChecksum(key string, stored []byte) uint32 {
+	if checksum, ok := m.loadedChecksums[key]; ok {
+		return checksum
+	}
+
+	return valueChecksum(stored)
+}
+
+// checksumsOf returns the checksums of all given values that are written to the
+// memory file, or nil if value checksums are disabled.
+func (m *Storage) checksumsOf(data map[string][]byte) map[string]uint32 {
+	if !m.valueChecksums {
+		return nil
+	}
+
+	checksums := make(map[string]uint32, len(data))
+	for key, stored := range data {
+		checksums[key] = m.storedChecksum(key, stored)
+	}
+
+	return checksums
+}
+
+// verifyValue checks the stored value of the key against the checksum that was
+// loaded from the memory file. It returns true if the value can be used. If the
+// value is corrupt, it either returns an error that wraps ErrValueCorrupt or,
+// if corrupt values are dropped, it logs the corruption and returns false. The
+// caller must hold at least the read lock.
+func (m *Storage) verifyValue(key string, stored []byte) (bool, error) {
+	checksum, ok := m.loadedChecksums[key]
+	if !ok || valueChecksum(stored) == checksum {
+		return true, nil
+	}
+
+	err := fmt.Errorf("%w: value of key %q does not match its checksum", ErrValueCorrupt, key)
+	if !m.dropCorrupt {
+		return false, err
+	}
+
+	m.logger.Error("Ignoring corrupt value", zap.String("key", key), zap.Error(err))
+	return false, nil
+}
@@ -0,0 +1,163 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corruptFile replaces the first occurrence of old in the file at the given
+// path with new, which must have the same length.
+func corruptFile(t *testing.T, path, old, new string) {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	corrupted := bytes.Replace(content, []byte(old), []byte(new), 1)
+	require.NotEqual(t, content, corrupted)
+	require.NoError(t, os.WriteFile(path, corrupted, 0660))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithValueChecksums(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithValueChecksums(false))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("hello")))
+	require.NoError(t, mem.Set("bar", []byte("world")))
+	require.NoError(t, mem.Close())
+
+	// "aGVsbG8=" is "hello" and "bGVsbG8=" is still valid base64
+	corruptFile(t, tempFile, "aGVsbG8=", "bGVsbG8=")
+
+	mem, err = NewMemory(tempFile, WithValueChecksums(false))
+	require.NoError(t, err)
+
+	value, ok, err := mem.Get("bar")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "world", string(value))
+
+	_, ok, err = mem.Get("foo")
+	require.ErrorIs(t, err, ErrValueCorrupt)
+	assert.False(t, ok)
+
+	_, err = mem.GetMany([]string{"foo", "bar"})
+	require.ErrorIs(t, err, ErrValueCorrupt)
+
+	// rewriting the file keeps the corrupt value detectable
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	require.NoError(t, mem.Close())
+
+	mem, err = NewMemory(tempFile, WithValueChecksums(false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, _, err = mem.Get("foo")
+	require.ErrorIs(t, err, ErrValueCorrupt)
+
+	// setting the key again repairs it
+	require.NoError(t, mem.Set("foo", []byte("new")))
+	value, ok, err = mem.Get("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "new", string(value))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithValueChecksums_DropCorrupt(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithValueChecksums(true))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("hello")))
+	require.NoError(t, mem.Set("bar", []byte("world")))
+	require.NoError(t, mem.Close())
+
+	corruptFile(t, tempFile, "aGVsbG8=", "bGVsbG8=")
+
+	// without value checksums the entire file is rejected
+	_, err = NewMemory(tempFile)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+
+	mem, err = NewMemory(tempFile, WithValueChecksums(true))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, ok, err := mem.Get("foo")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	values, err := mem.GetMany([]string{"foo", "bar"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"bar": []byte("world")}, values)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithValueChecksums_AppendLog(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".log")
+
+	mem, err := NewMemory(tempFile, WithAppendLog(1000), WithValueChecksums(false))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("foo", []byte("hello")))
+	require.NoError(t, mem.Set("bar", []byte("world")))
+	require.NoError(t, mem.Close())
+
+	corruptFile(t, tempFile+".log", "aGVsbG8=", "bGVsbG8=")
+
+	mem, err = NewMemory(tempFile, WithAppendLog(1000), WithValueChecksums(false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, _, err = mem.Get("foo")
+	require.ErrorIs(t, err, ErrValueCorrupt)
+
+	value, ok, err := mem.Get("bar")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "world", string(value))
+}
+
+func TestWithValueChecksums_ExternalValues(t *testing.T) {
+	dir := t.TempDir()
+	tempFile := filepath.Join(dir, "joe.json")
+	valuesDir := filepath.Join(dir, "values")
+	large := bytes.Repeat([]byte("x"), 100)
+
+	mem, err := NewMemory(tempFile, WithExternalValues(10, valuesDir), WithValueChecksums(false))
+	require.NoError(t, err)
+	require.NoError(t, mem.Set("small", []byte("bar")))
+	require.NoError(t, mem.Set("large", large))
+	require.NoError(t, mem.Close())
+
+	side := filepath.Join(valuesDir, hashName(large)+externalSuffix)
+	corruptFile(t, side, "xxx", "xyx")
+
+	mem, err = NewMemory(tempFile, WithExternalValues(10, valuesDir), WithValueChecksums(false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	_, _, err = mem.Get("large")
+	require.ErrorIs(t, err, ErrValueCorrupt)
+
+	value, ok, err := mem.Get("small")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+}
+
+func TestWithValueChecksums_Invalid(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithValueChecksums(false), WithShards(2))
+	require.EqualError(t, err, "value checksums cannot be combined with sharding")
+
+	_, err = NewMemory(tempFilePath(), WithValueChecksums(false), WithLazyLoad())
+	require.EqualError(t, err, "value checksums cannot be combined with lazy loading")
+}
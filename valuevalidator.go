@@ -0,0 +1,63 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// ErrInvalidValue is matched via errors.Is by all errors that are returned
+// because the validator of WithValueValidator(…) rejected a value.
+var ErrInvalidValue = errors.New("invalid value")
+
+// validateValue returns an error that wraps ErrInvalidValue and the error of
+// the validator if the value is rejected (see WithValueValidator).
+func (m *Storage) validateValue(key string, value []byte) error {
+	if m.valueValidator == nil {
+		return nil
+	}
+
+	if err := m.valueValidator(key, value); err != nil {
+		return fmt.Errorf("%w of key %q: %w", ErrInvalidValue, key, err)
+	}
+
+	return nil
+}
+
+// checkLoadedValues validates all values that were loaded from the file at the
+// given path if this was enabled via WithValueValidator(…). Invalid values are
+// logged as warnings, so a single bad value does not prevent the bot from
+// starting.
+func (m *Storage) checkLoadedValues(path string, data map[string][]byte) error {
+	if m.valueValidator == nil || !m.validateLoaded {
+		return nil
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := m.openValue(data[key])
+		if err != nil {
+			return err
+		}
+
+		err = m.validateValue(key, value)
+		if err == nil {
+			continue
+		}
+
+		m.logger.Warn("Memory file contains invalid value",
+			zap.String("path", path),
+			zap.String("key", key),
+			zap.Error(err),
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// requireObject is a value validator that only accepts JSON objects with a
+// "name" field.
+func requireObject(_ string, value []byte) error {
+	var v struct {
+		Name *string `json:"name"`
+	}
+
+	if err := json.Unmarshal(value, &v); err != nil {
+		return err
+	}
+
+	if v.Name == nil {
+		return errors.New("missing field \"name\"")
+	}
+
+	return nil
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithValueValidator(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile, WithValueValidator(requireObject, false))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	require.NoError(t, mem.Set("user", []byte(`{"name":"Alice"}`)))
+
+	err = mem.Set("user", []byte(`{"age":42}`))
+	require.ErrorIs(t, err, ErrInvalidValue)
+	assert.EqualError(t, err, `invalid value of key "user": missing field "name"`)
+
+	err = mem.SetMany(map[string][]byte{"other": []byte("garbage")})
+	require.ErrorIs(t, err, ErrInvalidValue)
+
+	value, ok, err := mem.Get("user")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, `{"name":"Alice"}`, string(value))
+
+	_, ok, err = mem.Get("other")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestWithValueValidator_Loaded(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	writeMemoryFile(t, tempFile, map[string][]byte{
+		"alice": []byte(`{"name":"Alice"}`),
+		"bob":   []byte("garbage"),
+	})
+
+	core, logs := observer.New(zap.WarnLevel)
+	mem, err := NewMemory(tempFile,
+		WithValueValidator(requireObject, true),
+		WithLogger(zap.New(core)),
+	)
+	require.NoError(t, err)
+
+	// invalid values are reported but still loaded
+	_, ok, err := mem.Get("bob")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, mem.Close())
+
+	invalid := logs.FilterMessage("Memory file contains invalid value").All()
+	require.Len(t, invalid, 1)
+	assert.Equal(t, "bob", invalid[0].ContextMap()["key"])
+
+	// loaded values are not validated by default
+	core, logs = observer.New(zap.WarnLevel)
+	mem, err = NewMemory(tempFile,
+		WithValueValidator(requireObject, false),
+		WithLogger(zap.New(core)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, mem.Close())
+	assert.Equal(t, 0, logs.Len())
+}
+
+func TestWithValueValidator_Invalid(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	_, err := NewMemory(tempFile, WithValueValidator(nil, false))
+	assert.EqualError(t, err, "value validator must not be nil")
+
+	_, err = NewMemory(tempFile, WithValueValidator(requireObject, true), WithLazyLoad())
+	assert.EqualError(t, err, "lazy loading cannot be combined with validating loaded values")
+}
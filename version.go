@@ -0,0 +1,120 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// versionFilePath returns the path of the sidecar file that holds the version
+// of the memory file if the WithVersionFile(…) option is used.
+func (m *Storage) versionFilePath() string {
+	return m.path + ".ver"
+}
+
+// readVersion returns the version that is currently stored in the version
+// file. If the file does not exist or cannot be parsed, version 0 is returned.
+func (m *Storage) readVersion() uint64 {
+	content, err := m.readAll(m.versionFilePath())
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			m.logger.Error("Failed to read version file", zap.Error(err))
+		}
+		return 0
+	}
+
+	version, err := strconv.ParseUint(string(bytes.TrimSpace(content)), 10, 64)
+	if err != nil {
+		m.logger.Error("Failed to parse version file", zap.Error(err))
+		return 0
+	}
+
+	return version
+}
+
+// ErrStaleVersion is returned when a change was rejected because another
+// process has written a newer version of the memory file which this memory has
+// not loaded yet (see WithVersionFile).
+var ErrStaleVersion = errors.New("memory file was changed by another process")
+
+// checkVersion returns ErrStaleVersion if another process has written a
+// version of the memory file that this memory has not loaded yet. Writing the
+// file now would discard the changes of the other process. The caller must
+// hold the write lock.
+func (m *Storage) checkVersion() error {
+	version := m.readVersion()
+	if version > m.version {
+		return fmt.Errorf("%w: version %d is newer than loaded version %d",
+			ErrStaleVersion, version, m.version,
+		)
+	}
+
+	return nil
+}
+
+// bumpVersion increments the version in the version file so other processes
+// notice that the memory file has changed. The caller must hold the write lock.
+func (m *Storage) bumpVersion() error {
+	version := m.version + 1
+	content := []byte(strconv.FormatUint(version, 10) + "\n")
+	err := m.writeAll(m.versionFilePath(), content, 0660)
+	if err != nil {
+		return fmt.Errorf("failed to write version file: %w", err)
+	}
+
+	m.version = version
+	return nil
+}
+
+// pollVersionFile periodically checks the version file and reloads the memory
+// file when another process has written a new version.
+func (m *Storage) pollVersionFile(stop <-chan struct{}) {
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			m.reloadIfNewerVersion()
+		}
+	}
+}
+
+func (m *Storage) reloadIfNewerVersion() {
+	version := m.readVersion()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.data == nil || version <= m.version || !m.isLoaded() {
+		// a background load picks up the latest content of the file anyway
+		return
+	}
+
+	m.logger.Debug("Memory file was changed by another process",
+		zap.Uint64("old_version", m.version),
+		zap.Uint64("new_version", version),
+	)
+
+	data, err := m.loadFile(m.path)
+	if err != nil {
+		m.logger.Error("Failed to reload memory file; keeping current data", zap.Error(err))
+		return
+	}
+
+	if data == nil {
+		data = map[string][]byte{}
+	}
+
+	m.notifyReload(m.data, data)
+	m.data = data
+	m.indexValues()
+	m.version = version
+}
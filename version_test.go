@@ -0,0 +1,86 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestWithVersionFile(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".ver")
+
+	writer, err := NewMemory(tempFile, WithVersionFile(time.Millisecond))
+	require.NoError(t, err)
+	defer writer.Close()
+
+	reader, err := NewMemory(tempFile, WithVersionFile(time.Millisecond))
+	require.NoError(t, err)
+	defer reader.Close()
+
+	require.NoError(t, writer.Set("foo", []byte("bar")))
+	require.Equal(t, uint64(1), writer.readVersion())
+
+	eventually(t, func() bool {
+		val, ok, err := reader.Get("foo")
+		return err == nil && ok && string(val) == "bar"
+	})
+
+	// the reader continues counting from the version it has seen
+	require.NoError(t, reader.Set("foo", []byte("baz")))
+	require.Equal(t, uint64(2), reader.readVersion())
+
+	eventually(t, func() bool {
+		val, ok, err := writer.Get("foo")
+		return err == nil && ok && string(val) == "baz"
+	})
+}
+
+func TestWithVersionFile_InvalidInterval(t *testing.T) {
+	_, err := NewMemory(tempFilePath(), WithVersionFile(0))
+	require.EqualError(t, err, "version file poll interval must be positive")
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithVersionFile_StaleVersion(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+	defer os.Remove(tempFile + ".ver")
+
+	// polling never happens during the test
+	memA, err := NewMemory(tempFile, WithVersionFile(time.Hour))
+	require.NoError(t, err)
+	defer memA.Close()
+
+	memB, err := NewMemory(tempFile, WithVersionFile(time.Hour))
+	require.NoError(t, err)
+	defer memB.Close()
+
+	require.NoError(t, memA.Set("a", []byte("1")))
+
+	err = memB.Set("b", []byte("2"))
+	require.True(t, errors.Is(err, ErrStaleVersion), err)
+
+	// the rejected change was reverted
+	_, ok, err := memB.Get("b")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// the data of the first writer is still on disk
+	memB.reloadIfNewerVersion()
+	keys, err := memB.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, keys)
+
+	// after reloading, the second writer can write again
+	require.NoError(t, memB.Set("b", []byte("2")))
+	memA.reloadIfNewerVersion()
+	keys, err = memA.Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "b"}, keys)
+}
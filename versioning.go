@@ -0,0 +1,182 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrVersionMismatch is returned by SetWithVersion(…) if the key was changed
+// since the caller has read its version.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// GetWithVersion returns the value of the given key together with its current
+// version. The version can be passed to SetWithVersion(…) to update the key
+// only if nobody else has changed it in the meantime. Keys that have never been
+// written via SetWithVersion have version 0.
+//
+// An error is only returned if this function is called after the memory was
+// closed already.
+func (m *Storage) GetWithVersion(key string) (value []byte, version uint64, ok bool, err error) {
+	key = m.normalizeKey(key)
+
+	if err := m.awaitLoad(); err != nil {
+		return nil, 0, false, err
+	}
+
+	if err := m.rlock(); err != nil {
+		return nil, 0, false, err
+	}
+	defer m.runlock()
+
+	value, ok = m.data[key]
+	if !ok || m.isExpired(key, m.now()) {
+		return nil, m.versions[key], false, nil
+	}
+
+	if ok, err := m.verifyValue(key, value); !ok {
+		if err != nil {
+			return nil, 0, false, err
+		}
+		return nil, m.versions[key], false, nil
+	}
+
+	value, err = m.openValue(value)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return value, m.versions[key], true, nil
+}
+
+// SetWithVersion sets the key to the given value, but only if the current
+// version of the key matches the expected version. On success the version is
+// incremented and the new version is returned. If the version does not match,
+// an error that wraps ErrVersionMismatch is returned and nothing is changed.
+//
+// Versions are persisted in the memory file so they survive restarts. A key
+// starts being versioned with its first call to SetWithVersion. From then on
+// every Set increments its version as well and Delete resets it to 0. Since the
+// versions are stored in the header of the memory file, SetWithVersion fails if
+// a custom codec is configured via WithCodec(…).
+func (m *Storage) SetWithVersion(key string, value []byte, expectedVersion uint64) (uint64, error) {
+	key = m.normalizeKey(key)
+
+	if err := m.checkKey(key); err != nil {
+		return 0, err
+	}
+
+	if m.codec != nil {
+		return 0, errors.New("versions can only be persisted in the JSON format")
+	}
+
+	if err := m.checkValue(key, value); err != nil {
+		return 0, err
+	}
+
+	m.inspectValue(key, value)
+	stored, err := m.sealValue(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.lock(); err != nil {
+		return 0, err
+	}
+	defer m.unlock()
+
+	current := m.versions[key]
+	if current != expectedVersion {
+		return current, fmt.Errorf("%w: key %q has version %d but expected version %d",
+			ErrVersionMismatch, key, current, expectedVersion,
+		)
+	}
+
+	if err := m.checkLimits(key, stored); err != nil {
+		return current, err
+	}
+
+	prev := m.entry(key)
+	m.put(key, stored)
+	m.setVersion(key, current+1)
+
+	err = m.commit(context.Background())
+	if isRejected(err) {
+		// the file was not written so we revert the change to stay consistent
+		m.restore(key, prev)
+		return current, err
+	}
+
+	if err != nil {
+		return current + 1, err
+	}
+
+	m.changed(key, WatchEvent{Value: value})
+	return current + 1, nil
+}
+
+// CompareAndSwap sets the key to the new value, but only if its current value
+// equals the old value. The boolean return value indicates whether the value
+// was swapped. If the key does not exist, an empty (or nil) old value means
+// "create if absent": the key is created with the new value and true is
+// returned. A non-empty old value never matches an absent key.
+//
+// An error is returned if the memory was closed already or if the memory file
+// could not be written. If the write was rejected (e.g. via
+// WithMaxSerializedSize), the value is not changed.
+func (m *Storage) CompareAndSwap(key string, oldValue, newValue []byte) (bool, error) {
+	key = m.normalizeKey(key)
+
+	if err := m.checkKey(key); err != nil {
+		return false, err
+	}
+
+	if err := m.checkValue(key, newValue); err != nil {
+		return false, err
+	}
+
+	m.inspectValue(key, newValue)
+	stored, err := m.sealValue(newValue)
+	if err != nil {
+		return false, err
+	}
+
+	if err := m.lock(); err != nil {
+		return false, err
+	}
+	defer m.unlock()
+
+	prev := m.entry(key)
+	if prev.exists {
+		current, err := m.openValue(prev.value)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(current, oldValue) {
+			return false, nil
+		}
+	} else if len(oldValue) > 0 {
+		return false, nil
+	}
+
+	if err := m.checkLimits(key, stored); err != nil {
+		return false, err
+	}
+
+	m.put(key, stored)
+
+	err = m.commit(context.Background())
+	if isRejected(err) {
+		// the file was not written so we revert the change to stay consistent
+		m.restore(key, prev)
+		return false, err
+	}
+
+	if err != nil {
+		return true, err
+	}
+
+	m.changed(key, WatchEvent{Value: newValue})
+	return true, nil
+}
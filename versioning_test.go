@@ -0,0 +1,125 @@
+package file
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestMemory_SetWithVersion(t *testing.T) {
+	path := tempFilePath()
+	defer os.Remove(path)
+
+	logger := zaptest.NewLogger(t)
+	m, err := NewMemory(path, WithLogger(logger))
+	require.NoError(t, err)
+
+	_, version, ok, err := m.GetWithVersion("foo")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(0), version)
+
+	version, err = m.SetWithVersion("foo", []byte("bar"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), version)
+
+	_, err = m.SetWithVersion("foo", []byte("baz"), 0)
+	assert.True(t, errors.Is(err, ErrVersionMismatch))
+
+	value, version, ok, err := m.GetWithVersion("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", string(value))
+	assert.Equal(t, uint64(1), version)
+
+	// plain Set bumps the version of versioned keys
+	require.NoError(t, m.Set("foo", []byte("qux")))
+	_, version, _, err = m.GetWithVersion("foo")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), version)
+
+	require.NoError(t, m.Close())
+
+	// versions survive restarts
+	m, err = NewMemory(path, WithLogger(logger))
+	require.NoError(t, err)
+
+	value, version, ok, err = m.GetWithVersion("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "qux", string(value))
+	assert.Equal(t, uint64(2), version)
+
+	ok, err = m.Delete("foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, version, _, err = m.GetWithVersion("foo")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), version)
+
+	require.NoError(t, m.Close())
+}
+
+func TestMemory_SetWithVersionRollback(t *testing.T) {
+	path := tempFilePath()
+	defer os.Remove(path)
+
+	m, err := NewMemory(path, WithLogger(zaptest.NewLogger(t)), WithMaxSerializedSize(142))
+	require.NoError(t, err)
+
+	_, err = m.SetWithVersion("foo", []byte("bar"), 0)
+	require.NoError(t, err)
+
+	_, err = m.SetWithVersion("foo", make([]byte, 128), 1)
+	assert.True(t, errors.Is(err, ErrMaxSerializedSize))
+
+	value, version, _, err := m.GetWithVersion("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "bar", string(value))
+	assert.Equal(t, uint64(1), version)
+
+	require.NoError(t, m.Close())
+}
+
+func TestMemory_CompareAndSwap(t *testing.T) {
+	path := tempFilePath()
+	defer os.Remove(path)
+
+	m, err := NewMemory(path)
+	require.NoError(t, err)
+	defer m.Close()
+
+	// create if absent
+	ok, err := m.CompareAndSwap("foo", nil, []byte("1"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = m.CompareAndSwap("foo", nil, []byte("2"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = m.CompareAndSwap("foo", []byte("1"), []byte("2"))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = m.CompareAndSwap("foo", []byte("1"), []byte("3"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = m.CompareAndSwap("bar", []byte("1"), []byte("2"))
+	require.NoError(t, err)
+	assert.False(t, ok, "a non-empty old value must not match an absent key")
+
+	value, _, err := m.Get("foo")
+	require.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+
+	keys, err := m.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+}
@@ -0,0 +1,128 @@
+package file
+
+import (
+	"bytes"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// watchBufferSize is the number of values that are buffered for each watcher
+// before the oldest values are dropped.
+const watchBufferSize = 8
+
+// WatchEvent describes a change of a key that is observed via Watch(…).
+type WatchEvent struct {
+	// Value is the new value of the key. It is always nil if the key was
+	// deleted but note that a key may also have been set to a nil value.
+	Value []byte
+
+	// Deleted is true if the key was removed from the memory.
+	Deleted bool
+}
+
+type watcher struct {
+	key  string
+	ch   chan WatchEvent
+	once sync.Once
+}
+
+// Watch subscribes to all changes of the given key. Each time the key is set or
+// deleted, a WatchEvent is sent on the returned channel. The returned function
+// must be called to unsubscribe again, at which point the channel is closed.
+// All channels are closed as well when the memory is closed.
+//
+// The channel is buffered so a slow subscriber never blocks writes to the
+// memory. If the buffer is full, the oldest event is dropped in favor of the
+// newest one so subscribers always observe the latest state eventually.
+func (m *Storage) Watch(key string) (<-chan WatchEvent, func()) {
+	key = m.normalizeKey(key)
+
+	w := &watcher{key: key, ch: make(chan WatchEvent, watchBufferSize)}
+
+	m.mu.Lock()
+	if m.data == nil {
+		// the memory is already closed so there will never be any updates
+		m.mu.Unlock()
+		close(w.ch)
+		return w.ch, func() {}
+	}
+
+	if m.watchers == nil {
+		m.watchers = map[string]map[*watcher]struct{}{}
+	}
+	if m.watchers[key] == nil {
+		m.watchers[key] = map[*watcher]struct{}{}
+	}
+	m.watchers[key][w] = struct{}{}
+	m.mu.Unlock()
+
+	unsubscribe := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.removeWatcher(w)
+	}
+
+	return w.ch, unsubscribe
+}
+
+// notifyWatchers sends the event to all watchers of the given key. The caller
+// must hold the write lock.
+func (m *Storage) notifyWatchers(key string, event WatchEvent) {
+	for w := range m.watchers[key] {
+		select {
+		case w.ch <- event:
+		default:
+			// drop the oldest value to make room for the new one
+			select {
+			case <-w.ch:
+			default:
+			}
+			select {
+			case w.ch <- event:
+			default:
+			}
+		}
+	}
+}
+
+// notifyReload informs all watchers about the keys whose values differ between
+// the old and the new data. The caller must hold the write lock.
+func (m *Storage) notifyReload(oldData, newData map[string][]byte) {
+	for key := range m.watchers {
+		oldValue, oldOK := oldData[key]
+		newValue, newOK := newData[key]
+		switch {
+		case newOK && (!oldOK || !bytes.Equal(oldValue, newValue)):
+			value, err := m.openValue(newValue)
+			if err != nil {
+				m.logger.Error("Failed to decrypt reloaded value", zap.String("key", key), zap.Error(err))
+				continue
+			}
+			m.notifyWatchers(key, WatchEvent{Value: value})
+		case oldOK && !newOK:
+			m.notifyWatchers(key, WatchEvent{Deleted: true})
+		}
+	}
+}
+
+// removeWatcher unsubscribes the watcher and closes its channel. The caller
+// must hold the write lock.
+func (m *Storage) removeWatcher(w *watcher) {
+	w.once.Do(func() {
+		delete(m.watchers[w.key], w)
+		if len(m.watchers[w.key]) == 0 {
+			delete(m.watchers, w.key)
+		}
+		close(w.ch)
+	})
+}
+
+// closeWatchers unsubscribes all watchers. The caller must hold the write lock.
+func (m *Storage) closeWatchers() {
+	for _, watchers := range m.watchers {
+		for w := range watchers {
+			m.removeWatcher(w)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package file
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Watch(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	values, unsubscribe := mem.Watch("config")
+
+	require.NoError(t, mem.Set("other", []byte("ignored")))
+	require.NoError(t, mem.Set("config", []byte("v1")))
+	require.NoError(t, mem.Set("config", nil))
+	_, err = mem.Delete("config")
+	require.NoError(t, err)
+
+	require.Equal(t, WatchEvent{Value: []byte("v1")}, <-values)
+	require.Equal(t, WatchEvent{}, <-values)
+	require.Equal(t, WatchEvent{Deleted: true}, <-values)
+
+	unsubscribe()
+	unsubscribe() // must be safe to call multiple times
+
+	_, ok := <-values
+	require.False(t, ok, "channel should be closed after unsubscribing")
+
+	require.NoError(t, mem.Set("config", []byte("v2")))
+}
+
+// noinspection GoUnhandledErrorResult
+func TestMemory_Watch_SlowSubscriber(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	mem, err := NewMemory(tempFile)
+	require.NoError(t, err)
+
+	values, _ := mem.Watch("counter")
+	n := 3 * watchBufferSize
+	for i := 1; i <= n; i++ {
+		require.NoError(t, mem.Set("counter", []byte(strconv.Itoa(i))))
+	}
+
+	// closing the memory closes all watch channels
+	require.NoError(t, mem.Close())
+
+	var received []string
+	for v := range values {
+		received = append(received, string(v.Value))
+	}
+
+	require.Len(t, received, watchBufferSize)
+	require.Equal(t, strconv.Itoa(n), received[len(received)-1])
+}
@@ -0,0 +1,78 @@
+package file
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// checkWritableFallbackOptions returns an error if WithWritableFallback() is
+// combined with options that store files next to the memory file or that
+// expect the memory file to stay at its path.
+func (m *Storage) checkWritableFallbackOptions() error {
+	if !m.writableFallback {
+		return nil
+	}
+
+	switch {
+	case m.readOnly:
+		return errors.New("a read-only memory cannot be combined with a writable fallback")
+	case m.shards > 0:
+		return errors.New("sharding cannot be combined with a writable fallback")
+	case m.logRatio > 0:
+		return errors.New("an append log cannot be combined with a writable fallback")
+	case m.externalThreshold > 0:
+		return errors.New("external values cannot be combined with a writable fallback")
+	case m.versionFile:
+		return errors.New("a version file cannot be combined with a writable fallback")
+	case m.onConflict != nil:
+		return errors.New("conflict detection cannot be combined with a writable fallback")
+	case m.fileWatchInterval > 0:
+		return errors.New("a file watch cannot be combined with a writable fallback")
+	}
+
+	return nil
+}
+
+// isUnwritable returns true if the error indicates that the memory file cannot
+// be written because of missing permissions or a read-only file system.
+func isUnwritable(err error) bool {
+	return errors.Is(err, fs.ErrPermission) || errors.Is(err, syscall.EROFS)
+}
+
+// writableFallbackPath returns the temporary path the memory switches to if
+// its memory file is not writable. The path only depends on the path of the
+// memory file, so the temporary file of a previous run is reused.
+func (m *Storage) writableFallbackPath() string {
+	sum := sha256.Sum256([]byte(m.absPath))
+	name := fmt.Sprintf("%s.%x", filepath.Base(m.path), sum[:8])
+	return filepath.Join(os.TempDir(), name)
+}
+
+// switchToWritableFallback moves the memory file to a temporary path if the
+// given error of writing it indicates that it is not writable (see
+// WithWritableFallback). The switch happens at most once. It returns true if
+// the caller should write the memory file again. The caller must hold the
+// write lock.
+func (m *Storage) switchToWritableFallback(err error) bool {
+	if !m.writableFallback || m.fellBack || !isUnwritable(err) {
+		return false
+	}
+
+	m.fellBack = true
+	dest := m.writableFallbackPath()
+	m.logger.Warn("Memory file is not writable. Switching to a temporary file which is not loaded after a restart",
+		zap.String("path", m.path),
+		zap.String("fallback_path", dest),
+		zap.Error(err),
+	)
+
+	m.path = dest
+	return true
+}
@@ -0,0 +1,92 @@
+package file
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// lockedDirFS is an FS that rejects all writes to the files in dir, just like a
+// directory without write permission.
+type lockedDirFS struct {
+	osFS
+	dir string
+}
+
+func (fsys lockedDirFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 && strings.HasPrefix(name, fsys.dir) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+
+	return fsys.osFS.OpenFile(name, flag, perm)
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithWritableFallback(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "locked")
+	path := filepath.Join(dir, "memory.json")
+
+	core, logs := observer.New(zap.WarnLevel)
+	mem, err := NewMemory(path,
+		WithFS(lockedDirFS{dir: dir}),
+		WithWritableFallback(),
+		WithLogger(zap.New(core)),
+	)
+	require.NoError(t, err)
+	defer mem.Close()
+
+	fallback := mem.writableFallbackPath()
+	defer os.Remove(fallback)
+	assert.Equal(t, os.TempDir(), filepath.Dir(fallback))
+
+	require.NoError(t, mem.Set("foo", []byte("bar")))
+	require.NoError(t, mem.Set("baz", []byte("qux")))
+	assert.NoFileExists(t, path)
+	assert.Equal(t, path, mem.Path())
+
+	reloaded, err := NewMemory(fallback)
+	require.NoError(t, err)
+	keys, err := reloaded.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"baz", "foo"}, keys)
+	require.NoError(t, reloaded.Close())
+
+	switches := logs.FilterMessage("Memory file is not writable. Switching to a temporary file which is not loaded after a restart")
+	require.Equal(t, 1, switches.Len())
+	assert.Equal(t, path, switches.All()[0].ContextMap()["path"])
+}
+
+// noinspection GoUnhandledErrorResult
+func TestWithWritableFallback_Disabled(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "locked")
+	path := filepath.Join(dir, "memory.json")
+
+	mem, err := NewMemory(path, WithFS(lockedDirFS{dir: dir}))
+	require.NoError(t, err)
+	defer mem.Close()
+
+	err = mem.Set("foo", []byte("bar"))
+	require.ErrorIs(t, err, fs.ErrPermission)
+	require.ErrorIs(t, err, ErrPersist)
+}
+
+func TestWithWritableFallback_Invalid(t *testing.T) {
+	tempFile := tempFilePath()
+	defer os.Remove(tempFile)
+
+	_, err := NewMemory(tempFile, WithWritableFallback(), WithReadOnly())
+	assert.EqualError(t, err, "a read-only memory cannot be combined with a writable fallback")
+
+	_, err = NewMemory(tempFile, WithWritableFallback(), WithShards(2))
+	assert.EqualError(t, err, "sharding cannot be combined with a writable fallback")
+
+	_, err = NewMemory("", WithWritableFallback())
+	assert.EqualError(t, err, "a memory without a path cannot be combined with a writable fallback")
+}